@@ -0,0 +1,115 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// 文件存储目录名，位于数据目录下，与磁盘缓存目录分开，避免被缓存清理任务删除
+const fileStorageDir = "new-api-files"
+
+// FileStorage 文件内容存储接口，可替换为对象存储等实现
+type FileStorage interface {
+	// Save 写入文件内容，返回写入的字节数
+	Save(key string, r io.Reader) (int64, error)
+	// Open 打开文件内容用于读取
+	Open(key string) (io.ReadCloser, error)
+	// Delete 删除文件内容，文件不存在时不报错
+	Delete(key string) error
+}
+
+// LocalFileStorage 本地磁盘文件存储
+type LocalFileStorage struct {
+	Dir string
+}
+
+func (s *LocalFileStorage) path(key string) (string, error) {
+	if key == "" || strings.ContainsAny(key, `/\`) || strings.Contains(key, "..") {
+		return "", fmt.Errorf("invalid storage key: %q", key)
+	}
+	return filepath.Join(s.Dir, key), nil
+}
+
+func (s *LocalFileStorage) Save(key string, r io.Reader) (int64, error) {
+	filePath, err := s.path(key)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return 0, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	tmpPath := filePath + ".part"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create storage file: %w", err)
+	}
+	n, err := io.Copy(file, r)
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return 0, err
+	}
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		_ = os.Remove(tmpPath)
+		return 0, err
+	}
+	return n, nil
+}
+
+func (s *LocalFileStorage) Open(key string) (io.ReadCloser, error) {
+	filePath, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(filePath)
+}
+
+func (s *LocalFileStorage) Delete(key string) error {
+	filePath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+var (
+	fileStorage   FileStorage
+	fileStorageMu sync.RWMutex
+)
+
+// SetFileStorage 替换全局文件存储实现
+func SetFileStorage(storage FileStorage) {
+	fileStorageMu.Lock()
+	defer fileStorageMu.Unlock()
+	fileStorage = storage
+}
+
+// GetFileStorage 获取全局文件存储，未设置时使用本地磁盘存储。
+// dir 为空时使用数据目录（工作目录，Docker 镜像中为 /data，与默认 SQLite 数据库同目录）下的 new-api-files；
+// 不使用磁盘缓存或系统临时目录，它们可能在重启或被清理任务清空，而数据库中的文件记录仍然存在
+func GetFileStorage(dir string) FileStorage {
+	fileStorageMu.RLock()
+	storage := fileStorage
+	fileStorageMu.RUnlock()
+	if storage != nil {
+		return storage
+	}
+	if dir == "" {
+		dir = fileStorageDir
+		if wd, err := os.Getwd(); err == nil {
+			dir = filepath.Join(wd, fileStorageDir)
+		}
+	}
+	return &LocalFileStorage{Dir: dir}
+}
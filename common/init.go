@@ -15,17 +15,18 @@ import (
 )
 
 var (
-	Port         = flag.Int("port", 3000, "the listening port")
-	PrintVersion = flag.Bool("version", false, "print version and exit")
-	PrintHelp    = flag.Bool("help", false, "print help and exit")
-	LogDir       = flag.String("log-dir", "./logs", "specify the log directory")
+	Port             = flag.Int("port", 3000, "the listening port")
+	PrintVersion     = flag.Bool("version", false, "print version and exit")
+	PrintHelp        = flag.Bool("help", false, "print help and exit")
+	LogDir           = flag.String("log-dir", "./logs", "specify the log directory")
+	ReencryptSecrets = flag.Bool("reencrypt-secrets", false, "re-encrypt stored secrets with the current master key and exit")
 )
 
 func printHelp() {
 	fmt.Println("NewAPI(Based OneAPI) " + Version + " - The next-generation LLM gateway and AI asset management system supports multiple languages.")
 	fmt.Println("Original Project: OneAPI by JustSong - https://github.com/songquanpeng/one-api")
 	fmt.Println("Maintainer: QuantumNous - https://github.com/QuantumNous/new-api")
-	fmt.Println("Usage: newapi [--port <port>] [--log-dir <log directory>] [--version] [--help]")
+	fmt.Println("Usage: newapi [--port <port>] [--log-dir <log directory>] [--reencrypt-secrets] [--version] [--help]")
 }
 
 func InitEnv() {
@@ -61,6 +62,9 @@ func InitEnv() {
 	} else {
 		CryptoSecret = SessionSecret
 	}
+	if err := InitSecretEncryption(); err != nil {
+		log.Fatal(err)
+	}
 	if os.Getenv("SQLITE_PATH") != "" {
 		SQLitePath = os.Getenv("SQLITE_PATH")
 	}
@@ -148,6 +152,12 @@ func initConstantEnv() {
 	constant.GenerateDefaultToken = GetEnvOrDefaultBool("GENERATE_DEFAULT_TOKEN", false)
 	// 是否启用错误日志
 	constant.ErrorLogEnabled = GetEnvOrDefaultBool("ERROR_LOG_ENABLED", false)
+	// 令牌密钥与访问令牌是否加密存储，开启后无法再查看完整密钥，启动时会转换已有的明文密钥
+	constant.TokenKeyHashEnabled = GetEnvOrDefaultBool("TOKEN_KEY_HASH_ENABLED", false)
+	// 哈希使用 CRYPTO_SECRET（未设置时为 SESSION_SECRET），两者都未设置时每次启动随机生成，重启后所有密钥都将失效
+	if constant.TokenKeyHashEnabled && os.Getenv("CRYPTO_SECRET") == "" && os.Getenv("SESSION_SECRET") == "" {
+		log.Fatal("TOKEN_KEY_HASH_ENABLED requires CRYPTO_SECRET or SESSION_SECRET to be set explicitly")
+	}
 	// 任务轮询时查询的最大数量
 	constant.TaskQueryLimit = GetEnvOrDefault("TASK_QUERY_LIMIT", 1000)
 	// 异步任务超时时间（分钟），超过此时间未完成的任务将被标记为失败并退款。0 表示禁用。
@@ -13,9 +13,13 @@ import (
 //go:embed lua/rate_limit.lua
 var rateLimitScript string
 
+//go:embed lua/token_bucket.lua
+var tokenBucketScript string
+
 type RedisLimiter struct {
-	client         *redis.Client
-	limitScriptSHA string
+	client          *redis.Client
+	limitScriptSHA  string
+	bucketScriptSHA string
 }
 
 var (
@@ -30,9 +34,14 @@ func New(ctx context.Context, r *redis.Client) *RedisLimiter {
 		if err != nil {
 			common.SysLog(fmt.Sprintf("Failed to load rate limit script: %v", err))
 		}
+		bucketSHA, err := r.ScriptLoad(ctx, tokenBucketScript).Result()
+		if err != nil {
+			common.SysLog(fmt.Sprintf("Failed to load token bucket script: %v", err))
+		}
 		instance = &RedisLimiter{
-			client:         r,
-			limitScriptSHA: limitSHA,
+			client:          r,
+			limitScriptSHA:  limitSHA,
+			bucketScriptSHA: bucketSHA,
 		}
 	})
 
@@ -68,6 +77,45 @@ func (rl *RedisLimiter) Allow(ctx context.Context, key string, opts ...Option) (
 	return result == 1, nil
 }
 
+// Take 从令牌桶中取出令牌并返回剩余令牌数。force 为 true 时总是扣除（允许欠额），
+// 用于请求结束后按实际用量结算；Requested 为负数时退还令牌
+func (rl *RedisLimiter) Take(ctx context.Context, key string, force bool, opts ...Option) (bool, int64, error) {
+	config := newConfig(opts...)
+	forceArg := 0
+	if force {
+		forceArg = 1
+	}
+	result, err := rl.client.EvalSha(
+		ctx,
+		rl.bucketScriptSHA,
+		[]string{key},
+		config.Requested,
+		config.Rate,
+		config.Capacity,
+		forceArg,
+	).Int64Slice()
+	if err != nil {
+		return false, 0, fmt.Errorf("rate limit failed: %w", err)
+	}
+	if len(result) != 2 {
+		return false, 0, fmt.Errorf("rate limit failed: unexpected result %v", result)
+	}
+	return result[0] == 1, result[1], nil
+}
+
+func newConfig(opts ...Option) *Config {
+	// 默认配置
+	config := &Config{
+		Capacity:  10,
+		Rate:      1,
+		Requested: 1,
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+	return config
+}
+
 // Config 配置选项模式
 type Config struct {
 	Capacity  int64
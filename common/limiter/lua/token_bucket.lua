@@ -0,0 +1,37 @@
+-- 令牌桶，返回是否允许与剩余令牌数，用于需要返回剩余额度或事后结算的限流
+-- KEYS[1]: 限流器唯一标识
+-- ARGV[1]: 请求令牌数，可为负数（退还）
+-- ARGV[2]: 令牌生成速率 (每秒)
+-- ARGV[3]: 桶容量
+-- ARGV[4]: 是否强制扣除 (1/0)，强制扣除时令牌数可为负，最低为 -容量
+
+local key = KEYS[1]
+local requested = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local capacity = tonumber(ARGV[3])
+local force = tonumber(ARGV[4]) == 1
+
+local now = redis.call('TIME')
+local nowInSeconds = tonumber(now[1])
+
+local bucket = redis.call('HMGET', key, 'tokens', 'last_time')
+local tokens = tonumber(bucket[1])
+local last_time = tonumber(bucket[2])
+
+if not tokens or not last_time then
+    tokens = capacity
+else
+    local elapsed = nowInSeconds - last_time
+    tokens = math.min(capacity, tokens + elapsed * rate)
+end
+
+local allowed = 0
+if force or tokens >= requested then
+    tokens = math.max(-capacity, math.min(capacity, tokens - requested))
+    allowed = 1
+end
+
+redis.call('HMSET', key, 'tokens', tokens, 'last_time', nowInSeconds)
+redis.call('EXPIRE', key, math.ceil(2 * capacity / rate) + 60)
+
+return {allowed, tokens}
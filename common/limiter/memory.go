@@ -0,0 +1,58 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryLimiter 与 RedisLimiter.Take 语义一致的内存令牌桶，用于未启用 Redis 时，仅对单实例有效
+type MemoryLimiter struct {
+	mutex   sync.Mutex
+	buckets map[string]*memoryBucket
+}
+
+type memoryBucket struct {
+	tokens   int64
+	lastTime int64
+	expireAt int64
+}
+
+var memoryInstance = &MemoryLimiter{buckets: make(map[string]*memoryBucket)}
+
+// NewMemory 获取内存令牌桶单例
+func NewMemory() *MemoryLimiter {
+	return memoryInstance
+}
+
+func (ml *MemoryLimiter) Take(_ context.Context, key string, force bool, opts ...Option) (bool, int64, error) {
+	config := newConfig(opts...)
+	now := time.Now().Unix()
+
+	ml.mutex.Lock()
+	defer ml.mutex.Unlock()
+
+	bucket, ok := ml.buckets[key]
+	if !ok || now >= bucket.expireAt {
+		bucket = &memoryBucket{tokens: config.Capacity}
+		ml.buckets[key] = bucket
+	} else {
+		bucket.tokens = min(config.Capacity, bucket.tokens+(now-bucket.lastTime)*config.Rate)
+	}
+	allowed := force || bucket.tokens >= config.Requested
+	if allowed {
+		bucket.tokens = max(-config.Capacity, min(config.Capacity, bucket.tokens-config.Requested))
+	}
+	bucket.lastTime = now
+	bucket.expireAt = now + 2*config.Capacity/max(config.Rate, 1) + 60
+
+	// 顺带清理过期的桶，避免令牌删除后残留
+	if len(ml.buckets) > 1024 {
+		for k, b := range ml.buckets {
+			if now >= b.expireAt {
+				delete(ml.buckets, k)
+			}
+		}
+	}
+	return allowed, bucket.tokens, nil
+}
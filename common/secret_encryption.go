@@ -0,0 +1,213 @@
+package common
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// 加密后的格式：enc:v1:<主密钥ID>:<被主密钥加密的数据密钥>:<被数据密钥加密的明文>
+// 每个值使用独立的数据密钥，主密钥只用于加密数据密钥，轮换主密钥时只需重新加密数据密钥即可
+const secretEncryptionPrefix = "enc:v1:"
+
+type secretMasterKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+var secretKeyring = struct {
+	sync.RWMutex
+	current *secretMasterKey
+	keys    map[string]*secretMasterKey
+}{}
+
+// InitSecretEncryption 从环境变量加载主密钥。
+// SECRET_ENCRYPTION_KEY 或 SECRET_ENCRYPTION_KEY_FILE 指定当前主密钥（32 字节，base64 或 hex 编码），
+// SECRET_ENCRYPTION_OLD_KEYS 指定轮换前的旧主密钥（逗号分隔），仅用于解密
+func InitSecretEncryption() error {
+	current := strings.TrimSpace(os.Getenv("SECRET_ENCRYPTION_KEY"))
+	if keyFile := os.Getenv("SECRET_ENCRYPTION_KEY_FILE"); current == "" && keyFile != "" {
+		content, err := os.ReadFile(keyFile)
+		if err != nil {
+			return fmt.Errorf("failed to read SECRET_ENCRYPTION_KEY_FILE: %w", err)
+		}
+		current = strings.TrimSpace(string(content))
+	}
+	var old []string
+	for _, k := range strings.Split(os.Getenv("SECRET_ENCRYPTION_OLD_KEYS"), ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			old = append(old, k)
+		}
+	}
+	return SetSecretEncryptionKeys(current, old...)
+}
+
+// SetSecretEncryptionKeys 设置当前主密钥与旧主密钥，current 为空表示不加密新写入的数据
+func SetSecretEncryptionKeys(current string, old ...string) error {
+	keys := make(map[string]*secretMasterKey, len(old)+1)
+	var currentKey *secretMasterKey
+	if current != "" {
+		key, err := newSecretMasterKey(current)
+		if err != nil {
+			return fmt.Errorf("invalid secret encryption key: %w", err)
+		}
+		currentKey = key
+		keys[key.id] = key
+	}
+	for i, k := range old {
+		key, err := newSecretMasterKey(k)
+		if err != nil {
+			return fmt.Errorf("invalid old secret encryption key #%d: %w", i+1, err)
+		}
+		if _, ok := keys[key.id]; !ok {
+			keys[key.id] = key
+		}
+	}
+	secretKeyring.Lock()
+	secretKeyring.current = currentKey
+	secretKeyring.keys = keys
+	secretKeyring.Unlock()
+	return nil
+}
+
+func newSecretMasterKey(encoded string) (*secretMasterKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(raw) != 32 {
+		raw, err = hex.DecodeString(encoded)
+	}
+	if err != nil || len(raw) != 32 {
+		return nil, errors.New("key must be 32 bytes, encoded as base64 or hex")
+	}
+	aead, err := newSecretAEAD(raw)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(raw)
+	return &secretMasterKey{id: hex.EncodeToString(sum[:4]), aead: aead}, nil
+}
+
+func newSecretAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func sealSecret(aead cipher.AEAD, plaintext []byte, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func openSecret(aead cipher.AEAD, sealed []byte, aad []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, aad)
+}
+
+// SecretEncryptionEnabled 是否配置了当前主密钥
+func SecretEncryptionEnabled() bool {
+	secretKeyring.RLock()
+	defer secretKeyring.RUnlock()
+	return secretKeyring.current != nil
+}
+
+// IsEncryptedSecret 判断值是否为加密后的格式
+func IsEncryptedSecret(value string) bool {
+	return strings.HasPrefix(value, secretEncryptionPrefix)
+}
+
+// EncryptSecret 使用当前主密钥加密，未配置主密钥、空值或已加密的值原样返回
+func EncryptSecret(plaintext string) (string, error) {
+	secretKeyring.RLock()
+	master := secretKeyring.current
+	secretKeyring.RUnlock()
+	if master == nil || plaintext == "" || IsEncryptedSecret(plaintext) {
+		return plaintext, nil
+	}
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return "", err
+	}
+	dataAEAD, err := newSecretAEAD(dek)
+	if err != nil {
+		return "", err
+	}
+	data, err := sealSecret(dataAEAD, []byte(plaintext), nil)
+	if err != nil {
+		return "", err
+	}
+	wrappedKey, err := sealSecret(master.aead, dek, []byte(master.id))
+	if err != nil {
+		return "", err
+	}
+	return secretEncryptionPrefix + master.id + ":" +
+		base64.RawURLEncoding.EncodeToString(wrappedKey) + ":" +
+		base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// DecryptSecret 解密 EncryptSecret 的结果，未加密的值原样返回
+func DecryptSecret(value string) (string, error) {
+	if !IsEncryptedSecret(value) {
+		return value, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(value, secretEncryptionPrefix), ":")
+	if len(parts) != 3 {
+		return "", errors.New("malformed encrypted secret")
+	}
+	secretKeyring.RLock()
+	master := secretKeyring.keys[parts[0]]
+	secretKeyring.RUnlock()
+	if master == nil {
+		return "", fmt.Errorf("secret is encrypted with unknown master key %s", parts[0])
+	}
+	wrappedKey, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", errors.New("malformed encrypted secret")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", errors.New("malformed encrypted secret")
+	}
+	dek, err := openSecret(master.aead, wrappedKey, []byte(master.id))
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap data key with master key %s", master.id)
+	}
+	dataAEAD, err := newSecretAEAD(dek)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := openSecret(dataAEAD, data, nil)
+	if err != nil {
+		return "", errors.New("failed to decrypt secret")
+	}
+	return string(plaintext), nil
+}
+
+// SecretNeedsReencrypt 判断值是否需要按当前主密钥重新写入：
+// 配置了主密钥时，明文或由旧主密钥加密的值需要重新加密；未配置主密钥时，已加密的值需要还原为明文
+func SecretNeedsReencrypt(value string) bool {
+	if value == "" {
+		return false
+	}
+	secretKeyring.RLock()
+	master := secretKeyring.current
+	secretKeyring.RUnlock()
+	if master == nil {
+		return IsEncryptedSecret(value)
+	}
+	return !strings.HasPrefix(value, secretEncryptionPrefix+master.id+":")
+}
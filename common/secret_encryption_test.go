@@ -0,0 +1,72 @@
+package common
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testSecretKeyA = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	testSecretKeyB = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+)
+
+func TestSecretEncryptionRoundTrip(t *testing.T) {
+	t.Cleanup(func() { _ = SetSecretEncryptionKeys("") })
+	require.NoError(t, SetSecretEncryptionKeys(testSecretKeyA))
+
+	encrypted, err := EncryptSecret("sk-upstream")
+	require.NoError(t, err)
+	require.True(t, IsEncryptedSecret(encrypted))
+	require.NotContains(t, encrypted, "sk-upstream")
+
+	// 每次加密使用独立的数据密钥与随机数
+	again, err := EncryptSecret("sk-upstream")
+	require.NoError(t, err)
+	require.NotEqual(t, encrypted, again)
+
+	plaintext, err := DecryptSecret(encrypted)
+	require.NoError(t, err)
+	require.Equal(t, "sk-upstream", plaintext)
+
+	plaintext, err = DecryptSecret("plain-value")
+	require.NoError(t, err)
+	require.Equal(t, "plain-value", plaintext)
+
+	tampered := encrypted[:len(encrypted)-2] + strings.Repeat("A", 2)
+	_, err = DecryptSecret(tampered)
+	require.Error(t, err)
+}
+
+func TestSecretEncryptionKeyRotation(t *testing.T) {
+	t.Cleanup(func() { _ = SetSecretEncryptionKeys("") })
+	require.NoError(t, SetSecretEncryptionKeys(testSecretKeyA))
+	encrypted, err := EncryptSecret("sk-upstream")
+	require.NoError(t, err)
+	require.False(t, SecretNeedsReencrypt(encrypted))
+	require.True(t, SecretNeedsReencrypt("sk-plain"))
+
+	require.NoError(t, SetSecretEncryptionKeys(testSecretKeyB))
+	_, err = DecryptSecret(encrypted)
+	require.Error(t, err)
+
+	require.NoError(t, SetSecretEncryptionKeys(testSecretKeyB, testSecretKeyA))
+	plaintext, err := DecryptSecret(encrypted)
+	require.NoError(t, err)
+	require.Equal(t, "sk-upstream", plaintext)
+	require.True(t, SecretNeedsReencrypt(encrypted))
+
+	// 未配置主密钥时不加密，已加密的数据需要还原
+	require.NoError(t, SetSecretEncryptionKeys("", testSecretKeyA))
+	value, err := EncryptSecret("sk-plain")
+	require.NoError(t, err)
+	require.Equal(t, "sk-plain", value)
+	require.True(t, SecretNeedsReencrypt(encrypted))
+}
+
+func TestSecretEncryptionInvalidKey(t *testing.T) {
+	require.Error(t, SetSecretEncryptionKeys("too-short"))
+	require.False(t, SecretEncryptionEnabled())
+}
@@ -9,6 +9,8 @@ const (
 
 	ContextKeyOriginalModel    ContextKey = "original_model"
 	ContextKeyRequestStartTime ContextKey = "request_start_time"
+	// ContextKeyRequestedModel 发生模型降级时记录客户端请求的模型
+	ContextKeyRequestedModel ContextKey = "requested_model"
 
 	/* token related keys */
 	ContextKeyTokenUnlimited         ContextKey = "token_unlimited_quota"
@@ -19,6 +21,18 @@ const (
 	ContextKeyTokenModelLimitEnabled ContextKey = "token_model_limit_enabled"
 	ContextKeyTokenModelLimit        ContextKey = "token_model_limit"
 	ContextKeyTokenCrossGroupRetry   ContextKey = "token_cross_group_retry"
+	ContextKeyTokenHedging           ContextKey = "token_hedging"
+	ContextKeyTokenModelFallback     ContextKey = "token_model_fallback"
+	ContextKeyTokenRateLimitRPM      ContextKey = "token_rate_limit_rpm"
+	ContextKeyTokenRateLimitTPM      ContextKey = "token_rate_limit_tpm"
+	ContextKeyTokenMaxConcurrency    ContextKey = "token_max_concurrency"
+	ContextKeyTokenBudgetQuota       ContextKey = "token_budget_quota"
+	ContextKeyTokenBudgetSoftLimit   ContextKey = "token_budget_soft_limit"
+	ContextKeyTokenParentId          ContextKey = "token_parent_id"
+	ContextKeyTokenJWTId             ContextKey = "token_jwt_id"
+	ContextKeyTokenJWTQuota          ContextKey = "token_jwt_quota"
+	ContextKeyTokenJWTExpiresAt      ContextKey = "token_jwt_expires_at"
+	ContextKeyTokenKeyVersion        ContextKey = "token_key_version"
 
 	/* channel related keys */
 	ContextKeyChannelId                ContextKey = "channel_id"
@@ -66,4 +80,10 @@ const (
 	// ContextKeyLanguage stores the user's language preference for i18n
 	ContextKeyLanguage ContextKey = "language"
 	ContextKeyIsStream ContextKey = "is_stream"
+
+	// ContextKeyBatchId marks requests replayed by the batch worker (/v1/batches)
+	ContextKeyBatchId ContextKey = "batch_id"
+
+	// ContextKeyResponsesWssSession holds the upstream websocket connections reused by a /v1/responses websocket connection
+	ContextKeyResponsesWssSession ContextKey = "responses_wss_session"
 )
@@ -15,6 +15,7 @@ var NotifyLimitCount int
 var NotificationLimitDurationMinute int
 var GenerateDefaultToken bool
 var ErrorLogEnabled bool
+var TokenKeyHashEnabled bool
 var TaskQueryLimit int
 var TaskTimeoutMinutes int
 
@@ -3,6 +3,9 @@ package constant
 type MultiKeyMode string
 
 const (
-	MultiKeyModeRandom  MultiKeyMode = "random"  // 随机
-	MultiKeyModePolling MultiKeyMode = "polling" // 轮询
+	MultiKeyModeRandom    MultiKeyMode = "random"     // 随机
+	MultiKeyModePolling   MultiKeyMode = "polling"    // 轮询
+	MultiKeyModeLeastUsed MultiKeyMode = "least_used" // 最久未使用
+	MultiKeyModeWeighted  MultiKeyMode = "weighted"   // 按声明的 RPM/TPM 加权
+	MultiKeyModeRateLimit MultiKeyMode = "rate_limit" // 遵循上游限流响应头
 )
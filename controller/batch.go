@@ -0,0 +1,278 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// batchSupportedEndpoints 批处理支持的接口及其中继格式
+var batchSupportedEndpoints = map[string]types.RelayFormat{
+	"/v1/chat/completions": types.RelayFormatOpenAI,
+	"/v1/completions":      types.RelayFormatOpenAI,
+	"/v1/moderations":      types.RelayFormatOpenAI,
+	"/v1/embeddings":       types.RelayFormatEmbedding,
+	"/v1/responses":        types.RelayFormatOpenAIResponses,
+}
+
+const (
+	batchCompletionWindow  = "24h"
+	batchCompletionSeconds = 24 * 3600
+	batchMetadataMaxKeys   = 16
+	batchListDefaultLimit  = 20
+	batchListMaxLimit      = 100
+	batchInputFilePurpose  = "batch"
+	batchOutputFilePurpose = "batch_output"
+)
+
+func int64PtrIfSet(value int64) *int64 {
+	if value == 0 {
+		return nil
+	}
+	return &value
+}
+
+func stringPtrIfSet(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+func toOpenAIBatch(batch *model.Batch) dto.OpenAIBatch {
+	openAIBatch := dto.OpenAIBatch{
+		Id:               batch.BatchId,
+		Object:           "batch",
+		Endpoint:         batch.Endpoint,
+		InputFileId:      batch.InputFileId,
+		CompletionWindow: batch.CompletionWindow,
+		Status:           batch.Status,
+		OutputFileId:     stringPtrIfSet(batch.OutputFileId),
+		ErrorFileId:      stringPtrIfSet(batch.ErrorFileId),
+		CreatedAt:        batch.CreatedAt,
+		InProgressAt:     int64PtrIfSet(batch.InProgressAt),
+		ExpiresAt:        int64PtrIfSet(batch.ExpiresAt),
+		FinalizingAt:     int64PtrIfSet(batch.FinalizingAt),
+		CompletedAt:      int64PtrIfSet(batch.CompletedAt),
+		FailedAt:         int64PtrIfSet(batch.FailedAt),
+		ExpiredAt:        int64PtrIfSet(batch.ExpiredAt),
+		CancellingAt:     int64PtrIfSet(batch.CancellingAt),
+		CancelledAt:      int64PtrIfSet(batch.CancelledAt),
+		RequestCounts: dto.OpenAIBatchRequestCounts{
+			Total:     batch.TotalCount,
+			Completed: batch.CompletedCount,
+			Failed:    batch.FailedCount,
+		},
+		Metadata: map[string]string{},
+	}
+	if batch.Errors != "" {
+		var batchErrors []dto.OpenAIBatchError
+		if err := common.UnmarshalJsonStr(batch.Errors, &batchErrors); err == nil && len(batchErrors) > 0 {
+			openAIBatch.Errors = &dto.OpenAIBatchErrors{Object: "list", Data: batchErrors}
+		}
+	}
+	if batch.Metadata != "" {
+		_ = common.UnmarshalJsonStr(batch.Metadata, &openAIBatch.Metadata)
+	}
+	return openAIBatch
+}
+
+func checkBatchApiEnabled(c *gin.Context) bool {
+	if !operation_setting.GetBatchSetting().Enabled || !operation_setting.GetFileSetting().Enabled {
+		RelayNotImplemented(c)
+		return false
+	}
+	return true
+}
+
+// getRequestUserBatch 获取路径参数中当前用户的批处理，失败时已写入响应
+func getRequestUserBatch(c *gin.Context) (*model.Batch, bool) {
+	batchId := c.Param("id")
+	batch, err := model.GetUserBatchById(c.GetInt("id"), batchId)
+	if err != nil {
+		if errors.Is(err, model.ErrBatchNotFound) {
+			writeInvalidRequestError(c, http.StatusNotFound, fmt.Sprintf("No batch found with id '%s'.", batchId), "batch_not_found")
+		} else {
+			writeInvalidRequestError(c, http.StatusInternalServerError, err.Error(), "query_data_error")
+		}
+		return nil, false
+	}
+	return batch, true
+}
+
+// CreateBatch POST /v1/batches
+func CreateBatch(c *gin.Context) {
+	if !checkBatchApiEnabled(c) {
+		return
+	}
+	// 批处理在后台使用创建时的令牌发起请求，JWT 令牌不落库，无法在后台鉴权
+	if common.GetContextKeyString(c, constant.ContextKeyTokenJWTId) != "" {
+		writeInvalidRequestError(c, http.StatusForbidden, "Batches cannot be created with JWT tokens, use an API key instead", "unsupported_token_type")
+		return
+	}
+	var req dto.OpenAIBatchRequest
+	if err := common.UnmarshalBodyReusable(c, &req); err != nil {
+		writeInvalidRequestError(c, http.StatusBadRequest, "Invalid request body: "+err.Error(), "invalid_request_body")
+		return
+	}
+	if _, ok := batchSupportedEndpoints[req.Endpoint]; !ok {
+		writeInvalidRequestError(c, http.StatusBadRequest, fmt.Sprintf("Unsupported endpoint: %q", req.Endpoint), "invalid_endpoint")
+		return
+	}
+	if req.CompletionWindow != batchCompletionWindow {
+		writeInvalidRequestError(c, http.StatusBadRequest, fmt.Sprintf("completion_window must be %q", batchCompletionWindow), "invalid_completion_window")
+		return
+	}
+	if len(req.Metadata) > batchMetadataMaxKeys {
+		writeInvalidRequestError(c, http.StatusBadRequest, fmt.Sprintf("metadata can have at most %d keys", batchMetadataMaxKeys), "invalid_metadata")
+		return
+	}
+	userId := c.GetInt("id")
+	inputFile, err := model.GetUserFileById(userId, req.InputFileId)
+	if err != nil {
+		if errors.Is(err, model.ErrFileNotFound) {
+			writeInvalidRequestError(c, http.StatusBadRequest, fmt.Sprintf("No such File object: %s", req.InputFileId), "invalid_input_file")
+		} else {
+			writeInvalidRequestError(c, http.StatusInternalServerError, err.Error(), "query_data_error")
+		}
+		return
+	}
+	if inputFile.Purpose != batchInputFilePurpose {
+		writeInvalidRequestError(c, http.StatusBadRequest, fmt.Sprintf("File %s must be uploaded with purpose %q", inputFile.FileId, batchInputFilePurpose), "invalid_input_file")
+		return
+	}
+
+	metadata := ""
+	if len(req.Metadata) > 0 {
+		data, err := common.Marshal(req.Metadata)
+		if err != nil {
+			writeInvalidRequestError(c, http.StatusBadRequest, err.Error(), "invalid_metadata")
+			return
+		}
+		metadata = string(data)
+	}
+	now := common.GetTimestamp()
+	batch := &model.Batch{
+		BatchId:          model.GenerateBatchId(),
+		UserId:           userId,
+		TokenId:          common.GetContextKeyInt(c, constant.ContextKeyTokenId),
+		Endpoint:         req.Endpoint,
+		InputFileId:      inputFile.FileId,
+		CompletionWindow: req.CompletionWindow,
+		Status:           model.BatchStatusValidating,
+		Metadata:         metadata,
+		CreatedAt:        now,
+		ExpiresAt:        now + batchCompletionSeconds,
+	}
+	if err := batch.Insert(); err != nil {
+		common.SysError("failed to create batch: " + err.Error())
+		writeInvalidRequestError(c, http.StatusInternalServerError, "Failed to create batch", "create_batch_failed")
+		return
+	}
+	c.JSON(http.StatusOK, toOpenAIBatch(batch))
+}
+
+// RetrieveBatch GET /v1/batches/:id
+func RetrieveBatch(c *gin.Context) {
+	if !checkBatchApiEnabled(c) {
+		return
+	}
+	batch, ok := getRequestUserBatch(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, toOpenAIBatch(batch))
+}
+
+// CancelBatch POST /v1/batches/:id/cancel
+func CancelBatch(c *gin.Context) {
+	if !checkBatchApiEnabled(c) {
+		return
+	}
+	batch, ok := getRequestUserBatch(c)
+	if !ok {
+		return
+	}
+	now := common.GetTimestamp()
+	var updated bool
+	var err error
+	switch batch.Status {
+	case model.BatchStatusValidating:
+		// 尚未开始执行，直接取消
+		updated, err = batch.UpdateWithStatus(model.BatchStatusValidating, map[string]any{
+			"status":        model.BatchStatusCancelled,
+			"cancelling_at": now,
+			"cancelled_at":  now,
+		})
+	case model.BatchStatusInProgress:
+		// 由后台任务停止执行并生成已完成部分的输出文件
+		updated, err = batch.UpdateWithStatus(model.BatchStatusInProgress, map[string]any{
+			"status":        model.BatchStatusCancelling,
+			"cancelling_at": now,
+		})
+	case model.BatchStatusCancelling, model.BatchStatusCancelled:
+		updated = true
+	}
+	if err != nil {
+		writeInvalidRequestError(c, http.StatusInternalServerError, err.Error(), "update_data_error")
+		return
+	}
+	if !updated {
+		writeInvalidRequestError(c, http.StatusConflict, fmt.Sprintf("Cannot cancel a batch with status '%s'.", batch.Status), "batch_not_cancellable")
+		return
+	}
+	batch, err = model.GetBatchById(batch.Id)
+	if err != nil {
+		writeInvalidRequestError(c, http.StatusInternalServerError, err.Error(), "query_data_error")
+		return
+	}
+	c.JSON(http.StatusOK, toOpenAIBatch(batch))
+}
+
+// ListBatches GET /v1/batches
+func ListBatches(c *gin.Context) {
+	if !checkBatchApiEnabled(c) {
+		return
+	}
+	limit := batchListDefaultLimit
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > batchListMaxLimit {
+			writeInvalidRequestError(c, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", batchListMaxLimit), "invalid_limit")
+			return
+		}
+		limit = parsed
+	}
+	batches, hasMore, err := model.ListUserBatches(c.GetInt("id"), c.Query("after"), limit)
+	if err != nil {
+		if errors.Is(err, model.ErrBatchNotFound) {
+			writeInvalidRequestError(c, http.StatusNotFound, fmt.Sprintf("No batch found with id '%s'.", c.Query("after")), "batch_not_found")
+			return
+		}
+		writeInvalidRequestError(c, http.StatusInternalServerError, err.Error(), "query_data_error")
+		return
+	}
+	resp := dto.OpenAIBatchList{
+		Object:  "list",
+		Data:    make([]dto.OpenAIBatch, 0, len(batches)),
+		HasMore: hasMore,
+	}
+	for _, batch := range batches {
+		resp.Data = append(resp.Data, toOpenAIBatch(batch))
+	}
+	if len(resp.Data) > 0 {
+		resp.FirstId = resp.Data[0].Id
+		resp.LastId = resp.Data[len(resp.Data)-1].Id
+	}
+	c.JSON(http.StatusOK, resp)
+}
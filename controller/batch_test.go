@@ -0,0 +1,188 @@
+package controller
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func setupBatchControllerTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	common.UsingSQLite = true
+	common.UsingMySQL = false
+	common.UsingPostgreSQL = false
+	common.RedisEnabled = false
+
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"))
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	require.NoError(t, err)
+	model.DB = db
+	model.LOG_DB = db
+
+	require.NoError(t, db.AutoMigrate(&model.User{}, &model.Token{}, &model.File{}, &model.Batch{}, &model.BatchItem{}))
+	common.SetFileStorage(&common.LocalFileStorage{Dir: t.TempDir()})
+
+	t.Cleanup(func() {
+		common.SetFileStorage(nil)
+		sqlDB, err := db.DB()
+		if err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+
+	return db
+}
+
+func createBatchInputFile(t *testing.T, userId int, content string) *model.File {
+	t.Helper()
+	file, err := service.CreateFile(service.CreateFileParams{
+		UserId:   userId,
+		Filename: "input.jsonl",
+		Purpose:  batchInputFilePurpose,
+		Size:     int64(len(content)),
+	}, strings.NewReader(content))
+	require.NoError(t, err)
+	return file
+}
+
+func createTestBatch(t *testing.T, userId int, inputFileId string) *model.Batch {
+	t.Helper()
+	now := common.GetTimestamp()
+	batch := &model.Batch{
+		BatchId:          model.GenerateBatchId(),
+		UserId:           userId,
+		TokenId:          999,
+		Endpoint:         "/v1/chat/completions",
+		InputFileId:      inputFileId,
+		CompletionWindow: batchCompletionWindow,
+		Status:           model.BatchStatusValidating,
+		CreatedAt:        now,
+		ExpiresAt:        now + batchCompletionSeconds,
+	}
+	require.NoError(t, batch.Insert())
+	return batch
+}
+
+func TestValidateBatchRejectsInvalidLines(t *testing.T) {
+	setupBatchControllerTestDB(t)
+	input := strings.Join([]string{
+		`{"custom_id":"a","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o-mini"}}`,
+		`{"custom_id":"a","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o-mini"}}`,
+		`{"custom_id":"b","method":"POST","url":"/v1/embeddings","body":{"model":"text-embedding-3-small"}}`,
+		`not json`,
+	}, "\n")
+	file := createBatchInputFile(t, 1, input)
+	batch := createTestBatch(t, 1, file.FileId)
+
+	validateBatch(context.Background(), batch)
+
+	stored, err := model.GetBatchById(batch.Id)
+	require.NoError(t, err)
+	assert.Equal(t, model.BatchStatusFailed, stored.Status)
+	openAIBatch := toOpenAIBatch(stored)
+	require.NotNil(t, openAIBatch.Errors)
+	require.Len(t, openAIBatch.Errors.Data, 3)
+	assert.Equal(t, "duplicate_custom_id", openAIBatch.Errors.Data[0].Code)
+	assert.Equal(t, 2, *openAIBatch.Errors.Data[0].Line)
+	assert.Equal(t, "mismatched_endpoint", openAIBatch.Errors.Data[1].Code)
+	assert.Equal(t, "invalid_json_line", openAIBatch.Errors.Data[2].Code)
+}
+
+func TestProcessBatchWritesErrorFile(t *testing.T) {
+	setupBatchControllerTestDB(t)
+	input := `{"custom_id":"a","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o-mini","stream":true}}` + "\n\n" +
+		`{"custom_id":"b","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o-mini"}}` + "\n"
+	file := createBatchInputFile(t, 1, input)
+	batch := createTestBatch(t, 1, file.FileId)
+
+	validateBatch(context.Background(), batch)
+	stored, err := model.GetBatchById(batch.Id)
+	require.NoError(t, err)
+	require.Equal(t, model.BatchStatusInProgress, stored.Status)
+	require.Equal(t, 2, stored.TotalCount)
+
+	// 令牌不存在，每一行都应以 401 写入错误文件
+	processBatch(context.Background(), stored)
+
+	stored, err = model.GetBatchById(batch.Id)
+	require.NoError(t, err)
+	assert.Equal(t, model.BatchStatusCompleted, stored.Status)
+	assert.Equal(t, 0, stored.CompletedCount)
+	assert.Equal(t, 2, stored.FailedCount)
+	assert.Empty(t, stored.OutputFileId)
+	require.NotEmpty(t, stored.ErrorFileId)
+
+	errorFile, err := model.GetUserFileById(1, stored.ErrorFileId)
+	require.NoError(t, err)
+	assert.Equal(t, batchOutputFilePurpose, errorFile.Purpose)
+	content, err := service.GetFileStorage().Open(errorFile.StorageKey)
+	require.NoError(t, err)
+	defer content.Close()
+
+	customIds := make(map[string]int)
+	scanner := bufio.NewScanner(content)
+	for scanner.Scan() {
+		var line dto.OpenAIBatchOutputLine
+		require.NoError(t, common.Unmarshal(scanner.Bytes(), &line))
+		require.NotNil(t, line.Response)
+		customIds[line.CustomId] = line.Response.StatusCode
+	}
+	assert.Equal(t, map[string]int{"a": 401, "b": 401}, customIds)
+
+	items, err := model.GetBatchItems(batch.Id, -1, 10)
+	require.NoError(t, err)
+	assert.Empty(t, items)
+}
+
+func TestProcessBatchAppliesTokenRateLimit(t *testing.T) {
+	db := setupBatchControllerTestDB(t)
+	origInterval := batchRateLimitRetryInterval
+	batchRateLimitRetryInterval = 10 * time.Millisecond
+	t.Cleanup(func() { batchRateLimitRetryInterval = origInterval })
+
+	user := &model.User{Username: "batch_rpm", Password: "password123", Status: common.UserStatusEnabled, Group: "default"}
+	require.NoError(t, db.Create(user).Error)
+	token := &model.Token{UserId: user.Id, Key: "batchRateLimitTokenKey000000000000000000000000", Name: "batch", Status: common.TokenStatusEnabled,
+		ExpiredTime: -1, UnlimitedQuota: true, RateLimitRPM: 1}
+	require.NoError(t, db.Create(token).Error)
+
+	input := `{"custom_id":"a","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o-mini"}}` + "\n" +
+		`{"custom_id":"b","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o-mini"}}` + "\n"
+	file := createBatchInputFile(t, user.Id, input)
+	batch := createTestBatch(t, user.Id, file.FileId)
+	// 完成时限很短，被限流的行在重试到期后以 429 结束
+	require.NoError(t, db.Model(batch).Updates(map[string]any{"token_id": token.Id, "expires_at": common.GetTimestamp() + 1}).Error)
+	stored, err := model.GetBatchById(batch.Id)
+	require.NoError(t, err)
+	validateBatch(context.Background(), stored)
+	stored, err = model.GetBatchById(batch.Id)
+	require.NoError(t, err)
+	require.Equal(t, model.BatchStatusInProgress, stored.Status)
+
+	statuses := make(map[int]int)
+	for lineIndex, line := range strings.Split(strings.TrimSpace(input), "\n") {
+		item := executeBatchLineWithRetry(context.Background(), stored, lineIndex, []byte(line))
+		var output dto.OpenAIBatchOutputLine
+		require.NoError(t, common.Unmarshal([]byte(item.Output), &output))
+		require.NotNil(t, output.Response)
+		statuses[output.Response.StatusCode]++
+	}
+	// 每分钟 1 次请求的令牌只放行第一行
+	assert.Equal(t, 1, statuses[http.StatusTooManyRequests])
+}
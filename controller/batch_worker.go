@@ -0,0 +1,585 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/middleware"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	batchWorkerTickInterval   = 5 * time.Second
+	batchStatusCheckInterval  = 2 * time.Second
+	batchItemPageSize         = 500
+	batchMaxValidationErrors  = 100
+	batchInputLineMaxBytes    = 64 << 20
+	batchValidatingFetchLimit = 20
+)
+
+// batchRateLimitRetryInterval 批处理请求被限流时等待后重试的间隔
+var batchRateLimitRetryInterval = 5 * time.Second
+
+var (
+	batchWorkerOnce    sync.Once
+	batchWorkerRunning atomic.Bool
+	runningBatches     sync.Map
+	runningBatchCount  atomic.Int32
+
+	batchEngineOnce sync.Once
+	batchEngine     *gin.Engine
+)
+
+// batchContextKey 存放于内部请求的 context 中，客户端无法伪造
+type batchContextKey struct{}
+
+// StartBatchWorker 启动批处理后台任务，仅在主节点运行
+func StartBatchWorker() {
+	batchWorkerOnce.Do(func() {
+		if !common.IsMasterNode {
+			return
+		}
+		gopool.Go(func() {
+			logger.LogInfo(context.Background(), fmt.Sprintf("batch worker started: tick=%s", batchWorkerTickInterval))
+			ticker := time.NewTicker(batchWorkerTickInterval)
+			defer ticker.Stop()
+
+			runBatchWorkerOnce()
+			for range ticker.C {
+				runBatchWorkerOnce()
+			}
+		})
+	})
+}
+
+func runBatchWorkerOnce() {
+	if !batchWorkerRunning.CompareAndSwap(false, true) {
+		return
+	}
+	defer batchWorkerRunning.Store(false)
+
+	ctx := context.Background()
+	validating, err := model.GetBatchesByStatus([]string{model.BatchStatusValidating}, batchValidatingFetchLimit)
+	if err != nil {
+		logger.LogWarn(ctx, fmt.Sprintf("batch worker failed to fetch batches: %v", err))
+		return
+	}
+	for _, batch := range validating {
+		validateBatch(ctx, batch)
+	}
+
+	maxRunning := operation_setting.GetBatchSetting().MaxRunningBatches
+	if maxRunning <= 0 {
+		maxRunning = 1
+	}
+	active, err := model.GetBatchesByStatus([]string{
+		model.BatchStatusInProgress,
+		model.BatchStatusCancelling,
+		model.BatchStatusFinalizing,
+	}, maxRunning*4)
+	if err != nil {
+		logger.LogWarn(ctx, fmt.Sprintf("batch worker failed to fetch batches: %v", err))
+		return
+	}
+	for _, batch := range active {
+		if int(runningBatchCount.Load()) >= maxRunning {
+			break
+		}
+		if _, loaded := runningBatches.LoadOrStore(batch.Id, true); loaded {
+			continue
+		}
+		runningBatchCount.Add(1)
+		batch := batch
+		gopool.Go(func() {
+			defer func() {
+				if r := recover(); r != nil {
+					logger.LogError(ctx, fmt.Sprintf("batch %s panic: %v", batch.BatchId, r))
+				}
+				runningBatches.Delete(batch.Id)
+				runningBatchCount.Add(-1)
+			}()
+			processBatch(ctx, batch)
+		})
+	}
+}
+
+// readBatchInputLines 逐行读取输入文件，跳过空行，lineIndex 为从 0 开始的物理行号
+func readBatchInputLines(batch *model.Batch, fn func(lineIndex int, line []byte) bool) error {
+	inputFile, err := model.GetUserFileById(batch.UserId, batch.InputFileId)
+	if err != nil {
+		return err
+	}
+	content, err := service.GetFileStorage().Open(inputFile.StorageKey)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	scanner := bufio.NewScanner(content)
+	scanner.Buffer(make([]byte, 0, 64*1024), batchInputLineMaxBytes)
+	lineIndex := -1
+	for scanner.Scan() {
+		lineIndex++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if !fn(lineIndex, line) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+func validateBatch(ctx context.Context, batch *model.Batch) {
+	var batchErrors []dto.OpenAIBatchError
+	addError := func(lineIndex int, code string, message string) {
+		if len(batchErrors) >= batchMaxValidationErrors {
+			return
+		}
+		line := lineIndex + 1
+		batchErrors = append(batchErrors, dto.OpenAIBatchError{Code: code, Message: message, Line: &line})
+	}
+
+	maxRequests := operation_setting.GetBatchSetting().MaxRequestsPerBatch
+	customIds := make(map[string]bool)
+	total := 0
+	err := readBatchInputLines(batch, func(lineIndex int, line []byte) bool {
+		total++
+		var input dto.OpenAIBatchInputLine
+		if err := common.Unmarshal(line, &input); err != nil {
+			addError(lineIndex, "invalid_json_line", "This line is not parseable as valid JSON.")
+			return true
+		}
+		switch {
+		case input.CustomId == "":
+			addError(lineIndex, "missing_required_parameter", "Missing required parameter: 'custom_id'.")
+		case customIds[input.CustomId]:
+			addError(lineIndex, "duplicate_custom_id", fmt.Sprintf("The custom_id '%s' is not unique.", input.CustomId))
+		case input.Method != http.MethodPost:
+			addError(lineIndex, "invalid_method", "The method must be 'POST'.")
+		case input.Url != batch.Endpoint:
+			addError(lineIndex, "mismatched_endpoint", fmt.Sprintf("The url '%s' does not match the batch endpoint '%s'.", input.Url, batch.Endpoint))
+		case input.Body == nil:
+			addError(lineIndex, "missing_required_parameter", "Missing required parameter: 'body'.")
+		}
+		customIds[input.CustomId] = true
+		return true
+	})
+	if err != nil {
+		if errors.Is(err, model.ErrFileNotFound) {
+			batchErrors = append(batchErrors, dto.OpenAIBatchError{Code: "invalid_input_file", Message: fmt.Sprintf("Input file %s no longer exists.", batch.InputFileId)})
+		} else {
+			logger.LogWarn(ctx, fmt.Sprintf("failed to read batch %s input file: %v", batch.BatchId, err))
+			batchErrors = append(batchErrors, dto.OpenAIBatchError{Code: "invalid_input_file", Message: "Failed to read input file."})
+		}
+	} else if total == 0 {
+		batchErrors = append(batchErrors, dto.OpenAIBatchError{Code: "empty_file", Message: "The input file is empty."})
+	} else if maxRequests > 0 && total > maxRequests {
+		batchErrors = append(batchErrors, dto.OpenAIBatchError{Code: "too_many_requests", Message: fmt.Sprintf("The input file contains %d requests, exceeding the limit of %d.", total, maxRequests)})
+	}
+
+	now := common.GetTimestamp()
+	var fields map[string]any
+	if len(batchErrors) > 0 {
+		errorsJson, _ := common.Marshal(batchErrors)
+		fields = map[string]any{
+			"status":    model.BatchStatusFailed,
+			"errors":    string(errorsJson),
+			"failed_at": now,
+		}
+	} else {
+		fields = map[string]any{
+			"status":         model.BatchStatusInProgress,
+			"total_count":    total,
+			"in_progress_at": now,
+		}
+	}
+	if _, err := batch.UpdateWithStatus(model.BatchStatusValidating, fields); err != nil {
+		logger.LogWarn(ctx, fmt.Sprintf("failed to update batch %s: %v", batch.BatchId, err))
+	}
+}
+
+func processBatch(ctx context.Context, batch *model.Batch) {
+	if batch.Status == model.BatchStatusInProgress {
+		if err := runBatchLines(ctx, batch); err != nil {
+			logger.LogWarn(ctx, fmt.Sprintf("batch %s stopped: %v", batch.BatchId, err))
+			if errors.Is(err, model.ErrFileNotFound) {
+				errorsJson, _ := common.Marshal([]dto.OpenAIBatchError{{Code: "invalid_input_file", Message: fmt.Sprintf("Input file %s no longer exists.", batch.InputFileId)}})
+				_, _ = batch.UpdateWithStatus(model.BatchStatusInProgress, map[string]any{
+					"status":    model.BatchStatusFailed,
+					"errors":    string(errorsJson),
+					"failed_at": common.GetTimestamp(),
+				})
+			}
+			return
+		}
+		refreshed, err := model.GetBatchById(batch.Id)
+		if err != nil {
+			logger.LogWarn(ctx, fmt.Sprintf("failed to reload batch %s: %v", batch.BatchId, err))
+			return
+		}
+		batch = refreshed
+	}
+	if batch.Status == model.BatchStatusInProgress {
+		updated, err := batch.UpdateWithStatus(model.BatchStatusInProgress, map[string]any{
+			"status":        model.BatchStatusFinalizing,
+			"finalizing_at": common.GetTimestamp(),
+		})
+		if err != nil || !updated {
+			return
+		}
+		batch.Status = model.BatchStatusFinalizing
+	}
+	if batch.Status != model.BatchStatusFinalizing && batch.Status != model.BatchStatusCancelling {
+		return
+	}
+	if err := finalizeBatch(ctx, batch); err != nil {
+		logger.LogWarn(ctx, fmt.Sprintf("failed to finalize batch %s: %v", batch.BatchId, err))
+	}
+}
+
+// runBatchLines 依次执行尚未处理的行，在批处理被取消或超过完成时限时提前结束
+func runBatchLines(ctx context.Context, batch *model.Batch) error {
+	processed, err := model.GetBatchItemLineIndexes(batch.Id)
+	if err != nil {
+		return err
+	}
+	concurrency := operation_setting.GetBatchSetting().Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	semaphore := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	lastStatusCheck := time.Now()
+
+	readErr := readBatchInputLines(batch, func(lineIndex int, line []byte) bool {
+		if processed[lineIndex] {
+			return true
+		}
+		if common.GetTimestamp() >= batch.ExpiresAt {
+			return false
+		}
+		if time.Since(lastStatusCheck) >= batchStatusCheckInterval {
+			lastStatusCheck = time.Now()
+			if status, err := model.GetBatchStatus(batch.Id); err == nil && status != model.BatchStatusInProgress {
+				return false
+			}
+		}
+		semaphore <- struct{}{}
+		wg.Add(1)
+		lineCopy := append([]byte(nil), line...)
+		gopool.Go(func() {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			item := executeBatchLineWithRetry(ctx, batch, lineIndex, lineCopy)
+			if err := model.InsertBatchItem(item); err != nil {
+				logger.LogWarn(ctx, fmt.Sprintf("failed to save batch %s line %d result: %v", batch.BatchId, lineIndex+1, err))
+			}
+		})
+		return true
+	})
+	wg.Wait()
+	return readErr
+}
+
+// executeBatchLineWithRetry 请求被限流（429）时等待后重试，直到超过完成时限或批处理不再执行
+func executeBatchLineWithRetry(ctx context.Context, batch *model.Batch, lineIndex int, line []byte) *model.BatchItem {
+	for {
+		item, status := executeBatchLine(ctx, batch, lineIndex, line)
+		if status != http.StatusTooManyRequests || !waitBatchRetry(batch) {
+			return item
+		}
+	}
+}
+
+func waitBatchRetry(batch *model.Batch) bool {
+	if common.GetTimestamp()+int64(batchRateLimitRetryInterval/time.Second) >= batch.ExpiresAt {
+		return false
+	}
+	time.Sleep(batchRateLimitRetryInterval)
+	status, err := model.GetBatchStatus(batch.Id)
+	return err == nil && status == model.BatchStatusInProgress
+}
+
+// executeBatchLine 通过内部 gin 引擎将一行请求重放到普通中继流程，计费、日志与限流与普通请求一致，
+// 返回结果与响应状态码
+func executeBatchLine(ctx context.Context, batch *model.Batch, lineIndex int, line []byte) (*model.BatchItem, int) {
+	item := &model.BatchItem{BatchId: batch.Id, LineIndex: lineIndex}
+	output := dto.OpenAIBatchOutputLine{Id: "batch_req_" + common.GetUUID()}
+
+	var input dto.OpenAIBatchInputLine
+	if err := common.Unmarshal(line, &input); err != nil {
+		output.Error = &dto.OpenAIBatchError{Code: "invalid_json_line", Message: "This line is not parseable as valid JSON."}
+		item.Output = marshalBatchOutputLine(output)
+		return item, 0
+	}
+	output.CustomId = input.CustomId
+
+	body, err := common.Marshal(input.Body)
+	if err == nil {
+		// 批处理不支持流式输出
+		body, err = sjson.DeleteBytes(body, "stream")
+	}
+	if err == nil {
+		body, err = sjson.DeleteBytes(body, "stream_options")
+	}
+	if err != nil {
+		output.Error = &dto.OpenAIBatchError{Code: "invalid_body", Message: err.Error()}
+		item.Output = marshalBatchOutputLine(output)
+		return item, 0
+	}
+
+	reqCtx := context.WithValue(ctx, batchContextKey{}, batch)
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, batch.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		output.Error = &dto.OpenAIBatchError{Code: "invalid_request", Message: err.Error()}
+		item.Output = marshalBatchOutputLine(output)
+		return item, 0
+	}
+	req.Header.Set("Content-Type", "application/json")
+	writer := newBatchResponseWriter()
+	getBatchEngine().ServeHTTP(writer, req)
+
+	respBody := writer.body.Bytes()
+	response := &dto.OpenAIBatchOutputResponse{
+		StatusCode: writer.status,
+		RequestId:  writer.header.Get(common.RequestIdKey),
+		Body:       string(respBody),
+	}
+	var jsonBody any
+	if err := common.Unmarshal(respBody, &jsonBody); err == nil {
+		response.Body = jsonBody
+	}
+	output.Response = response
+	item.Succeeded = writer.status >= 200 && writer.status < 300
+	item.Output = marshalBatchOutputLine(output)
+	return item, writer.status
+}
+
+func marshalBatchOutputLine(output dto.OpenAIBatchOutputLine) string {
+	data, err := common.Marshal(output)
+	if err != nil {
+		return fmt.Sprintf(`{"id":%q,"custom_id":%q,"response":null,"error":{"code":"internal_error","message":%q}}`, output.Id, output.CustomId, err.Error())
+	}
+	return string(data)
+}
+
+// finalizeBatch 根据已处理的结果生成输出文件与错误文件，并将批处理置为终态
+func finalizeBatch(ctx context.Context, batch *model.Batch) error {
+	outputFile, errorFile, err := writeBatchResultFiles(batch)
+	if err != nil {
+		return err
+	}
+
+	now := common.GetTimestamp()
+	fields := map[string]any{}
+	if outputFile != nil {
+		fields["output_file_id"] = outputFile.FileId
+	}
+	if errorFile != nil {
+		fields["error_file_id"] = errorFile.FileId
+	}
+	switch {
+	case batch.Status == model.BatchStatusCancelling:
+		fields["status"] = model.BatchStatusCancelled
+		fields["cancelled_at"] = now
+	case batch.CompletedCount+batch.FailedCount < batch.TotalCount && now >= batch.ExpiresAt:
+		fields["status"] = model.BatchStatusExpired
+		fields["expired_at"] = now
+	default:
+		fields["status"] = model.BatchStatusCompleted
+		fields["completed_at"] = now
+	}
+	updated, err := batch.UpdateWithStatus(batch.Status, fields)
+	if err != nil || !updated {
+		// 状态已被其他流程修改，清理本次生成的文件
+		for _, file := range []*model.File{outputFile, errorFile} {
+			if file != nil {
+				_ = service.DeleteFile(file)
+			}
+		}
+		return err
+	}
+	if err := model.DeleteBatchItems(batch.Id); err != nil {
+		logger.LogWarn(ctx, fmt.Sprintf("failed to delete batch %s items: %v", batch.BatchId, err))
+	}
+	logger.LogInfo(ctx, fmt.Sprintf("batch %s finished: status=%v, completed=%d, failed=%d", batch.BatchId, fields["status"], batch.CompletedCount, batch.FailedCount))
+	return nil
+}
+
+func writeBatchResultFiles(batch *model.Batch) (outputFile *model.File, errorFile *model.File, err error) {
+	outputPath, outputTmp, err := common.CreateDiskCacheFile(common.DiskCacheTypeFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		outputTmp.Close()
+		_ = os.Remove(outputPath)
+	}()
+	errorPath, errorTmp, err := common.CreateDiskCacheFile(common.DiskCacheTypeFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		errorTmp.Close()
+		_ = os.Remove(errorPath)
+	}()
+
+	outputCount, errorCount := 0, 0
+	afterLineIndex := -1
+	for {
+		items, err := model.GetBatchItems(batch.Id, afterLineIndex, batchItemPageSize)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, item := range items {
+			target := outputTmp
+			if item.Succeeded {
+				outputCount++
+			} else {
+				target = errorTmp
+				errorCount++
+			}
+			if _, err := io.WriteString(target, item.Output+"\n"); err != nil {
+				return nil, nil, err
+			}
+			afterLineIndex = item.LineIndex
+		}
+		if len(items) < batchItemPageSize {
+			break
+		}
+	}
+
+	createResultFile := func(tmp *os.File, suffix string) (*model.File, error) {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return service.CreateFile(service.CreateFileParams{
+			UserId:      batch.UserId,
+			TokenId:     batch.TokenId,
+			Filename:    fmt.Sprintf("%s_%s.jsonl", batch.BatchId, suffix),
+			ContentType: "application/jsonl",
+			Purpose:     batchOutputFilePurpose,
+			SkipQuota:   true,
+		}, tmp)
+	}
+	if outputCount > 0 {
+		if outputFile, err = createResultFile(outputTmp, "output"); err != nil {
+			return nil, nil, err
+		}
+	}
+	if errorCount > 0 {
+		if errorFile, err = createResultFile(errorTmp, "error"); err != nil {
+			if outputFile != nil {
+				_ = service.DeleteFile(outputFile)
+			}
+			return nil, nil, err
+		}
+	}
+	return outputFile, errorFile, nil
+}
+
+// getBatchEngine 构建用于重放批处理请求的内部 gin 引擎
+func getBatchEngine() *gin.Engine {
+	batchEngineOnce.Do(func() {
+		engine := gin.New()
+		engine.Use(gin.CustomRecovery(func(c *gin.Context, err any) {
+			common.SysLog(fmt.Sprintf("batch request panic detected: %v", err))
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": types.OpenAIError{
+					Message: fmt.Sprintf("Panic detected, error: %v", err),
+					Type:    "new_api_panic",
+				},
+			})
+		}))
+		engine.Use(middleware.RequestId())
+		engine.Use(middleware.BodyStorageCleanup())
+		engine.Use(batchTokenAuth())
+		// 与普通请求共用模型请求限流与令牌 RPM 限流
+		engine.Use(middleware.ModelRequestRateLimit())
+		engine.Use(middleware.TokenRateLimit())
+		engine.Use(middleware.Distribute())
+		for endpoint, relayFormat := range batchSupportedEndpoints {
+			relayFormat := relayFormat
+			engine.POST(endpoint, func(c *gin.Context) {
+				Relay(c, relayFormat)
+			})
+		}
+		batchEngine = engine
+	})
+	return batchEngine
+}
+
+// batchTokenAuth 使用批处理创建时的令牌为内部请求鉴权
+func batchTokenAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		batch, ok := c.Request.Context().Value(batchContextKey{}).(*model.Batch)
+		if !ok || batch == nil {
+			writeInvalidRequestError(c, http.StatusUnauthorized, "missing batch context", "invalid_api_key")
+			c.Abort()
+			return
+		}
+		token, err := model.ValidateUserTokenById(batch.TokenId)
+		if err != nil || token.UserId != batch.UserId {
+			writeInvalidRequestError(c, http.StatusUnauthorized, "The token used to create this batch is no longer valid", "invalid_api_key")
+			c.Abort()
+			return
+		}
+		if !middleware.SetupContextForTokenUser(c, token) {
+			return
+		}
+		if err := middleware.SetupContextForToken(c, token); err != nil {
+			return
+		}
+		common.SetContextKey(c, constant.ContextKeyBatchId, batch.BatchId)
+		c.Next()
+	}
+}
+
+// batchResponseWriter 收集内部请求的响应
+type batchResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBatchResponseWriter() *batchResponseWriter {
+	return &batchResponseWriter{header: http.Header{}, status: http.StatusOK}
+}
+
+func (w *batchResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *batchResponseWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *batchResponseWriter) WriteHeader(statusCode int) {
+	w.status = statusCode
+}
+
+func (w *batchResponseWriter) Flush() {}
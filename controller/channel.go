@@ -13,6 +13,8 @@ import (
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/pkg/billingexpr"
+	keyusage "github.com/QuantumNous/new-api/pkg/key_usage"
 	relaychannel "github.com/QuantumNous/new-api/relay/channel"
 	"github.com/QuantumNous/new-api/relay/channel/gemini"
 	"github.com/QuantumNous/new-api/relay/channel/ollama"
@@ -460,6 +462,16 @@ func validateChannel(channel *model.Channel, isAdd bool) error {
 		return fmt.Errorf("渠道额外设置[channel setting] 格式错误：%s", err.Error())
 	}
 
+	// 校验上游成本表达式
+	for modelName, upstreamCost := range channel.GetOtherSettings().UpstreamCost {
+		if upstreamCost.Expr == "" {
+			continue
+		}
+		if _, err := billingexpr.CompileFromCache(upstreamCost.Expr); err != nil {
+			return fmt.Errorf("模型 %s 的上游成本表达式错误：%s", modelName, err.Error())
+		}
+	}
+
 	// 如果是添加操作，检查 channel 和 key 是否为空
 	if isAdd {
 		if channel == nil || channel.Key == "" {
@@ -1231,11 +1243,13 @@ func CopyChannel(c *gin.Context) {
 // MultiKeyManageRequest represents the request for multi-key management operations
 type MultiKeyManageRequest struct {
 	ChannelId int    `json:"channel_id"`
-	Action    string `json:"action"`              // "disable_key", "enable_key", "delete_key", "delete_disabled_keys", "get_key_status"
-	KeyIndex  *int   `json:"key_index,omitempty"` // for disable_key, enable_key, and delete_key actions
+	Action    string `json:"action"`              // "disable_key", "enable_key", "delete_key", "delete_disabled_keys", "get_key_status", "set_key_limit"
+	KeyIndex  *int   `json:"key_index,omitempty"` // for disable_key, enable_key, delete_key and set_key_limit actions
 	Page      int    `json:"page,omitempty"`      // for get_key_status pagination
 	PageSize  int    `json:"page_size,omitempty"` // for get_key_status pagination
 	Status    *int   `json:"status,omitempty"`    // for get_key_status filtering: 1=enabled, 2=manual_disabled, 3=auto_disabled, nil=all
+	RPM       int    `json:"rpm,omitempty"`       // for set_key_limit, 0 means unlimited
+	TPM       int    `json:"tpm,omitempty"`       // for set_key_limit, 0 means unlimited
 }
 
 // MultiKeyStatusResponse represents the response for key status query
@@ -1257,6 +1271,9 @@ type KeyStatus struct {
 	DisabledTime int64  `json:"disabled_time,omitempty"`
 	Reason       string `json:"reason,omitempty"`
 	KeyPreview   string `json:"key_preview"` // first 10 chars of key for identification
+	// Limit is the declared upstream limit of the key, Usage is the usage counted on this node
+	Limit *model.MultiKeyLimit `json:"limit,omitempty"`
+	Usage keyusage.Usage       `json:"usage"`
 }
 
 // ManageMultiKeys handles multi-key management operations
@@ -1344,13 +1361,18 @@ func ManageMultiKeys(c *gin.Context) {
 				keyPreview = key[:10] + "..."
 			}
 
-			allKeyStatusList = append(allKeyStatusList, KeyStatus{
+			keyStatus := KeyStatus{
 				Index:        i,
 				Status:       status,
 				DisabledTime: disabledTime,
 				Reason:       reason,
 				KeyPreview:   keyPreview,
-			})
+				Usage:        keyusage.Get(channel.Id, i),
+			}
+			if limit, ok := channel.ChannelInfo.MultiKeyLimits[i]; ok {
+				keyStatus.Limit = &limit
+			}
+			allKeyStatusList = append(allKeyStatusList, keyStatus)
 		}
 
 		// Apply status filter if specified
@@ -1560,6 +1582,53 @@ func ManageMultiKeys(c *gin.Context) {
 		})
 		return
 
+	case "set_key_limit":
+		if request.KeyIndex == nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "未指定要设置限额的密钥索引",
+			})
+			return
+		}
+
+		keyIndex := *request.KeyIndex
+		if keyIndex < 0 || keyIndex >= channel.ChannelInfo.MultiKeySize {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "密钥索引超出范围",
+			})
+			return
+		}
+		if request.RPM < 0 || request.TPM < 0 {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "限额不能为负数",
+			})
+			return
+		}
+
+		if channel.ChannelInfo.MultiKeyLimits == nil {
+			channel.ChannelInfo.MultiKeyLimits = make(map[int]model.MultiKeyLimit)
+		}
+		if request.RPM == 0 && request.TPM == 0 {
+			delete(channel.ChannelInfo.MultiKeyLimits, keyIndex)
+		} else {
+			channel.ChannelInfo.MultiKeyLimits[keyIndex] = model.MultiKeyLimit{RPM: request.RPM, TPM: request.TPM}
+		}
+
+		err = channel.Update()
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+
+		model.InitChannelCache()
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "密钥限额已更新",
+		})
+		return
+
 	case "delete_key":
 		if request.KeyIndex == nil {
 			c.JSON(http.StatusOK, gin.H{
@@ -1583,6 +1652,7 @@ func ManageMultiKeys(c *gin.Context) {
 		var newStatusList = make(map[int]int)
 		var newDisabledTime = make(map[int]int64)
 		var newDisabledReason = make(map[int]string)
+		var newLimits = make(map[int]model.MultiKeyLimit)
 
 		newIndex := 0
 		for i, key := range keys {
@@ -1609,6 +1679,9 @@ func ManageMultiKeys(c *gin.Context) {
 					newDisabledReason[newIndex] = r
 				}
 			}
+			if limit, exists := channel.ChannelInfo.MultiKeyLimits[i]; exists {
+				newLimits[newIndex] = limit
+			}
 			newIndex++
 		}
 
@@ -1626,6 +1699,7 @@ func ManageMultiKeys(c *gin.Context) {
 		channel.ChannelInfo.MultiKeyStatusList = newStatusList
 		channel.ChannelInfo.MultiKeyDisabledTime = newDisabledTime
 		channel.ChannelInfo.MultiKeyDisabledReason = newDisabledReason
+		channel.ChannelInfo.MultiKeyLimits = newLimits
 
 		err = channel.Update()
 		if err != nil {
@@ -1633,6 +1707,8 @@ func ManageMultiKeys(c *gin.Context) {
 			return
 		}
 
+		// 密钥索引已变化，清空按索引统计的使用情况
+		keyusage.ResetChannel(channel.Id)
 		model.InitChannelCache()
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
@@ -1647,6 +1723,7 @@ func ManageMultiKeys(c *gin.Context) {
 		var newStatusList = make(map[int]int)
 		var newDisabledTime = make(map[int]int64)
 		var newDisabledReason = make(map[int]string)
+		var newLimits = make(map[int]model.MultiKeyLimit)
 
 		newIndex := 0
 		for i, key := range keys {
@@ -1662,6 +1739,9 @@ func ManageMultiKeys(c *gin.Context) {
 				deletedCount++
 			} else {
 				remainingKeys = append(remainingKeys, key)
+				if limit, exists := channel.ChannelInfo.MultiKeyLimits[i]; exists {
+					newLimits[newIndex] = limit
+				}
 				// 保留非自动禁用密钥的状态信息，重新索引
 				if status != 1 {
 					newStatusList[newIndex] = status
@@ -1694,6 +1774,7 @@ func ManageMultiKeys(c *gin.Context) {
 		channel.ChannelInfo.MultiKeyStatusList = newStatusList
 		channel.ChannelInfo.MultiKeyDisabledTime = newDisabledTime
 		channel.ChannelInfo.MultiKeyDisabledReason = newDisabledReason
+		channel.ChannelInfo.MultiKeyLimits = newLimits
 
 		err = channel.Update()
 		if err != nil {
@@ -1701,6 +1782,8 @@ func ManageMultiKeys(c *gin.Context) {
 			return
 		}
 
+		// 密钥索引已变化，清空按索引统计的使用情况
+		keyusage.ResetChannel(channel.Id)
 		model.InitChannelCache()
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
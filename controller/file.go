@@ -0,0 +1,240 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+var validFilePurposes = map[string]bool{
+	"assistants": true,
+	"batch":      true,
+	"fine-tune":  true,
+	"vision":     true,
+	"user_data":  true,
+	"evals":      true,
+}
+
+const (
+	fileListDefaultLimit = 10000
+	fileListMaxLimit     = 10000
+	fileExpiresAfterMin  = 3600
+	fileExpiresAfterMax  = 30 * 24 * 3600
+)
+
+func writeInvalidRequestError(c *gin.Context, statusCode int, message string, code string) {
+	c.JSON(statusCode, gin.H{
+		"error": types.OpenAIError{
+			Message: message,
+			Type:    "invalid_request_error",
+			Code:    code,
+		},
+	})
+}
+
+func fileNotFound(c *gin.Context, fileId string) {
+	writeInvalidRequestError(c, http.StatusNotFound, fmt.Sprintf("No such File object: %s", fileId), "file_not_found")
+}
+
+// getRequestUserFile 获取路径参数中当前用户的文件，失败时已写入响应
+func getRequestUserFile(c *gin.Context) (*model.File, bool) {
+	fileId := c.Param("id")
+	file, err := model.GetUserFileById(c.GetInt("id"), fileId)
+	if err != nil {
+		if errors.Is(err, model.ErrFileNotFound) {
+			fileNotFound(c, fileId)
+		} else {
+			writeInvalidRequestError(c, http.StatusInternalServerError, err.Error(), "query_data_error")
+		}
+		return nil, false
+	}
+	return file, true
+}
+
+func checkFileApiEnabled(c *gin.Context) bool {
+	if !operation_setting.GetFileSetting().Enabled {
+		RelayNotImplemented(c)
+		return false
+	}
+	return true
+}
+
+// UploadFile POST /v1/files
+func UploadFile(c *gin.Context) {
+	if !checkFileApiEnabled(c) {
+		return
+	}
+	purpose := c.PostForm("purpose")
+	if !validFilePurposes[purpose] {
+		writeInvalidRequestError(c, http.StatusBadRequest, fmt.Sprintf("Invalid purpose: %q", purpose), "invalid_purpose")
+		return
+	}
+	var expiresAfter int64
+	if seconds := c.PostForm("expires_after[seconds]"); seconds != "" {
+		if anchor := c.PostForm("expires_after[anchor]"); anchor != "" && anchor != "created_at" {
+			writeInvalidRequestError(c, http.StatusBadRequest, "expires_after[anchor] must be created_at", "invalid_expires_after")
+			return
+		}
+		value, err := strconv.ParseInt(seconds, 10, 64)
+		if err != nil || value < fileExpiresAfterMin || value > fileExpiresAfterMax {
+			writeInvalidRequestError(c, http.StatusBadRequest, fmt.Sprintf("expires_after[seconds] must be between %d and %d", fileExpiresAfterMin, fileExpiresAfterMax), "invalid_expires_after")
+			return
+		}
+		expiresAfter = value
+	}
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		writeInvalidRequestError(c, http.StatusBadRequest, "Missing required parameter: 'file'", "missing_file")
+		return
+	}
+	content, err := header.Open()
+	if err != nil {
+		writeInvalidRequestError(c, http.StatusBadRequest, err.Error(), "read_file_failed")
+		return
+	}
+	defer content.Close()
+
+	contentType := header.Header.Get("Content-Type")
+	if contentType == "" || contentType == "application/octet-stream" {
+		sniff := make([]byte, 512)
+		n, _ := io.ReadFull(content, sniff)
+		contentType = http.DetectContentType(sniff[:n])
+		if _, err := content.Seek(0, io.SeekStart); err != nil {
+			writeInvalidRequestError(c, http.StatusInternalServerError, err.Error(), "read_file_failed")
+			return
+		}
+	}
+
+	file, err := service.CreateFile(service.CreateFileParams{
+		UserId:       c.GetInt("id"),
+		TokenId:      common.GetContextKeyInt(c, constant.ContextKeyTokenId),
+		Group:        common.GetContextKeyString(c, constant.ContextKeyUserGroup),
+		Filename:     header.Filename,
+		ContentType:  contentType,
+		Purpose:      purpose,
+		Size:         header.Size,
+		ExpiresAfter: expiresAfter,
+	}, content)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrFileTooLarge):
+			writeInvalidRequestError(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("File exceeds the maximum allowed size of %d MB", operation_setting.GetFileSetting().MaxFileSizeMB), "file_too_large")
+		case errors.Is(err, service.ErrFileStorageQuotaExceeded):
+			writeInvalidRequestError(c, http.StatusBadRequest, "File storage quota exceeded, please delete unused files", "file_storage_quota_exceeded")
+		default:
+			common.SysError("failed to create file: " + err.Error())
+			writeInvalidRequestError(c, http.StatusInternalServerError, "Failed to save file", "save_file_failed")
+		}
+		return
+	}
+	c.JSON(http.StatusOK, service.ToOpenAIFile(file))
+}
+
+// ListFiles GET /v1/files
+func ListFiles(c *gin.Context) {
+	if !checkFileApiEnabled(c) {
+		return
+	}
+	limit := fileListDefaultLimit
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > fileListMaxLimit {
+			writeInvalidRequestError(c, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", fileListMaxLimit), "invalid_limit")
+			return
+		}
+		limit = parsed
+	}
+	ascending := c.Query("order") == "asc"
+	files, hasMore, err := model.ListUserFiles(c.GetInt("id"), c.Query("purpose"), c.Query("after"), limit, ascending)
+	if err != nil {
+		if errors.Is(err, model.ErrFileNotFound) {
+			fileNotFound(c, c.Query("after"))
+			return
+		}
+		writeInvalidRequestError(c, http.StatusInternalServerError, err.Error(), "query_data_error")
+		return
+	}
+	resp := dto.OpenAIFileList{
+		Object:  "list",
+		Data:    make([]dto.OpenAIFile, 0, len(files)),
+		HasMore: hasMore,
+	}
+	for _, file := range files {
+		resp.Data = append(resp.Data, service.ToOpenAIFile(file))
+	}
+	if len(resp.Data) > 0 {
+		resp.FirstId = resp.Data[0].Id
+		resp.LastId = resp.Data[len(resp.Data)-1].Id
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// RetrieveFile GET /v1/files/:id
+func RetrieveFile(c *gin.Context) {
+	if !checkFileApiEnabled(c) {
+		return
+	}
+	file, ok := getRequestUserFile(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, service.ToOpenAIFile(file))
+}
+
+// RetrieveFileContent GET /v1/files/:id/content
+func RetrieveFileContent(c *gin.Context) {
+	if !checkFileApiEnabled(c) {
+		return
+	}
+	file, ok := getRequestUserFile(c)
+	if !ok {
+		return
+	}
+	content, err := service.GetFileStorage().Open(file.StorageKey)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to open file content %s: %s", file.FileId, err.Error()))
+		writeInvalidRequestError(c, http.StatusInternalServerError, "Failed to read file content", "read_file_failed")
+		return
+	}
+	defer content.Close()
+	contentType := file.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	c.DataFromReader(http.StatusOK, file.Bytes, contentType, content, map[string]string{
+		"Content-Disposition": fmt.Sprintf("attachment; filename=%q", file.Filename),
+	})
+}
+
+// DeleteFile DELETE /v1/files/:id
+func DeleteFile(c *gin.Context) {
+	if !checkFileApiEnabled(c) {
+		return
+	}
+	file, ok := getRequestUserFile(c)
+	if !ok {
+		return
+	}
+	if err := service.DeleteFile(file); err != nil {
+		writeInvalidRequestError(c, http.StatusInternalServerError, err.Error(), "delete_file_failed")
+		return
+	}
+	c.JSON(http.StatusOK, dto.OpenAIFileDeleteResponse{
+		Id:      file.FileId,
+		Object:  "file",
+		Deleted: true,
+	})
+}
@@ -0,0 +1,195 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/relay/channel/task/finetune"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	fineTuningListDefaultLimit = 20
+	fineTuningListMaxLimit     = 100
+	fineTuningUpstreamTimeout  = 60 * time.Second
+)
+
+// getRequestUserFineTuningJob 获取路径参数中当前用户的微调任务，失败时已写入响应
+func getRequestUserFineTuningJob(c *gin.Context) (*model.Task, bool) {
+	jobId := c.Param("id")
+	task, exist, err := model.GetByTaskId(c.GetInt("id"), jobId)
+	if err != nil {
+		writeInvalidRequestError(c, http.StatusInternalServerError, err.Error(), "query_data_error")
+		return nil, false
+	}
+	if !exist || task.Platform != constant.TaskPlatformFineTuning {
+		writeInvalidRequestError(c, http.StatusNotFound, fmt.Sprintf("No fine-tuning job found with id '%s'.", jobId), "fine_tuning_job_not_found")
+		return nil, false
+	}
+	return task, true
+}
+
+// toPublicFineTuningJob 将上游任务对象中的任务ID替换为公开 task ID
+func toPublicFineTuningJob(task *model.Task, data []byte) []byte {
+	if len(data) == 0 || !gjson.ValidBytes(data) {
+		data, _ = common.Marshal(map[string]any{
+			"object":     "fine_tuning.job",
+			"model":      task.Properties.OriginModelName,
+			"created_at": task.SubmitTime,
+		})
+	}
+	if publicData, err := sjson.SetBytes(data, "id", task.TaskID); err == nil {
+		return publicData
+	}
+	return data
+}
+
+// doFineTuningUpstreamRequest 使用任务创建时的渠道与 key 请求上游微调接口
+func doFineTuningUpstreamRequest(c *gin.Context, task *model.Task, method string, suffix string) (int, []byte, error) {
+	ch, err := model.CacheGetChannel(task.ChannelId)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to get channel #%d: %w", task.ChannelId, err)
+	}
+	// 早期任务在 PrivateData.Key 中保存了 key，新任务只记录多 key 渠道中的序号
+	key := task.PrivateData.Key
+	if key == "" {
+		key = ch.GetKeyByIndex(task.PrivateData.KeyIndex)
+	}
+
+	url := finetune.ChannelJobsURL(ch, "/"+task.GetUpstreamTaskID()+suffix)
+	if c.Request.URL.RawQuery != "" {
+		if strings.Contains(url, "?") {
+			url += "&" + c.Request.URL.RawQuery
+		} else {
+			url += "?" + c.Request.URL.RawQuery
+		}
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), fineTuningUpstreamTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	finetune.SetupChannelHeader(req.Header, ch, key)
+
+	client, err := service.GetHttpClientWithProxy(ch.GetSetting().Proxy)
+	if err != nil {
+		return 0, nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, body, nil
+}
+
+// relayFineTuningJobRequest 转发到任务所在渠道，成功响应经 rewrite 处理后返回，上游错误原样返回
+func relayFineTuningJobRequest(c *gin.Context, method string, suffix string, rewrite func(task *model.Task, body []byte) []byte) {
+	task, ok := getRequestUserFineTuningJob(c)
+	if !ok {
+		return
+	}
+	statusCode, body, err := doFineTuningUpstreamRequest(c, task, method, suffix)
+	if err != nil {
+		logger.LogError(c, fmt.Sprintf("fine-tuning job %s upstream request failed: %s", task.TaskID, err.Error()))
+		writeInvalidRequestError(c, http.StatusBadGateway, "Failed to reach upstream fine-tuning service", "upstream_request_failed")
+		return
+	}
+	if statusCode == http.StatusOK && rewrite != nil {
+		body = rewrite(task, body)
+	}
+	c.Data(statusCode, "application/json", body)
+}
+
+// ListFineTuningJobs GET /v1/fine_tuning/jobs
+func ListFineTuningJobs(c *gin.Context) {
+	limit := fineTuningListDefaultLimit
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > fineTuningListMaxLimit {
+			writeInvalidRequestError(c, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", fineTuningListMaxLimit), "invalid_limit")
+			return
+		}
+		limit = parsed
+	}
+	userId := c.GetInt("id")
+	var beforeId int64
+	if after := c.Query("after"); after != "" {
+		task, exist, err := model.GetByTaskId(userId, after)
+		if err != nil {
+			writeInvalidRequestError(c, http.StatusInternalServerError, err.Error(), "query_data_error")
+			return
+		}
+		if !exist || task.Platform != constant.TaskPlatformFineTuning {
+			writeInvalidRequestError(c, http.StatusNotFound, fmt.Sprintf("No fine-tuning job found with id '%s'.", after), "fine_tuning_job_not_found")
+			return
+		}
+		beforeId = task.ID
+	}
+	tasks, err := model.GetUserPlatformTasks(userId, constant.TaskPlatformFineTuning, beforeId, limit+1)
+	if err != nil {
+		writeInvalidRequestError(c, http.StatusInternalServerError, err.Error(), "query_data_error")
+		return
+	}
+	resp := dto.OpenAIFineTuningJobList{
+		Object:  "list",
+		Data:    make([]json.RawMessage, 0, len(tasks)),
+		HasMore: len(tasks) > limit,
+	}
+	if resp.HasMore {
+		tasks = tasks[:limit]
+	}
+	for _, task := range tasks {
+		resp.Data = append(resp.Data, toPublicFineTuningJob(task, task.Data))
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// RetrieveFineTuningJob GET /v1/fine_tuning/jobs/:id
+func RetrieveFineTuningJob(c *gin.Context) {
+	relayFineTuningJobRequest(c, http.MethodGet, "", toPublicFineTuningJob)
+}
+
+// CancelFineTuningJob POST /v1/fine_tuning/jobs/:id/cancel
+// 取消后的状态与退款由任务轮询处理
+func CancelFineTuningJob(c *gin.Context) {
+	relayFineTuningJobRequest(c, http.MethodPost, "/cancel", toPublicFineTuningJob)
+}
+
+// ListFineTuningJobEvents GET /v1/fine_tuning/jobs/:id/events
+func ListFineTuningJobEvents(c *gin.Context) {
+	relayFineTuningJobRequest(c, http.MethodGet, "/events", nil)
+}
+
+// ListFineTuningJobCheckpoints GET /v1/fine_tuning/jobs/:id/checkpoints
+func ListFineTuningJobCheckpoints(c *gin.Context) {
+	relayFineTuningJobRequest(c, http.MethodGet, "/checkpoints", func(task *model.Task, body []byte) []byte {
+		count := gjson.GetBytes(body, "data.#").Int()
+		for i := int64(0); i < count; i++ {
+			if updated, err := sjson.SetBytes(body, fmt.Sprintf("data.%d.fine_tuning_job_id", i), task.TaskID); err == nil {
+				body = updated
+			}
+		}
+		return body
+	})
+}
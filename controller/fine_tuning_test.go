@@ -0,0 +1,157 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+	"gorm.io/gorm"
+)
+
+func setupFineTuningControllerTestDB(t *testing.T) {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	common.UsingSQLite = true
+	common.UsingMySQL = false
+	common.UsingPostgreSQL = false
+	common.RedisEnabled = false
+	common.MemoryCacheEnabled = false
+	service.InitHttpClient()
+
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"))
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	require.NoError(t, err)
+	model.DB = db
+	model.LOG_DB = db
+	require.NoError(t, db.AutoMigrate(&model.Channel{}, &model.Task{}))
+
+	t.Cleanup(func() {
+		sqlDB, err := db.DB()
+		if err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+}
+
+func createFineTuningTask(t *testing.T, userId int, channelId int, upstreamId string, platform constant.TaskPlatform) *model.Task {
+	t.Helper()
+	task := &model.Task{
+		TaskID:    model.GenerateTaskID(),
+		Platform:  platform,
+		UserId:    userId,
+		ChannelId: channelId,
+		Status:    model.TaskStatusQueued,
+		Progress:  "20%",
+		PrivateData: model.TaskPrivateData{
+			KeyIndex:       1,
+			UpstreamTaskID: upstreamId,
+		},
+		Data: []byte(fmt.Sprintf(`{"id":"%s","object":"fine_tuning.job","status":"queued"}`, upstreamId)),
+	}
+	require.NoError(t, task.Insert())
+	return task
+}
+
+func newFineTuningTestContext(method string, target string, userId int, params gin.Params) (*gin.Context, *httptest.ResponseRecorder) {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(method, target, nil)
+	c.Params = params
+	c.Set("id", userId)
+	return c, recorder
+}
+
+func TestFineTuningJobRequestsUsePinnedChannel(t *testing.T) {
+	setupFineTuningControllerTestDB(t)
+
+	var requests []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.RequestURI()+" "+r.Header.Get("Authorization"))
+		switch r.URL.Path {
+		case "/v1/fine_tuning/jobs/ftjob-up/checkpoints":
+			_, _ = w.Write([]byte(`{"object":"list","data":[{"id":"ftckpt-1","fine_tuning_job_id":"ftjob-up"}],"has_more":false}`))
+		case "/v1/fine_tuning/jobs/ftjob-up/cancel":
+			_, _ = w.Write([]byte(`{"id":"ftjob-up","object":"fine_tuning.job","status":"cancelled"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":{"message":"not found"}}`))
+		}
+	}))
+	defer upstream.Close()
+
+	baseURL := upstream.URL
+	// 多 key 渠道按任务记录的序号使用创建任务时的 key
+	channel := &model.Channel{Type: constant.ChannelTypeOpenAI, Key: "sk-channel\nsk-pinned", BaseURL: &baseURL, Status: common.ChannelStatusEnabled,
+		ChannelInfo: model.ChannelInfo{IsMultiKey: true}}
+	require.NoError(t, model.DB.Create(channel).Error)
+	task := createFineTuningTask(t, 1, channel.Id, "ftjob-up", constant.TaskPlatformFineTuning)
+	params := gin.Params{{Key: "id", Value: task.TaskID}}
+
+	c, recorder := newFineTuningTestContext(http.MethodGet, "/v1/fine_tuning/jobs/"+task.TaskID+"/checkpoints?limit=5", 1, params)
+	ListFineTuningJobCheckpoints(c)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, task.TaskID, gjson.Get(recorder.Body.String(), "data.0.fine_tuning_job_id").String())
+
+	c, recorder = newFineTuningTestContext(http.MethodPost, "/v1/fine_tuning/jobs/"+task.TaskID+"/cancel", 1, params)
+	CancelFineTuningJob(c)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, task.TaskID, gjson.Get(recorder.Body.String(), "id").String())
+
+	// 上游错误原样返回
+	c, recorder = newFineTuningTestContext(http.MethodGet, "/v1/fine_tuning/jobs/"+task.TaskID+"/events", 1, params)
+	ListFineTuningJobEvents(c)
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	assert.Equal(t, "not found", gjson.Get(recorder.Body.String(), "error.message").String())
+
+	assert.Equal(t, []string{
+		"GET /v1/fine_tuning/jobs/ftjob-up/checkpoints?limit=5 Bearer sk-pinned",
+		"POST /v1/fine_tuning/jobs/ftjob-up/cancel Bearer sk-pinned",
+		"GET /v1/fine_tuning/jobs/ftjob-up/events Bearer sk-pinned",
+	}, requests)
+
+	// 其他用户的任务不可访问
+	c, recorder = newFineTuningTestContext(http.MethodGet, "/v1/fine_tuning/jobs/"+task.TaskID, 2, params)
+	RetrieveFineTuningJob(c)
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	assert.Len(t, requests, 3)
+}
+
+func TestListFineTuningJobs(t *testing.T) {
+	setupFineTuningControllerTestDB(t)
+	first := createFineTuningTask(t, 1, 1, "ftjob-1", constant.TaskPlatformFineTuning)
+	createFineTuningTask(t, 1, 1, "video-1", constant.TaskPlatform("45"))
+	second := createFineTuningTask(t, 1, 1, "ftjob-2", constant.TaskPlatformFineTuning)
+	createFineTuningTask(t, 2, 1, "ftjob-3", constant.TaskPlatformFineTuning)
+
+	c, recorder := newFineTuningTestContext(http.MethodGet, "/v1/fine_tuning/jobs?limit=1", 1, nil)
+	ListFineTuningJobs(c)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var list dto.OpenAIFineTuningJobList
+	require.NoError(t, common.Unmarshal(recorder.Body.Bytes(), &list))
+	require.Len(t, list.Data, 1)
+	assert.True(t, list.HasMore)
+	assert.Equal(t, second.TaskID, gjson.GetBytes(list.Data[0], "id").String())
+	assert.Equal(t, "queued", gjson.GetBytes(list.Data[0], "status").String())
+
+	c, recorder = newFineTuningTestContext(http.MethodGet, "/v1/fine_tuning/jobs?after="+second.TaskID, 1, nil)
+	ListFineTuningJobs(c)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	list = dto.OpenAIFineTuningJobList{}
+	require.NoError(t, common.Unmarshal(recorder.Body.Bytes(), &list))
+	require.Len(t, list.Data, 1)
+	assert.False(t, list.HasMore)
+	assert.Equal(t, first.TaskID, gjson.GetBytes(list.Data[0], "id").String())
+}
@@ -0,0 +1,28 @@
+package controller
+
+import (
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/gin-gonic/gin"
+)
+
+type RevokeJWTRequest struct {
+	Jti       string `json:"jti"`
+	ExpiresAt int64  `json:"expires_at"` // 令牌的过期时间（秒），吊销记录保留到此时间，未知时可不填
+}
+
+// RevokeJWTToken 吊销 JWT 令牌，之后使用该 jti 的请求都会被拒绝
+func RevokeJWTToken(c *gin.Context) {
+	req := RevokeJWTRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := service.RevokeJWT(strings.TrimSpace(req.Jti), req.ExpiresAt); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
@@ -122,6 +122,20 @@ func GetLogsStat(c *gin.Context) {
 	return
 }
 
+// GetChannelMargin 按渠道统计收入与上游成本，用于查看各渠道的毛利
+func GetChannelMargin(c *gin.Context) {
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	modelName := c.Query("model_name")
+	channel, _ := strconv.Atoi(c.Query("channel"))
+	margins, err := model.SumChannelMargin(startTimestamp, endTimestamp, modelName, channel)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, margins)
+}
+
 func GetLogsSelfStat(c *gin.Context) {
 	username := c.GetString("username")
 	logType, _ := strconv.Atoi(c.Query("type"))
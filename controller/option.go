@@ -183,6 +183,14 @@ func UpdateOption(c *gin.Context) {
 			})
 			return
 		}
+	case "responses_store_setting.enabled":
+		if option.Value == "true" && !common.SecretEncryptionEnabled() {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无法启用 Responses 状态存储，请先配置 SECRET_ENCRYPTION_KEY，避免对话记录以明文保存！",
+			})
+			return
+		}
 	case "LinuxDOOAuthEnabled":
 		if option.Value == "true" && common.LinuxDOClientId == "" {
 			c.JSON(http.StatusOK, gin.H{
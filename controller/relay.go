@@ -35,7 +35,7 @@ import (
 func relayHandler(c *gin.Context, info *relaycommon.RelayInfo) *types.NewAPIError {
 	var err *types.NewAPIError
 	switch info.RelayMode {
-	case relayconstant.RelayModeImagesGenerations, relayconstant.RelayModeImagesEdits:
+	case relayconstant.RelayModeImagesGenerations, relayconstant.RelayModeImagesEdits, relayconstant.RelayModeImagesVariations:
 		err = relay.ImageHelper(c, info)
 	case relayconstant.RelayModeAudioSpeech:
 		fallthrough
@@ -65,6 +65,19 @@ func geminiRelayHandler(c *gin.Context, info *relaycommon.RelayInfo) *types.NewA
 	return err
 }
 
+func relayByFormat(c *gin.Context, relayFormat types.RelayFormat, info *relaycommon.RelayInfo) *types.NewAPIError {
+	switch relayFormat {
+	case types.RelayFormatOpenAIRealtime:
+		return relay.WssHelper(c, info)
+	case types.RelayFormatClaude:
+		return relay.ClaudeHelper(c, info)
+	case types.RelayFormatGemini:
+		return geminiRelayHandler(c, info)
+	default:
+		return relayHandler(c, info)
+	}
+}
+
 func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 
 	requestId := c.GetString(common.RequestIdKey)
@@ -123,11 +136,19 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		return
 	}
 
+	releaseConcurrency, concurrencyErr := service.AcquireTokenConcurrency(c, relayInfo)
+	if concurrencyErr != nil {
+		newAPIError = concurrencyErr
+		return
+	}
+	defer releaseConcurrency()
+
 	needSensitiveCheck := setting.ShouldCheckPromptSensitive()
+	needModeration := operation_setting.GetModerationSetting().Enabled && relayFormat != types.RelayFormatOpenAIRealtime
 	needCountToken := constant.CountToken
 	// Avoid building huge CombineText (strings.Join) when token counting and sensitive check are both disabled.
 	var meta *types.TokenCountMeta
-	if needSensitiveCheck || needCountToken {
+	if needSensitiveCheck || needModeration || needCountToken {
 		meta = request.GetTokenCountMeta()
 	} else {
 		meta = fastTokenCountMetaForPricing(request)
@@ -142,6 +163,34 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		}
 	}
 
+	if needModeration {
+		decision, moderationErr := service.ModerateRequest(c, relayInfo, meta)
+		if moderationErr != nil {
+			newAPIError = moderationErr
+			return
+		}
+		if decision != nil && len(decision.Redactors) > 0 {
+			newAPIError = rewriteRelayRequest(c, relayFormat, relayInfo, decision.Redact)
+			if newAPIError != nil {
+				return
+			}
+		}
+	}
+
+	if operation_setting.GetInjectionGuardSetting().Enabled {
+		stripped, guardErr := service.GuardPromptInjection(c, relayInfo)
+		if guardErr != nil {
+			newAPIError = guardErr
+			return
+		}
+		if stripped {
+			newAPIError = reparseRelayRequest(c, relayFormat, relayInfo)
+			if newAPIError != nil {
+				return
+			}
+		}
+	}
+
 	tokens, err := service.EstimateRequestToken(c, meta, relayInfo)
 	if err != nil {
 		newAPIError = types.NewError(err, types.ErrorCodeCountTokenFailed)
@@ -150,6 +199,28 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 
 	relayInfo.SetEstimatePromptTokens(tokens)
 
+	newAPIError = service.ReserveTokenTPM(c, relayInfo, tokens)
+	if newAPIError != nil {
+		return
+	}
+	defer func() {
+		// 请求失败时退还预留的 TPM 额度
+		if newAPIError != nil {
+			service.SettleTokenTPM(c, relayInfo, 0)
+		}
+	}()
+
+	estimatedCompletionTokens := 0
+	if meta != nil {
+		estimatedCompletionTokens = meta.MaxTokens
+	}
+	if service.ShouldRerouteByCost(c, relayInfo) {
+		newAPIError = rerouteByCost(c, relayInfo, tokens, estimatedCompletionTokens)
+		if newAPIError != nil {
+			return
+		}
+	}
+
 	priceData, err := helper.ModelPriceHelper(c, relayInfo, tokens, meta)
 	if err != nil {
 		newAPIError = types.NewError(err, types.ErrorCodeModelPriceError, types.ErrOptionWithStatusCode(http.StatusBadRequest))
@@ -179,10 +250,12 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 	}()
 
 	retryParam := &service.RetryParam{
-		Ctx:        c,
-		TokenGroup: relayInfo.TokenGroup,
-		ModelName:  relayInfo.OriginModelName,
-		Retry:      common.GetPointer(0),
+		Ctx:              c,
+		TokenGroup:       relayInfo.TokenGroup,
+		ModelName:        relayInfo.OriginModelName,
+		Retry:            common.GetPointer(0),
+		PromptTokens:     tokens,
+		CompletionTokens: estimatedCompletionTokens,
 	}
 	relayInfo.RetryIndex = 0
 	relayInfo.LastError = nil
@@ -193,10 +266,20 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		if channelErr != nil {
 			logger.LogError(c, channelErr.Error())
 			newAPIError = channelErr
+			if switchModelFallback(c, relayInfo, retryParam, newAPIError, tokens, meta) {
+				continue
+			}
 			break
 		}
 
 		addUsedChannel(c, channel.Id)
+		if redactor := service.NewPIIRedactor(c, relayInfo); redactor != nil {
+			newAPIError = rewriteRelayRequest(c, relayFormat, relayInfo, redactor.Redact)
+			if newAPIError != nil {
+				break
+			}
+			service.SetupPIIRestore(c, relayInfo)
+		}
 		bodyStorage, bodyErr := common.GetBodyStorage(c)
 		if bodyErr != nil {
 			// Ensure consistent 413 for oversized bodies even when error occurs later (e.g., retry path)
@@ -209,15 +292,27 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		}
 		c.Request.Body = io.NopCloser(bodyStorage)
 
-		switch relayFormat {
-		case types.RelayFormatOpenAIRealtime:
-			newAPIError = relay.WssHelper(c, relayInfo)
-		case types.RelayFormatClaude:
-			newAPIError = relay.ClaudeHelper(c, relayInfo)
-		case types.RelayFormatGemini:
-			newAPIError = geminiRelayHandler(c, relayInfo)
-		default:
-			newAPIError = relayHandler(c, relayInfo)
+		attempt, attemptErr := service.BeginChannelAttempt(c, channel.Id, relayInfo)
+		if attemptErr != nil {
+			// 半开渠道的探测名额已满，不消耗重试次数重新选择渠道
+			logger.LogWarn(c, attemptErr.Error())
+			newAPIError = attemptErr
+			relayInfo.LastError = newAPIError
+			if _, pinned := c.Get("specific_channel_id"); !pinned &&
+				(retryParam.ReselectAfterBreakerReject() || retryParam.GetRetry() < common.RetryTimes) {
+				continue
+			}
+			if switchModelFallback(c, relayInfo, retryParam, newAPIError, tokens, meta) {
+				continue
+			}
+			break
+		}
+		errCtx, errChannel := c, channel
+		if hedgeDelay, ok := service.GetHedgeDelay(c, relayInfo); ok && len(c.GetStringSlice("use_channel")) == 1 {
+			newAPIError, errCtx, errChannel = relayWithHedge(c, relayFormat, relayInfo, channel, attempt, hedgeDelay)
+		} else {
+			newAPIError = relayByFormat(c, relayFormat, relayInfo)
+			service.FinishChannelAttempt(attempt, relayInfo, newAPIError)
 		}
 
 		if newAPIError == nil {
@@ -228,9 +323,12 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		newAPIError = service.NormalizeViolationFeeError(newAPIError)
 		relayInfo.LastError = newAPIError
 
-		processChannelError(c, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(c, constant.ContextKeyChannelKey), channel.GetAutoBan()), newAPIError)
+		processChannelError(errCtx, *types.NewChannelError(errChannel.Id, errChannel.Type, errChannel.Name, errChannel.ChannelInfo.IsMultiKey, common.GetContextKeyString(errCtx, constant.ContextKeyChannelKey), errChannel.GetAutoBan()), newAPIError)
 
-		if !shouldRetry(c, newAPIError, common.RetryTimes-retryParam.GetRetry()) {
+		if shouldRetry(c, newAPIError, common.RetryTimes-retryParam.GetRetry()) && retryParam.GetRetry() < common.RetryTimes {
+			continue
+		}
+		if !switchModelFallback(c, relayInfo, retryParam, newAPIError, tokens, meta) {
 			break
 		}
 	}
@@ -254,6 +352,28 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
+// rewriteRelayRequest 改写请求体中的文本内容并重新解析请求
+func rewriteRelayRequest(c *gin.Context, relayFormat types.RelayFormat, relayInfo *relaycommon.RelayInfo, rewrite func(text string) string) *types.NewAPIError {
+	changed, err := service.RewriteRequestBodyText(c, rewrite)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeReadRequestBodyFailed, types.ErrOptionWithSkipRetry())
+	}
+	if !changed {
+		return nil
+	}
+	return reparseRelayRequest(c, relayFormat, relayInfo)
+}
+
+// reparseRelayRequest 请求体被改写后重新解析请求
+func reparseRelayRequest(c *gin.Context, relayFormat types.RelayFormat, relayInfo *relaycommon.RelayInfo) *types.NewAPIError {
+	request, err := helper.GetAndValidateRequest(c, relayFormat)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeInvalidRequest, types.ErrOptionWithSkipRetry())
+	}
+	relayInfo.Request = request
+	return nil
+}
+
 func addUsedChannel(c *gin.Context, channelId int) {
 	useChannel := c.GetStringSlice("use_channel")
 	useChannel = append(useChannel, fmt.Sprintf("%d", channelId))
@@ -290,7 +410,7 @@ func fastTokenCountMetaForPricing(request dto.Request) *types.TokenCountMeta {
 }
 
 func getChannel(c *gin.Context, info *relaycommon.RelayInfo, retryParam *service.RetryParam) (*model.Channel, *types.NewAPIError) {
-	if info.ChannelMeta == nil {
+	if reselect := retryParam.TakeReselect(); info.ChannelMeta == nil && !reselect {
 		autoBan := c.GetBool("auto_ban")
 		autoBanInt := 1
 		if !autoBan {
@@ -321,6 +441,66 @@ func getChannel(c *gin.Context, info *relaycommon.RelayInfo, retryParam *service
 	return channel, nil
 }
 
+// rerouteByCost 分发时尚未估算 token，按估算结果重新选择成本最低的首个渠道
+func rerouteByCost(c *gin.Context, info *relaycommon.RelayInfo, promptTokens int, completionTokens int) *types.NewAPIError {
+	channel, _, err := service.CacheGetRandomSatisfiedChannel(&service.RetryParam{
+		Ctx:              c,
+		TokenGroup:       info.TokenGroup,
+		ModelName:        info.OriginModelName,
+		Retry:            common.GetPointer(0),
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+	})
+	if err != nil || channel == nil || channel.Id == common.GetContextKeyInt(c, constant.ContextKeyChannelId) {
+		return nil
+	}
+	return middleware.SetupContextForSelectedChannel(c, channel, info.OriginModelName)
+}
+
+// switchModelFallback 当前模型的渠道均已失败时切换到降级链中的下一个模型，重新计价并从头开始重试
+func switchModelFallback(c *gin.Context, info *relaycommon.RelayInfo, retryParam *service.RetryParam, apiErr *types.NewAPIError, promptTokens int, meta *types.TokenCountMeta) bool {
+	if apiErr == nil || info.ClientWs != nil {
+		return false
+	}
+	if apiErr.GetErrorCode() != types.ErrorCodeGetChannelFailed && !shouldRetry(c, apiErr, 1) {
+		return false
+	}
+	requestedModel := info.RequestedModelName
+	if requestedModel == "" {
+		requestedModel = info.OriginModelName
+	}
+	nextModel, ok := service.NextModelFallback(c, requestedModel, info.OriginModelName)
+	if !ok {
+		return false
+	}
+	logger.LogInfo(c, fmt.Sprintf("模型 %s 的渠道均不可用，降级到模型 %s", info.OriginModelName, nextModel))
+
+	info.RequestedModelName = requestedModel
+	info.OriginModelName = nextModel
+	info.TieredBillingSnapshot = nil
+	common.SetContextKey(c, constant.ContextKeyRequestedModel, requestedModel)
+	common.SetContextKey(c, constant.ContextKeyOriginalModel, nextModel)
+	priceData, err := helper.ModelPriceHelper(c, info, promptTokens, meta)
+	if err != nil {
+		logger.LogError(c, fmt.Sprintf("模型 %s 计价失败，停止降级: %s", nextModel, err.Error()))
+		return false
+	}
+	if info.Billing == nil && !priceData.FreeModel {
+		if newAPIError := service.PreConsumeBilling(c, priceData.QuotaToPreConsume, info); newAPIError != nil {
+			logger.LogError(c, fmt.Sprintf("模型 %s 预扣费失败，停止降级: %s", nextModel, newAPIError.Error()))
+			return false
+		}
+	}
+
+	// 新模型从首个分组、最高优先级重新开始选择渠道
+	common.SetContextKey(c, constant.ContextKeyAutoGroupIndex, 0)
+	common.SetContextKey(c, constant.ContextKeyAutoGroupRetryIndex, 0)
+	retryParam.ModelName = nextModel
+	retryParam.SetRetry(0)
+	retryParam.ResetRetryNextTry()
+	return true
+}
+
 func shouldRetry(c *gin.Context, openaiErr *types.NewAPIError, retryTimes int) bool {
 	if openaiErr == nil {
 		return false
@@ -357,7 +537,7 @@ func processChannelError(c *gin.Context, channelError types.ChannelError, err *t
 	logger.LogError(c, fmt.Sprintf("channel error (channel #%d, status code: %d): %s", channelError.ChannelId, err.StatusCode, err.Error()))
 	// 不要使用context获取渠道信息，异步处理时可能会出现渠道信息不一致的情况
 	// do not use context to get channel info, there may be inconsistent channel info when processing asynchronously
-	if service.ShouldDisableChannel(err) && channelError.AutoBan {
+	if service.ShouldDisableChannel(err) && channelError.AutoBan && !service.ShouldDeferDisableToCircuitBreaker(err) {
 		gopool.Go(func() {
 			service.DisableChannel(channelError, err.ErrorWithStatusCode())
 		})
@@ -0,0 +1,86 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	circuitbreaker "github.com/QuantumNous/new-api/pkg/circuit_breaker"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetChannelReselectsWhenHalfOpenProbesAreFull(t *testing.T) {
+	db := setupModelListControllerTestDB(t)
+	originalMemoryCache := common.MemoryCacheEnabled
+	common.MemoryCacheEnabled = false
+	t.Cleanup(func() { common.MemoryCacheEnabled = originalMemoryCache })
+
+	for _, id := range []int{1, 2} {
+		channel := &model.Channel{Id: id, Name: "breaker", Key: "sk-breaker", Status: common.ChannelStatusEnabled, Group: "default", Models: "gpt-4o"}
+		require.NoError(t, db.Create(channel).Error)
+		require.NoError(t, channel.AddAbilities(nil))
+	}
+
+	setting := operation_setting.GetCircuitBreakerSetting()
+	previous := *setting
+	setting.Enabled = true
+	setting.FailureThreshold = 1
+	setting.FailureWindowSeconds = 60
+	setting.OpenSeconds = 1
+	setting.MaxOpenSeconds = 1
+	setting.HalfOpenMaxRequests = 1
+	key := circuitbreaker.ChannelKey(1)
+	t.Cleanup(func() {
+		circuitbreaker.Reset(key)
+		*setting = previous
+	})
+
+	// 渠道 1 冷却结束进入半开状态，唯一的探测名额已被占用
+	circuitbreaker.Reset(key)
+	circuitbreaker.Record(key, false, false)
+	require.Eventually(t, func() bool { return circuitbreaker.Allow(key) }, 3*time.Second, 50*time.Millisecond)
+	probe, allowed := circuitbreaker.Acquire(key)
+	require.True(t, probe)
+	require.True(t, allowed)
+
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	c.Set("channel_id", 1)
+	common.SetContextKey(c, constant.ContextKeyChannelId, 1)
+	info := &relaycommon.RelayInfo{OriginModelName: "gpt-4o", TokenGroup: "default"}
+	retryParam := &service.RetryParam{Ctx: c, TokenGroup: "default", ModelName: "gpt-4o", Retry: common.GetPointer(0)}
+
+	// 首次使用分发时选定的渠道，探测名额已满被拒绝
+	channel, apiErr := getChannel(c, info, retryParam)
+	require.Nil(t, apiErr)
+	require.Equal(t, 1, channel.Id)
+	_, attemptErr := service.BeginChannelAttempt(c, channel.Id, info)
+	require.NotNil(t, attemptErr)
+	require.Equal(t, types.ErrorCodeCircuitBreakerOpen, attemptErr.GetErrorCode())
+
+	// 不消耗重试次数，重新选择到其他渠道
+	require.True(t, retryParam.ReselectAfterBreakerReject())
+	retryParam.IncreaseRetry()
+	require.Zero(t, retryParam.GetRetry())
+	channel, apiErr = getChannel(c, info, retryParam)
+	require.Nil(t, apiErr)
+	require.Equal(t, 2, channel.Id)
+	attempt, attemptErr := service.BeginChannelAttempt(c, channel.Id, info)
+	require.Nil(t, attemptErr)
+	service.CancelChannelAttempt(attempt, info)
+
+	// 重新选择的次数有上限
+	require.True(t, retryParam.ReselectAfterBreakerReject())
+	require.True(t, retryParam.ReselectAfterBreakerReject())
+	require.False(t, retryParam.ReselectAfterBreakerReject())
+}
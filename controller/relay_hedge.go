@@ -0,0 +1,215 @@
+package controller
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/middleware"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// hedgeChannelSelectTimes 选择对冲渠道时避开首个渠道的最大尝试次数
+const hedgeChannelSelectTimes = 3
+
+type hedgeOutcome struct {
+	index int
+	err   *types.NewAPIError
+}
+
+type hedgeAttempt struct {
+	ctx     *gin.Context
+	info    *relaycommon.RelayInfo
+	channel *model.Channel
+	attempt *service.ChannelAttempt
+	outcome *hedgeOutcome
+}
+
+// relayWithHedge 对冲请求：首个渠道在 delay 内未向客户端写出数据时，向另一个渠道发出相同请求，
+// 最先写出数据的请求胜出，另一个请求被取消且不结算。
+// 返回需要由调用方处理的错误及其所属的上下文与渠道，其余失败的请求在此处理
+func relayWithHedge(c *gin.Context, relayFormat types.RelayFormat, relayInfo *relaycommon.RelayInfo, channel *model.Channel, channelAttempt *service.ChannelAttempt, delay time.Duration) (*types.NewAPIError, *gin.Context, *model.Channel) {
+	// 在首个请求开始前复制上下文与请求信息，避免与其并发读写
+	bodyBytes, err := readHedgeBody(c)
+	if err != nil {
+		logger.LogWarn(c, fmt.Sprintf("hedge disabled, failed to read request body: %s", err.Error()))
+		newAPIError := relayByFormat(c, relayFormat, relayInfo)
+		service.FinishChannelAttempt(channelAttempt, relayInfo, newAPIError)
+		return newAPIError, c, channel
+	}
+	hedgeCtx := c.Copy()
+	hedgeCtx.Request = c.Request.Clone(c.Request.Context())
+	hedgeInfo := relayInfo.CloneForHedge()
+
+	race := relaycommon.NewHedgeRace(c.Request.Context())
+	writer := c.Writer
+	header := writer.Header().Clone()
+	disablePing := relayInfo.DisablePing
+	defer func() {
+		race.Close()
+		c.Writer = writer
+		relayInfo.Hedge = nil
+		relayInfo.DisablePing = disablePing
+		common.CleanupBodyStorage(hedgeCtx)
+	}()
+
+	attempts := []*hedgeAttempt{{ctx: c, info: relayInfo, channel: channel, attempt: channelAttempt}}
+	// 流式保活的 ping 会被视为首字，对冲期间禁用
+	relayInfo.DisablePing = true
+	relayInfo.Hedge = race.NewAttempt(channel.Id, relayInfo.GetEstimatePromptTokens())
+	c.Writer = relayInfo.Hedge.WrapWriter(writer, header)
+
+	outcomes := make(chan hedgeOutcome, 2)
+	go runHedgeAttempt(c, relayFormat, relayInfo, 0, outcomes)
+	pending := 1
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	timeout := timer.C
+	decided := race.Decided()
+	for pending > 0 {
+		select {
+		case <-decided:
+			timeout = nil
+			decided = nil
+		case <-timeout:
+			timeout = nil
+			if race.Winner() != nil {
+				continue
+			}
+			hedge := startHedgeAttempt(hedgeCtx, hedgeInfo, race, writer, header, bodyBytes, channel.Id)
+			if hedge == nil {
+				continue
+			}
+			addUsedChannel(c, hedge.channel.Id)
+			logger.LogInfo(c, fmt.Sprintf("channel #%d has no response after %dms, hedging to channel #%d", channel.Id, delay.Milliseconds(), hedge.channel.Id))
+			attempts = append(attempts, hedge)
+			go runHedgeAttempt(hedge.ctx, relayFormat, hedge.info, 1, outcomes)
+			pending++
+		case outcome := <-outcomes:
+			pending--
+			attempts[outcome.index].outcome = &outcome
+			// 首个请求在对冲前就已失败，交给常规重试处理
+			timeout = nil
+		}
+	}
+
+	result := attempts[0]
+	if winner := race.Winner(); winner != nil {
+		for _, attempt := range attempts {
+			if attempt.info.Hedge == winner {
+				result = attempt
+			}
+		}
+	} else if len(attempts) > 1 && attempts[0].outcome.err != nil && attempts[1].outcome.err == nil {
+		result = attempts[1]
+	}
+	for _, attempt := range attempts {
+		if attempt.info.Hedge.IsLoser() {
+			service.CancelChannelAttempt(attempt.attempt, attempt.info)
+			continue
+		}
+		service.FinishChannelAttempt(attempt.attempt, attempt.info, attempt.outcome.err)
+		if attempt != result && attempt.outcome.err != nil {
+			newAPIError := service.NormalizeViolationFeeError(attempt.outcome.err)
+			processChannelError(attempt.ctx, *types.NewChannelError(attempt.channel.Id, attempt.channel.Type, attempt.channel.Name, attempt.channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(attempt.ctx, constant.ContextKeyChannelKey), attempt.channel.GetAutoBan()), newAPIError)
+		}
+	}
+	return result.outcome.err, result.ctx, result.channel
+}
+
+func runHedgeAttempt(c *gin.Context, relayFormat types.RelayFormat, info *relaycommon.RelayInfo, index int, outcomes chan<- hedgeOutcome) {
+	var newAPIError *types.NewAPIError
+	defer func() {
+		if r := recover(); r != nil {
+			logger.LogError(c, fmt.Sprintf("hedged request panic: %v", r))
+			newAPIError = types.NewError(fmt.Errorf("hedged request panic: %v", r), types.ErrorCodeBadResponse, types.ErrOptionWithSkipRetry())
+		}
+		info.Hedge.Finish()
+		outcomes <- hedgeOutcome{index: index, err: newAPIError}
+	}()
+	newAPIError = relayByFormat(c, relayFormat, info)
+}
+
+// startHedgeAttempt 选择另一个渠道并准备对冲请求，没有其他可用渠道时返回 nil
+func startHedgeAttempt(c *gin.Context, info *relaycommon.RelayInfo, race *relaycommon.HedgeRace, writer gin.ResponseWriter, header http.Header, bodyBytes []byte, excludeChannelId int) *hedgeAttempt {
+	channel, err := selectHedgeChannel(c, info, excludeChannelId)
+	if err != nil {
+		logger.LogWarn(c, fmt.Sprintf("failed to select hedge channel: %s", err.Error()))
+		return nil
+	}
+	if channel == nil {
+		return nil
+	}
+	channelAttempt, attemptErr := service.BeginChannelAttempt(c, channel.Id, info)
+	if attemptErr != nil {
+		logger.LogWarn(c, fmt.Sprintf("skip hedging: %s", attemptErr.Error()))
+		return nil
+	}
+	bodyStorage, err := common.CreateBodyStorage(bodyBytes)
+	if err != nil {
+		logger.LogWarn(c, fmt.Sprintf("failed to create hedge request body: %s", err.Error()))
+		service.CancelChannelAttempt(channelAttempt, info)
+		return nil
+	}
+	c.Set(common.KeyBodyStorage, bodyStorage)
+	c.Request.Body = io.NopCloser(bodyStorage)
+	addUsedChannel(c, channel.Id)
+
+	info.DisablePing = true
+	info.Hedge = race.NewAttempt(channel.Id, info.GetEstimatePromptTokens())
+	c.Writer = info.Hedge.WrapWriter(writer, header)
+	return &hedgeAttempt{
+		ctx:     c,
+		info:    info,
+		channel: channel,
+		attempt: channelAttempt,
+	}
+}
+
+// selectHedgeChannel 在同一优先级中选择与首个渠道不同的渠道
+func selectHedgeChannel(c *gin.Context, info *relaycommon.RelayInfo, excludeChannelId int) (*model.Channel, error) {
+	retryParam := &service.RetryParam{
+		Ctx:          c,
+		TokenGroup:   info.TokenGroup,
+		ModelName:    info.OriginModelName,
+		Retry:        common.GetPointer(0),
+		PromptTokens: info.GetEstimatePromptTokens(),
+	}
+	for i := 0; i < hedgeChannelSelectTimes; i++ {
+		channel, _, err := service.CacheGetRandomSatisfiedChannel(retryParam)
+		if err != nil {
+			return nil, err
+		}
+		if channel == nil {
+			return nil, nil
+		}
+		if channel.Id == excludeChannelId {
+			continue
+		}
+		info.PriceData.GroupRatioInfo = helper.HandleGroupRatio(c, info)
+		if newAPIError := middleware.SetupContextForSelectedChannel(c, channel, info.OriginModelName); newAPIError != nil {
+			return nil, newAPIError
+		}
+		return channel, nil
+	}
+	return nil, nil
+}
+
+func readHedgeBody(c *gin.Context) ([]byte, error) {
+	bodyStorage, err := common.GetBodyStorage(c)
+	if err != nil {
+		return nil, err
+	}
+	return bodyStorage.Bytes()
+}
@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/middleware"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+	"github.com/QuantumNous/new-api/types"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRelayWithHedgeSettlesOnlyTheWinner(t *testing.T) {
+	db := setupModelListControllerTestDB(t)
+	require.NoError(t, db.AutoMigrate(&model.Token{}, &model.Log{}, &model.UserSubscription{}))
+	service.InitHttpClient()
+	originalMemoryCache := common.MemoryCacheEnabled
+	common.MemoryCacheEnabled = false
+	t.Cleanup(func() { common.MemoryCacheEnabled = originalMemoryCache })
+
+	previousRatios := ratio_setting.ModelRatio2JSONString()
+	require.NoError(t, ratio_setting.UpdateModelRatioByJSONString(`{"gpt-4o":1}`))
+	t.Cleanup(func() { _ = ratio_setting.UpdateModelRatioByJSONString(previousRatios) })
+
+	hedgeSetting := operation_setting.GetHedgeSetting()
+	previousHedge := *hedgeSetting
+	hedgeSetting.Enabled = true
+	hedgeSetting.DelayMs = 50
+	hedgeSetting.Groups = []string{"default"}
+	t.Cleanup(func() { *hedgeSetting = previousHedge })
+
+	// 首个渠道一直不返回，直到请求被取消
+	var slowCancelled atomic.Bool
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		select {
+		case <-r.Context().Done():
+			slowCancelled.Store(true)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	t.Cleanup(slow.Close)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-fast","object":"chat.completion","created":1,"model":"gpt-4o",` +
+			`"choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}],` +
+			`"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`))
+	}))
+	t.Cleanup(fast.Close)
+
+	user := &model.User{Username: "hedge_user", Password: "password123", Status: common.UserStatusEnabled, Group: "default", Quota: 1000000}
+	require.NoError(t, db.Create(user).Error)
+	token := &model.Token{UserId: user.Id, Key: "hedgeRelayTokenKey0000000000000000000000000000", Name: "hedge", Status: common.TokenStatusEnabled,
+		ExpiredTime: -1, RemainQuota: 1000000}
+	require.NoError(t, db.Create(token).Error)
+	channels := map[int]*model.Channel{}
+	for id, server := range map[int]*httptest.Server{1: slow, 2: fast} {
+		baseURL := server.URL
+		channel := &model.Channel{Id: id, Type: constant.ChannelTypeOpenAI, Name: fmt.Sprintf("hedge-%d", id), Key: "sk-hedge",
+			Status: common.ChannelStatusEnabled, Group: "default", Models: "gpt-4o", BaseURL: &baseURL}
+		require.NoError(t, db.Create(channel).Error)
+		require.NoError(t, channel.AddAbilities(nil))
+		channels[id] = channel
+	}
+
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions",
+		strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"hello"}]}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	require.True(t, middleware.SetupContextForTokenUser(c, token))
+	require.NoError(t, middleware.SetupContextForToken(c, token))
+	common.SetContextKey(c, constant.ContextKeyOriginalModel, "gpt-4o")
+	require.Nil(t, middleware.SetupContextForSelectedChannel(c, channels[1], "gpt-4o"))
+
+	Relay(c, types.RelayFormatOpenAI)
+
+	// 对冲渠道先返回并胜出，首个渠道的请求被取消
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	require.Contains(t, recorder.Body.String(), "chatcmpl-fast")
+	require.Eventually(t, slowCancelled.Load, 2*time.Second, 20*time.Millisecond)
+	require.Equal(t, []string{"1", "2"}, c.GetStringSlice("use_channel"))
+
+	// 只结算胜出的请求一次
+	var logs []model.Log
+	require.Eventually(t, func() bool {
+		logs = nil
+		return db.Where("type = ?", model.LogTypeConsume).Find(&logs).Error == nil && len(logs) > 0
+	}, 2*time.Second, 20*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, db.Where("type = ?", model.LogTypeConsume).Find(&logs).Error)
+	require.Len(t, logs, 1)
+	require.Equal(t, 2, logs[0].ChannelId)
+	require.Positive(t, logs[0].Quota)
+	var stored model.User
+	require.NoError(t, db.First(&stored, user.Id).Error)
+	require.Equal(t, 1000000-logs[0].Quota, stored.Quota)
+}
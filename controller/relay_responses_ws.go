@@ -0,0 +1,278 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/middleware"
+	"github.com/QuantumNous/new-api/relay/channel"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+var responsesUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		return true // 允许跨域
+	},
+}
+
+// RelayResponsesWebSocket 通过 WebSocket 承载 /v1/responses。
+// 每个 response.create 帧作为一轮独立的流式请求，分别选择渠道、预扣费与结算；
+// 连接级渠道亲和保证同一连接内的多轮请求优先复用首轮渠道，OpenAI 渠道复用上游 WebSocket 连接
+func RelayResponsesWebSocket(c *gin.Context) {
+	ws, err := responsesUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		logger.LogError(c, fmt.Sprintf("upgrade responses websocket failed: %s", err.Error()))
+		return
+	}
+	defer ws.Close()
+
+	connectionId := c.GetString(common.RequestIdKey)
+	service.SetChannelAffinityConnection(c, connectionId)
+	defer service.ReleaseChannelAffinityConnection(connectionId)
+
+	session := channel.NewResponsesWssSession()
+	defer session.Close()
+	common.SetContextKey(c, constant.ContextKeyResponsesWssSession, session)
+
+	send := func(data []byte) error {
+		return ws.WriteMessage(websocket.TextMessage, data)
+	}
+	for turn := 1; ; turn++ {
+		_, message, err := ws.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseNoStatusReceived) {
+				logger.LogError(c, fmt.Sprintf("read responses websocket failed: %s", err.Error()))
+			}
+			return
+		}
+		if eventType := gjson.GetBytes(message, "type").String(); eventType != "response.create" {
+			_ = send(responsesWsErrorEvent(http.StatusBadRequest, fmt.Sprintf("unsupported event type %q, only response.create is supported", eventType), string(types.ErrorCodeInvalidRequest)))
+			continue
+		}
+		body, err := responsesWsRequestBody(message)
+		if err != nil {
+			_ = send(responsesWsErrorEvent(http.StatusBadRequest, err.Error(), string(types.ErrorCodeInvalidRequest)))
+			continue
+		}
+		relayResponsesWsTurn(c, fmt.Sprintf("%s-%d", connectionId, turn), body, send)
+	}
+}
+
+// responsesWsRequestBody 将 response.create 帧转换为流式 /v1/responses 请求体，兼容参数嵌套在 response 字段中的写法
+func responsesWsRequestBody(message []byte) ([]byte, error) {
+	body := message
+	if response := gjson.GetBytes(message, "response"); response.IsObject() {
+		body = []byte(response.Raw)
+	}
+	body, err := sjson.DeleteBytes(body, "type")
+	if err != nil {
+		return nil, err
+	}
+	return sjson.SetBytes(body, "stream", true)
+}
+
+// relayResponsesWsTurn 以独立的请求上下文执行一轮请求，复用 HTTP 接口的分发、计费与重试逻辑
+func relayResponsesWsTurn(c *gin.Context, requestId string, body []byte, send func([]byte) error) {
+	ctx, cancel := context.WithCancel(context.WithValue(c.Request.Context(), common.RequestIdKey, requestId))
+	defer cancel()
+
+	request := c.Request.Clone(ctx)
+	request.Method = http.MethodPost
+	request.URL.Path = "/v1/responses"
+	request.URL.RawQuery = ""
+	request.Body = io.NopCloser(bytes.NewReader(body))
+	request.ContentLength = int64(len(body))
+	for _, header := range []string{"Upgrade", "Connection", "Sec-Websocket-Key", "Sec-Websocket-Version", "Sec-Websocket-Extensions", "Sec-Websocket-Protocol"} {
+		request.Header.Del(header)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "text/event-stream")
+
+	writer := newResponsesWsWriter(send, cancel)
+	turnCtx := c.Copy()
+	turnCtx.Request = request
+	turnCtx.Writer = writer
+	delete(turnCtx.Keys, common.KeyBodyStorage)
+	turnCtx.Set(common.RequestIdKey, requestId)
+	defer common.CleanupBodyStorage(turnCtx)
+
+	middleware.Distribute()(turnCtx)
+	if writer.Status() < http.StatusBadRequest {
+		Relay(turnCtx, types.RelayFormatOpenAIResponses)
+	}
+	writer.finish()
+
+	if writer.Status() < http.StatusBadRequest {
+		// 以最终成功的渠道更新连接级亲和
+		service.RecordChannelAffinity(turnCtx, turnCtx.GetInt("channel_id"))
+	}
+}
+
+func responsesWsErrorEvent(status int, message string, code string) []byte {
+	event, _ := common.Marshal(map[string]any{
+		"type":   "error",
+		"status": status,
+		"error": types.OpenAIError{
+			Message: message,
+			Type:    "invalid_request_error",
+			Code:    code,
+		},
+	})
+	return event
+}
+
+// responsesWsWriter 实现 gin.ResponseWriter，将 SSE 事件逐个转换为 WebSocket 帧；
+// 错误响应转换为 error 事件，非流式响应转换为 response.completed 事件
+type responsesWsWriter struct {
+	send   func([]byte) error
+	cancel context.CancelFunc
+	header http.Header
+	status int
+	size   int
+	buf    bytes.Buffer
+	err    error
+}
+
+func newResponsesWsWriter(send func([]byte) error, cancel context.CancelFunc) *responsesWsWriter {
+	return &responsesWsWriter{send: send, cancel: cancel, header: http.Header{}}
+}
+
+func (w *responsesWsWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *responsesWsWriter) WriteHeader(code int) {
+	if code > 0 && w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *responsesWsWriter) WriteHeaderNow() {
+	w.WriteHeader(http.StatusOK)
+}
+
+func (w *responsesWsWriter) Write(data []byte) (int, error) {
+	w.WriteHeaderNow()
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.size += len(data)
+	w.buf.Write(data)
+	if w.isEventStream() {
+		w.flushEvents()
+	}
+	return len(data), w.err
+}
+
+func (w *responsesWsWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *responsesWsWriter) isEventStream() bool {
+	return w.status < http.StatusBadRequest && strings.HasPrefix(w.header.Get("Content-Type"), "text/event-stream")
+}
+
+func (w *responsesWsWriter) forward(data []byte) {
+	if w.err != nil {
+		return
+	}
+	if err := w.send(data); err != nil {
+		// 客户端已断开，取消本轮请求以停止读取上游
+		w.err = err
+		w.cancel()
+	}
+}
+
+func (w *responsesWsWriter) flushEvents() {
+	for {
+		raw := w.buf.Bytes()
+		idx := bytes.Index(raw, []byte("\n\n"))
+		if idx < 0 {
+			return
+		}
+		block := string(raw[:idx])
+		w.buf.Next(idx + 2)
+
+		var data strings.Builder
+		for _, line := range strings.Split(block, "\n") {
+			if value, ok := strings.CutPrefix(line, "data:"); ok {
+				data.WriteString(strings.TrimPrefix(value, " "))
+			}
+		}
+		payload := strings.TrimSpace(data.String())
+		if payload == "" || payload == "[DONE]" {
+			continue
+		}
+		w.forward([]byte(payload))
+	}
+}
+
+// finish 处理缓冲中剩余的非 SSE 响应
+func (w *responsesWsWriter) finish() {
+	if w.isEventStream() || w.buf.Len() == 0 {
+		return
+	}
+	body := w.buf.Bytes()
+	w.buf.Reset()
+	if w.status >= http.StatusBadRequest {
+		errorObj := gjson.GetBytes(body, "error")
+		if !errorObj.IsObject() {
+			w.forward(responsesWsErrorEvent(w.status, string(body), ""))
+			return
+		}
+		event, _ := sjson.SetRawBytes([]byte(`{"type":"error"}`), "error", []byte(errorObj.Raw))
+		event, _ = sjson.SetBytes(event, "status", w.status)
+		w.forward(event)
+		return
+	}
+	event, _ := sjson.SetRawBytes([]byte(`{"type":"response.completed"}`), "response", body)
+	w.forward(event)
+}
+
+func (w *responsesWsWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *responsesWsWriter) Size() int {
+	if w.status == 0 {
+		return -1
+	}
+	return w.size
+}
+
+func (w *responsesWsWriter) Written() bool {
+	return w.status != 0
+}
+
+func (w *responsesWsWriter) Flush() {}
+
+func (w *responsesWsWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, errors.New("hijack is not supported")
+}
+
+func (w *responsesWsWriter) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+func (w *responsesWsWriter) Pusher() http.Pusher {
+	return nil
+}
@@ -0,0 +1,58 @@
+package controller
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestResponsesWsRequestBody(t *testing.T) {
+	body, err := responsesWsRequestBody([]byte(`{"type":"response.create","model":"gpt-5","input":"hi","stream":false}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"model":"gpt-5","input":"hi","stream":true}`, string(body))
+
+	body, err = responsesWsRequestBody([]byte(`{"type":"response.create","response":{"model":"gpt-5","input":"hi"}}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"model":"gpt-5","input":"hi","stream":true}`, string(body))
+}
+
+func TestResponsesWsWriter(t *testing.T) {
+	var frames []string
+	send := func(data []byte) error {
+		frames = append(frames, string(data))
+		return nil
+	}
+
+	writer := newResponsesWsWriter(send, func() {})
+	writer.Header().Set("Content-Type", "text/event-stream")
+	_, _ = writer.WriteString("event: response.created\n")
+	_, _ = writer.WriteString(`data: {"type":"response.created"}` + "\n\n: PING\n\n")
+	_, _ = writer.WriteString(`data: {"type":"response.output_text.delta",`)
+	_, _ = writer.WriteString(`"delta":"hi"}` + "\n\ndata: [DONE]\n\n")
+	writer.finish()
+	assert.Equal(t, []string{`{"type":"response.created"}`, `{"type":"response.output_text.delta","delta":"hi"}`}, frames)
+
+	frames = nil
+	writer = newResponsesWsWriter(send, func() {})
+	c := &gin.Context{Writer: writer}
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": gin.H{"message": "rate limited", "type": "new_api_error"}})
+	writer.finish()
+	require.Len(t, frames, 1)
+	assert.Equal(t, "error", gjson.Get(frames[0], "type").String())
+	assert.Equal(t, int64(http.StatusTooManyRequests), gjson.Get(frames[0], "status").Int())
+	assert.Equal(t, "rate limited", gjson.Get(frames[0], "error.message").String())
+	assert.Equal(t, http.StatusTooManyRequests, writer.Status())
+
+	frames = nil
+	writer = newResponsesWsWriter(send, func() {})
+	c = &gin.Context{Writer: writer}
+	c.JSON(http.StatusOK, gin.H{"id": "resp_1", "object": "response"})
+	writer.finish()
+	require.Len(t, frames, 1)
+	assert.Equal(t, "response.completed", gjson.Get(frames[0], "type").String())
+	assert.Equal(t, "resp_1", gjson.Get(frames[0], "response.id").String())
+}
@@ -0,0 +1,124 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	responseInputItemsDefaultLimit = 20
+	responseInputItemsMaxLimit     = 100
+)
+
+func checkResponsesStoreEnabled(c *gin.Context) bool {
+	if !operation_setting.GetResponsesStoreSetting().Enabled {
+		RelayNotImplemented(c)
+		return false
+	}
+	return true
+}
+
+// writeStoredResponseError 写入查询对话记录失败的响应
+func writeStoredResponseError(c *gin.Context, responseId string, err error) {
+	if errors.Is(err, model.ErrStoredResponseNotFound) {
+		writeInvalidRequestError(c, http.StatusNotFound, fmt.Sprintf("Response with id '%s' not found.", responseId), "response_not_found")
+		return
+	}
+	writeInvalidRequestError(c, http.StatusInternalServerError, err.Error(), "query_data_error")
+}
+
+// RetrieveStoredResponse GET /v1/responses/:id
+func RetrieveStoredResponse(c *gin.Context) {
+	if !checkResponsesStoreEnabled(c) {
+		return
+	}
+	responseId := c.Param("id")
+	response, err := service.GetStoredResponse(c.GetInt("id"), responseId)
+	if err != nil {
+		writeStoredResponseError(c, responseId, err)
+		return
+	}
+	c.Data(http.StatusOK, "application/json", response)
+}
+
+// DeleteStoredResponse DELETE /v1/responses/:id
+func DeleteStoredResponse(c *gin.Context) {
+	if !checkResponsesStoreEnabled(c) {
+		return
+	}
+	responseId := c.Param("id")
+	if err := service.DeleteStoredResponse(c.GetInt("id"), responseId); err != nil {
+		writeStoredResponseError(c, responseId, err)
+		return
+	}
+	c.JSON(http.StatusOK, dto.OpenAIResponsesDeleteResponse{
+		Id:      responseId,
+		Object:  "response",
+		Deleted: true,
+	})
+}
+
+// ListStoredResponseInputItems GET /v1/responses/:id/input_items
+func ListStoredResponseInputItems(c *gin.Context) {
+	if !checkResponsesStoreEnabled(c) {
+		return
+	}
+	limit := responseInputItemsDefaultLimit
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > responseInputItemsMaxLimit {
+			writeInvalidRequestError(c, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", responseInputItemsMaxLimit), "invalid_limit")
+			return
+		}
+		limit = parsed
+	}
+	responseId := c.Param("id")
+	items, err := service.ListStoredResponseInputItems(c.GetInt("id"), responseId)
+	if err != nil {
+		writeStoredResponseError(c, responseId, err)
+		return
+	}
+	// 默认按时间倒序返回
+	if c.DefaultQuery("order", "desc") != "asc" {
+		slices.Reverse(items)
+	}
+	if after := c.Query("after"); after != "" {
+		idx := slices.IndexFunc(items, func(item json.RawMessage) bool {
+			return gjson.GetBytes(item, "id").String() == after
+		})
+		if idx < 0 {
+			writeInvalidRequestError(c, http.StatusNotFound, fmt.Sprintf("Input item with id '%s' not found.", after), "item_not_found")
+			return
+		}
+		items = items[idx+1:]
+	}
+
+	resp := dto.OpenAIResponsesInputItemList{
+		Object:  "list",
+		Data:    items,
+		HasMore: len(items) > limit,
+	}
+	if resp.HasMore {
+		resp.Data = items[:limit]
+	}
+	if resp.Data == nil {
+		resp.Data = []json.RawMessage{}
+	}
+	if len(resp.Data) > 0 {
+		resp.FirstId = gjson.GetBytes(resp.Data[0], "id").String()
+		resp.LastId = gjson.GetBytes(resp.Data[len(resp.Data)-1], "id").String()
+	}
+	c.JSON(http.StatusOK, resp)
+}
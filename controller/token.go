@@ -5,10 +5,12 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/i18n"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
 	"github.com/QuantumNous/new-api/setting/operation_setting"
 
 	"github.com/gin-gonic/gin"
@@ -20,6 +22,7 @@ func buildMaskedTokenResponse(token *model.Token) *model.Token {
 	}
 	maskedToken := *token
 	maskedToken.Key = token.GetMaskedKey()
+	maskedToken.BudgetUsed = token.BudgetUsedInWindow(common.GetTimestamp())
 	return &maskedToken
 }
 
@@ -89,11 +92,59 @@ func GetTokenKey(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	if token.IsKeyHashed() {
+		common.ApiErrorMsg(c, "令牌密钥已加密存储，无法查看完整密钥，如已遗失请轮换密钥")
+		return
+	}
 	common.ApiSuccess(c, gin.H{
 		"key": token.GetFullKey(),
 	})
 }
 
+type RotateTokenRequest struct {
+	GraceSeconds *int `json:"grace_seconds"` // 旧密钥的宽限期，未指定时使用默认宽限期，0 表示旧密钥立即失效
+}
+
+// RotateTokenKey 为令牌生成新密钥，保留令牌的设置与使用记录，旧密钥在宽限期内仍然有效
+func RotateTokenKey(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	userId := c.GetInt("id")
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	req := RotateTokenRequest{}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+	}
+	graceSeconds := -1
+	if req.GraceSeconds != nil {
+		graceSeconds = max(*req.GraceSeconds, 0)
+	}
+	grace, err := service.GetTokenRotationGraceSeconds(graceSeconds)
+	if err != nil {
+		common.ApiErrorMsg(c, err.Error())
+		return
+	}
+	token, err := model.GetTokenByIds(id, userId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := model.RotateTokenKey(token, grace); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{
+		"key":                 token.GetFullKey(),
+		"key_version":         token.KeyVersion,
+		"previous_key_expiry": token.PreviousKeyExpiry,
+	})
+}
+
 func GetTokenStatus(c *gin.Context) {
 	tokenId := c.GetInt("token_id")
 	userId := c.GetInt("id")
@@ -175,6 +226,26 @@ func AddToken(c *gin.Context) {
 		common.ApiErrorI18n(c, i18n.MsgTokenNameTooLong)
 		return
 	}
+	if !model.IsValidTokenModelFallback(token.ModelFallback) {
+		common.ApiErrorMsg(c, "无效的模型降级设置")
+		return
+	}
+	if token.RateLimitRPM < 0 || token.RateLimitTPM < 0 || token.MaxConcurrency < 0 {
+		common.ApiErrorMsg(c, "限流设置不能为负数")
+		return
+	}
+	if token.BudgetQuota < 0 || token.BudgetSoftLimit < 0 {
+		common.ApiErrorMsg(c, "预算不能为负数")
+		return
+	}
+	if token.BudgetQuota > 0 && !model.IsValidTokenBudgetPeriod(token.BudgetPeriod) {
+		common.ApiErrorMsg(c, "无效的预算周期")
+		return
+	}
+	if token.AutoRotateDays < 0 {
+		common.ApiErrorMsg(c, "自动轮换周期不能为负数")
+		return
+	}
 	// 非无限额度时，检查额度值是否超出有效范围
 	if !token.UnlimitedQuota {
 		if token.RemainQuota < 0 {
@@ -221,15 +292,31 @@ func AddToken(c *gin.Context) {
 		AllowIps:           token.AllowIps,
 		Group:              token.Group,
 		CrossGroupRetry:    token.CrossGroupRetry,
-	}
+		Hedging:            token.Hedging,
+		ModelFallback:      token.ModelFallback,
+		RateLimitRPM:       token.RateLimitRPM,
+		RateLimitTPM:       token.RateLimitTPM,
+		MaxConcurrency:     token.MaxConcurrency,
+		BudgetQuota:        token.BudgetQuota,
+		BudgetSoftLimit:    token.BudgetSoftLimit,
+		BudgetPeriod:       token.BudgetPeriod,
+		AutoRotateDays:     token.AutoRotateDays,
+	}
+	cleanToken.KeyRotatedTime = cleanToken.CreatedTime
+	cleanToken.ResetBudgetWindow(time.Now())
 	err = cleanToken.Insert()
 	if err != nil {
 		common.ApiError(c, err)
 		return
 	}
+	// 加密存储时只在创建时返回完整密钥
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
+		"data": gin.H{
+			"id":  cleanToken.Id,
+			"key": cleanToken.GetFullKey(),
+		},
 	})
 }
 
@@ -260,6 +347,26 @@ func UpdateToken(c *gin.Context) {
 		common.ApiErrorI18n(c, i18n.MsgTokenNameTooLong)
 		return
 	}
+	if !model.IsValidTokenModelFallback(token.ModelFallback) {
+		common.ApiErrorMsg(c, "无效的模型降级设置")
+		return
+	}
+	if token.RateLimitRPM < 0 || token.RateLimitTPM < 0 || token.MaxConcurrency < 0 {
+		common.ApiErrorMsg(c, "限流设置不能为负数")
+		return
+	}
+	if token.BudgetQuota < 0 || token.BudgetSoftLimit < 0 {
+		common.ApiErrorMsg(c, "预算不能为负数")
+		return
+	}
+	if token.BudgetQuota > 0 && !model.IsValidTokenBudgetPeriod(token.BudgetPeriod) {
+		common.ApiErrorMsg(c, "无效的预算周期")
+		return
+	}
+	if token.AutoRotateDays < 0 {
+		common.ApiErrorMsg(c, "自动轮换周期不能为负数")
+		return
+	}
 	if !token.UnlimitedQuota {
 		if token.RemainQuota < 0 {
 			common.ApiErrorI18n(c, i18n.MsgTokenQuotaNegative)
@@ -299,6 +406,24 @@ func UpdateToken(c *gin.Context) {
 		cleanToken.AllowIps = token.AllowIps
 		cleanToken.Group = token.Group
 		cleanToken.CrossGroupRetry = token.CrossGroupRetry
+		cleanToken.Hedging = token.Hedging
+		cleanToken.ModelFallback = token.ModelFallback
+		cleanToken.RateLimitRPM = token.RateLimitRPM
+		cleanToken.RateLimitTPM = token.RateLimitTPM
+		cleanToken.MaxConcurrency = token.MaxConcurrency
+		// 预算周期变化或新开启预算时从当前时间开始新周期
+		budgetWindowChanged := cleanToken.BudgetPeriod != token.BudgetPeriod || (!cleanToken.HasBudget() && token.BudgetQuota > 0)
+		cleanToken.BudgetQuota = token.BudgetQuota
+		cleanToken.BudgetSoftLimit = token.BudgetSoftLimit
+		cleanToken.BudgetPeriod = token.BudgetPeriod
+		if budgetWindowChanged {
+			cleanToken.ResetBudgetWindow(time.Now())
+		}
+		// 开启自动轮换时从当前时间开始计算轮换周期
+		if cleanToken.AutoRotateDays == 0 && token.AutoRotateDays > 0 {
+			cleanToken.KeyRotatedTime = common.GetTimestamp()
+		}
+		cleanToken.AutoRotateDays = token.AutoRotateDays
 	}
 	err = cleanToken.Update()
 	if err != nil {
@@ -353,6 +478,10 @@ func GetTokenKeysBatch(c *gin.Context) {
 	}
 	keysMap := make(map[int]string)
 	for _, t := range tokens {
+		// 加密存储的密钥无法查看
+		if t.IsKeyHashed() {
+			continue
+		}
 		keysMap[t.Id] = t.GetFullKey()
 	}
 	common.ApiSuccess(c, gin.H{"keys": keysMap})
@@ -0,0 +1,194 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ChildTokenRequest struct {
+	ParentId   int      `json:"parent_id"` // 使用用户身份签发时必填，使用令牌签发时父令牌即为当前令牌
+	Name       string   `json:"name"`
+	TTLMinutes int      `json:"ttl_minutes"`
+	Quota      int      `json:"quota"`
+	Models     []string `json:"models"`
+	Group      string   `json:"group"`
+}
+
+// CreateChildToken 签发短期子令牌，可通过父令牌（Bearer sk-xxx）或用户身份（访问令牌/会话）调用
+func CreateChildToken(c *gin.Context) {
+	req := ChildTokenRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if common.GetContextKeyString(c, constant.ContextKeyTokenJWTId) != "" {
+		common.ApiErrorMsg(c, "JWT 令牌不能签发子令牌")
+		return
+	}
+	userId := c.GetInt("id")
+	parentId := req.ParentId
+	if tokenId := common.GetContextKeyInt(c, constant.ContextKeyTokenId); tokenId != 0 {
+		parentId = tokenId
+	}
+	parent, err := model.GetTokenByIds(parentId, userId)
+	if err != nil {
+		common.ApiErrorMsg(c, "父令牌不存在")
+		return
+	}
+	if parent.Status != common.TokenStatusEnabled {
+		common.ApiErrorMsg(c, "父令牌不可用")
+		return
+	}
+	if parent.IsChild() {
+		common.ApiErrorMsg(c, "子令牌不能再签发子令牌")
+		return
+	}
+
+	if req.TTLMinutes <= 0 || req.TTLMinutes > model.MaxChildTokenTTLMinutes {
+		common.ApiErrorMsg(c, fmt.Sprintf("有效期必须在 1 到 %d 分钟之间", model.MaxChildTokenTTLMinutes))
+		return
+	}
+	expiredTime := common.GetTimestamp() + int64(req.TTLMinutes)*60
+	if parent.ExpiredTime != -1 {
+		expiredTime = min(expiredTime, parent.ExpiredTime)
+	}
+	if req.Quota <= 0 {
+		common.ApiErrorMsg(c, "子令牌额度必须大于 0")
+		return
+	}
+
+	modelLimits, err := childTokenModelLimits(parent, req.Models)
+	if err != nil {
+		common.ApiErrorMsg(c, err.Error())
+		return
+	}
+	group, err := childTokenGroup(parent, req.Group)
+	if err != nil {
+		common.ApiErrorMsg(c, err.Error())
+		return
+	}
+
+	name := req.Name
+	if name == "" {
+		name = parent.Name + "-child"
+	}
+	if len(name) > 50 {
+		name = name[:50]
+	}
+	key, err := common.GenerateKey()
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	child := model.Token{
+		UserId:             userId,
+		Name:               name,
+		Key:                key,
+		CreatedTime:        common.GetTimestamp(),
+		AccessedTime:       common.GetTimestamp(),
+		ExpiredTime:        expiredTime,
+		RemainQuota:        req.Quota,
+		ModelLimitsEnabled: len(modelLimits) > 0,
+		ModelLimits:        strings.Join(modelLimits, ","),
+		AllowIps:           parent.AllowIps,
+		Group:              group,
+		CrossGroupRetry:    parent.CrossGroupRetry,
+		Hedging:            parent.Hedging,
+		ModelFallback:      parent.ModelFallback,
+		// 限流与周期预算继承父令牌，并计入父令牌的用量，签发多个子令牌也无法绕过
+		RateLimitRPM:    parent.RateLimitRPM,
+		RateLimitTPM:    parent.RateLimitTPM,
+		MaxConcurrency:  parent.MaxConcurrency,
+		BudgetQuota:     parent.BudgetQuota,
+		BudgetSoftLimit: parent.BudgetSoftLimit,
+		BudgetPeriod:    parent.BudgetPeriod,
+	}
+	if err := model.CreateChildToken(parent, &child); err != nil {
+		if errors.Is(err, model.ErrParentTokenQuotaNotEnough) {
+			common.ApiErrorMsg(c, err.Error())
+			return
+		}
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{
+		"id":           child.Id,
+		"parent_id":    parent.Id,
+		"key":          child.GetFullKey(),
+		"expired_time": child.ExpiredTime,
+		"remain_quota": child.RemainQuota,
+		"model_limits": child.ModelLimits,
+		"group":        child.Group,
+	})
+}
+
+// childTokenModelLimits 子令牌的模型列表必须是父令牌模型限制的子集，未指定时继承父令牌
+func childTokenModelLimits(parent *model.Token, models []string) ([]string, error) {
+	parentModels := []string{}
+	if parent.ModelLimitsEnabled {
+		parentModels = parent.GetModelLimits()
+	}
+	if len(models) == 0 {
+		return parentModels, nil
+	}
+	limits := make([]string, 0, len(models))
+	for _, m := range models {
+		m = strings.TrimSpace(m)
+		if m == "" || slices.Contains(limits, m) {
+			continue
+		}
+		if parent.ModelLimitsEnabled && !slices.Contains(parentModels, m) {
+			return nil, fmt.Errorf("模型 %s 不在父令牌的可用模型中", m)
+		}
+		limits = append(limits, m)
+	}
+	return limits, nil
+}
+
+// childTokenGroup 父令牌指定了分组时子令牌只能使用相同分组，否则只能指定用户可用的分组
+func childTokenGroup(parent *model.Token, group string) (string, error) {
+	if group == "" || group == parent.Group {
+		return parent.Group, nil
+	}
+	if parent.Group != "" {
+		return "", fmt.Errorf("子令牌的分组必须与父令牌一致：%s", parent.Group)
+	}
+	userGroup, err := model.GetUserGroup(parent.UserId, false)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := service.GetUserUsableGroups(userGroup)[group]; !ok {
+		return "", fmt.Errorf("无权访问 %s 分组", group)
+	}
+	return group, nil
+}
+
+// RevokeChildTokens 吊销父令牌的所有子令牌，未用完的额度退还父令牌
+func RevokeChildTokens(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	parent, err := model.GetTokenByIds(id, c.GetInt("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	count, err := model.RevokeChildTokens(parent.Id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, count)
+}
@@ -303,7 +303,7 @@ func GenerateAccessToken(c *gin.Context) {
 		common.SysLog("failed to generate key: " + err.Error())
 		return
 	}
-	user.SetAccessToken(key)
+	user.SetAccessToken(model.StoredAccessToken(key))
 
 	if model.DB.Where("access_token = ?", user.AccessToken).First(user).RowsAffected != 0 {
 		common.ApiErrorI18n(c, i18n.MsgUuidDuplicate)
@@ -315,10 +315,11 @@ func GenerateAccessToken(c *gin.Context) {
 		return
 	}
 
+	// 加密存储时只在生成时返回明文
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
-		"data":    user.AccessToken,
+		"data":    key,
 	})
 	return
 }
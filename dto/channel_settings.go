@@ -1,5 +1,7 @@
 package dto
 
+import "github.com/QuantumNous/new-api/pkg/billingexpr"
+
 type ChannelSettings struct {
 	ForceFormat            bool   `json:"force_format,omitempty"`
 	ThinkingToContent      bool   `json:"thinking_to_content,omitempty"`
@@ -7,6 +9,7 @@ type ChannelSettings struct {
 	PassThroughBodyEnabled bool   `json:"pass_through_body_enabled,omitempty"`
 	SystemPrompt           string `json:"system_prompt,omitempty"`
 	SystemPromptOverride   bool   `json:"system_prompt_override,omitempty"`
+	PIIRedactionEnabled    bool   `json:"pii_redaction_enabled,omitempty"` // 请求发往该渠道前对 PII 脱敏
 }
 
 type VertexKeyType string
@@ -41,6 +44,53 @@ type ChannelOtherSettings struct {
 	UpstreamModelUpdateLastDetectedModels []string      `json:"upstream_model_update_last_detected_models,omitempty"` // 上次检测到的可加入模型
 	UpstreamModelUpdateLastRemovedModels  []string      `json:"upstream_model_update_last_removed_models,omitempty"`  // 上次检测到的可删除模型
 	UpstreamModelUpdateIgnoredModels      []string      `json:"upstream_model_update_ignored_models,omitempty"`       // 手动忽略的模型
+
+	// UpstreamCost 按请求模型配置的上游成本，"*" 为默认，用于按成本选择渠道与统计毛利
+	UpstreamCost map[string]UpstreamCost `json:"upstream_cost,omitempty"`
+}
+
+// UpstreamCost 渠道实际支付给上游的价格，单位为美元 / 1M tokens，与 billingexpr 表达式一致
+type UpstreamCost struct {
+	InputPrice  float64 `json:"input_price,omitempty"`
+	OutputPrice float64 `json:"output_price,omitempty"`
+	// Expr 计费表达式，设置后优先于单价，可使用 p、c、cr、cc 等变量
+	Expr string `json:"expr,omitempty"`
+}
+
+// GetUpstreamCost 获取模型的上游成本，未精确匹配时使用 "*"
+func (s *ChannelOtherSettings) GetUpstreamCost(model string) (UpstreamCost, bool) {
+	if s == nil || len(s.UpstreamCost) == 0 {
+		return UpstreamCost{}, false
+	}
+	if cost, ok := s.UpstreamCost[model]; ok {
+		return cost, true
+	}
+	cost, ok := s.UpstreamCost["*"]
+	return cost, ok
+}
+
+// UsedVars 表达式中单独计价的变量，用于规范化 token 数，单价模式返回 nil
+func (c UpstreamCost) UsedVars() map[string]bool {
+	if c.Expr == "" {
+		return nil
+	}
+	return billingexpr.UsedVars(c.Expr)
+}
+
+// Cost 计算成本（美元），单价模式按完整输入长度计算输入成本
+func (c UpstreamCost) Cost(params billingexpr.TokenParams) (float64, error) {
+	if c.Expr != "" {
+		cost, _, err := billingexpr.RunExpr(c.Expr, params)
+		if err != nil {
+			return 0, err
+		}
+		return cost / 1_000_000, nil
+	}
+	input := params.Len
+	if input == 0 {
+		input = params.P
+	}
+	return (input*c.InputPrice + params.C*c.OutputPrice) / 1_000_000, nil
 }
 
 func (s *ChannelOtherSettings) IsOpenRouterEnterprise() bool {
@@ -0,0 +1,10 @@
+package dto
+
+import "encoding/json"
+
+// OpenAIFineTuningJobList GET /v1/fine_tuning/jobs 的响应，任务对象保留上游原始结构
+type OpenAIFineTuningJobList struct {
+	Object  string            `json:"object"`
+	Data    []json.RawMessage `json:"data"`
+	HasMore bool              `json:"has_more"`
+}
@@ -11,6 +11,9 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// DefaultImageVariationPrompt 不支持原生 variations 的渠道通过图像编辑模拟时使用的默认提示词
+const DefaultImageVariationPrompt = "Create a variation of this image. Keep the main subject, composition and style, but vary the details."
+
 type ImageRequest struct {
 	Model             string          `json:"model"`
 	Prompt            string          `json:"prompt" binding:"required"`
@@ -444,3 +444,18 @@ func GetOpenAIError(errorField any) *types.OpenAIError {
 		}
 	}
 }
+
+// OpenAIResponsesInputItemList GET /v1/responses/{id}/input_items 的响应
+type OpenAIResponsesInputItemList struct {
+	Object  string            `json:"object"`
+	Data    []json.RawMessage `json:"data"`
+	FirstId string            `json:"first_id,omitempty"`
+	LastId  string            `json:"last_id,omitempty"`
+	HasMore bool              `json:"has_more"`
+}
+
+type OpenAIResponsesDeleteResponse struct {
+	Id      string `json:"id"`
+	Object  string `json:"object"`
+	Deleted bool   `json:"deleted"`
+}
@@ -119,6 +119,18 @@ func main() {
 	// Subscription quota reset task (daily/weekly/monthly/custom)
 	service.StartSubscriptionQuotaResetTask()
 
+	// Token previous key expiry and scheduled key rotation
+	service.StartTokenRotationTask()
+
+	// Expired file cleanup task (/v1/files)
+	service.StartFileCleanupTask()
+
+	// Expired stored responses cleanup task (/v1/responses)
+	service.StartResponsesStoreCleanupTask()
+
+	// Batch worker (/v1/batches)
+	controller.StartBatchWorker()
+
 	// Wire task polling adaptor factory (breaks service -> relay import cycle)
 	service.GetTaskAdaptorFunc = func(platform constant.TaskPlatform) service.TaskPollingAdaptor {
 		a := relay.GetTaskAdaptor(platform)
@@ -284,6 +296,22 @@ func InitResources() error {
 		return err
 	}
 
+	// 加密数据无法解密时拒绝启动，避免使用错误的密钥继续服务
+	err = model.CheckEncryptedSecrets()
+	if err != nil {
+		common.FatalLog("failed to check encrypted secrets: " + err.Error())
+		return err
+	}
+
+	if *common.ReencryptSecrets {
+		updated, err := model.ReencryptSecrets()
+		if err != nil {
+			common.FatalLog("failed to re-encrypt secrets: " + err.Error())
+		}
+		common.SysLog(fmt.Sprintf("re-encrypted %d secrets", updated))
+		os.Exit(0)
+	}
+
 	model.CheckSetup()
 
 	// Initialize options, should after model.InitDB()
@@ -228,6 +228,15 @@ func TokenAuthReadOnly() func(c *gin.Context) {
 		key = strings.TrimPrefix(key, "sk-")
 		parts := strings.Split(key, "-")
 		key = parts[0]
+		if model.IsHashedKey(key) {
+			// 数据库中保存的哈希值不能作为密钥使用
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"message": common.TranslateMessage(c, i18n.MsgTokenInvalid),
+			})
+			c.Abort()
+			return
+		}
 
 		token, err := model.GetTokenByKey(key, false)
 		if err != nil {
@@ -316,6 +325,10 @@ func TokenAuth() func(c *gin.Context) {
 		if strings.HasPrefix(key, "Bearer ") || strings.HasPrefix(key, "bearer ") {
 			key = strings.TrimSpace(key[7:])
 		}
+		if service.IsJWTBearer(key) {
+			jwtTokenAuth(c, key)
+			return
+		}
 		if key == "" || key == "midjourney-proxy" {
 			key = c.Request.Header.Get("mj-api-secret")
 			if strings.HasPrefix(key, "Bearer ") || strings.HasPrefix(key, "bearer ") {
@@ -364,48 +377,79 @@ func TokenAuth() func(c *gin.Context) {
 			logger.LogDebug(c, "Client IP %s passed the token IP restrictions check", clientIp)
 		}
 
-		userCache, err := model.GetUserCache(token.UserId)
-		if err != nil {
-			common.SysLog(fmt.Sprintf("TokenAuth GetUserCache error for user %d: %v", token.UserId, err))
-			abortWithOpenAiMessage(c, http.StatusInternalServerError,
-				common.TranslateMessage(c, i18n.MsgDatabaseError))
-			return
-		}
-		userEnabled := userCache.Status == common.UserStatusEnabled
-		if !userEnabled {
-			abortWithOpenAiMessage(c, http.StatusForbidden, common.TranslateMessage(c, i18n.MsgAuthUserBanned))
+		if !SetupContextForTokenUser(c, token) {
 			return
 		}
 
-		userCache.WriteContext(c)
-
-		userGroup := userCache.Group
-		tokenGroup := token.Group
-		if tokenGroup != "" {
-			// check common.UserUsableGroups[userGroup]
-			if _, ok := service.GetUserUsableGroups(userGroup)[tokenGroup]; !ok {
-				abortWithOpenAiMessage(c, http.StatusForbidden, fmt.Sprintf("无权访问 %s 分组", tokenGroup))
-				return
-			}
-			// check group in common.GroupRatio
-			if !ratio_setting.ContainsGroupRatio(tokenGroup) {
-				if tokenGroup != "auto" {
-					abortWithOpenAiMessage(c, http.StatusForbidden, fmt.Sprintf("分组 %s 已被弃用", tokenGroup))
-					return
-				}
-			}
-			userGroup = tokenGroup
-		}
-		common.SetContextKey(c, constant.ContextKeyUsingGroup, userGroup)
-
 		err = SetupContextForToken(c, token, parts...)
 		if err != nil {
 			return
 		}
+		common.SetContextKey(c, constant.ContextKeyTokenKeyVersion, token.UsedKeyVersion(key))
 		c.Next()
 	}
 }
 
+// jwtTokenAuth 使用管理员配置的密钥校验 JWT 令牌，令牌不落库，花费记录在 sub 对应的用户上
+func jwtTokenAuth(c *gin.Context, raw string) {
+	token, claims, err := service.ValidateJWTToken(raw)
+	if err != nil {
+		logger.LogDebug(c, "jwt token rejected: %s", err.Error())
+		abortWithOpenAiMessage(c, http.StatusUnauthorized,
+			common.TranslateMessage(c, i18n.MsgTokenInvalid))
+		return
+	}
+	if !SetupContextForTokenUser(c, token) {
+		return
+	}
+	if err := SetupContextForToken(c, token); err != nil {
+		return
+	}
+	common.SetContextKey(c, constant.ContextKeyTokenJWTId, claims.ID)
+	common.SetContextKey(c, constant.ContextKeyTokenJWTQuota, claims.Quota)
+	common.SetContextKey(c, constant.ContextKeyTokenJWTExpiresAt, token.ExpiredTime)
+	c.Next()
+}
+
+// SetupContextForTokenUser 校验令牌所属用户与令牌分组，并写入用户及使用分组上下文
+// 失败时已写入错误响应并返回 false
+func SetupContextForTokenUser(c *gin.Context, token *model.Token) bool {
+	userCache, err := model.GetUserCache(token.UserId)
+	if err != nil {
+		common.SysLog(fmt.Sprintf("TokenAuth GetUserCache error for user %d: %v", token.UserId, err))
+		abortWithOpenAiMessage(c, http.StatusInternalServerError,
+			common.TranslateMessage(c, i18n.MsgDatabaseError))
+		return false
+	}
+	userEnabled := userCache.Status == common.UserStatusEnabled
+	if !userEnabled {
+		abortWithOpenAiMessage(c, http.StatusForbidden, common.TranslateMessage(c, i18n.MsgAuthUserBanned))
+		return false
+	}
+
+	userCache.WriteContext(c)
+
+	userGroup := userCache.Group
+	tokenGroup := token.Group
+	if tokenGroup != "" {
+		// check common.UserUsableGroups[userGroup]
+		if _, ok := service.GetUserUsableGroups(userGroup)[tokenGroup]; !ok {
+			abortWithOpenAiMessage(c, http.StatusForbidden, fmt.Sprintf("无权访问 %s 分组", tokenGroup))
+			return false
+		}
+		// check group in common.GroupRatio
+		if !ratio_setting.ContainsGroupRatio(tokenGroup) {
+			if tokenGroup != "auto" {
+				abortWithOpenAiMessage(c, http.StatusForbidden, fmt.Sprintf("分组 %s 已被弃用", tokenGroup))
+				return false
+			}
+		}
+		userGroup = tokenGroup
+	}
+	common.SetContextKey(c, constant.ContextKeyUsingGroup, userGroup)
+	return true
+}
+
 func SetupContextForToken(c *gin.Context, token *model.Token, parts ...string) error {
 	if token == nil {
 		return fmt.Errorf("token is nil")
@@ -426,6 +470,18 @@ func SetupContextForToken(c *gin.Context, token *model.Token, parts ...string) e
 	}
 	common.SetContextKey(c, constant.ContextKeyTokenGroup, token.Group)
 	common.SetContextKey(c, constant.ContextKeyTokenCrossGroupRetry, token.CrossGroupRetry)
+	common.SetContextKey(c, constant.ContextKeyTokenHedging, token.Hedging)
+	common.SetContextKey(c, constant.ContextKeyTokenModelFallback, token.ModelFallback)
+	common.SetContextKey(c, constant.ContextKeyTokenRateLimitRPM, token.RateLimitRPM)
+	common.SetContextKey(c, constant.ContextKeyTokenRateLimitTPM, token.RateLimitTPM)
+	common.SetContextKey(c, constant.ContextKeyTokenMaxConcurrency, token.MaxConcurrency)
+	if token.IsChild() {
+		common.SetContextKey(c, constant.ContextKeyTokenParentId, token.ParentId)
+	}
+	if token.HasBudget() {
+		common.SetContextKey(c, constant.ContextKeyTokenBudgetQuota, token.BudgetQuota)
+		common.SetContextKey(c, constant.ContextKeyTokenBudgetSoftLimit, token.BudgetSoftLimit)
+	}
 	if len(parts) > 1 {
 		if model.IsAdmin(token.UserId) {
 			c.Set("specific_channel_id", parts[1])
@@ -134,6 +134,13 @@ func Distribute() func(c *gin.Context) {
 						TokenGroup: usingGroup,
 						Retry:      common.GetPointer(0),
 					})
+					if err != nil || channel == nil {
+						if fallbackChannel, fallbackGroup, fallbackModel, ok := selectFallbackChannel(c, modelRequest.Model, usingGroup); ok {
+							common.SetContextKey(c, constant.ContextKeyRequestedModel, modelRequest.Model)
+							channel, selectGroup, err = fallbackChannel, fallbackGroup, nil
+							modelRequest.Model = fallbackModel
+						}
+					}
 					if err != nil {
 						showGroup := usingGroup
 						if usingGroup == "auto" {
@@ -164,6 +171,22 @@ func Distribute() func(c *gin.Context) {
 	}
 }
 
+// selectFallbackChannel 请求模型没有可用渠道时，按模型降级链选择第一个有可用渠道的模型
+func selectFallbackChannel(c *gin.Context, requestedModel string, usingGroup string) (*model.Channel, string, string, bool) {
+	for _, fallbackModel := range service.GetModelFallbacks(c, requestedModel) {
+		channel, selectGroup, err := service.CacheGetRandomSatisfiedChannel(&service.RetryParam{
+			Ctx:        c,
+			ModelName:  fallbackModel,
+			TokenGroup: usingGroup,
+			Retry:      common.GetPointer(0),
+		})
+		if err == nil && channel != nil {
+			return channel, selectGroup, fallbackModel, true
+		}
+	}
+	return nil, "", "", false
+}
+
 // getModelFromRequest 从请求中读取模型信息
 // 根据 Content-Type 自动处理：
 // - application/json
@@ -262,6 +285,14 @@ func getModelRequest(c *gin.Context) (*ModelRequest, bool, error) {
 		if _, ok := c.Get("relay_mode"); !ok {
 			c.Set("relay_mode", relayMode)
 		}
+	} else if strings.HasPrefix(c.Request.URL.Path, "/v1/fine_tuning/jobs") {
+		// 仅创建微调任务需要分发渠道，后续查询锁定到任务创建时的渠道
+		req, err := getModelFromRequest(c)
+		if err != nil {
+			return nil, false, err
+		}
+		modelRequest.Model = req.Model
+		c.Set("platform", string(constant.TaskPlatformFineTuning))
 	} else if strings.HasPrefix(c.Request.URL.Path, "/v1beta/models/") || strings.HasPrefix(c.Request.URL.Path, "/v1/models/") {
 		// Gemini API 路径处理: /v1beta/models/gemini-2.0-flash:generateContent
 		relayMode := relayconstant.RelayModeGemini
@@ -302,6 +333,11 @@ func getModelRequest(c *gin.Context) (*ModelRequest, bool, error) {
 				modelRequest.Model = req.Model
 			}
 		}
+	} else if strings.HasPrefix(c.Request.URL.Path, "/v1/images/variations") {
+		if req, err := getModelFromRequest(c); err == nil && req.Model != "" {
+			modelRequest.Model = req.Model
+		}
+		modelRequest.Model = common.GetStringIfEmpty(modelRequest.Model, "dall-e-2")
 	}
 	if strings.HasPrefix(c.Request.URL.Path, "/v1/audio") {
 		relayMode := relayconstant.RelayModeAudioSpeech
@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+	"github.com/gin-gonic/gin"
+)
+
+// TokenRateLimit 令牌级 RPM 限流，TPM 与并发限制需要请求内容，在 relay 中处理
+func TokenRateLimit() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		allowed, err := service.TakeTokenRequestRateLimit(c)
+		if err != nil {
+			// 限流存储异常时不影响请求
+			common.SysError("token rate limit failed: " + err.Error())
+			c.Next()
+			return
+		}
+		if !allowed {
+			rpm := common.GetContextKeyInt(c, constant.ContextKeyTokenRateLimitRPM)
+			abortWithOpenAiMessage(c, http.StatusTooManyRequests, fmt.Sprintf("令牌已达到每分钟 %d 次请求的限制", rpm), types.ErrorCodeTokenRateLimitExceeded)
+			return
+		}
+		c.Next()
+	}
+}
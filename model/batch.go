@@ -0,0 +1,192 @@
+package model
+
+import (
+	"errors"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Batch status
+const (
+	BatchStatusValidating = "validating"
+	BatchStatusFailed     = "failed"
+	BatchStatusInProgress = "in_progress"
+	BatchStatusFinalizing = "finalizing"
+	BatchStatusCompleted  = "completed"
+	BatchStatusExpired    = "expired"
+	BatchStatusCancelling = "cancelling"
+	BatchStatusCancelled  = "cancelled"
+)
+
+var ErrBatchNotFound = errors.New("batch not found")
+
+// Batch 通过 /v1/batches 提交的批处理任务，每一行请求由后台任务依次重放到普通中继流程
+type Batch struct {
+	Id               int    `json:"-"`
+	BatchId          string `json:"id" gorm:"type:varchar(64);uniqueIndex"`
+	UserId           int    `json:"-" gorm:"index"`
+	TokenId          int    `json:"-" gorm:"index"`
+	Endpoint         string `json:"endpoint" gorm:"type:varchar(64)"`
+	InputFileId      string `json:"input_file_id" gorm:"type:varchar(64)"`
+	CompletionWindow string `json:"completion_window" gorm:"type:varchar(16)"`
+	Status           string `json:"status" gorm:"type:varchar(16);index"`
+	OutputFileId     string `json:"output_file_id" gorm:"type:varchar(64)"`
+	ErrorFileId      string `json:"error_file_id" gorm:"type:varchar(64)"`
+	Errors           string `json:"errors" gorm:"type:text"`   // 校验错误列表 JSON
+	Metadata         string `json:"metadata" gorm:"type:text"` // 用户元数据 JSON
+	TotalCount       int    `json:"total_count"`
+	CompletedCount   int    `json:"completed_count"`
+	FailedCount      int    `json:"failed_count"`
+	CreatedAt        int64  `json:"created_at" gorm:"bigint;index"`
+	InProgressAt     int64  `json:"in_progress_at" gorm:"bigint"`
+	ExpiresAt        int64  `json:"expires_at" gorm:"bigint"`
+	FinalizingAt     int64  `json:"finalizing_at" gorm:"bigint"`
+	CompletedAt      int64  `json:"completed_at" gorm:"bigint"`
+	FailedAt         int64  `json:"failed_at" gorm:"bigint"`
+	ExpiredAt        int64  `json:"expired_at" gorm:"bigint"`
+	CancellingAt     int64  `json:"cancelling_at" gorm:"bigint"`
+	CancelledAt      int64  `json:"cancelled_at" gorm:"bigint"`
+}
+
+// BatchItem 批处理中单行请求的执行结果，用于断点续跑及生成输出文件，批处理结束后删除
+type BatchItem struct {
+	Id        int    `json:"id"`
+	BatchId   int    `json:"batch_id" gorm:"uniqueIndex:idx_batch_item_line"`
+	LineIndex int    `json:"line_index" gorm:"uniqueIndex:idx_batch_item_line"`
+	Succeeded bool   `json:"succeeded"`
+	Output    string `json:"output" gorm:"type:text"` // 输出文件中的一行 JSON
+}
+
+// GenerateBatchId 生成对外暴露的批处理ID
+func GenerateBatchId() string {
+	return "batch_" + common.GetUUID()
+}
+
+// IsBatchFinished 批处理是否已进入终态
+func IsBatchFinished(status string) bool {
+	switch status {
+	case BatchStatusFailed, BatchStatusCompleted, BatchStatusExpired, BatchStatusCancelled:
+		return true
+	}
+	return false
+}
+
+func (b *Batch) Insert() error {
+	if b.CreatedAt == 0 {
+		b.CreatedAt = common.GetTimestamp()
+	}
+	return DB.Create(b).Error
+}
+
+// UpdateWithStatus 仅当状态仍为 fromStatus 时更新，返回是否更新成功
+func (b *Batch) UpdateWithStatus(fromStatus string, fields map[string]any) (bool, error) {
+	result := DB.Model(&Batch{}).Where("id = ? AND status = ?", b.Id, fromStatus).Updates(fields)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func GetBatchById(id int) (*Batch, error) {
+	var batch Batch
+	if err := DB.Where("id = ?", id).First(&batch).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrBatchNotFound
+		}
+		return nil, err
+	}
+	return &batch, nil
+}
+
+func GetUserBatchById(userId int, batchId string) (*Batch, error) {
+	var batch Batch
+	if err := DB.Where("batch_id = ? AND user_id = ?", batchId, userId).First(&batch).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrBatchNotFound
+		}
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// ListUserBatches 按创建时间倒序列出用户批处理，after 为上一页最后一个批处理ID
+func ListUserBatches(userId int, after string, limit int) ([]*Batch, bool, error) {
+	query := DB.Model(&Batch{}).Where("user_id = ?", userId)
+	if after != "" {
+		cursor, err := GetUserBatchById(userId, after)
+		if err != nil {
+			return nil, false, err
+		}
+		query = query.Where("id < ?", cursor.Id)
+	}
+	var batches []*Batch
+	if err := query.Order("id desc").Limit(limit + 1).Find(&batches).Error; err != nil {
+		return nil, false, err
+	}
+	hasMore := len(batches) > limit
+	if hasMore {
+		batches = batches[:limit]
+	}
+	return batches, hasMore, nil
+}
+
+// GetBatchesByStatus 获取指定状态的批处理，按创建顺序
+func GetBatchesByStatus(statuses []string, limit int) ([]*Batch, error) {
+	var batches []*Batch
+	err := DB.Where("status IN ?", statuses).Order("id asc").Limit(limit).Find(&batches).Error
+	return batches, err
+}
+
+// GetBatchStatus 仅查询批处理状态，用于处理过程中检查是否被取消
+func GetBatchStatus(id int) (string, error) {
+	var status string
+	err := DB.Model(&Batch{}).Where("id = ?", id).Select("status").Scan(&status).Error
+	return status, err
+}
+
+// InsertBatchItem 记录单行结果并累加批处理计数，同一行重复写入时忽略
+func InsertBatchItem(item *BatchItem) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(item)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		column := "failed_count"
+		if item.Succeeded {
+			column = "completed_count"
+		}
+		return tx.Model(&Batch{}).Where("id = ?", item.BatchId).
+			Update(column, gorm.Expr(column+" + ?", 1)).Error
+	})
+}
+
+// GetBatchItemLineIndexes 获取已处理的行号
+func GetBatchItemLineIndexes(batchId int) (map[int]bool, error) {
+	var indexes []int
+	if err := DB.Model(&BatchItem{}).Where("batch_id = ?", batchId).Pluck("line_index", &indexes).Error; err != nil {
+		return nil, err
+	}
+	processed := make(map[int]bool, len(indexes))
+	for _, index := range indexes {
+		processed[index] = true
+	}
+	return processed, nil
+}
+
+// GetBatchItems 按行号顺序分页获取结果
+func GetBatchItems(batchId int, afterLineIndex int, limit int) ([]*BatchItem, error) {
+	var items []*BatchItem
+	err := DB.Where("batch_id = ? AND line_index > ?", batchId, afterLineIndex).
+		Order("line_index asc").Limit(limit).Find(&items).Error
+	return items, err
+}
+
+func DeleteBatchItems(batchId int) error {
+	return DB.Where("batch_id = ?", batchId).Delete(&BatchItem{}).Error
+}
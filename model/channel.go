@@ -12,6 +12,8 @@ import (
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/pkg/billingexpr"
+	keyusage "github.com/QuantumNous/new-api/pkg/key_usage"
 	"github.com/QuantumNous/new-api/types"
 
 	"github.com/samber/lo"
@@ -56,6 +58,9 @@ type Channel struct {
 
 	// cache info
 	Keys []string `json:"-" gorm:"-"`
+
+	// 写入数据库时密钥被加密，写入后还原为明文
+	keyPlaintext string
 }
 
 type ChannelInfo struct {
@@ -66,6 +71,13 @@ type ChannelInfo struct {
 	MultiKeyDisabledTime   map[int]int64         `json:"multi_key_disabled_time,omitempty"`   // key禁用时间列表，key index -> time
 	MultiKeyPollingIndex   int                   `json:"multi_key_polling_index"`             // 多Key模式下轮询的key索引
 	MultiKeyMode           constant.MultiKeyMode `json:"multi_key_mode"`
+	MultiKeyLimits         map[int]MultiKeyLimit `json:"multi_key_limits,omitempty"` // 声明的密钥限额，key index -> limit
+}
+
+// MultiKeyLimit 多密钥模式下单个密钥声明的上游限额，0 表示不限制
+type MultiKeyLimit struct {
+	RPM int `json:"rpm"`
+	TPM int `json:"tpm"`
 }
 
 type ChannelSortOptions struct {
@@ -178,7 +190,8 @@ func (channel *Channel) GetKeys() []string {
 	if len(channel.Keys) > 0 {
 		return channel.Keys
 	}
-	trimmed := strings.TrimSpace(channel.Key)
+	plainKey := channel.decryptedKey()
+	trimmed := strings.TrimSpace(plainKey)
 	// If the key starts with '[', try to parse it as a JSON array (e.g., for Vertex AI scenarios)
 	if strings.HasPrefix(trimmed, "[") {
 		var arr []json.RawMessage
@@ -191,14 +204,30 @@ func (channel *Channel) GetKeys() []string {
 		}
 	}
 	// Otherwise, fall back to splitting by newline
-	keys := strings.Split(strings.Trim(channel.Key, "\n"), "\n")
+	keys := strings.Split(strings.Trim(plainKey, "\n"), "\n")
 	return keys
 }
 
-func (channel *Channel) GetNextEnabledKey() (string, int, *types.NewAPIError) {
+// GetKeyByIndex 获取多 key 渠道中指定序号的 key，用于异步任务查询时复用创建任务所用的 key；
+// 单 key 渠道返回完整 key，序号越界时返回第一个 key
+func (channel *Channel) GetKeyByIndex(index int) string {
+	if !channel.ChannelInfo.IsMultiKey {
+		return channel.decryptedKey()
+	}
+	keys := channel.GetKeys()
+	if len(keys) == 0 {
+		return ""
+	}
+	if index < 0 || index >= len(keys) {
+		index = 0
+	}
+	return strings.TrimSpace(keys[index])
+}
+
+func (channel *Channel) GetNextEnabledKey() (key string, keyIndex int, apiErr *types.NewAPIError) {
 	// If not in multi-key mode, return the original key string directly.
 	if !channel.ChannelInfo.IsMultiKey {
-		return channel.Key, 0, nil
+		return channel.decryptedKey(), 0, nil
 	}
 
 	// Obtain all keys (split by \n)
@@ -211,6 +240,12 @@ func (channel *Channel) GetNextEnabledKey() (string, int, *types.NewAPIError) {
 	lock := GetChannelPollingLock(channel.Id)
 	lock.Lock()
 	defer lock.Unlock()
+	// 在锁内记录使用情况，保证按使用情况选择密钥的模式不会并发选中同一个密钥
+	defer func() {
+		if apiErr == nil {
+			keyusage.MarkUsed(channel.Id, keyIndex)
+		}
+	}()
 
 	statusList := channel.ChannelInfo.MultiKeyStatusList
 	// helper to get key status, default to enabled when missing
@@ -237,6 +272,12 @@ func (channel *Channel) GetNextEnabledKey() (string, int, *types.NewAPIError) {
 	if len(enabledIdx) == 0 {
 		return "", 0, types.NewError(errors.New("no enabled keys"), types.ErrorCodeChannelNoAvailableKey)
 	}
+	// 跳过熔断中的密钥，全部熔断时仍使用所有启用的密钥，避免渠道完全不可用
+	enabledIdx = filterCircuitBreakerKeys(channel.Id, enabledIdx)
+	selectable := make(map[int]bool, len(enabledIdx))
+	for _, idx := range enabledIdx {
+		selectable[idx] = true
+	}
 
 	switch channel.ChannelInfo.MultiKeyMode {
 	case constant.MultiKeyModeRandom:
@@ -268,7 +309,7 @@ func (channel *Channel) GetNextEnabledKey() (string, int, *types.NewAPIError) {
 		}
 		for i := 0; i < len(keys); i++ {
 			idx := (start + i) % len(keys)
-			if getStatus(idx) == common.ChannelStatusEnabled {
+			if selectable[idx] {
 				// update polling index for next call (point to the next position)
 				channel.ChannelInfo.MultiKeyPollingIndex = (idx + 1) % len(keys)
 				return keys[idx], idx, nil
@@ -276,6 +317,15 @@ func (channel *Channel) GetNextEnabledKey() (string, int, *types.NewAPIError) {
 		}
 		// Fallback – should not happen, but return first enabled key
 		return keys[enabledIdx[0]], enabledIdx[0], nil
+	case constant.MultiKeyModeLeastUsed:
+		selectedIdx := pickLeastUsedKey(channel.Id, enabledIdx)
+		return keys[selectedIdx], selectedIdx, nil
+	case constant.MultiKeyModeWeighted:
+		selectedIdx := pickWeightedLimitKey(channel.Id, channel.ChannelInfo.MultiKeyLimits, enabledIdx)
+		return keys[selectedIdx], selectedIdx, nil
+	case constant.MultiKeyModeRateLimit:
+		selectedIdx := pickRateLimitAwareKey(channel.Id, enabledIdx)
+		return keys[selectedIdx], selectedIdx, nil
 	default:
 		// Unknown mode, default to first enabled key (or original key string)
 		return keys[enabledIdx[0]], enabledIdx[0], nil
@@ -923,8 +973,8 @@ func (channel *Channel) GetSetting() dto.ChannelSettings {
 		err := common.Unmarshal([]byte(*channel.Setting), &setting)
 		if err != nil {
 			common.SysLog(fmt.Sprintf("failed to unmarshal setting: channel_id=%d, error=%v", channel.Id, err))
-			channel.Setting = nil // 清空设置以避免后续错误
-			_ = channel.Save()    // 保存修改
+			channel.Setting = nil        // 清空设置以避免后续错误
+			_ = channel.SaveWithoutKey() // 保存修改
 		}
 	}
 	return setting
@@ -946,7 +996,7 @@ func (channel *Channel) GetOtherSettings() dto.ChannelOtherSettings {
 		if err != nil {
 			common.SysLog(fmt.Sprintf("failed to unmarshal setting: channel_id=%d, error=%v", channel.Id, err))
 			channel.OtherSettings = "{}" // 清空设置以避免后续错误
-			_ = channel.Save()           // 保存修改
+			_ = channel.SaveWithoutKey() // 保存修改
 		}
 	}
 	return setting
@@ -961,6 +1011,26 @@ func (channel *Channel) SetOtherSettings(setting dto.ChannelOtherSettings) {
 	channel.OtherSettings = string(settingBytes)
 }
 
+// EstimateUpstreamCost 按渠道配置的上游成本估算请求成本（美元），未配置时返回 false
+func (channel *Channel) EstimateUpstreamCost(model string, params billingexpr.TokenParams) (float64, bool) {
+	if channel.OtherSettings == "" {
+		return 0, false
+	}
+	setting := dto.ChannelOtherSettings{}
+	if err := common.UnmarshalJsonStr(channel.OtherSettings, &setting); err != nil {
+		return 0, false
+	}
+	upstreamCost, ok := setting.GetUpstreamCost(model)
+	if !ok {
+		return 0, false
+	}
+	cost, err := upstreamCost.Cost(params)
+	if err != nil {
+		return 0, false
+	}
+	return cost, true
+}
+
 func (channel *Channel) GetParamOverride() map[string]interface{} {
 	paramOverride := make(map[string]interface{})
 	if channel.ParamOverride != nil && *channel.ParamOverride != "" {
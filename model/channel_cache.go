@@ -3,7 +3,6 @@ package model
 import (
 	"errors"
 	"fmt"
-	"math/rand"
 	"sort"
 	"strings"
 	"sync"
@@ -94,41 +93,33 @@ func SyncChannelCache(frequency int) {
 }
 
 func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel, error) {
-	// if memory cache is disabled, get channel directly from database
-	if !common.MemoryCacheEnabled {
-		return GetChannel(group, model, retry)
-	}
-
-	channelSyncLock.RLock()
-	defer channelSyncLock.RUnlock()
-
-	// First, try to find channels with the exact model name.
-	channels := group2model2channels[group][model]
+	return GetRandomSatisfiedChannelWithHint(group, model, retry, ChannelSelectHint{})
+}
 
-	// If no channels found, try to find channels with the normalized model name.
-	if len(channels) == 0 {
-		normalizedModel := ratio_setting.FormatMatchingModelName(model)
-		channels = group2model2channels[group][normalizedModel]
+// GetRandomSatisfiedChannelWithHint 与 GetRandomSatisfiedChannel 相同，hint 提供请求的预估 token 数用于按成本选择
+func GetRandomSatisfiedChannelWithHint(group string, model string, retry int, hint ChannelSelectHint) (*Channel, error) {
+	var channels []*Channel
+	var err error
+	if common.MemoryCacheEnabled {
+		channels, err = getSatisfiedChannelCandidates(group, model)
+	} else {
+		// 未启用内存缓存时从数据库读取候选渠道，之后与缓存一致地熔断过滤并按策略选择
+		channels, err = getSatisfiedChannelCandidatesFromDB(group, model)
 	}
-
-	if len(channels) == 0 {
-		return nil, nil
+	if err != nil || len(channels) == 0 {
+		return nil, err
 	}
 
+	// 熔断状态查询可能访问 Redis，在释放 channelSyncLock 后进行
+	channels = filterCircuitBreakerChannels(channels)
+
 	if len(channels) == 1 {
-		if channel, ok := channelsIDM[channels[0]]; ok {
-			return channel, nil
-		}
-		return nil, fmt.Errorf("数据库一致性错误，渠道# %d 不存在，请联系管理员修复", channels[0])
+		return channels[0], nil
 	}
 
 	uniquePriorities := make(map[int]bool)
-	for _, channelId := range channels {
-		if channel, ok := channelsIDM[channelId]; ok {
-			uniquePriorities[int(channel.GetPriority())] = true
-		} else {
-			return nil, fmt.Errorf("数据库一致性错误，渠道# %d 不存在，请联系管理员修复", channelId)
-		}
+	for _, channel := range channels {
+		uniquePriorities[int(channel.GetPriority())] = true
 	}
 	var sortedUniquePriorities []int
 	for priority := range uniquePriorities {
@@ -142,16 +133,10 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 	targetPriority := int64(sortedUniquePriorities[retry])
 
 	// get the priority for the given retry number
-	var sumWeight = 0
 	var targetChannels []*Channel
-	for _, channelId := range channels {
-		if channel, ok := channelsIDM[channelId]; ok {
-			if channel.GetPriority() == targetPriority {
-				sumWeight += channel.GetWeight()
-				targetChannels = append(targetChannels, channel)
-			}
-		} else {
-			return nil, fmt.Errorf("数据库一致性错误，渠道# %d 不存在，请联系管理员修复", channelId)
+	for _, channel := range channels {
+		if channel.GetPriority() == targetPriority {
+			targetChannels = append(targetChannels, channel)
 		}
 	}
 
@@ -159,35 +144,55 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 		return nil, errors.New(fmt.Sprintf("no channel found, group: %s, model: %s, priority: %d", group, model, targetPriority))
 	}
 
-	// smoothing factor and adjustment
-	smoothingFactor := 1
-	smoothingAdjustment := 0
+	return selectChannelByStrategy(group, model, targetChannels, hint)
+}
 
-	if sumWeight == 0 {
-		// when all channels have weight 0, set sumWeight to the number of channels and set smoothing adjustment to 100
-		// each channel's effective weight = 100
-		sumWeight = len(targetChannels) * 100
-		smoothingAdjustment = 100
-	} else if sumWeight/len(targetChannels) < 10 {
-		// when the average weight is less than 10, set smoothing factor to 100
-		smoothingFactor = 100
-	}
+// getSatisfiedChannelCandidates 在 channelSyncLock 内取出分组下支持该模型的渠道
+func getSatisfiedChannelCandidates(group string, model string) ([]*Channel, error) {
+	channelSyncLock.RLock()
+	defer channelSyncLock.RUnlock()
+
+	// First, try to find channels with the exact model name.
+	channelIds := group2model2channels[group][model]
 
-	// Calculate the total weight of all channels up to endIdx
-	totalWeight := sumWeight * smoothingFactor
+	// If no channels found, try to find channels with the normalized model name.
+	if len(channelIds) == 0 {
+		normalizedModel := ratio_setting.FormatMatchingModelName(model)
+		channelIds = group2model2channels[group][normalizedModel]
+	}
 
-	// Generate a random value in the range [0, totalWeight)
-	randomWeight := rand.Intn(totalWeight)
+	channels := make([]*Channel, 0, len(channelIds))
+	for _, channelId := range channelIds {
+		channel, ok := channelsIDM[channelId]
+		if !ok {
+			return nil, fmt.Errorf("数据库一致性错误，渠道# %d 不存在，请联系管理员修复", channelId)
+		}
+		channels = append(channels, channel)
+	}
+	return channels, nil
+}
 
-	// Find a channel based on its weight
-	for _, channel := range targetChannels {
-		randomWeight -= channel.GetWeight()*smoothingFactor + smoothingAdjustment
-		if randomWeight < 0 {
-			return channel, nil
+// getSatisfiedChannelCandidatesFromDB 从数据库取出分组下支持该模型的已启用渠道
+func getSatisfiedChannelCandidatesFromDB(group string, model string) ([]*Channel, error) {
+	var channelIds []int
+	for _, name := range []string{model, ratio_setting.FormatMatchingModelName(model)} {
+		err := DB.Model(&Ability{}).Where(commonGroupCol+" = ? and model = ? and enabled = ?", group, name, true).
+			Pluck("channel_id", &channelIds).Error
+		if err != nil {
+			return nil, err
 		}
+		if len(channelIds) > 0 {
+			break
+		}
+	}
+	if len(channelIds) == 0 {
+		return nil, nil
+	}
+	var channels []*Channel
+	if err := DB.Where("id IN ?", channelIds).Find(&channels).Error; err != nil {
+		return nil, err
 	}
-	// return null if no channel is not found
-	return nil, errors.New("channel not found")
+	return channels, nil
 }
 
 func CacheGetChannel(id int) (*Channel, error) {
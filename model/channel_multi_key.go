@@ -0,0 +1,102 @@
+package model
+
+import (
+	"math/rand"
+	"time"
+
+	keyusage "github.com/QuantumNous/new-api/pkg/key_usage"
+)
+
+// pickLeastUsedKey 选择最久未被使用的密钥，从未使用过的密钥优先，相同时随机
+func pickLeastUsedKey(channelId int, keyIndexes []int) int {
+	var oldest time.Time
+	candidates := make([]int, 0, len(keyIndexes))
+	for _, idx := range keyIndexes {
+		lastUsed := keyusage.Get(channelId, idx).LastUsed()
+		switch {
+		case len(candidates) == 0 || lastUsed.Before(oldest):
+			oldest = lastUsed
+			candidates = append(candidates[:0], idx)
+		case lastUsed.Equal(oldest):
+			candidates = append(candidates, idx)
+		}
+	}
+	return candidates[rand.Intn(len(candidates))]
+}
+
+// pickWeightedLimitKey 按声明的限额在当前分钟内的剩余量加权随机选择密钥。
+// 有密钥声明 RPM 时按剩余 RPM 加权，TPM 仅按剩余比例折算；都未声明 RPM 时按剩余 TPM 加权。
+// 未声明限额的密钥使用已声明密钥的平均限额，所有密钥都已用满时退化为最久未使用
+func pickWeightedLimitKey(channelId int, limits map[int]MultiKeyLimit, keyIndexes []int) int {
+	byRPM := false
+	for _, idx := range keyIndexes {
+		if limits[idx].RPM > 0 {
+			byRPM = true
+			break
+		}
+	}
+	capacityOf := func(limit MultiKeyLimit) int {
+		if byRPM {
+			return limit.RPM
+		}
+		return limit.TPM
+	}
+	declared, total := 0, 0
+	for _, idx := range keyIndexes {
+		if capacity := capacityOf(limits[idx]); capacity > 0 {
+			declared++
+			total += capacity
+		}
+	}
+	defaultCapacity := 1.0
+	if declared > 0 {
+		defaultCapacity = float64(total) / float64(declared)
+	}
+
+	weights := make([]float64, len(keyIndexes))
+	for i, idx := range keyIndexes {
+		limit := limits[idx]
+		usage := keyusage.Get(channelId, idx)
+		weight := defaultCapacity
+		if capacity := capacityOf(limit); capacity > 0 {
+			used := usage.MinuteTokens
+			if byRPM {
+				used = usage.MinuteRequests
+			}
+			weight = float64(int64(capacity) - used)
+		}
+		if byRPM && limit.TPM > 0 {
+			weight *= float64(int64(limit.TPM)-usage.MinuteTokens) / float64(limit.TPM)
+		}
+		weights[i] = max(weight, 0)
+	}
+	index := pickWeightedIndex(weights, -1)
+	if index < 0 {
+		return pickLeastUsedKey(channelId, keyIndexes)
+	}
+	return keyIndexes[index]
+}
+
+// pickRateLimitAwareKey 跳过因上游限流冷却中的密钥，在其余密钥中选择最久未使用的。
+// 全部冷却时选择最早结束冷却的密钥
+func pickRateLimitAwareKey(channelId int, keyIndexes []int) int {
+	now := time.Now()
+	available := make([]int, 0, len(keyIndexes))
+	earliest := -1
+	var earliestEnd time.Time
+	for _, idx := range keyIndexes {
+		usage := keyusage.Get(channelId, idx)
+		if !usage.CoolingDown(now) {
+			available = append(available, idx)
+			continue
+		}
+		if earliest < 0 || usage.CooldownEnd().Before(earliestEnd) {
+			earliest = idx
+			earliestEnd = usage.CooldownEnd()
+		}
+	}
+	if len(available) == 0 {
+		return earliest
+	}
+	return pickLeastUsedKey(channelId, available)
+}
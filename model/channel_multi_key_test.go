@@ -0,0 +1,58 @@
+package model
+
+import (
+	"net/http"
+	"testing"
+
+	keyusage "github.com/QuantumNous/new-api/pkg/key_usage"
+	"github.com/stretchr/testify/require"
+)
+
+func resetKeyUsage(t *testing.T) {
+	t.Helper()
+	keyusage.Reset()
+	t.Cleanup(keyusage.Reset)
+}
+
+func TestPickLeastUsedKey(t *testing.T) {
+	resetKeyUsage(t)
+	keyusage.MarkUsed(1, 0)
+	keyusage.MarkUsed(1, 2)
+	require.Equal(t, 1, pickLeastUsedKey(1, []int{0, 1, 2}))
+
+	keyusage.MarkUsed(1, 1)
+	require.Equal(t, 0, pickLeastUsedKey(1, []int{0, 1, 2}))
+}
+
+func TestPickWeightedLimitKey(t *testing.T) {
+	resetKeyUsage(t)
+	limits := map[int]MultiKeyLimit{0: {RPM: 2}, 1: {RPM: 100}}
+	keyusage.MarkUsed(1, 0)
+	keyusage.MarkUsed(1, 0)
+	// 密钥 0 当前分钟已用满
+	for i := 0; i < 20; i++ {
+		require.Equal(t, 1, pickWeightedLimitKey(1, limits, []int{0, 1}))
+	}
+
+	// TPM 用满时同样不再选择
+	limits = map[int]MultiKeyLimit{0: {RPM: 100, TPM: 1000}, 1: {RPM: 100, TPM: 1000}}
+	keyusage.AddTokens(1, 1, 1000)
+	for i := 0; i < 20; i++ {
+		require.Equal(t, 0, pickWeightedLimitKey(1, limits, []int{0, 1}))
+	}
+}
+
+func TestPickRateLimitAwareKey(t *testing.T) {
+	resetKeyUsage(t)
+	header := http.Header{}
+	header.Set("retry-after", "60")
+	keyusage.ObserveResponse(1, 0, http.StatusTooManyRequests, header)
+	for i := 0; i < 10; i++ {
+		require.Equal(t, 1, pickRateLimitAwareKey(1, []int{0, 1}))
+	}
+
+	header.Set("retry-after", "30")
+	keyusage.ObserveResponse(1, 1, http.StatusTooManyRequests, header)
+	// 全部冷却时选择最早恢复的密钥
+	require.Equal(t, 1, pickRateLimitAwareKey(1, []int{0, 1}))
+}
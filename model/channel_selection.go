@@ -0,0 +1,295 @@
+package model
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"slices"
+
+	"github.com/QuantumNous/new-api/pkg/billingexpr"
+	channelstats "github.com/QuantumNous/new-api/pkg/channel_stats"
+	circuitbreaker "github.com/QuantumNous/new-api/pkg/circuit_breaker"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+)
+
+// ChannelSelectHint 选择渠道时可用的请求信息，用于按成本选择
+type ChannelSelectHint struct {
+	PromptTokens     int
+	CompletionTokens int
+}
+
+func (h ChannelSelectHint) tokenParams() billingexpr.TokenParams {
+	completionTokens := h.CompletionTokens
+	if completionTokens <= 0 {
+		completionTokens = operation_setting.GetChannelSelectionSetting().CostCompletionTokens
+	}
+	return billingexpr.TokenParams{
+		P:   float64(h.PromptTokens),
+		C:   float64(completionTokens),
+		Len: float64(h.PromptTokens),
+	}
+}
+
+// selectChannelByStrategy 在同优先级的渠道中按分组配置的策略选择渠道。
+// 统计样本不足或已过期的渠道视为健康，保证新渠道与恢复后的渠道能重新获得流量。
+func selectChannelByStrategy(group string, model string, channels []*Channel, hint ChannelSelectHint) (*Channel, error) {
+	if len(channels) == 1 {
+		return channels[0], nil
+	}
+	weights := channelBaseWeights(channels)
+	switch operation_setting.GetChannelSelectionStrategy(group) {
+	case operation_setting.ChannelSelectionLeastLatency:
+		return pickLeastLatencyChannel(model, channels, weights)
+	case operation_setting.ChannelSelectionErrorRate:
+		return pickErrorRateChannel(model, channels, weights)
+	case operation_setting.ChannelSelectionPowerOfTwo:
+		return pickPowerOfTwoChannel(model, channels, weights)
+	case operation_setting.ChannelSelectionLowestCost:
+		return pickLowestCostChannel(model, channels, weights, hint)
+	default:
+		return pickWeightedChannel(channels, weights)
+	}
+}
+
+// filterCircuitBreakerChannels 过滤熔断中的渠道，全部熔断时保留原列表，避免分组完全不可用。
+// 多密钥渠道按密钥熔断，在选择密钥时处理。熔断状态可能存储在 Redis 中，调用方不应持有 channelSyncLock
+func filterCircuitBreakerChannels(channels []*Channel) []*Channel {
+	if !circuitbreaker.Enabled() {
+		return channels
+	}
+	keys := make([]string, 0, len(channels))
+	for _, channel := range channels {
+		if !channel.ChannelInfo.IsMultiKey {
+			keys = append(keys, circuitbreaker.ChannelKey(channel.Id))
+		}
+	}
+	allowed := circuitbreaker.AllowMany(keys)
+	available := make([]*Channel, 0, len(channels))
+	next := 0
+	for _, channel := range channels {
+		if channel.ChannelInfo.IsMultiKey {
+			available = append(available, channel)
+			continue
+		}
+		if allowed[next] {
+			available = append(available, channel)
+		}
+		next++
+	}
+	if len(available) == 0 {
+		return channels
+	}
+	return available
+}
+
+// filterCircuitBreakerKeys 过滤多密钥渠道中熔断中的密钥，全部熔断时保留原列表
+func filterCircuitBreakerKeys(channelId int, keyIndexes []int) []int {
+	if !circuitbreaker.Enabled() {
+		return keyIndexes
+	}
+	keys := make([]string, len(keyIndexes))
+	for i, idx := range keyIndexes {
+		keys[i] = circuitbreaker.ChannelKeyIndexKey(channelId, idx)
+	}
+	allowed := circuitbreaker.AllowMany(keys)
+	available := make([]int, 0, len(keyIndexes))
+	for i, idx := range keyIndexes {
+		if allowed[i] {
+			available = append(available, idx)
+		}
+	}
+	if len(available) == 0 {
+		return keyIndexes
+	}
+	return available
+}
+
+// channelBaseWeights 计算渠道的基础权重
+func channelBaseWeights(channels []*Channel) []float64 {
+	sumWeight := 0
+	for _, channel := range channels {
+		sumWeight += channel.GetWeight()
+	}
+
+	// smoothing factor and adjustment
+	smoothingFactor := 1
+	smoothingAdjustment := 0
+	if sumWeight == 0 {
+		// when all channels have weight 0, each channel's effective weight = 100
+		smoothingAdjustment = 100
+	} else if sumWeight/len(channels) < 10 {
+		// when the average weight is less than 10, set smoothing factor to 100
+		smoothingFactor = 100
+	}
+
+	weights := make([]float64, len(channels))
+	for i, channel := range channels {
+		weights[i] = float64(channel.GetWeight()*smoothingFactor + smoothingAdjustment)
+	}
+	return weights
+}
+
+func pickWeightedChannel(channels []*Channel, weights []float64) (*Channel, error) {
+	index := pickWeightedIndex(weights, -1)
+	if index < 0 {
+		return nil, errors.New("channel not found")
+	}
+	return channels[index], nil
+}
+
+// pickWeightedIndex 按权重随机选择下标，exclude 为需要排除的下标
+func pickWeightedIndex(weights []float64, exclude int) int {
+	totalWeight := 0.0
+	for i, weight := range weights {
+		if i != exclude && weight > 0 {
+			totalWeight += weight
+		}
+	}
+	if totalWeight <= 0 {
+		return -1
+	}
+	randomWeight := rand.Float64() * totalWeight
+	last := -1
+	for i, weight := range weights {
+		if i == exclude || weight <= 0 {
+			continue
+		}
+		last = i
+		randomWeight -= weight
+		if randomWeight < 0 {
+			return i
+		}
+	}
+	return last
+}
+
+func isWarmStats(stats channelstats.Stats) bool {
+	minSamples := operation_setting.GetChannelSelectionSetting().MinSamples
+	return stats.Samples > 0 && stats.Samples >= int64(minSamples)
+}
+
+// channelLatency 优先使用首字延迟，没有流式样本时使用总耗时
+func channelLatency(stats channelstats.Stats) float64 {
+	if stats.TtftMs > 0 {
+		return stats.TtftMs
+	}
+	return stats.LatencyMs
+}
+
+// pickLeastLatencyChannel 选择滚动平均延迟最低的渠道，延迟相同时按权重随机。
+// 样本不足的渠道按 ColdProbePercent 分得探测流量，其余请求中按已预热渠道的延迟中位数参与比较，
+// 全部未预热时按权重随机
+func pickLeastLatencyChannel(model string, channels []*Channel, weights []float64) (*Channel, error) {
+	latencies := make([]float64, len(channels))
+	warm := make([]bool, len(channels))
+	warmLatencies := make([]float64, 0, len(channels))
+	coldWeights := make([]float64, len(channels))
+	for i, channel := range channels {
+		if stats := channelstats.Get(channel.Id, model); isWarmStats(stats) {
+			latencies[i] = channelLatency(stats)
+			warm[i] = true
+			warmLatencies = append(warmLatencies, latencies[i])
+		} else {
+			coldWeights[i] = math.Max(weights[i], 1)
+		}
+	}
+	hasCold := len(warmLatencies) < len(channels)
+	probePercent := operation_setting.GetChannelSelectionSetting().ColdProbePercent
+	if hasCold && len(warmLatencies) > 0 && rand.Intn(100) < probePercent {
+		return pickWeightedChannel(channels, coldWeights)
+	}
+	coldLatency := medianLatency(warmLatencies)
+	best := math.MaxFloat64
+	candidateWeights := make([]float64, len(channels))
+	for i, latency := range latencies {
+		if !warm[i] {
+			latency = coldLatency
+		}
+		if latency < best {
+			best = latency
+			clear(candidateWeights)
+		}
+		if latency == best {
+			candidateWeights[i] = math.Max(weights[i], 1)
+		}
+	}
+	return pickWeightedChannel(channels, candidateWeights)
+}
+
+// medianLatency 计算延迟中位数，没有样本时返回 0
+func medianLatency(latencies []float64) float64 {
+	if len(latencies) == 0 {
+		return 0
+	}
+	sorted := slices.Clone(latencies)
+	slices.Sort(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// pickErrorRateChannel 按错误率衰减权重后随机选择，保留最低权重用于探测渠道是否恢复
+func pickErrorRateChannel(model string, channels []*Channel, weights []float64) (*Channel, error) {
+	minFactor := float64(operation_setting.GetChannelSelectionSetting().MinWeightPercent) / 100
+	adjusted := make([]float64, len(channels))
+	for i, channel := range channels {
+		factor := 1.0
+		if stats := channelstats.Get(channel.Id, model); isWarmStats(stats) {
+			factor = math.Pow(1-stats.ErrorRate, 2)
+		}
+		adjusted[i] = weights[i] * math.Max(factor, minFactor)
+	}
+	return pickWeightedChannel(channels, adjusted)
+}
+
+// channelScore 综合延迟、并发与错误率的得分，越小越好
+func channelScore(stats channelstats.Stats) float64 {
+	if !isWarmStats(stats) {
+		return float64(stats.InFlight)
+	}
+	successRate := math.Max(1-stats.ErrorRate, 0.01)
+	return math.Max(channelLatency(stats), 1) * float64(stats.InFlight+1) / successRate
+}
+
+// pickPowerOfTwoChannel 按权重随机抽取两个渠道，选择得分更优的一个
+func pickPowerOfTwoChannel(model string, channels []*Channel, weights []float64) (*Channel, error) {
+	first := pickWeightedIndex(weights, -1)
+	if first < 0 {
+		return nil, errors.New("channel not found")
+	}
+	second := pickWeightedIndex(weights, first)
+	if second < 0 {
+		return channels[first], nil
+	}
+	if channelScore(channelstats.Get(channels[second].Id, model)) < channelScore(channelstats.Get(channels[first].Id, model)) {
+		return channels[second], nil
+	}
+	return channels[first], nil
+}
+
+// pickLowestCostChannel 选择本次请求预估上游成本最低的渠道，成本相同时按权重随机。
+// 未配置上游成本的渠道仅在所有渠道都未配置时参与选择
+func pickLowestCostChannel(model string, channels []*Channel, weights []float64, hint ChannelSelectHint) (*Channel, error) {
+	params := hint.tokenParams()
+	best := math.MaxFloat64
+	candidateWeights := make([]float64, len(channels))
+	for i, channel := range channels {
+		cost, ok := channel.EstimateUpstreamCost(model, params)
+		if !ok {
+			continue
+		}
+		if cost < best {
+			best = cost
+			clear(candidateWeights)
+		}
+		if cost == best {
+			candidateWeights[i] = math.Max(weights[i], 1)
+		}
+	}
+	if best == math.MaxFloat64 {
+		return pickWeightedChannel(channels, weights)
+	}
+	return pickWeightedChannel(channels, candidateWeights)
+}
@@ -0,0 +1,186 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	circuitbreaker "github.com/QuantumNous/new-api/pkg/circuit_breaker"
+	channelstats "github.com/QuantumNous/new-api/pkg/channel_stats"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/stretchr/testify/require"
+)
+
+func recordChannelSamples(channelId int, model string, count int, sample channelstats.Sample) {
+	for i := 0; i < count; i++ {
+		channelstats.Begin(channelId, model)
+		channelstats.Finish(channelId, model, sample)
+	}
+}
+
+func withChannelSelectionStrategy(t *testing.T, group string, strategy string) {
+	t.Helper()
+	setting := operation_setting.GetChannelSelectionSetting()
+	previous := setting.GroupStrategies
+	setting.GroupStrategies = map[string]string{group: strategy}
+	channelstats.Reset()
+	t.Cleanup(func() {
+		setting.GroupStrategies = previous
+		channelstats.Reset()
+	})
+}
+
+func TestSelectChannelByStrategyLeastLatency(t *testing.T) {
+	withChannelSelectionStrategy(t, "default", operation_setting.ChannelSelectionLeastLatency)
+	channels := []*Channel{{Id: 1}, {Id: 2}}
+	recordChannelSamples(1, "gpt-4o", 10, channelstats.Sample{Success: true, LatencyMs: 3000})
+	recordChannelSamples(2, "gpt-4o", 10, channelstats.Sample{Success: true, LatencyMs: 500})
+
+	for i := 0; i < 20; i++ {
+		channel, err := selectChannelByStrategy("default", "gpt-4o", channels, ChannelSelectHint{})
+		require.NoError(t, err)
+		require.Equal(t, 2, channel.Id)
+	}
+
+	// 样本不足的渠道只分得探测流量，其余请求仍选择最快的渠道
+	channels = append(channels, &Channel{Id: 3})
+	picked := map[int]int{}
+	for i := 0; i < 2000; i++ {
+		channel, err := selectChannelByStrategy("default", "gpt-4o", channels, ChannelSelectHint{})
+		require.NoError(t, err)
+		picked[channel.Id]++
+	}
+	require.Zero(t, picked[1])
+	require.Greater(t, picked[3], 0)
+	require.Less(t, picked[3], 400)
+	require.Greater(t, picked[2], picked[3])
+}
+
+func TestSelectChannelByStrategyLeastLatencyPenalizesFailures(t *testing.T) {
+	withChannelSelectionStrategy(t, "default", operation_setting.ChannelSelectionLeastLatency)
+	channels := []*Channel{{Id: 1}, {Id: 2}}
+	recordChannelSamples(1, "gpt-4o", 10, channelstats.Sample{Success: true, LatencyMs: 3000})
+	// 快速失败的渠道按惩罚延迟计入统计
+	recordChannelSamples(2, "gpt-4o", 10, channelstats.Sample{Success: false, LatencyMs: 50})
+
+	for i := 0; i < 20; i++ {
+		channel, err := selectChannelByStrategy("default", "gpt-4o", channels, ChannelSelectHint{})
+		require.NoError(t, err)
+		require.Equal(t, 1, channel.Id)
+	}
+}
+
+func TestSelectChannelByStrategyErrorRate(t *testing.T) {
+	withChannelSelectionStrategy(t, "default", operation_setting.ChannelSelectionErrorRate)
+	channels := []*Channel{{Id: 1}, {Id: 2}}
+	recordChannelSamples(1, "gpt-4o", 20, channelstats.Sample{Success: false})
+	recordChannelSamples(2, "gpt-4o", 20, channelstats.Sample{Success: true, LatencyMs: 800})
+
+	picked := map[int]int{}
+	for i := 0; i < 2000; i++ {
+		channel, err := selectChannelByStrategy("default", "gpt-4o", channels, ChannelSelectHint{})
+		require.NoError(t, err)
+		picked[channel.Id]++
+	}
+	// 持续失败的渠道只保留最低探测权重
+	require.Greater(t, picked[1], 0)
+	require.Less(t, picked[1], 300)
+}
+
+func TestSelectChannelByStrategyPowerOfTwo(t *testing.T) {
+	withChannelSelectionStrategy(t, "vip", operation_setting.ChannelSelectionPowerOfTwo)
+	channels := []*Channel{{Id: 1}, {Id: 2}}
+	recordChannelSamples(1, "gpt-4o", 10, channelstats.Sample{Success: true, LatencyMs: 2000})
+	recordChannelSamples(2, "gpt-4o", 10, channelstats.Sample{Success: true, LatencyMs: 200})
+
+	for i := 0; i < 20; i++ {
+		channel, err := selectChannelByStrategy("vip", "gpt-4o", channels, ChannelSelectHint{})
+		require.NoError(t, err)
+		require.Equal(t, 2, channel.Id)
+	}
+
+	// 其他分组仍使用默认的按权重随机
+	picked := map[int]int{}
+	for i := 0; i < 200; i++ {
+		channel, err := selectChannelByStrategy("default", "gpt-4o", channels, ChannelSelectHint{})
+		require.NoError(t, err)
+		picked[channel.Id]++
+	}
+	require.Greater(t, picked[1], 0)
+	require.Greater(t, picked[2], 0)
+}
+
+func TestSelectChannelByStrategyLowestCost(t *testing.T) {
+	withChannelSelectionStrategy(t, "default", operation_setting.ChannelSelectionLowestCost)
+	// 渠道 1 输入便宜输出贵，渠道 2 相反，渠道 3 未配置成本
+	channels := []*Channel{
+		{Id: 1, OtherSettings: `{"upstream_cost":{"gpt-4o":{"input_price":1,"output_price":20}}}`},
+		{Id: 2, OtherSettings: `{"upstream_cost":{"*":{"expr":"p * 4 + c * 8"}}}`},
+		{Id: 3},
+	}
+
+	longPrompt := ChannelSelectHint{PromptTokens: 100000, CompletionTokens: 100}
+	longOutput := ChannelSelectHint{PromptTokens: 100, CompletionTokens: 100000}
+	for i := 0; i < 20; i++ {
+		channel, err := selectChannelByStrategy("default", "gpt-4o", channels, longPrompt)
+		require.NoError(t, err)
+		require.Equal(t, 1, channel.Id)
+
+		channel, err = selectChannelByStrategy("default", "gpt-4o", channels, longOutput)
+		require.NoError(t, err)
+		require.Equal(t, 2, channel.Id)
+	}
+
+	// 都未配置成本时按权重随机
+	picked := map[int]int{}
+	for i := 0; i < 200; i++ {
+		channel, err := selectChannelByStrategy("default", "gpt-4o", []*Channel{{Id: 3}, {Id: 4}}, longPrompt)
+		require.NoError(t, err)
+		picked[channel.Id]++
+	}
+	require.Greater(t, picked[3], 0)
+	require.Greater(t, picked[4], 0)
+}
+
+func TestGetRandomSatisfiedChannelWithoutMemoryCache(t *testing.T) {
+	truncateTables(t)
+	initCol()
+	previous := common.MemoryCacheEnabled
+	common.MemoryCacheEnabled = false
+	t.Cleanup(func() { common.MemoryCacheEnabled = previous })
+	withChannelSelectionStrategy(t, "default", operation_setting.ChannelSelectionLeastLatency)
+
+	for _, id := range []int{1, 2} {
+		channel := &Channel{Id: id, Name: "db", Key: "sk-db", Status: common.ChannelStatusEnabled, Group: "default", Models: "gpt-4o"}
+		require.NoError(t, DB.Create(channel).Error)
+		require.NoError(t, channel.AddAbilities(nil))
+	}
+	recordChannelSamples(1, "gpt-4o", 10, channelstats.Sample{Success: true, LatencyMs: 3000})
+	recordChannelSamples(2, "gpt-4o", 10, channelstats.Sample{Success: true, LatencyMs: 500})
+
+	// 从数据库选择渠道时同样按分组策略选择
+	for i := 0; i < 20; i++ {
+		channel, err := GetRandomSatisfiedChannel("default", "gpt-4o", 0)
+		require.NoError(t, err)
+		require.Equal(t, 2, channel.Id)
+	}
+
+	// 同样跳过熔断中的渠道
+	breakerSetting := operation_setting.GetCircuitBreakerSetting()
+	previousBreaker := *breakerSetting
+	breakerSetting.Enabled = true
+	breakerSetting.FailureThreshold = 1
+	breakerSetting.FailureWindowSeconds = 60
+	breakerSetting.OpenSeconds = 60
+	key := circuitbreaker.ChannelKey(2)
+	t.Cleanup(func() {
+		circuitbreaker.Reset(key)
+		*breakerSetting = previousBreaker
+	})
+	circuitbreaker.Reset(key)
+	circuitbreaker.Record(key, false, false)
+	for i := 0; i < 20; i++ {
+		channel, err := GetRandomSatisfiedChannel("default", "gpt-4o", 0)
+		require.NoError(t, err)
+		require.Equal(t, 1, channel.Id)
+	}
+}
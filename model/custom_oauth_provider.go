@@ -64,6 +64,9 @@ type CustomOAuthProvider struct {
 
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+
+	// 写入数据库时密钥被加密，写入后还原为明文
+	clientSecretPlaintext string
 }
 
 func (CustomOAuthProvider) TableName() string {
@@ -0,0 +1,247 @@
+package model
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// File status
+const (
+	FileStatusUploaded  = "uploaded"
+	FileStatusProcessed = "processed"
+	FileStatusError     = "error"
+)
+
+var ErrFileNotFound = errors.New("file not found")
+
+// UpstreamFileRef 文件在某个上游渠道中的副本
+type UpstreamFileRef struct {
+	FileId     string `json:"file_id"`
+	UploadedAt int64  `json:"uploaded_at"`
+}
+
+// UpstreamFiles 渠道（多Key渠道细分到Key）-> 上游文件副本，用于在不同渠道间复用已上传的文件
+// key 由 UpstreamFileKey 生成
+type UpstreamFiles map[string]UpstreamFileRef
+
+// UpstreamFileKey 生成上游文件副本的key，多Key渠道中不同Key可能属于不同组织，需要分别上传
+func UpstreamFileKey(channelId int, isMultiKey bool, keyIndex int) string {
+	if isMultiKey {
+		return fmt.Sprintf("%d:%d", channelId, keyIndex)
+	}
+	return strconv.Itoa(channelId)
+}
+
+// ParseUpstreamFileKey 解析上游文件副本key
+func ParseUpstreamFileKey(key string) (channelId int, keyIndex int, isMultiKey bool) {
+	channelPart, indexPart, found := strings.Cut(key, ":")
+	channelId, _ = strconv.Atoi(channelPart)
+	if found {
+		keyIndex, _ = strconv.Atoi(indexPart)
+	}
+	return channelId, keyIndex, found
+}
+
+// Value implements driver.Valuer interface
+func (u UpstreamFiles) Value() (driver.Value, error) {
+	if u == nil {
+		return "{}", nil
+	}
+	data, err := common.Marshal(u)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan implements sql.Scanner interface
+func (u *UpstreamFiles) Scan(value interface{}) error {
+	var bytesValue []byte
+	switch v := value.(type) {
+	case []byte:
+		bytesValue = v
+	case string:
+		bytesValue = []byte(v)
+	}
+	if len(bytesValue) == 0 {
+		*u = UpstreamFiles{}
+		return nil
+	}
+	return common.Unmarshal(bytesValue, u)
+}
+
+// File 通过 /v1/files 上传到网关的文件，内容保存在 common.FileStorage 中
+type File struct {
+	Id            int            `json:"-"`
+	FileId        string         `json:"id" gorm:"type:varchar(64);uniqueIndex"`
+	UserId        int            `json:"-" gorm:"index"`
+	TokenId       int            `json:"-" gorm:"index"`
+	Filename      string         `json:"filename" gorm:"type:varchar(255)"`
+	Purpose       string         `json:"purpose" gorm:"type:varchar(32);index"`
+	Bytes         int64          `json:"bytes" gorm:"bigint"`
+	ContentType   string         `json:"-" gorm:"type:varchar(128)"`
+	StorageKey    string         `json:"-" gorm:"type:varchar(255)"`
+	Status        string         `json:"status" gorm:"type:varchar(16);default:'uploaded'"`
+	CreatedAt     int64          `json:"created_at" gorm:"bigint;index"`
+	ExpiresAt     int64          `json:"expires_at,omitempty" gorm:"bigint;index;default:0"` // 0 表示不过期
+	UpstreamFiles UpstreamFiles  `json:"-" gorm:"type:text"`
+	DeletedAt     gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// GenerateFileId 生成对外暴露的文件ID
+func GenerateFileId() string {
+	return "file-" + common.GetUUID()
+}
+
+// IsGatewayFileId 判断是否为网关生成的文件ID格式
+func IsGatewayFileId(id string) bool {
+	return strings.HasPrefix(id, "file-") && len(id) == len("file-")+32
+}
+
+func (f *File) IsExpired() bool {
+	return f.ExpiresAt > 0 && f.ExpiresAt <= time.Now().Unix()
+}
+
+func (f *File) Insert() error {
+	return f.insert(DB)
+}
+
+func (f *File) insert(tx *gorm.DB) error {
+	if f.CreatedAt == 0 {
+		f.CreatedAt = common.GetTimestamp()
+	}
+	if f.UpstreamFiles == nil {
+		f.UpstreamFiles = UpstreamFiles{}
+	}
+	return tx.Create(f).Error
+}
+
+// GetUserFileById 获取用户拥有的未过期文件
+func GetUserFileById(userId int, fileId string) (*File, error) {
+	if fileId == "" {
+		return nil, ErrFileNotFound
+	}
+	var file File
+	err := DB.Where("file_id = ? AND user_id = ?", fileId, userId).First(&file).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrFileNotFound
+		}
+		return nil, err
+	}
+	if file.IsExpired() {
+		return nil, ErrFileNotFound
+	}
+	return &file, nil
+}
+
+// ListUserFiles 按创建时间倒序列出用户文件，after 为上一页最后一个文件ID
+func ListUserFiles(userId int, purpose string, after string, limit int, ascending bool) ([]*File, bool, error) {
+	query := DB.Model(&File{}).Where("user_id = ?", userId).
+		Where("expires_at = 0 OR expires_at > ?", common.GetTimestamp())
+	if purpose != "" {
+		query = query.Where("purpose = ?", purpose)
+	}
+	if after != "" {
+		cursor, err := GetUserFileById(userId, after)
+		if err != nil {
+			return nil, false, err
+		}
+		if ascending {
+			query = query.Where("id > ?", cursor.Id)
+		} else {
+			query = query.Where("id < ?", cursor.Id)
+		}
+	}
+	order := "id desc"
+	if ascending {
+		order = "id asc"
+	}
+	var files []*File
+	if err := query.Order(order).Limit(limit + 1).Find(&files).Error; err != nil {
+		return nil, false, err
+	}
+	hasMore := len(files) > limit
+	if hasMore {
+		files = files[:limit]
+	}
+	return files, hasMore, nil
+}
+
+// InsertWithinQuota 在锁定用户行的事务中校验存储配额并写入记录，避免并发上传超出配额。
+// 超出配额时不写入并返回 false，limit 为 0 表示不限制
+func (f *File) InsertWithinQuota(limit int64) (bool, error) {
+	if limit <= 0 {
+		return true, f.Insert()
+	}
+	inserted := false
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var user User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").Where("id = ?", f.UserId).Find(&user).Error; err != nil {
+			return err
+		}
+		used, err := sumUserFileBytes(tx, f.UserId)
+		if err != nil {
+			return err
+		}
+		if used+f.Bytes > limit {
+			return nil
+		}
+		inserted = true
+		return f.insert(tx)
+	})
+	return inserted, err
+}
+
+// SumUserFileBytes 统计用户当前占用的文件存储字节数，已过期但尚未清理的文件不计入
+func SumUserFileBytes(userId int) (int64, error) {
+	return sumUserFileBytes(DB, userId)
+}
+
+func sumUserFileBytes(tx *gorm.DB, userId int) (int64, error) {
+	var total int64
+	err := tx.Model(&File{}).Where("user_id = ?", userId).
+		Where("expires_at = 0 OR expires_at > ?", common.GetTimestamp()).
+		Select("COALESCE(SUM(bytes), 0)").Scan(&total).Error
+	return total, err
+}
+
+// SetFileUpstream 记录文件在指定渠道中的上游副本
+func SetFileUpstream(id int, upstreamKey string, upstreamFileId string) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var file File
+		if err := tx.Select("id", "upstream_files").Where("id = ?", id).First(&file).Error; err != nil {
+			return err
+		}
+		if file.UpstreamFiles == nil {
+			file.UpstreamFiles = UpstreamFiles{}
+		}
+		file.UpstreamFiles[upstreamKey] = UpstreamFileRef{
+			FileId:     upstreamFileId,
+			UploadedAt: common.GetTimestamp(),
+		}
+		return tx.Model(&File{}).Where("id = ?", id).Update("upstream_files", file.UpstreamFiles).Error
+	})
+}
+
+// DeleteFile 软删除文件记录
+func DeleteFile(id int) error {
+	return DB.Delete(&File{}, id).Error
+}
+
+// GetExpiredFiles 获取已过期的文件，用于清理存储
+func GetExpiredFiles(limit int) ([]*File, error) {
+	var files []*File
+	err := DB.Where("expires_at > 0 AND expires_at <= ?", common.GetTimestamp()).
+		Order("id asc").Limit(limit).Find(&files).Error
+	return files, err
+}
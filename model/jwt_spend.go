@@ -0,0 +1,67 @@
+package model
+
+import (
+	"github.com/QuantumNous/new-api/common"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// JWTSpend JWT 令牌按 jti 累计的花费，用于额度上限。
+// 记录保存在数据库中，多节点共享且不会被缓存淘汰，令牌过期后由定时任务清理
+type JWTSpend struct {
+	Jti       string `gorm:"primaryKey;type:varchar(128)"`
+	Spend     int    `gorm:"default:0"`
+	ExpiresAt int64  `gorm:"bigint;index"`
+}
+
+func ensureJWTSpend(tx *gorm.DB, jti string, expiresAt int64) error {
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&JWTSpend{Jti: jti, ExpiresAt: expiresAt}).Error
+}
+
+// GetJWTSpend 获取 jti 已花费的额度，没有记录时为 0
+func GetJWTSpend(jti string) (int, error) {
+	var spends []JWTSpend
+	if err := DB.Where("jti = ?", jti).Limit(1).Find(&spends).Error; err != nil {
+		return 0, err
+	}
+	if len(spends) == 0 {
+		return 0, nil
+	}
+	return spends[0].Spend, nil
+}
+
+// ReserveJWTSpend 在额度上限内原子地累加 jti 的花费，超出上限时不累加并返回 false
+func ReserveJWTSpend(jti string, amount int, limit int, expiresAt int64) (bool, error) {
+	if err := ensureJWTSpend(DB, jti, expiresAt); err != nil {
+		return false, err
+	}
+	result := DB.Model(&JWTSpend{}).
+		Where("jti = ? AND spend + ? <= ?", jti, amount, limit).
+		Update("spend", gorm.Expr("spend + ?", amount))
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// AddJWTSpend 不检查上限地调整 jti 的花费，delta 为负数时退还
+func AddJWTSpend(jti string, delta int, expiresAt int64) error {
+	if err := ensureJWTSpend(DB, jti, expiresAt); err != nil {
+		return err
+	}
+	return DB.Model(&JWTSpend{}).Where("jti = ?", jti).
+		Update("spend", gorm.Expr("spend + ?", delta)).Error
+}
+
+// DeleteExpiredJWTSpends 删除已过期令牌的花费记录，返回删除的数量
+func DeleteExpiredJWTSpends(limit int) (int, error) {
+	var jtis []string
+	err := DB.Model(&JWTSpend{}).Where("expires_at <= ?", common.GetTimestamp()).
+		Limit(limit).Pluck("jti", &jtis).Error
+	if err != nil || len(jtis) == 0 {
+		return 0, err
+	}
+	result := DB.Where("jti IN ?", jtis).Delete(&JWTSpend{})
+	return int(result.RowsAffected), result.Error
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/types"
 
@@ -27,6 +28,7 @@ type Log struct {
 	TokenName        string `json:"token_name" gorm:"index;default:''"`
 	ModelName        string `json:"model_name" gorm:"index;index:index_username_model_name,priority:1;default:''"`
 	Quota            int    `json:"quota" gorm:"default:0"`
+	UpstreamCost     int    `json:"upstream_cost" gorm:"default:0"` // 实际支付给上游的额度，渠道未配置上游成本时为 0
 	PromptTokens     int    `json:"prompt_tokens" gorm:"default:0"`
 	CompletionTokens int    `json:"completion_tokens" gorm:"default:0"`
 	UseTime          int    `json:"use_time" gorm:"default:0"`
@@ -34,6 +36,8 @@ type Log struct {
 	ChannelId        int    `json:"channel" gorm:"index"`
 	ChannelName      string `json:"channel_name" gorm:"->"`
 	TokenId          int    `json:"token_id" gorm:"default:0;index"`
+	ParentTokenId    int    `json:"parent_token_id" gorm:"default:0;index"` // 子令牌的父令牌，用于将子令牌的花费归属到父令牌
+	TokenKeyVersion  int    `json:"token_key_version" gorm:"default:0"`     // 请求使用的令牌密钥版本，每次轮换加一
 	Group            string `json:"group" gorm:"index"`
 	Ip               string `json:"ip" gorm:"index;default:''"`
 	RequestId         string `json:"request_id,omitempty" gorm:"type:varchar(64);index:idx_logs_request_id;default:''"`
@@ -171,6 +175,7 @@ func RecordErrorLog(c *gin.Context, userId int, channelId int, modelName string,
 		Quota:            0,
 		ChannelId:        channelId,
 		TokenId:          tokenId,
+		TokenKeyVersion:  common.GetContextKeyInt(c, constant.ContextKeyTokenKeyVersion),
 		UseTime:          useTimeSeconds,
 		IsStream:         isStream,
 		Group:            group,
@@ -197,6 +202,7 @@ type RecordConsumeLogParams struct {
 	ModelName        string                 `json:"model_name"`
 	TokenName        string                 `json:"token_name"`
 	Quota            int                    `json:"quota"`
+	UpstreamCost     int                    `json:"upstream_cost"`
 	Content          string                 `json:"content"`
 	TokenId          int                    `json:"token_id"`
 	UseTimeSeconds   int                    `json:"use_time_seconds"`
@@ -232,8 +238,11 @@ func RecordConsumeLog(c *gin.Context, userId int, params RecordConsumeLogParams)
 		TokenName:        params.TokenName,
 		ModelName:        params.ModelName,
 		Quota:            params.Quota,
+		UpstreamCost:     params.UpstreamCost,
 		ChannelId:        params.ChannelId,
 		TokenId:          params.TokenId,
+		ParentTokenId:    common.GetContextKeyInt(c, constant.ContextKeyTokenParentId),
+		TokenKeyVersion:  common.GetContextKeyInt(c, constant.ContextKeyTokenKeyVersion),
 		UseTime:          params.UseTimeSeconds,
 		IsStream:         params.IsStream,
 		Group:            params.Group,
@@ -494,6 +503,38 @@ func SumUsedQuota(logType int, startTimestamp int64, endTimestamp int64, modelNa
 	return stat, nil
 }
 
+// ChannelMargin 渠道在时间范围内的收入、上游成本与毛利，仅统计配置了上游成本的请求
+type ChannelMargin struct {
+	ChannelId    int `json:"channel_id"`
+	Requests     int `json:"requests"`
+	Quota        int `json:"quota"`
+	UpstreamCost int `json:"upstream_cost"`
+	Margin       int `json:"margin" gorm:"-"`
+}
+
+func SumChannelMargin(startTimestamp int64, endTimestamp int64, modelName string, channel int) (margins []*ChannelMargin, err error) {
+	tx := LOG_DB.Table("logs").Select("channel_id, count(*) requests, sum(quota) quota, sum(upstream_cost) upstream_cost").
+		Where("type = ? AND upstream_cost > 0", LogTypeConsume)
+	if startTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", startTimestamp)
+	}
+	if endTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", endTimestamp)
+	}
+	tx = applyLogContainsFilter(tx, "model_name", modelName)
+	if channel != 0 {
+		tx = tx.Where("channel_id = ?", channel)
+	}
+	if err = tx.Group("channel_id").Order("channel_id").Scan(&margins).Error; err != nil {
+		common.SysError("failed to query channel margin: " + err.Error())
+		return nil, errors.New("查询统计数据失败")
+	}
+	for _, margin := range margins {
+		margin.Margin = margin.Quota - margin.UpstreamCost
+	}
+	return margins, nil
+}
+
 func SumUsedToken(logType int, startTimestamp int64, endTimestamp int64, modelName string, username string, tokenName string) (token int) {
 	tx := LOG_DB.Table("logs").Select("ifnull(sum(prompt_tokens),0) + ifnull(sum(completion_tokens),0)")
 	if username != "" {
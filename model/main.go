@@ -281,6 +281,11 @@ func migrateDB() error {
 		&CustomOAuthProvider{},
 		&UserOAuthBinding{},
 		&PerfMetric{},
+		&File{},
+		&Batch{},
+		&BatchItem{},
+		&StoredResponse{},
+		&JWTSpend{},
 	)
 	if err != nil {
 		return err
@@ -294,6 +299,9 @@ func migrateDB() error {
 			return err
 		}
 	}
+	if err := migrateTokenKeysToHash(); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -330,6 +338,11 @@ func migrateDBFast() error {
 		{&CustomOAuthProvider{}, "CustomOAuthProvider"},
 		{&UserOAuthBinding{}, "UserOAuthBinding"},
 		{&PerfMetric{}, "PerfMetric"},
+		{&File{}, "File"},
+		{&Batch{}, "Batch"},
+		{&BatchItem{}, "BatchItem"},
+		{&StoredResponse{}, "StoredResponse"},
+		{&JWTSpend{}, "JWTSpend"},
 	}
 	// 动态计算migration数量，确保errChan缓冲区足够大
 	errChan := make(chan error, len(migrations))
@@ -363,6 +376,9 @@ func migrateDBFast() error {
 			return err
 		}
 	}
+	if err := migrateTokenKeysToHash(); err != nil {
+		return err
+	}
 	common.SysLog("database migrated")
 	return nil
 }
@@ -191,7 +191,12 @@ func InitOptionMap() {
 func loadOptionsFromDatabase() {
 	options, _ := AllOption()
 	for _, option := range options {
-		err := updateOptionMap(option.Key, option.Value)
+		value, err := common.DecryptSecret(option.Value)
+		if err != nil {
+			common.SysError("failed to decrypt option " + option.Key + ": " + err.Error())
+			continue
+		}
+		err = updateOptionMap(option.Key, value)
 		if err != nil {
 			common.SysLog("failed to update option map: " + err.Error())
 		}
@@ -0,0 +1,276 @@
+package model
+
+import (
+	"fmt"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"gorm.io/gorm"
+)
+
+// 加密存储的配置项，均为支付相关的密钥
+var encryptedOptionKeys = map[string]bool{
+	"EpayKey":                    true,
+	"StripeApiSecret":            true,
+	"StripeWebhookSecret":        true,
+	"CreemApiKey":                true,
+	"CreemWebhookSecret":         true,
+	"WaffoApiKey":                true,
+	"WaffoPrivateKey":            true,
+	"WaffoSandboxApiKey":         true,
+	"WaffoSandboxPrivateKey":     true,
+	"WaffoPancakePrivateKey":     true,
+	"WaffoPancakeWebhookTestKey": true,
+}
+
+// encryptSecretField 在写入前加密敏感列，返回写入前的明文以便写入后还原。
+// 通过 Update("key", ...) 等 map 方式更新时直接加密 map 中的值
+func encryptSecretField(tx *gorm.DB, column string, field *string) (string, error) {
+	for _, omit := range tx.Statement.Omits {
+		if omit == column {
+			return "", nil
+		}
+	}
+	if len(tx.Statement.Selects) > 0 {
+		selected := false
+		for _, sel := range tx.Statement.Selects {
+			if sel == column || sel == "*" {
+				selected = true
+				break
+			}
+		}
+		if !selected {
+			return "", nil
+		}
+	}
+	if dest, ok := tx.Statement.Dest.(map[string]interface{}); ok {
+		if value, ok := dest[column].(string); ok {
+			encrypted, err := common.EncryptSecret(value)
+			if err != nil {
+				return "", err
+			}
+			dest[column] = encrypted
+		}
+		return "", nil
+	}
+	// Updates(Channel{...}) 等以其他结构体写入时不会写入接收者的字段
+	if tx.Statement.Dest != tx.Statement.Model {
+		return "", nil
+	}
+	plaintext := *field
+	encrypted, err := common.EncryptSecret(plaintext)
+	if err != nil {
+		return "", err
+	}
+	*field = encrypted
+	return plaintext, nil
+}
+
+// restoreSecretField 写入后还原内存中的明文
+func restoreSecretField(field *string, plaintext *string) {
+	if *plaintext != "" {
+		*field = *plaintext
+		*plaintext = ""
+	}
+}
+
+func decryptSecretField(field *string) error {
+	plaintext, err := common.DecryptSecret(*field)
+	if err != nil {
+		return err
+	}
+	*field = plaintext
+	return nil
+}
+
+func (channel *Channel) BeforeSave(tx *gorm.DB) (err error) {
+	channel.keyPlaintext, err = encryptSecretField(tx, "key", &channel.Key)
+	return err
+}
+
+func (channel *Channel) AfterSave(tx *gorm.DB) error {
+	restoreSecretField(&channel.Key, &channel.keyPlaintext)
+	return nil
+}
+
+func (channel *Channel) AfterFind(tx *gorm.DB) error {
+	if err := decryptSecretField(&channel.Key); err != nil {
+		return fmt.Errorf("failed to decrypt key of channel #%d: %w", channel.Id, err)
+	}
+	return nil
+}
+
+// decryptedKey 返回明文密钥。写入失败时 AfterSave 不会执行，内存中的密钥可能仍是密文
+func (channel *Channel) decryptedKey() string {
+	if !common.IsEncryptedSecret(channel.Key) {
+		return channel.Key
+	}
+	key, err := common.DecryptSecret(channel.Key)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to decrypt key of channel #%d: %s", channel.Id, err.Error()))
+		return ""
+	}
+	return key
+}
+
+func (p *CustomOAuthProvider) BeforeSave(tx *gorm.DB) (err error) {
+	p.clientSecretPlaintext, err = encryptSecretField(tx, "client_secret", &p.ClientSecret)
+	return err
+}
+
+func (p *CustomOAuthProvider) AfterSave(tx *gorm.DB) error {
+	restoreSecretField(&p.ClientSecret, &p.clientSecretPlaintext)
+	return nil
+}
+
+func (p *CustomOAuthProvider) AfterFind(tx *gorm.DB) error {
+	if err := decryptSecretField(&p.ClientSecret); err != nil {
+		return fmt.Errorf("failed to decrypt client secret of oauth provider %s: %w", p.Slug, err)
+	}
+	return nil
+}
+
+// BeforeSave 加密支付密钥配置项，读取时在 loadOptionsFromDatabase 中解密
+func (option *Option) BeforeSave(tx *gorm.DB) (err error) {
+	if !encryptedOptionKeys[option.Key] {
+		return nil
+	}
+	option.Value, err = common.EncryptSecret(option.Value)
+	return err
+}
+
+type storedSecret struct {
+	name   string
+	value  string
+	update func(value string) error
+}
+
+// forEachStoredSecret 遍历数据库中所有需要加密存储的值，读取时跳过钩子以获得原始值
+func forEachStoredSecret(fn func(secret storedSecret) error) error {
+	raw := DB.Session(&gorm.Session{SkipHooks: true})
+
+	var channels []*Channel
+	err := raw.Select("id", "key").FindInBatches(&channels, 200, func(tx *gorm.DB, batch int) error {
+		for _, channel := range channels {
+			id := channel.Id
+			err := fn(storedSecret{
+				name:  fmt.Sprintf("channel #%d key", id),
+				value: channel.Key,
+				update: func(value string) error {
+					return DB.Model(&Channel{}).Where("id = ?", id).UpdateColumn("key", value).Error
+				},
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	var providers []*CustomOAuthProvider
+	if err = raw.Select("id", "slug", "client_secret").Find(&providers).Error; err != nil {
+		return err
+	}
+	for _, provider := range providers {
+		id := provider.Id
+		err = fn(storedSecret{
+			name:  fmt.Sprintf("oauth provider %s client secret", provider.Slug),
+			value: provider.ClientSecret,
+			update: func(value string) error {
+				return DB.Model(&CustomOAuthProvider{}).Where("id = ?", id).UpdateColumn("client_secret", value).Error
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var responses []*StoredResponse
+	err = raw.Select("id", "response_id", "data").FindInBatches(&responses, 200, func(tx *gorm.DB, batch int) error {
+		for _, response := range responses {
+			id := response.Id
+			err := fn(storedSecret{
+				name:  fmt.Sprintf("stored response %s", response.ResponseId),
+				value: response.Data,
+				update: func(value string) error {
+					return DB.Model(&StoredResponse{}).Where("id = ?", id).UpdateColumn("data", value).Error
+				},
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	options, err := AllOption()
+	if err != nil {
+		return err
+	}
+	for _, option := range options {
+		if !encryptedOptionKeys[option.Key] && !common.IsEncryptedSecret(option.Value) {
+			continue
+		}
+		key := option.Key
+		err = fn(storedSecret{
+			name:  "option " + key,
+			value: option.Value,
+			update: func(value string) error {
+				return DB.Model(&Option{Key: key}).UpdateColumn("value", value).Error
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckEncryptedSecrets 校验数据库中的加密数据都能被解密，主密钥缺失或错误时拒绝启动
+func CheckEncryptedSecrets() error {
+	var failed []string
+	err := forEachStoredSecret(func(secret storedSecret) error {
+		if _, err := common.DecryptSecret(secret.value); err != nil {
+			failed = append(failed, secret.name+": "+err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d encrypted secrets cannot be decrypted, check SECRET_ENCRYPTION_KEY and SECRET_ENCRYPTION_OLD_KEYS (first: %s)", len(failed), failed[0])
+	}
+	return nil
+}
+
+// ReencryptSecrets 使用当前主密钥重新加密所有敏感数据，用于首次开启加密或轮换主密钥；
+// 未配置主密钥时将已加密的数据还原为明文。返回更新的条数
+func ReencryptSecrets() (int, error) {
+	updated := 0
+	err := forEachStoredSecret(func(secret storedSecret) error {
+		if !common.SecretNeedsReencrypt(secret.value) {
+			return nil
+		}
+		plaintext, err := common.DecryptSecret(secret.value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", secret.name, err)
+		}
+		encrypted, err := common.EncryptSecret(plaintext)
+		if err != nil {
+			return fmt.Errorf("%s: %w", secret.name, err)
+		}
+		if err = secret.update(encrypted); err != nil {
+			return fmt.Errorf("%s: %w", secret.name, err)
+		}
+		updated++
+		return nil
+	})
+	return updated, err
+}
@@ -0,0 +1,102 @@
+package model
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+var (
+	testSecretKeyOld = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	testSecretKeyNew = base64.StdEncoding.EncodeToString([]byte("fedcba9876543210fedcba9876543210"))
+)
+
+func rawChannelKey(t *testing.T, id int) string {
+	t.Helper()
+	var channel Channel
+	require.NoError(t, DB.Session(&gorm.Session{SkipHooks: true}).Select("id", "key").First(&channel, id).Error)
+	return channel.Key
+}
+
+func TestChannelKeyEncryptedAtRest(t *testing.T) {
+	truncateTables(t)
+	t.Cleanup(func() { _ = common.SetSecretEncryptionKeys("") })
+	require.NoError(t, common.SetSecretEncryptionKeys(testSecretKeyOld))
+
+	channel := &Channel{Name: "encrypted", Key: "sk-a\nsk-b", Status: common.ChannelStatusEnabled}
+	require.NoError(t, DB.Create(channel).Error)
+	require.Equal(t, "sk-a\nsk-b", channel.Key)
+	require.True(t, common.IsEncryptedSecret(rawChannelKey(t, channel.Id)))
+
+	loaded, err := GetChannelById(channel.Id, true)
+	require.NoError(t, err)
+	require.Equal(t, []string{"sk-a", "sk-b"}, loaded.GetKeys())
+
+	// map 方式更新密钥同样加密
+	require.NoError(t, DB.Model(&Channel{}).Where("id = ?", channel.Id).Update("key", "sk-c").Error)
+	raw := rawChannelKey(t, channel.Id)
+	require.True(t, common.IsEncryptedSecret(raw))
+
+	// 不写入密钥的更新不改变密文
+	require.NoError(t, loaded.SaveWithoutKey())
+	require.Equal(t, raw, rawChannelKey(t, channel.Id))
+
+	// 内存中残留的密文在取用时透明解密
+	loaded.Key = raw
+	key, _, apiErr := loaded.GetNextEnabledKey()
+	require.Nil(t, apiErr)
+	require.Equal(t, "sk-c", key)
+}
+
+func TestReencryptSecrets(t *testing.T) {
+	truncateTables(t)
+	t.Cleanup(func() { _ = common.SetSecretEncryptionKeys("") })
+
+	plain := &Channel{Name: "plain", Key: "sk-plain"}
+	require.NoError(t, DB.Create(plain).Error)
+	require.NoError(t, DB.Create(&CustomOAuthProvider{Name: "idp", Slug: "idp", ClientSecret: "client-secret"}).Error)
+
+	require.NoError(t, common.SetSecretEncryptionKeys(testSecretKeyOld))
+	require.NoError(t, DB.Save(&Option{Key: "StripeApiSecret", Value: "sk_live_stripe"}).Error)
+	response := &StoredResponse{ResponseId: "resp_encrypted", UserId: 1, Data: `{"output":[]}`}
+	require.NoError(t, response.Insert())
+	updated, err := ReencryptSecrets()
+	require.NoError(t, err)
+	require.Equal(t, 2, updated)
+	oldRaw := rawChannelKey(t, plain.Id)
+	require.True(t, common.IsEncryptedSecret(oldRaw))
+
+	// 轮换主密钥：旧主密钥缺失时启动检查失败
+	require.NoError(t, common.SetSecretEncryptionKeys(testSecretKeyNew))
+	require.Error(t, CheckEncryptedSecrets())
+
+	require.NoError(t, common.SetSecretEncryptionKeys(testSecretKeyNew, testSecretKeyOld))
+	require.NoError(t, CheckEncryptedSecrets())
+	updated, err = ReencryptSecrets()
+	require.NoError(t, err)
+	require.Equal(t, 4, updated)
+
+	require.NoError(t, common.SetSecretEncryptionKeys(testSecretKeyNew))
+	require.NoError(t, CheckEncryptedSecrets())
+	provider, err := GetCustomOAuthProviderBySlug("idp")
+	require.NoError(t, err)
+	require.Equal(t, "client-secret", provider.ClientSecret)
+
+	var option Option
+	require.NoError(t, DB.First(&option, "key = ?", "StripeApiSecret").Error)
+	require.True(t, common.IsEncryptedSecret(option.Value))
+	value, err := common.DecryptSecret(option.Value)
+	require.NoError(t, err)
+	require.Equal(t, "sk_live_stripe", value)
+
+	stored, err := GetUserStoredResponse(1, "resp_encrypted")
+	require.NoError(t, err)
+	require.Equal(t, `{"output":[]}`, stored.Data)
+
+	// 未配置主密钥时拒绝以明文保存对话记录
+	require.NoError(t, common.SetSecretEncryptionKeys(""))
+	require.ErrorIs(t, (&StoredResponse{ResponseId: "resp_plain", UserId: 1, Data: "{}"}).Insert(), ErrStoredResponseUnencrypted)
+}
@@ -0,0 +1,100 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"gorm.io/gorm"
+)
+
+var ErrStoredResponseNotFound = errors.New("stored response not found")
+
+// ErrStoredResponseUnencrypted 未配置 SECRET_ENCRYPTION_KEY 时拒绝以明文保存对话记录
+var ErrStoredResponseUnencrypted = errors.New("responses store requires SECRET_ENCRYPTION_KEY to be configured")
+
+// StoredResponse 网关为不保存会话状态的渠道保存的一轮 Responses 对话记录，
+// Data 为本轮输入条目与响应对象的 JSON，写入前按 SECRET_ENCRYPTION_KEY 加密
+type StoredResponse struct {
+	Id                 int    `json:"-"`
+	ResponseId         string `json:"id" gorm:"type:varchar(128);uniqueIndex"`
+	UserId             int    `json:"-" gorm:"index"`
+	TokenId            int    `json:"-" gorm:"index"`
+	Model              string `json:"model" gorm:"type:varchar(128)"`
+	PreviousResponseId string `json:"previous_response_id" gorm:"type:varchar(128)"`
+	Data               string `json:"-" gorm:"type:text"`
+	CreatedAt          int64  `json:"created_at" gorm:"bigint;index"`
+	ExpiresAt          int64  `json:"expires_at,omitempty" gorm:"bigint;index;default:0"` // 0 表示不过期
+}
+
+func (r *StoredResponse) IsExpired() bool {
+	return r.ExpiresAt > 0 && r.ExpiresAt <= time.Now().Unix()
+}
+
+// Insert 写入记录，同一 response ID 重复写入时覆盖旧记录
+func (r *StoredResponse) Insert() error {
+	if r.CreatedAt == 0 {
+		r.CreatedAt = common.GetTimestamp()
+	}
+	if !common.SecretEncryptionEnabled() {
+		return ErrStoredResponseUnencrypted
+	}
+	data, err := common.EncryptSecret(r.Data)
+	if err != nil {
+		return err
+	}
+	record := *r
+	record.Data = data
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("response_id = ?", r.ResponseId).Delete(&StoredResponse{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&record).Error; err != nil {
+			return err
+		}
+		r.Id = record.Id
+		return nil
+	})
+}
+
+// GetUserStoredResponse 获取用户拥有的未过期记录，Data 已解密
+func GetUserStoredResponse(userId int, responseId string) (*StoredResponse, error) {
+	if responseId == "" {
+		return nil, ErrStoredResponseNotFound
+	}
+	var record StoredResponse
+	err := DB.Where("response_id = ? AND user_id = ?", responseId, userId).First(&record).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrStoredResponseNotFound
+		}
+		return nil, err
+	}
+	if record.IsExpired() {
+		return nil, ErrStoredResponseNotFound
+	}
+	if record.Data, err = common.DecryptSecret(record.Data); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// DeleteStoredResponse 删除记录，对话记录不保留软删除副本
+func DeleteStoredResponse(id int) error {
+	return DB.Delete(&StoredResponse{}, id).Error
+}
+
+// DeleteExpiredStoredResponses 删除一批已过期的记录，返回删除数量
+func DeleteExpiredStoredResponses(limit int) (int, error) {
+	var ids []int
+	err := DB.Model(&StoredResponse{}).Where("expires_at > 0 AND expires_at <= ?", common.GetTimestamp()).
+		Order("id asc").Limit(limit).Pluck("id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+	if err = DB.Delete(&StoredResponse{}, ids).Error; err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
@@ -98,6 +98,7 @@ func (m Properties) Value() (driver.Value, error) {
 
 type TaskPrivateData struct {
 	Key            string `json:"key,omitempty"`
+	KeyIndex       int    `json:"key_index,omitempty"`        // 多 key 渠道中创建任务所用 key 的序号
 	UpstreamTaskID string `json:"upstream_task_id,omitempty"` // 上游真实 task ID
 	ResultURL      string `json:"result_url,omitempty"`       // 任务成功后的结果 URL（视频地址等）
 	// 计费上下文：用于异步退款/差额结算（轮询阶段读取）
@@ -177,6 +178,11 @@ func InitTask(platform constant.TaskPlatform, relayInfo *commonRelay.RelayInfo)
 			relayInfo.ChannelMeta.ChannelType == constant.ChannelTypeVertexAi {
 			privateData.Key = relayInfo.ChannelMeta.ApiKey
 		}
+		// 微调任务归属于创建时所用 key 的组织，多 key 渠道下后续查询必须使用同一 key；
+		// 只记录 key 的序号，查询时从渠道中解析，避免在任务表中保存明文密钥
+		if platform == constant.TaskPlatformFineTuning && relayInfo.ChannelMeta.ChannelIsMultiKey {
+			privateData.KeyIndex = relayInfo.ChannelMeta.ChannelMultiKeyIndex
+		}
 		if relayInfo.UpstreamModelName != "" {
 			properties.UpstreamModelName = relayInfo.UpstreamModelName
 		}
@@ -289,10 +295,13 @@ func TaskGetAllTasks(startIdx int, num int, queryParams SyncTaskQueryParams) []*
 	return tasks
 }
 
+// GetTimedOutUnfinishedTasks 获取超时未完成的任务。
+// 微调任务可能排队或训练超过一天，且上游一定会给出终态，因此不参与超时清理。
 func GetTimedOutUnfinishedTasks(cutoffUnix int64, limit int) []*Task {
 	var tasks []*Task
 	err := DB.Where("progress != ?", "100%").
 		Where("status NOT IN ?", []string{TaskStatusFailure, TaskStatusSuccess}).
+		Where("platform != ?", constant.TaskPlatformFineTuning).
 		Where("submit_time < ?", cutoffUnix).
 		Order("submit_time").
 		Limit(limit).
@@ -343,6 +352,20 @@ func GetByTaskId(userId int, taskId string) (*Task, bool, error) {
 	return task, exist, err
 }
 
+// GetUserPlatformTasks 按 id 倒序分页获取用户指定平台的任务，beforeId > 0 时只返回更早的任务
+func GetUserPlatformTasks(userId int, platform constant.TaskPlatform, beforeId int64, limit int) ([]*Task, error) {
+	var tasks []*Task
+	query := DB.Where("user_id = ? and platform = ?", userId, platform)
+	if beforeId > 0 {
+		query = query.Where("id < ?", beforeId)
+	}
+	err := query.Order("id desc").Limit(limit).Find(&tasks).Error
+	if err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
 func GetByTaskIds(userId int, taskIds []any) ([]*Task, error) {
 	if len(taskIds) == 0 {
 		return nil, nil
@@ -43,6 +43,10 @@ func TestMain(m *testing.M) {
 		&SubscriptionPlan{},
 		&SubscriptionOrder{},
 		&UserSubscription{},
+		&Option{},
+		&CustomOAuthProvider{},
+		&StoredResponse{},
+		&Ability{},
 	); err != nil {
 		panic("failed to migrate: " + err.Error())
 	}
@@ -62,6 +66,10 @@ func truncateTables(t *testing.T) {
 		DB.Exec("DELETE FROM subscription_orders")
 		DB.Exec("DELETE FROM subscription_plans")
 		DB.Exec("DELETE FROM user_subscriptions")
+		DB.Exec("DELETE FROM options")
+		DB.Exec("DELETE FROM custom_oauth_providers")
+		DB.Exec("DELETE FROM stored_responses")
+		DB.Exec("DELETE FROM abilities")
 	})
 }
 
@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/bytedance/gopkg/util/gopool"
 	"gorm.io/gorm"
@@ -28,11 +29,44 @@ type Token struct {
 	UsedQuota          int            `json:"used_quota" gorm:"default:0"` // used quota
 	Group              string         `json:"group" gorm:"default:''"`
 	CrossGroupRetry    bool           `json:"cross_group_retry"` // 跨分组重试，仅auto分组有效
+	Hedging            bool           `json:"hedging"`           // 对冲请求，需在对冲设置中开启总开关
+	ModelFallback      string         `json:"model_fallback" gorm:"type:varchar(16);default:''"`
+	RateLimitRPM       int            `json:"rate_limit_rpm" gorm:"default:0"`  // 每分钟请求数，0 表示不限制
+	RateLimitTPM       int            `json:"rate_limit_tpm" gorm:"default:0"`  // 每分钟 token 数，0 表示不限制
+	MaxConcurrency     int            `json:"max_concurrency" gorm:"default:0"` // 最大并发流式请求数，0 表示不限制
+	BudgetQuota        int            `json:"budget_quota" gorm:"default:0"`
+	BudgetSoftLimit    int            `json:"budget_soft_limit" gorm:"default:0"`
+	BudgetPeriod       string         `json:"budget_period" gorm:"type:varchar(16);default:'never'"`
+	BudgetUsed         int            `json:"budget_used" gorm:"default:0"`
+	BudgetResetTime    int64          `json:"budget_reset_time" gorm:"bigint;default:0"`
+	ParentId           int            `json:"parent_id" gorm:"index;default:0"`
+	PreviousKey        string         `json:"-" gorm:"type:varchar(128);index;default:''"`
+	PreviousKeyExpiry  int64          `json:"previous_key_expiry" gorm:"bigint;default:0"`
+	KeyVersion         int            `json:"key_version" gorm:"default:0"`
+	KeyRotatedTime     int64          `json:"key_rotated_time" gorm:"bigint;default:0"`
+	AutoRotateDays     int            `json:"auto_rotate_days" gorm:"default:0"`
+	KeyHint            string         `json:"-" gorm:"type:varchar(16);default:''"`
 	DeletedAt          gorm.DeletedAt `gorm:"index"`
 }
 
+// 令牌的模型降级设置
+const (
+	TokenModelFallbackDefault  = ""         // 跟随模型降级设置
+	TokenModelFallbackEnabled  = "enabled"  // 启用
+	TokenModelFallbackDisabled = "disabled" // 禁用
+)
+
+func IsValidTokenModelFallback(value string) bool {
+	switch value {
+	case TokenModelFallbackDefault, TokenModelFallbackEnabled, TokenModelFallbackDisabled:
+		return true
+	}
+	return false
+}
+
 func (token *Token) Clean() {
 	token.Key = ""
+	token.PreviousKey = ""
 }
 
 func MaskTokenKey(key string) string {
@@ -53,6 +87,12 @@ func (token *Token) GetFullKey() string {
 }
 
 func (token *Token) GetMaskedKey() string {
+	if token.IsKeyHashed() {
+		if token.KeyHint == "" {
+			return "**********"
+		}
+		return token.KeyHint[:4] + "**********" + token.KeyHint[4:]
+	}
 	return MaskTokenKey(token.Key)
 }
 
@@ -166,7 +206,16 @@ func SearchUserTokens(userId int, keyword string, token string, offset int, limi
 		if err != nil {
 			return nil, 0, err
 		}
-		baseQuery = baseQuery.Where(commonKeyCol+" LIKE ? ESCAPE '!'", tokenPattern)
+		if strings.Contains(token, "%") {
+			// 哈希存储的密钥无法模糊搜索，改为匹配保存的首尾各 4 位
+			if constant.TokenKeyHashEnabled {
+				baseQuery = baseQuery.Where("("+commonKeyCol+" LIKE ? ESCAPE '!' OR key_hint LIKE ? ESCAPE '!')", tokenPattern, tokenPattern)
+			} else {
+				baseQuery = baseQuery.Where(commonKeyCol+" LIKE ? ESCAPE '!'", tokenPattern)
+			}
+		} else {
+			baseQuery = baseQuery.Where(commonKeyCol+" IN ?", tokenKeyCandidates(token))
+		}
 	}
 
 	// 先查匹配总数（用于分页，受 maxTokens 上限保护，避免全表 COUNT）
@@ -189,34 +238,17 @@ func ValidateUserToken(key string) (token *Token, err error) {
 	if key == "" {
 		return nil, ErrTokenNotProvided
 	}
+	if IsHashedKey(key) {
+		// 数据库中保存的哈希值不能作为密钥使用
+		return nil, ErrTokenInvalid
+	}
 	token, err = GetTokenByKey(key, false)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// 轮换后的旧密钥在宽限期内仍然有效
+		token, err = GetTokenByPreviousKey(key)
+	}
 	if err == nil {
-		if token.Status == common.TokenStatusExhausted ||
-			token.Status == common.TokenStatusExpired ||
-			token.Status != common.TokenStatusEnabled {
-			return token, ErrTokenInvalid
-		}
-		if token.ExpiredTime != -1 && token.ExpiredTime < common.GetTimestamp() {
-			if !common.RedisEnabled {
-				token.Status = common.TokenStatusExpired
-				err := token.SelectUpdate()
-				if err != nil {
-					common.SysLog("failed to update token status" + err.Error())
-				}
-			}
-			return token, ErrTokenInvalid
-		}
-		if !token.UnlimitedQuota && token.RemainQuota <= 0 {
-			if !common.RedisEnabled {
-				token.Status = common.TokenStatusExhausted
-				err := token.SelectUpdate()
-				if err != nil {
-					common.SysLog("failed to update token status" + err.Error())
-				}
-			}
-			return token, ErrTokenInvalid
-		}
-		return token, nil
+		return token, validateTokenStatus(token)
 	}
 	common.SysLog("ValidateUserToken: failed to get token: " + err.Error())
 	if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -225,6 +257,47 @@ func ValidateUserToken(key string) (token *Token, err error) {
 	return nil, fmt.Errorf("%w: %v", ErrDatabase, err)
 }
 
+// ValidateUserTokenById 按令牌ID校验令牌状态，用于网关内部发起的请求（如 Batch 任务）
+func ValidateUserTokenById(id int) (token *Token, err error) {
+	token, err = GetTokenById(id)
+	if err == nil {
+		return token, validateTokenStatus(token)
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrTokenInvalid
+	}
+	return nil, fmt.Errorf("%w: %v", ErrDatabase, err)
+}
+
+func validateTokenStatus(token *Token) error {
+	if token.Status == common.TokenStatusExhausted ||
+		token.Status == common.TokenStatusExpired ||
+		token.Status != common.TokenStatusEnabled {
+		return ErrTokenInvalid
+	}
+	if token.ExpiredTime != -1 && token.ExpiredTime < common.GetTimestamp() {
+		if !common.RedisEnabled {
+			token.Status = common.TokenStatusExpired
+			err := token.SelectUpdate()
+			if err != nil {
+				common.SysLog("failed to update token status" + err.Error())
+			}
+		}
+		return ErrTokenInvalid
+	}
+	if !token.UnlimitedQuota && token.RemainQuota <= 0 {
+		if !common.RedisEnabled {
+			token.Status = common.TokenStatusExhausted
+			err := token.SelectUpdate()
+			if err != nil {
+				common.SysLog("failed to update token status" + err.Error())
+			}
+		}
+		return ErrTokenInvalid
+	}
+	return nil
+}
+
 func GetTokenByIds(id int, userId int) (*Token, error) {
 	if id == 0 || userId == 0 {
 		return nil, errors.New("id 或 userId 为空！")
@@ -272,14 +345,12 @@ func GetTokenByKey(key string, fromDB bool) (token *Token, err error) {
 		// Don't return error - fall through to DB
 	}
 	fromDB = true
-	err = DB.Where(commonKeyCol+" = ?", key).First(&token).Error
+	err = DB.Where(commonKeyCol+" IN ?", tokenKeyCandidates(key)).First(&token).Error
 	return token, err
 }
 
 func (token *Token) Insert() error {
-	var err error
-	err = DB.Create(token).Error
-	return err
+	return createToken(DB, token)
 }
 
 // Update Make sure your token's fields is completed, because this will update non-zero values
@@ -295,7 +366,10 @@ func (token *Token) Update() (err error) {
 		}
 	}()
 	err = DB.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota",
-		"model_limits_enabled", "model_limits", "allow_ips", "group", "cross_group_retry").Updates(token).Error
+		"model_limits_enabled", "model_limits", "allow_ips", "group", "cross_group_retry", "hedging", "model_fallback",
+		"rate_limit_rpm", "rate_limit_tpm", "max_concurrency",
+		"budget_quota", "budget_soft_limit", "budget_period", "budget_used", "budget_reset_time",
+		"auto_rotate_days", "key_rotated_time").Updates(token).Error
 	return err
 }
 
@@ -369,7 +443,14 @@ func DeleteTokenById(id int, userId int) (err error) {
 	if err != nil {
 		return err
 	}
-	return token.Delete()
+	if err = token.Delete(); err != nil {
+		return err
+	}
+	// 子令牌随父令牌一起吊销
+	if _, err = RevokeChildTokens(token.Id); err != nil {
+		common.SysLog(fmt.Sprintf("failed to delete child tokens of token %d: %s", token.Id, err.Error()))
+	}
+	return nil
 }
 
 func IncreaseTokenQuota(tokenId int, key string, quota int) (err error) {
@@ -458,6 +539,12 @@ func BatchDeleteTokens(ids []int, userId int) (int, error) {
 		return 0, err
 	}
 
+	children, err := deleteChildTokens(tx, ids)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
 	if err := tx.Commit().Error; err != nil {
 		return 0, err
 	}
@@ -467,6 +554,9 @@ func BatchDeleteTokens(ids []int, userId int) (int, error) {
 			for _, t := range tokens {
 				_ = cacheDeleteToken(t.Key)
 			}
+			for _, t := range children {
+				_ = cacheDeleteToken(t.Key)
+			}
 		})
 	}
 
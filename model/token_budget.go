@@ -0,0 +1,131 @@
+package model
+
+import (
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"gorm.io/gorm"
+)
+
+// 令牌周期预算：BudgetQuota 为每个周期可花费的额度，BudgetUsed 为当前周期内已花费的额度，
+// 周期按 BudgetPeriod 对齐自然日/周/月，与订阅额度的重置周期一致，BudgetResetTime 为当前周期结束时间
+
+// IsValidTokenBudgetPeriod 令牌预算仅支持自然日/周/月周期
+func IsValidTokenBudgetPeriod(period string) bool {
+	switch period {
+	case SubscriptionResetDaily, SubscriptionResetWeekly, SubscriptionResetMonthly:
+		return true
+	}
+	return false
+}
+
+func calcTokenBudgetResetTime(base time.Time, period string) int64 {
+	return calcNextResetTime(base, &SubscriptionPlan{QuotaResetPeriod: period}, 0)
+}
+
+// HasBudget 令牌是否设置了周期预算
+func (token *Token) HasBudget() bool {
+	return token.BudgetQuota > 0 && IsValidTokenBudgetPeriod(token.BudgetPeriod)
+}
+
+// BudgetUsedInWindow 返回当前周期内的花费，周期已结束时为 0
+func (token *Token) BudgetUsedInWindow(now int64) int {
+	if !token.HasBudget() || token.BudgetResetTime <= now {
+		return 0
+	}
+	return max(token.BudgetUsed, 0)
+}
+
+// ResetBudgetWindow 清空花费并从当前时间开始新的预算周期，用于创建令牌或修改预算周期
+func (token *Token) ResetBudgetWindow(now time.Time) {
+	token.BudgetUsed = 0
+	token.BudgetResetTime = 0
+	if token.HasBudget() {
+		token.BudgetResetTime = calcTokenBudgetResetTime(now, token.BudgetPeriod)
+	}
+}
+
+// GetTokenBudgetUsed 从数据库读取令牌当前周期内的花费，缓存中的花费可能已过时
+func GetTokenBudgetUsed(tokenId int) (int, error) {
+	var token Token
+	err := DB.Select("id", "budget_quota", "budget_period", "budget_used", "budget_reset_time").
+		Where("id = ?", tokenId).First(&token).Error
+	if err != nil {
+		return 0, err
+	}
+	return token.BudgetUsedInWindow(common.GetTimestamp()), nil
+}
+
+// ReserveTokenBudget 在预算内原子地累加令牌当前周期内的花费，超出预算时不累加并返回 false；
+// 周期已结束时先开始新周期
+func ReserveTokenBudget(tokenId int, amount int, limit int) (bool, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		result := DB.Model(&Token{}).
+			Where("id = ? AND budget_reset_time > ? AND budget_used + ? <= ?", tokenId, common.GetTimestamp(), amount, limit).
+			Update("budget_used", gorm.Expr("budget_used + ?", amount))
+		if result.Error != nil {
+			return false, result.Error
+		}
+		if result.RowsAffected > 0 {
+			return true, nil
+		}
+		// 未更新时区分周期已结束与超出预算
+		var token Token
+		err := DB.Select("id", "budget_quota", "budget_period", "budget_used", "budget_reset_time").
+			Where("id = ?", tokenId).First(&token).Error
+		if err != nil {
+			return false, err
+		}
+		if !token.HasBudget() {
+			return true, nil
+		}
+		now := time.Now()
+		if token.BudgetResetTime > now.Unix() {
+			return false, nil
+		}
+		if err = startTokenBudgetWindow(tokenId, token.BudgetResetTime, token.BudgetPeriod, now); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// startTokenBudgetWindow 条件更新，并发请求中只有一个会开始新周期
+func startTokenBudgetWindow(tokenId int, resetTime int64, period string, now time.Time) error {
+	return DB.Model(&Token{}).
+		Where("id = ? AND budget_reset_time = ?", tokenId, resetTime).
+		Updates(map[string]interface{}{
+			"budget_used":       0,
+			"budget_reset_time": calcTokenBudgetResetTime(now, period),
+		}).Error
+}
+
+// AddTokenBudgetUsed 累加令牌当前周期内的花费，delta 为负数时退还；周期已结束时先开始新周期。
+// 返回累加后的花费
+func AddTokenBudgetUsed(tokenId int, delta int) (int, error) {
+	var token Token
+	err := DB.Select("id", "budget_quota", "budget_period", "budget_used", "budget_reset_time").
+		Where("id = ?", tokenId).First(&token).Error
+	if err != nil {
+		return 0, err
+	}
+	if !token.HasBudget() {
+		return 0, nil
+	}
+	now := time.Now()
+	if token.BudgetResetTime <= now.Unix() {
+		if err = startTokenBudgetWindow(tokenId, token.BudgetResetTime, token.BudgetPeriod, now); err != nil {
+			return 0, err
+		}
+		if delta < 0 {
+			// 上个周期的退还不计入新周期
+			return 0, nil
+		}
+	}
+	err = DB.Model(&Token{}).Where("id = ?", tokenId).
+		Update("budget_used", gorm.Expr("budget_used + ?", delta)).Error
+	if err != nil {
+		return 0, err
+	}
+	return GetTokenBudgetUsed(tokenId)
+}
@@ -0,0 +1,79 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBudgetWindow(t *testing.T) {
+	truncateTables(t)
+
+	token := &Token{
+		UserId:       1,
+		Key:          "budget-window-test",
+		Name:         "budget",
+		BudgetQuota:  1000,
+		BudgetPeriod: SubscriptionResetDaily,
+	}
+	token.ResetBudgetWindow(time.Now())
+	require.Greater(t, token.BudgetResetTime, common.GetTimestamp())
+	require.NoError(t, token.Insert())
+
+	used, err := AddTokenBudgetUsed(token.Id, 300)
+	require.NoError(t, err)
+	require.Equal(t, 300, used)
+	used, err = AddTokenBudgetUsed(token.Id, -100)
+	require.NoError(t, err)
+	require.Equal(t, 200, used)
+
+	// 周期结束后花费清零，并开始新的周期
+	require.NoError(t, DB.Model(&Token{}).Where("id = ?", token.Id).Update("budget_reset_time", common.GetTimestamp()-1).Error)
+	used, err = GetTokenBudgetUsed(token.Id)
+	require.NoError(t, err)
+	require.Zero(t, used)
+	used, err = AddTokenBudgetUsed(token.Id, 50)
+	require.NoError(t, err)
+	require.Equal(t, 50, used)
+
+	var stored Token
+	require.NoError(t, DB.First(&stored, token.Id).Error)
+	require.Greater(t, stored.BudgetResetTime, common.GetTimestamp())
+
+	// 未设置预算的令牌不记录花费
+	noBudget := &Token{UserId: 1, Key: "budget-window-none", Name: "none"}
+	require.NoError(t, noBudget.Insert())
+	used, err = AddTokenBudgetUsed(noBudget.Id, 50)
+	require.NoError(t, err)
+	require.Zero(t, used)
+}
+
+func TestReserveTokenBudget(t *testing.T) {
+	truncateTables(t)
+
+	token := &Token{UserId: 1, Key: "budget-reserve-test", Name: "budget", BudgetQuota: 1000, BudgetPeriod: SubscriptionResetDaily}
+	token.ResetBudgetWindow(time.Now())
+	require.NoError(t, token.Insert())
+
+	ok, err := ReserveTokenBudget(token.Id, 600, 1000)
+	require.NoError(t, err)
+	require.True(t, ok)
+	// 预留后剩余预算不足，并发请求无法超出预算
+	ok, err = ReserveTokenBudget(token.Id, 500, 1000)
+	require.NoError(t, err)
+	require.False(t, ok)
+	used, err := GetTokenBudgetUsed(token.Id)
+	require.NoError(t, err)
+	require.Equal(t, 600, used)
+
+	// 周期结束后开始新周期再预留
+	require.NoError(t, DB.Model(&Token{}).Where("id = ?", token.Id).Update("budget_reset_time", common.GetTimestamp()-1).Error)
+	ok, err = ReserveTokenBudget(token.Id, 500, 1000)
+	require.NoError(t, err)
+	require.True(t, ok)
+	used, err = GetTokenBudgetUsed(token.Id)
+	require.NoError(t, err)
+	require.Equal(t, 500, used)
+}
@@ -9,7 +9,7 @@ import (
 )
 
 func cacheSetToken(token Token) error {
-	key := common.GenerateHMAC(token.Key)
+	key := tokenKeyCacheName(token.Key)
 	token.Clean()
 	err := common.RedisHSetObj(fmt.Sprintf("token:%s", key), &token, time.Duration(common.RedisKeyCacheSeconds())*time.Second)
 	if err != nil {
@@ -19,7 +19,7 @@ func cacheSetToken(token Token) error {
 }
 
 func cacheDeleteToken(key string) error {
-	key = common.GenerateHMAC(key)
+	key = tokenKeyCacheName(key)
 	err := common.RedisDelKey(fmt.Sprintf("token:%s", key))
 	if err != nil {
 		return err
@@ -28,7 +28,7 @@ func cacheDeleteToken(key string) error {
 }
 
 func cacheIncrTokenQuota(key string, increment int64) error {
-	key = common.GenerateHMAC(key)
+	key = tokenKeyCacheName(key)
 	err := common.RedisHIncrBy(fmt.Sprintf("token:%s", key), constant.TokenFiledRemainQuota, increment)
 	if err != nil {
 		return err
@@ -41,7 +41,7 @@ func cacheDecrTokenQuota(key string, decrement int64) error {
 }
 
 func cacheSetTokenField(key string, field string, value string) error {
-	key = common.GenerateHMAC(key)
+	key = tokenKeyCacheName(key)
 	err := common.RedisHSetField(fmt.Sprintf("token:%s", key), field, value)
 	if err != nil {
 		return err
@@ -51,7 +51,7 @@ func cacheSetTokenField(key string, field string, value string) error {
 
 // CacheGetTokenByKey 从缓存中获取 token，如果缓存中不存在，则从数据库中获取
 func cacheGetTokenByKey(key string) (*Token, error) {
-	hmacKey := common.GenerateHMAC(key)
+	hmacKey := tokenKeyCacheName(key)
 	if !common.RedisEnabled {
 		return nil, fmt.Errorf("redis is not enabled")
 	}
@@ -63,3 +63,12 @@ func cacheGetTokenByKey(key string) (*Token, error) {
 	token.Key = key
 	return &token, nil
 }
+
+// cacheSetTokenPreviousKey 缓存宽限期内的旧密钥对应的当前密钥，currentKey 为空表示旧密钥不存在
+func cacheSetTokenPreviousKey(key string, currentKey string, expiration time.Duration) error {
+	return common.RedisSet(fmt.Sprintf("token_prev:%s", tokenKeyCacheName(key)), currentKey, expiration)
+}
+
+func cacheGetTokenPreviousKey(key string) (string, error) {
+	return common.RedisGet(fmt.Sprintf("token_prev:%s", tokenKeyCacheName(key)))
+}
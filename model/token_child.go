@@ -0,0 +1,175 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/bytedance/gopkg/util/gopool"
+	"gorm.io/gorm"
+)
+
+// 子令牌：由父令牌或用户通过 API 签发的短期令牌，供浏览器等不可信环境使用。
+// 子令牌的额度从父令牌中划出，删除父令牌或吊销时一并删除，用量通过日志的 parent_token_id 归属到父令牌
+
+// MaxChildTokenTTLMinutes 子令牌的最长有效期
+const MaxChildTokenTTLMinutes = 24 * 60
+
+var ErrParentTokenQuotaNotEnough = errors.New("父令牌额度不足")
+
+// IsChild 是否为子令牌
+func (token *Token) IsChild() bool {
+	return token.ParentId != 0
+}
+
+// CreateChildToken 从父令牌划出子令牌的额度并创建子令牌，父令牌为无限额度时不划出额度
+func CreateChildToken(parent *Token, child *Token) error {
+	child.ParentId = parent.Id
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if !parent.UnlimitedQuota {
+			result := tx.Model(&Token{}).
+				Where("id = ? AND remain_quota >= ?", parent.Id, child.RemainQuota).
+				Update("remain_quota", gorm.Expr("remain_quota - ?", child.RemainQuota))
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return ErrParentTokenQuotaNotEnough
+			}
+		}
+		return createToken(tx, child)
+	})
+	if err != nil {
+		return err
+	}
+	if !parent.UnlimitedQuota && common.RedisEnabled {
+		gopool.Go(func() {
+			if err := cacheDecrTokenQuota(parent.Key, int64(child.RemainQuota)); err != nil {
+				common.SysLog("failed to decrease parent token quota cache: " + err.Error())
+			}
+		})
+	}
+	return nil
+}
+
+// RevokeChildTokens 删除父令牌的所有子令牌，未用完的额度退还给父令牌，返回删除的数量
+func RevokeChildTokens(parentId int) (int, error) {
+	var parent Token
+	if err := DB.Unscoped().First(&parent, "id = ?", parentId).Error; err != nil {
+		return 0, err
+	}
+	var children []Token
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		children, err = deleteChildTokens(tx, []int{parentId})
+		if err != nil || parent.UnlimitedQuota {
+			return err
+		}
+		refund := childTokensRemainQuota(children)
+		if refund <= 0 {
+			return nil
+		}
+		return tx.Model(&Token{}).Where("id = ?", parentId).
+			Update("remain_quota", gorm.Expr("remain_quota + ?", refund)).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	if common.RedisEnabled {
+		gopool.Go(func() {
+			for _, child := range children {
+				_ = cacheDeleteToken(child.Key)
+			}
+			if refund := childTokensRemainQuota(children); refund > 0 && !parent.UnlimitedQuota {
+				if err := cacheIncrTokenQuota(parent.Key, int64(refund)); err != nil {
+					common.SysLog(fmt.Sprintf("failed to refund parent token %d quota cache: %s", parentId, err.Error()))
+				}
+			}
+		})
+	}
+	return len(children), nil
+}
+
+func childTokensRemainQuota(children []Token) int {
+	remain := 0
+	for _, child := range children {
+		remain += max(child.RemainQuota, 0)
+	}
+	return remain
+}
+
+// deleteChildTokens 删除指定父令牌的子令牌，返回被删除的子令牌（用于清理缓存）
+func deleteChildTokens(tx *gorm.DB, parentIds []int) ([]Token, error) {
+	var children []Token
+	if err := tx.Where("parent_id IN (?)", parentIds).Find(&children).Error; err != nil {
+		return nil, err
+	}
+	if len(children) == 0 {
+		return nil, nil
+	}
+	if err := tx.Where("parent_id IN (?)", parentIds).Delete(&Token{}).Error; err != nil {
+		return nil, err
+	}
+	return children, nil
+}
+
+// ExpireChildTokens 将已过期的子令牌标记为过期，未用完的额度退还给父令牌，返回处理的数量
+func ExpireChildTokens(limit int) (int, error) {
+	var children []Token
+	err := DB.Where("parent_id <> 0 AND expired_time <> -1 AND expired_time <= ? AND (status = ? OR remain_quota > 0)",
+		common.GetTimestamp(), common.TokenStatusEnabled).
+		Order("id").Limit(limit).Find(&children).Error
+	if err != nil {
+		return 0, err
+	}
+	for i := range children {
+		if err := expireChildToken(&children[i]); err != nil {
+			return i, err
+		}
+	}
+	return len(children), nil
+}
+
+func expireChildToken(child *Token) error {
+	refund := max(child.RemainQuota, 0)
+	var parent Token
+	refunded := false
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		// 按读取时的额度条件更新，期间有请求扣费时留到下一轮处理
+		result := tx.Model(&Token{}).
+			Where("id = ? AND remain_quota = ?", child.Id, child.RemainQuota).
+			Updates(map[string]interface{}{
+				"status":       common.TokenStatusExpired,
+				"remain_quota": 0,
+			})
+		if result.Error != nil || result.RowsAffected == 0 || refund == 0 {
+			return result.Error
+		}
+		if err := tx.First(&parent, "id = ?", child.ParentId).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+		if parent.UnlimitedQuota {
+			return nil
+		}
+		refunded = true
+		return tx.Model(&Token{}).Where("id = ?", parent.Id).
+			Update("remain_quota", gorm.Expr("remain_quota + ?", refund)).Error
+	})
+	if err != nil {
+		return err
+	}
+	if common.RedisEnabled {
+		gopool.Go(func() {
+			_ = cacheDeleteToken(child.Key)
+			if refunded {
+				if err := cacheIncrTokenQuota(parent.Key, int64(refund)); err != nil {
+					common.SysLog(fmt.Sprintf("failed to refund parent token %d quota cache: %s", parent.Id, err.Error()))
+				}
+			}
+		})
+	}
+	return nil
+}
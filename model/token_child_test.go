@@ -0,0 +1,75 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChildTokenCarveAndRevoke(t *testing.T) {
+	truncateTables(t)
+
+	parent := &Token{UserId: 1, Key: "child-test-parent", Name: "parent", RemainQuota: 1000}
+	require.NoError(t, parent.Insert())
+
+	child := &Token{UserId: 1, Key: "child-test-child", Name: "child", RemainQuota: 600}
+	require.NoError(t, CreateChildToken(parent, child))
+	require.Equal(t, parent.Id, child.ParentId)
+	require.True(t, child.IsChild())
+
+	stored, err := GetTokenById(parent.Id)
+	require.NoError(t, err)
+	require.Equal(t, 400, stored.RemainQuota)
+
+	// 额度不足时不创建子令牌
+	err = CreateChildToken(parent, &Token{UserId: 1, Key: "child-test-too-big", Name: "big", RemainQuota: 500})
+	require.ErrorIs(t, err, ErrParentTokenQuotaNotEnough)
+
+	// 子令牌用掉一部分后吊销，剩余额度退还父令牌
+	require.NoError(t, DB.Model(&Token{}).Where("id = ?", child.Id).Update("remain_quota", 250).Error)
+	count, err := RevokeChildTokens(parent.Id)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	stored, err = GetTokenById(parent.Id)
+	require.NoError(t, err)
+	require.Equal(t, 650, stored.RemainQuota)
+	_, err = GetTokenById(child.Id)
+	require.Error(t, err)
+
+	// 删除父令牌时子令牌一并删除
+	child = &Token{UserId: 1, Key: "child-test-child-2", Name: "child2", RemainQuota: 100}
+	require.NoError(t, CreateChildToken(parent, child))
+	require.NoError(t, DeleteTokenById(parent.Id, 1))
+	_, err = GetTokenById(child.Id)
+	require.Error(t, err)
+}
+
+func TestExpireChildTokensRefundsParent(t *testing.T) {
+	truncateTables(t)
+
+	parent := &Token{UserId: 1, Key: "child-expire-parent", Name: "parent", RemainQuota: 1000, ExpiredTime: -1}
+	require.NoError(t, parent.Insert())
+	expired := &Token{UserId: 1, Key: "child-expire-old", Name: "old", RemainQuota: 300, ExpiredTime: common.GetTimestamp() - 1, Status: common.TokenStatusEnabled}
+	require.NoError(t, CreateChildToken(parent, expired))
+	active := &Token{UserId: 1, Key: "child-expire-new", Name: "new", RemainQuota: 200, ExpiredTime: common.GetTimestamp() + 600, Status: common.TokenStatusEnabled}
+	require.NoError(t, CreateChildToken(parent, active))
+
+	n, err := ExpireChildTokens(10)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	stored, err := GetTokenById(parent.Id)
+	require.NoError(t, err)
+	require.Equal(t, 800, stored.RemainQuota)
+	stored, err = GetTokenById(expired.Id)
+	require.NoError(t, err)
+	require.Equal(t, common.TokenStatusExpired, stored.Status)
+	require.Equal(t, 0, stored.RemainQuota)
+
+	// 已处理的子令牌不会重复退还
+	n, err = ExpireChildTokens(10)
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+}
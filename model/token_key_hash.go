@@ -0,0 +1,161 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"gorm.io/gorm"
+)
+
+// 令牌密钥与用户访问令牌的加密存储：开启 TOKEN_KEY_HASH_ENABLED 后，数据库中只保存以 CryptoSecret 为密钥的
+// HMAC-SHA256，并加上 hashedKeyPrefix 前缀与明文区分；令牌额外保存首尾各 4 位用于脱敏展示。
+// 查询时同时匹配明文与哈希，开启前创建的明文密钥在迁移完成前仍然可用。修改 CryptoSecret 会使已加密的密钥全部失效。
+// 生成的密钥只包含字母与数字，不会以 hashedKeyPrefix 开头，因此用户提交的密钥不会与哈希值混淆
+
+const (
+	hashedKeyPrefix = "h:"
+	// 用户访问令牌的列为 char(32)，哈希值截断到 30 位
+	accessTokenHashLength = 30
+	tokenKeyHashBatchSize = 500
+)
+
+// IsHashedKey 判断密钥是否为加密存储的哈希值
+func IsHashedKey(key string) bool {
+	return strings.HasPrefix(key, hashedKeyPrefix)
+}
+
+func hashTokenKey(key string) string {
+	if IsHashedKey(key) {
+		return key
+	}
+	return hashedKeyPrefix + common.GenerateHMAC(key)
+}
+
+// storedTokenKey 返回写入数据库的令牌密钥
+func storedTokenKey(key string) string {
+	if constant.TokenKeyHashEnabled {
+		return hashTokenKey(key)
+	}
+	return key
+}
+
+// tokenKeyCandidates 返回查询令牌时需要匹配的密钥：哈希值只匹配自身，明文同时匹配明文与哈希
+func tokenKeyCandidates(key string) []string {
+	if IsHashedKey(key) {
+		return []string{key}
+	}
+	return []string{key, hashTokenKey(key)}
+}
+
+// tokenKeyMatches 判断数据库中保存的密钥与请求中的明文密钥是否匹配
+func tokenKeyMatches(stored string, key string) bool {
+	return stored != "" && (stored == key || stored == hashTokenKey(key))
+}
+
+// tokenKeyCacheName 令牌缓存的键名，明文与哈希得到相同的键名，缓存不受加密存储影响
+func tokenKeyCacheName(key string) string {
+	if IsHashedKey(key) {
+		return strings.TrimPrefix(key, hashedKeyPrefix)
+	}
+	return common.GenerateHMAC(key)
+}
+
+func tokenKeyHint(key string) string {
+	if len(key) <= 8 {
+		return ""
+	}
+	return key[:4] + key[len(key)-4:]
+}
+
+// IsKeyHashed 令牌密钥是否已加密存储，加密存储的密钥无法再查看完整密钥
+func (token *Token) IsKeyHashed() bool {
+	return IsHashedKey(token.Key)
+}
+
+// createToken 按存储设置写入令牌，写入后 token.Key 仍为明文，供调用方返回给用户
+func createToken(tx *gorm.DB, token *Token) error {
+	key := token.Key
+	token.KeyHint = tokenKeyHint(key)
+	token.Key = storedTokenKey(key)
+	err := tx.Create(token).Error
+	token.Key = key
+	return err
+}
+
+func hashAccessToken(token string) string {
+	if IsHashedKey(token) {
+		return token
+	}
+	return hashedKeyPrefix + common.GenerateHMAC(token)[:accessTokenHashLength]
+}
+
+// StoredAccessToken 返回写入数据库的用户访问令牌
+func StoredAccessToken(token string) string {
+	if constant.TokenKeyHashEnabled {
+		return hashAccessToken(token)
+	}
+	return token
+}
+
+// migrateTokenKeysToHash 开启加密存储后将已有的明文令牌密钥与用户访问令牌转换为哈希，可重复执行
+func migrateTokenKeysToHash() error {
+	if !constant.TokenKeyHashEnabled {
+		return nil
+	}
+	total := 0
+	for {
+		var tokens []Token
+		// 已删除的令牌同样需要转换
+		err := DB.Unscoped().Select("id", commonKeyCol, "previous_key").
+			Where("("+commonKeyCol+" <> '' AND "+commonKeyCol+" NOT LIKE ?) OR (previous_key <> '' AND previous_key NOT LIKE ?)",
+				hashedKeyPrefix+"%", hashedKeyPrefix+"%").
+			Limit(tokenKeyHashBatchSize).Find(&tokens).Error
+		if err != nil {
+			return err
+		}
+		for _, t := range tokens {
+			updates := map[string]interface{}{}
+			if t.Key != "" && !IsHashedKey(t.Key) {
+				updates["key"] = hashTokenKey(t.Key)
+				updates["key_hint"] = tokenKeyHint(t.Key)
+			}
+			if t.PreviousKey != "" {
+				updates["previous_key"] = hashTokenKey(t.PreviousKey)
+			}
+			if err := DB.Unscoped().Model(&Token{}).Where("id = ?", t.Id).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+		total += len(tokens)
+		if len(tokens) < tokenKeyHashBatchSize {
+			break
+		}
+	}
+
+	for {
+		var users []User
+		err := DB.Unscoped().Select("id", "access_token").
+			Where("access_token IS NOT NULL AND access_token <> '' AND access_token NOT LIKE ?", hashedKeyPrefix+"%").
+			Limit(tokenKeyHashBatchSize).Find(&users).Error
+		if err != nil {
+			return err
+		}
+		for _, u := range users {
+			err := DB.Unscoped().Model(&User{}).Where("id = ?", u.Id).
+				Update("access_token", hashAccessToken(strings.TrimSpace(u.GetAccessToken()))).Error
+			if err != nil {
+				return err
+			}
+		}
+		total += len(users)
+		if len(users) < tokenKeyHashBatchSize {
+			break
+		}
+	}
+	if total > 0 {
+		common.SysLog(fmt.Sprintf("hashed %d plaintext token keys and access tokens", total))
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/stretchr/testify/require"
+)
+
+func enableTokenKeyHash(t *testing.T) {
+	t.Helper()
+	initCol()
+	original := constant.TokenKeyHashEnabled
+	constant.TokenKeyHashEnabled = true
+	t.Cleanup(func() { constant.TokenKeyHashEnabled = original })
+}
+
+func TestHashedTokenKeyLookup(t *testing.T) {
+	truncateTables(t)
+	enableTokenKeyHash(t)
+
+	rawKey := "abcdHashedTokenKeyLookupTest0000000000000000wxyz"
+	token := &Token{UserId: 1, Key: rawKey, Name: "hashed", RemainQuota: 100, ExpiredTime: -1, Status: common.TokenStatusEnabled}
+	require.NoError(t, token.Insert())
+	require.Equal(t, rawKey, token.Key)
+
+	stored, err := GetTokenById(token.Id)
+	require.NoError(t, err)
+	require.True(t, stored.IsKeyHashed())
+	require.NotContains(t, stored.Key, rawKey)
+	require.Equal(t, "abcd**********wxyz", stored.GetMaskedKey())
+
+	found, err := ValidateUserToken(rawKey)
+	require.NoError(t, err)
+	require.Equal(t, token.Id, found.Id)
+	// 计费流程使用数据库中保存的值查询令牌
+	found, err = GetTokenByKey(found.Key, true)
+	require.NoError(t, err)
+	require.Equal(t, token.Id, found.Id)
+
+	// 数据库中的哈希值不能作为密钥使用
+	_, err = ValidateUserToken(stored.Key)
+	require.ErrorIs(t, err, ErrTokenInvalid)
+
+	// 轮换后旧密钥在宽限期内仍然可用，并能识别使用的密钥版本
+	require.NoError(t, RotateTokenKey(stored, 3600))
+	newKey := stored.Key
+	previous, err := ValidateUserToken(rawKey)
+	require.NoError(t, err)
+	require.Equal(t, 0, previous.UsedKeyVersion(rawKey))
+	current, err := ValidateUserToken(newKey)
+	require.NoError(t, err)
+	require.Equal(t, 1, current.UsedKeyVersion(newKey))
+}
+
+func TestMigrateTokenKeysToHash(t *testing.T) {
+	truncateTables(t)
+	initCol()
+
+	rawKey := "plainTokenKeyBeforeHashMigration0000000000000000"
+	token := &Token{UserId: 1, Key: rawKey, Name: "plain", RemainQuota: 100, ExpiredTime: -1, Status: common.TokenStatusEnabled}
+	require.NoError(t, token.Insert())
+	user := &User{Username: "hash_migrate", Password: "password123", AccessToken: common.GetPointer("plainAccessTokenBeforeMigration0")}
+	require.NoError(t, DB.Create(user).Error)
+
+	enableTokenKeyHash(t)
+	require.NoError(t, migrateTokenKeysToHash())
+
+	stored, err := GetTokenById(token.Id)
+	require.NoError(t, err)
+	require.True(t, stored.IsKeyHashed())
+	found, err := ValidateUserToken(rawKey)
+	require.NoError(t, err)
+	require.Equal(t, token.Id, found.Id)
+
+	var storedUser User
+	require.NoError(t, DB.First(&storedUser, user.Id).Error)
+	require.True(t, IsHashedKey(storedUser.GetAccessToken()))
+	require.Len(t, storedUser.GetAccessToken(), 32)
+	validated, err := ValidateAccessToken("plainAccessTokenBeforeMigration0")
+	require.NoError(t, err)
+	require.NotNil(t, validated)
+	require.Equal(t, user.Id, validated.Id)
+	validated, err = ValidateAccessToken(storedUser.GetAccessToken())
+	require.NoError(t, err)
+	require.Nil(t, validated)
+
+	// 重复执行不会重复转换
+	require.NoError(t, migrateTokenKeysToHash())
+	again, err := GetTokenById(token.Id)
+	require.NoError(t, err)
+	require.Equal(t, stored.Key, again.Key)
+}
+
+func TestSearchHashedTokenKeys(t *testing.T) {
+	truncateTables(t)
+	enableTokenKeyHash(t)
+
+	rawKey := "abcdSearchHashedTokenKeysTest00000000000000wxyz"
+	token := &Token{UserId: 1, Key: rawKey, Name: "hashed", RemainQuota: 100, ExpiredTime: -1, Status: common.TokenStatusEnabled}
+	require.NoError(t, token.Insert())
+
+	// 精确搜索使用完整密钥
+	tokens, total, err := SearchUserTokens(1, "", "sk-"+rawKey, 0, 10)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, total)
+	require.Equal(t, token.Id, tokens[0].Id)
+
+	// 模糊搜索匹配首尾各 4 位
+	for _, pattern := range []string{"abcd%", "%wxyz"} {
+		tokens, total, err = SearchUserTokens(1, "", pattern, 0, 10)
+		require.NoError(t, err)
+		require.EqualValues(t, 1, total, pattern)
+		require.Equal(t, token.Id, tokens[0].Id)
+	}
+	_, total, err = SearchUserTokens(1, "", "%zzzz%", 0, 10)
+	require.NoError(t, err)
+	require.EqualValues(t, 0, total)
+}
@@ -0,0 +1,156 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/bytedance/gopkg/util/gopool"
+	"gorm.io/gorm"
+)
+
+// 令牌密钥轮换：为同一个令牌生成新密钥，旧密钥保存在 PreviousKey 中，在 PreviousKeyExpiry 之前仍然有效。
+// KeyVersion 每次轮换加一，日志中记录请求使用的密钥版本
+
+var ErrTokenKeyChanged = errors.New("令牌密钥已被修改，请刷新后重试")
+
+// RotateTokenKey 为令牌生成新密钥，graceSeconds 为旧密钥的宽限期，0 表示旧密钥立即失效。
+// 宽限期内再次轮换时，更早的密钥立即失效
+func RotateTokenKey(token *Token, graceSeconds int64) error {
+	newKey, err := common.GenerateKey()
+	if err != nil {
+		return err
+	}
+	now := common.GetTimestamp()
+	oldKey := token.Key
+	previousKey, previousKeyExpiry := "", int64(0)
+	if graceSeconds > 0 {
+		previousKey, previousKeyExpiry = storedTokenKey(oldKey), now+graceSeconds
+	}
+	// 条件更新，防止并发轮换时覆盖彼此的密钥
+	result := DB.Model(&Token{}).
+		Where("id = ? AND "+commonKeyCol+" = ?", token.Id, oldKey).
+		Updates(map[string]interface{}{
+			"key":                 storedTokenKey(newKey),
+			"key_hint":            tokenKeyHint(newKey),
+			"previous_key":        previousKey,
+			"previous_key_expiry": previousKeyExpiry,
+			"key_version":         gorm.Expr("key_version + 1"),
+			"key_rotated_time":    now,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTokenKeyChanged
+	}
+	// 返回明文密钥供调用方展示，加密存储时无法再次查看
+	token.Key = newKey
+	token.KeyHint = tokenKeyHint(newKey)
+	token.PreviousKey = previousKey
+	token.PreviousKeyExpiry = previousKeyExpiry
+	token.KeyVersion++
+	token.KeyRotatedTime = now
+	if common.RedisEnabled {
+		if err := cacheDeleteToken(oldKey); err != nil {
+			common.SysLog("failed to delete token cache: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// GetTokenByPreviousKey 按宽限期内的旧密钥查找令牌，返回的令牌 Key 为当前密钥。
+// 启用 Redis 时与 GetTokenByKey 一样走缓存：缓存旧密钥到当前密钥的映射，不存在的结果同样缓存，
+// 映射的有效期不超过宽限期。再次轮换时当前密钥的缓存被删除，映射随之失效
+func GetTokenByPreviousKey(key string) (*Token, error) {
+	if key == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+	if common.RedisEnabled {
+		if currentKey, err := cacheGetTokenPreviousKey(key); err == nil {
+			if currentKey == "" {
+				return nil, gorm.ErrRecordNotFound
+			}
+			token, err := GetTokenByKey(currentKey, false)
+			if err == nil && token.PreviousKeyExpiry > common.GetTimestamp() {
+				token.PreviousKey = key
+				return token, nil
+			}
+		}
+	}
+	var token Token
+	err := DB.Where("previous_key IN ? AND previous_key_expiry > ?", tokenKeyCandidates(key), common.GetTimestamp()).First(&token).Error
+	if common.RedisEnabled && (err == nil || errors.Is(err, gorm.ErrRecordNotFound)) {
+		cacheTokenPreviousKey(key, &token, err == nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func cacheTokenPreviousKey(key string, token *Token, found bool) {
+	expiration := time.Duration(common.RedisKeyCacheSeconds()) * time.Second
+	currentKey := ""
+	if found {
+		currentKey = token.Key
+		expiration = min(expiration, time.Duration(token.PreviousKeyExpiry-common.GetTimestamp())*time.Second)
+		if expiration <= 0 {
+			return
+		}
+	}
+	gopool.Go(func() {
+		if err := cacheSetTokenPreviousKey(key, currentKey, expiration); err != nil {
+			common.SysLog("failed to cache token previous key: " + err.Error())
+		}
+	})
+}
+
+// UsedKeyVersion 返回本次请求使用的密钥版本，使用宽限期内的旧密钥时为上一个版本
+func (token *Token) UsedKeyVersion(key string) int {
+	if !tokenKeyMatches(token.Key, key) && tokenKeyMatches(token.PreviousKey, key) {
+		return token.KeyVersion - 1
+	}
+	return token.KeyVersion
+}
+
+// ExpireTokenPreviousKeys 清理宽限期已结束的旧密钥并使相关用户的令牌缓存失效，返回处理的令牌数
+func ExpireTokenPreviousKeys(limit int) (int, error) {
+	var tokens []Token
+	err := DB.Select("id", "user_id").
+		Where("previous_key <> '' AND previous_key_expiry <= ?", common.GetTimestamp()).
+		Limit(limit).Find(&tokens).Error
+	if err != nil || len(tokens) == 0 {
+		return 0, err
+	}
+	ids := make([]int, 0, len(tokens))
+	userIds := make(map[int]struct{})
+	for _, t := range tokens {
+		ids = append(ids, t.Id)
+		userIds[t.UserId] = struct{}{}
+	}
+	err = DB.Model(&Token{}).
+		Where("id IN ? AND previous_key_expiry <= ?", ids, common.GetTimestamp()).
+		Updates(map[string]interface{}{
+			"previous_key":        "",
+			"previous_key_expiry": 0,
+		}).Error
+	if err != nil {
+		return 0, err
+	}
+	for userId := range userIds {
+		if err := InvalidateUserTokensCache(userId); err != nil {
+			common.SysLog("failed to invalidate user tokens cache: " + err.Error())
+		}
+	}
+	return len(ids), nil
+}
+
+// GetTokensDueForAutoRotation 获取已到自动轮换时间的令牌
+func GetTokensDueForAutoRotation(limit int) ([]*Token, error) {
+	var tokens []*Token
+	err := DB.Where("auto_rotate_days > 0 AND status = ? AND key_rotated_time + auto_rotate_days * 86400 <= ?",
+		common.TokenStatusEnabled, common.GetTimestamp()).
+		Order("id").Limit(limit).Find(&tokens).Error
+	return tokens, err
+}
@@ -0,0 +1,70 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotateTokenKeyWithGracePeriod(t *testing.T) {
+	truncateTables(t)
+	initCol()
+
+	token := &Token{UserId: 1, Key: "rotation-test-key", Name: "rotate", RemainQuota: 1000, ExpiredTime: -1, Status: common.TokenStatusEnabled}
+	require.NoError(t, token.Insert())
+
+	require.NoError(t, RotateTokenKey(token, 3600))
+	require.NotEqual(t, "rotation-test-key", token.Key)
+	require.Equal(t, 1, token.KeyVersion)
+
+	// 新旧密钥都能找到同一个令牌，旧密钥对应上一个版本
+	current, err := ValidateUserToken(token.Key)
+	require.NoError(t, err)
+	require.Equal(t, token.Id, current.Id)
+	require.Equal(t, 1, current.UsedKeyVersion(token.Key))
+	previous, err := ValidateUserToken("rotation-test-key")
+	require.NoError(t, err)
+	require.Equal(t, token.Id, previous.Id)
+	require.Equal(t, token.Key, previous.Key)
+	require.Equal(t, 0, previous.UsedKeyVersion("rotation-test-key"))
+
+	// 使用过期的令牌信息轮换时拒绝，避免覆盖新密钥
+	stale := &Token{Id: token.Id, Key: "rotation-test-key"}
+	require.ErrorIs(t, RotateTokenKey(stale, 3600), ErrTokenKeyChanged)
+
+	// 宽限期结束后旧密钥失效
+	require.NoError(t, DB.Model(&Token{}).Where("id = ?", token.Id).Update("previous_key_expiry", common.GetTimestamp()-1).Error)
+	_, err = ValidateUserToken("rotation-test-key")
+	require.ErrorIs(t, err, ErrTokenInvalid)
+	n, err := ExpireTokenPreviousKeys(100)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	stored, err := GetTokenById(token.Id)
+	require.NoError(t, err)
+	require.Empty(t, stored.PreviousKey)
+
+	// 不保留宽限期时旧密钥立即失效
+	oldKey := stored.Key
+	require.NoError(t, RotateTokenKey(stored, 0))
+	_, err = ValidateUserToken(oldKey)
+	require.ErrorIs(t, err, ErrTokenInvalid)
+	require.Equal(t, 2, stored.KeyVersion)
+}
+
+func TestGetTokensDueForAutoRotation(t *testing.T) {
+	truncateTables(t)
+
+	now := common.GetTimestamp()
+	due := &Token{UserId: 1, Key: "auto-rotate-due", Name: "due", Status: common.TokenStatusEnabled, AutoRotateDays: 1, KeyRotatedTime: now - 86401}
+	notDue := &Token{UserId: 1, Key: "auto-rotate-not-due", Name: "not-due", Status: common.TokenStatusEnabled, AutoRotateDays: 7, KeyRotatedTime: now - 86401}
+	disabled := &Token{UserId: 1, Key: "auto-rotate-disabled", Name: "disabled", Status: common.TokenStatusEnabled, KeyRotatedTime: now - 86401}
+	for _, token := range []*Token{due, notDue, disabled} {
+		require.NoError(t, token.Insert())
+	}
+
+	tokens, err := GetTokensDueForAutoRotation(10)
+	require.NoError(t, err)
+	require.Len(t, tokens, 1)
+	require.Equal(t, due.Id, tokens[0].Id)
+}
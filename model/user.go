@@ -768,8 +768,12 @@ func ValidateAccessToken(token string) (*User, error) {
 		return nil, nil
 	}
 	token = strings.Replace(token, "Bearer ", "", 1)
+	if IsHashedKey(token) {
+		// 数据库中保存的哈希值不能作为访问令牌使用
+		return nil, nil
+	}
 	user := &User{}
-	err := DB.Where("access_token = ?", token).First(user).Error
+	err := DB.Where("access_token IN ?", []string{token, hashAccessToken(token)}).First(user).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, nil
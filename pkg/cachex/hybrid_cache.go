@@ -108,6 +108,57 @@ func (c *HybridCache[V]) Get(key string) (value V, found bool, err error) {
 	return c.memCache().Get(full)
 }
 
+// GetMany returns the values found for keys, keyed by the raw keys. Redis uses a single MGET round trip.
+func (c *HybridCache[V]) GetMany(keys []string) (map[string]V, error) {
+	res := make(map[string]V, len(keys))
+	rawByFull := make(map[string]string, len(keys))
+	fullKeys := make([]string, 0, len(keys))
+	for _, k := range keys {
+		full := c.ns.FullKey(k)
+		if full == "" {
+			continue
+		}
+		if _, ok := rawByFull[full]; !ok {
+			fullKeys = append(fullKeys, full)
+		}
+		rawByFull[full] = k
+	}
+	if len(fullKeys) == 0 {
+		return res, nil
+	}
+
+	if c.redisOn() {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultRedisOpTimeout)
+		defer cancel()
+
+		values, err := c.redis.MGet(ctx, fullKeys...).Result()
+		if err != nil {
+			return res, err
+		}
+		for i, value := range values {
+			raw, ok := value.(string)
+			if !ok {
+				continue
+			}
+			v, decErr := c.redisCodec.Decode(raw)
+			if decErr != nil {
+				return res, decErr
+			}
+			res[rawByFull[fullKeys[i]]] = v
+		}
+		return res, nil
+	}
+
+	values, _, err := c.memCache().GetMany(fullKeys)
+	if err != nil {
+		return res, err
+	}
+	for full, v := range values {
+		res[rawByFull[full]] = v
+	}
+	return res, nil
+}
+
 func (c *HybridCache[V]) SetWithTTL(key string, v V, ttl time.Duration) error {
 	full := c.ns.FullKey(key)
 	if full == "" {
@@ -0,0 +1,146 @@
+package channelstats
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+)
+
+// Sample 单次渠道请求的结果
+type Sample struct {
+	Success   bool
+	LatencyMs int64
+	TtftMs    int64
+	HasTtft   bool
+}
+
+// Stats 渠道在某个模型上的滚动统计（指数加权移动平均）
+type Stats struct {
+	Samples   int64
+	LatencyMs float64
+	TtftMs    float64
+	ErrorRate float64
+	InFlight  int64
+	UpdatedAt time.Time
+}
+
+type statsKey struct {
+	channelId int
+	model     string
+}
+
+type entry struct {
+	mu       sync.Mutex
+	stats    Stats
+	inFlight atomic.Int64
+}
+
+var entries sync.Map
+
+func getEntry(channelId int, model string) *entry {
+	key := statsKey{channelId: channelId, model: model}
+	if value, ok := entries.Load(key); ok {
+		return value.(*entry)
+	}
+	actual, _ := entries.LoadOrStore(key, &entry{})
+	return actual.(*entry)
+}
+
+// Begin 标记渠道开始处理一个请求，需与 Finish 或 Cancel 成对调用
+func Begin(channelId int, model string) {
+	getEntry(channelId, model).inFlight.Add(1)
+}
+
+// Cancel 结束请求但不计入统计，用于与渠道质量无关的失败（如请求参数错误）
+func Cancel(channelId int, model string) {
+	e := getEntry(channelId, model)
+	if e.inFlight.Add(-1) < 0 {
+		e.inFlight.Store(0)
+	}
+}
+
+// Finish 结束请求并将结果计入滚动统计
+func Finish(channelId int, model string, sample Sample) {
+	e := getEntry(channelId, model)
+	if e.inFlight.Add(-1) < 0 {
+		e.inFlight.Store(0)
+	}
+
+	setting := operation_setting.GetChannelSelectionSetting()
+	alpha := setting.EWMAAlpha
+	if alpha <= 0 || alpha > 1 {
+		alpha = 0.2
+	}
+	now := time.Now()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if isExpired(e.stats, now) {
+		e.stats = Stats{}
+	}
+	errorValue := 0.0
+	if !sample.Success {
+		errorValue = 1
+	}
+	first := e.stats.Samples == 0
+	e.stats.ErrorRate = ewma(e.stats.ErrorRate, errorValue, alpha, first)
+	if sample.Success {
+		if sample.LatencyMs >= 0 {
+			e.stats.LatencyMs = ewma(e.stats.LatencyMs, float64(sample.LatencyMs), alpha, e.stats.LatencyMs == 0)
+		}
+		if sample.HasTtft && sample.TtftMs >= 0 {
+			e.stats.TtftMs = ewma(e.stats.TtftMs, float64(sample.TtftMs), alpha, e.stats.TtftMs == 0)
+		}
+	} else {
+		// 快速失败的渠道不能因耗时短而显得延迟更低，按惩罚值计入延迟
+		penalty := float64(max(sample.LatencyMs, int64(setting.FailureLatencyMs)))
+		e.stats.LatencyMs = ewma(e.stats.LatencyMs, penalty, alpha, e.stats.LatencyMs == 0)
+		if e.stats.TtftMs > 0 {
+			e.stats.TtftMs = ewma(e.stats.TtftMs, penalty, alpha, false)
+		}
+	}
+	e.stats.Samples++
+	e.stats.UpdatedAt = now
+}
+
+// Get 获取渠道在某个模型上的统计快照，过期的统计样本数为 0
+func Get(channelId int, model string) Stats {
+	value, ok := entries.Load(statsKey{channelId: channelId, model: model})
+	if !ok {
+		return Stats{}
+	}
+	e := value.(*entry)
+	e.mu.Lock()
+	stats := e.stats
+	e.mu.Unlock()
+	if isExpired(stats, time.Now()) {
+		stats = Stats{}
+	}
+	stats.InFlight = e.inFlight.Load()
+	return stats
+}
+
+// Reset 清空所有统计，主要用于测试
+func Reset() {
+	entries.Range(func(key, _ any) bool {
+		entries.Delete(key)
+		return true
+	})
+}
+
+func ewma(current float64, value float64, alpha float64, first bool) float64 {
+	if first {
+		return value
+	}
+	return alpha*value + (1-alpha)*current
+}
+
+func isExpired(stats Stats, now time.Time) bool {
+	ttl := operation_setting.GetChannelSelectionSetting().StatsTTLSeconds
+	if ttl <= 0 || stats.UpdatedAt.IsZero() {
+		return false
+	}
+	return now.Sub(stats.UpdatedAt) > time.Duration(ttl)*time.Second
+}
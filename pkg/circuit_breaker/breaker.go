@@ -0,0 +1,294 @@
+package circuitbreaker
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/pkg/cachex"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/samber/hot"
+)
+
+const (
+	StateClosed   = "closed"
+	StateOpen     = "open"
+	StateHalfOpen = "half_open"
+)
+
+const cacheNamespace = "new-api:circuit_breaker:v1"
+
+// State 熔断器状态，存储在 HybridCache 中以便多节点共享。
+// 多节点并发更新为尽力而为，同一节点内通过按 key 加锁保证一致。
+type State struct {
+	State          string  `json:"state"`
+	Failures       []int64 `json:"failures,omitempty"` // 统计窗口内的失败时间（秒）
+	OpenUntil      int64   `json:"open_until,omitempty"`
+	Trips          int     `json:"trips,omitempty"` // 连续熔断次数，用于计算冷却时间退避
+	HalfOpenAt     int64   `json:"half_open_at,omitempty"`
+	Probes         int     `json:"probes,omitempty"`
+	ProbeSuccesses int     `json:"probe_successes,omitempty"`
+}
+
+var (
+	stateCache     *cachex.HybridCache[State]
+	stateCacheOnce sync.Once
+	keyLocks       sync.Map
+)
+
+func getStateCache() *cachex.HybridCache[State] {
+	stateCacheOnce.Do(func() {
+		stateCache = cachex.NewHybridCache[State](cachex.HybridCacheConfig[State]{
+			Namespace: cachex.Namespace(cacheNamespace),
+			Redis:     common.RDB,
+			RedisEnabled: func() bool {
+				return common.RedisEnabled && common.RDB != nil
+			},
+			RedisCodec: cachex.JSONCodec[State]{},
+			Memory: func() *hot.HotCache[string, State] {
+				return hot.NewHotCache[string, State](hot.LRU, 100_000).
+					WithTTL(time.Hour).
+					WithJanitor().
+					Build()
+			},
+		})
+	})
+	return stateCache
+}
+
+// ChannelKey 渠道级熔断 key
+func ChannelKey(channelId int) string {
+	return fmt.Sprintf("channel:%d", channelId)
+}
+
+// ChannelKeyIndexKey 多密钥渠道中单个密钥的熔断 key
+func ChannelKeyIndexKey(channelId int, index int) string {
+	return fmt.Sprintf("channel:%d:key:%d", channelId, index)
+}
+
+func Enabled() bool {
+	return operation_setting.GetCircuitBreakerSetting().Enabled
+}
+
+func lockKey(key string) *sync.Mutex {
+	lock, _ := keyLocks.LoadOrStore(key, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+func load(key string) State {
+	state, found, err := getStateCache().Get(key)
+	if err != nil || !found || state.State == "" {
+		return State{State: StateClosed}
+	}
+	return state
+}
+
+func save(key string, state State) {
+	setting := operation_setting.GetCircuitBreakerSetting()
+	ttl := time.Duration(setting.FailureWindowSeconds+maxOpenSeconds(setting)) * time.Second * 2
+	if err := getStateCache().SetWithTTL(key, state, ttl); err != nil {
+		common.SysError(fmt.Sprintf("circuit breaker: failed to save state of %s: %s", key, err.Error()))
+	}
+}
+
+func maxOpenSeconds(setting *operation_setting.CircuitBreakerSetting) int {
+	if setting.MaxOpenSeconds < setting.OpenSeconds {
+		return setting.OpenSeconds
+	}
+	return setting.MaxOpenSeconds
+}
+
+// openDuration 冷却时间随连续熔断次数翻倍
+func openDuration(setting *operation_setting.CircuitBreakerSetting, trips int) int64 {
+	seconds := int64(setting.OpenSeconds)
+	if seconds <= 0 {
+		seconds = 30
+	}
+	limit := int64(maxOpenSeconds(setting))
+	for i := 1; i < trips && seconds < limit; i++ {
+		seconds *= 2
+	}
+	if seconds > limit {
+		seconds = limit
+	}
+	return seconds
+}
+
+// probesExpired 半开探测请求超过冷却时间仍未返回结果时视为丢失，避免一直停留在半开状态
+func probesExpired(setting *operation_setting.CircuitBreakerSetting, state State, now int64) bool {
+	return state.HalfOpenAt > 0 && now-state.HalfOpenAt > openDuration(setting, state.Trips)
+}
+
+func allow(setting *operation_setting.CircuitBreakerSetting, state State, now int64) bool {
+	switch state.State {
+	case StateOpen:
+		return now >= state.OpenUntil
+	case StateHalfOpen:
+		return state.Probes < max(setting.HalfOpenMaxRequests, 1) || probesExpired(setting, state, now)
+	default:
+		return true
+	}
+}
+
+// Allow 判断 key 当前是否可以接收请求，不修改状态
+func Allow(key string) bool {
+	if !Enabled() {
+		return true
+	}
+	return allow(operation_setting.GetCircuitBreakerSetting(), load(key), time.Now().Unix())
+}
+
+// AllowMany 批量判断 keys 当前是否可以接收请求，不修改状态，Redis 下只需一次往返
+func AllowMany(keys []string) []bool {
+	allowed := make([]bool, len(keys))
+	if !Enabled() {
+		for i := range allowed {
+			allowed[i] = true
+		}
+		return allowed
+	}
+	states, err := getStateCache().GetMany(keys)
+	if err != nil {
+		common.SysError(fmt.Sprintf("circuit breaker: failed to load states: %s", err.Error()))
+	}
+	setting := operation_setting.GetCircuitBreakerSetting()
+	now := time.Now().Unix()
+	for i, key := range keys {
+		state, ok := states[key]
+		if !ok || state.State == "" {
+			state = State{State: StateClosed}
+		}
+		allowed[i] = allow(setting, state, now)
+	}
+	return allowed
+}
+
+// Get 获取 key 的熔断状态
+func Get(key string) State {
+	return load(key)
+}
+
+// Acquire 请求开始时调用，冷却结束的熔断器进入半开状态。
+// probe 表示本次请求是否占用了探测名额，allowed 为 false 表示半开状态的探测名额已满，调用方应放弃该 key。
+// 冷却中的熔断器只会在全部熔断的兜底场景下被选中，此时仍放行
+func Acquire(key string) (probe bool, allowed bool) {
+	if !Enabled() {
+		return false, true
+	}
+	lock := lockKey(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	setting := operation_setting.GetCircuitBreakerSetting()
+	now := time.Now().Unix()
+	state := load(key)
+	switch state.State {
+	case StateOpen:
+		if now < state.OpenUntil {
+			return false, true
+		}
+		state.State = StateHalfOpen
+		state.HalfOpenAt = now
+		state.Probes = 1
+		state.ProbeSuccesses = 0
+		common.SysLog(fmt.Sprintf("circuit breaker %s: open -> half_open", key))
+	case StateHalfOpen:
+		if probesExpired(setting, state, now) {
+			state.HalfOpenAt = now
+			state.Probes = 0
+		}
+		if state.Probes >= max(setting.HalfOpenMaxRequests, 1) {
+			return false, false
+		}
+		state.Probes++
+	default:
+		return false, true
+	}
+	save(key, state)
+	return true, true
+}
+
+// Release 请求因与渠道无关的原因结束时释放探测名额，不影响熔断统计
+func Release(key string, probe bool) {
+	if !Enabled() || !probe {
+		return
+	}
+	lock := lockKey(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	state := load(key)
+	if state.State != StateHalfOpen || state.Probes == 0 {
+		return
+	}
+	state.Probes--
+	save(key, state)
+}
+
+// Record 记录请求结果并推进状态机
+func Record(key string, probe bool, success bool) {
+	if !Enabled() {
+		return
+	}
+	lock := lockKey(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	setting := operation_setting.GetCircuitBreakerSetting()
+	now := time.Now().Unix()
+	state := load(key)
+	if probe && state.State == StateHalfOpen && state.Probes > 0 {
+		state.Probes--
+	}
+
+	switch state.State {
+	case StateHalfOpen:
+		if !success {
+			trip(setting, key, &state, now)
+			break
+		}
+		state.ProbeSuccesses++
+		if state.ProbeSuccesses >= max(setting.HalfOpenSuccessThreshold, 1) {
+			state = State{State: StateClosed}
+			common.SysLog(fmt.Sprintf("circuit breaker %s: half_open -> closed", key))
+		}
+	case StateOpen:
+		// 熔断前发出的请求晚到的结果不再计入
+		return
+	default:
+		if success {
+			return
+		}
+		windowStart := now - int64(setting.FailureWindowSeconds)
+		failures := make([]int64, 0, len(state.Failures)+1)
+		for _, ts := range state.Failures {
+			if ts > windowStart {
+				failures = append(failures, ts)
+			}
+		}
+		state.Failures = append(failures, now)
+		if len(state.Failures) >= max(setting.FailureThreshold, 1) {
+			trip(setting, key, &state, now)
+		}
+	}
+	save(key, state)
+}
+
+func trip(setting *operation_setting.CircuitBreakerSetting, key string, state *State, now int64) {
+	previous := state.State
+	state.Trips++
+	state.State = StateOpen
+	state.OpenUntil = now + openDuration(setting, state.Trips)
+	state.Failures = nil
+	state.HalfOpenAt = 0
+	state.Probes = 0
+	state.ProbeSuccesses = 0
+	common.SysLog(fmt.Sprintf("circuit breaker %s: %s -> open until %d (trips: %d)", key, previous, state.OpenUntil, state.Trips))
+}
+
+// Reset 清除 key 的熔断状态
+func Reset(key string) {
+	_, _ = getStateCache().DeleteMany([]string{key})
+}
@@ -0,0 +1,117 @@
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/stretchr/testify/require"
+)
+
+func enableCircuitBreaker(t *testing.T) {
+	t.Helper()
+	setting := operation_setting.GetCircuitBreakerSetting()
+	previous := *setting
+	setting.Enabled = true
+	setting.FailureThreshold = 3
+	setting.FailureWindowSeconds = 60
+	setting.OpenSeconds = 30
+	setting.MaxOpenSeconds = 100
+	setting.HalfOpenMaxRequests = 1
+	setting.HalfOpenSuccessThreshold = 2
+	t.Cleanup(func() {
+		*setting = previous
+	})
+}
+
+// expireOpen 模拟冷却时间已过
+func expireOpen(key string) {
+	state := load(key)
+	state.OpenUntil = time.Now().Unix() - 1
+	save(key, state)
+}
+
+func TestCircuitBreakerStateMachine(t *testing.T) {
+	enableCircuitBreaker(t)
+	key := ChannelKey(1)
+	Reset(key)
+
+	for i := 0; i < 2; i++ {
+		probe, allowed := Acquire(key)
+		require.True(t, allowed)
+		Record(key, probe, false)
+	}
+	require.True(t, Allow(key))
+	probe, _ := Acquire(key)
+	Record(key, probe, false)
+	require.Equal(t, StateOpen, Get(key).State)
+	require.False(t, Allow(key))
+
+	// 冷却结束后只放行有限的探测请求
+	expireOpen(key)
+	require.True(t, Allow(key))
+	probe, allowed := Acquire(key)
+	require.True(t, probe)
+	require.True(t, allowed)
+	require.Equal(t, StateHalfOpen, Get(key).State)
+	require.False(t, Allow(key))
+	// 探测名额已满时拒绝其他请求
+	probe2, allowed := Acquire(key)
+	require.False(t, probe2)
+	require.False(t, allowed)
+
+	// 探测失败重新熔断，冷却时间翻倍
+	Record(key, probe, false)
+	state := Get(key)
+	require.Equal(t, StateOpen, state.State)
+	require.Equal(t, 2, state.Trips)
+	require.InDelta(t, time.Now().Unix()+60, state.OpenUntil, 1)
+
+	// 连续探测成功后恢复
+	expireOpen(key)
+	probe, _ = Acquire(key)
+	Record(key, probe, true)
+	require.Equal(t, StateHalfOpen, Get(key).State)
+	probe, _ = Acquire(key)
+	require.True(t, probe)
+	Record(key, probe, true)
+	require.Equal(t, StateClosed, Get(key).State)
+	require.Equal(t, 0, Get(key).Trips)
+}
+
+func TestCircuitBreakerReleaseAndDisabled(t *testing.T) {
+	enableCircuitBreaker(t)
+	key := ChannelKeyIndexKey(2, 1)
+	Reset(key)
+	for i := 0; i < 3; i++ {
+		Record(key, false, false)
+	}
+	expireOpen(key)
+
+	// 与渠道无关的失败只释放探测名额
+	probe, _ := Acquire(key)
+	Release(key, probe)
+	state := Get(key)
+	require.Equal(t, StateHalfOpen, state.State)
+	require.Equal(t, 0, state.Probes)
+	require.True(t, Allow(key))
+
+	operation_setting.GetCircuitBreakerSetting().Enabled = false
+	Record(key, true, false)
+	require.True(t, Allow(key))
+	require.Equal(t, StateHalfOpen, Get(key).State)
+}
+
+func TestCircuitBreakerAllowMany(t *testing.T) {
+	enableCircuitBreaker(t)
+	open, closed := ChannelKey(3), ChannelKey(4)
+	Reset(open)
+	Reset(closed)
+	for i := 0; i < 3; i++ {
+		Record(open, false, false)
+	}
+	require.Equal(t, []bool{false, true, false}, AllowMany([]string{open, closed, open}))
+
+	operation_setting.GetCircuitBreakerSetting().Enabled = false
+	require.Equal(t, []bool{true, true}, AllowMany([]string{open, closed}))
+}
@@ -0,0 +1,250 @@
+package keyusage
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultRateLimitCooldown 上游返回 429 但未给出重置时间时的冷却时间
+const defaultRateLimitCooldown = 30 * time.Second
+
+// Usage 多密钥渠道中单个密钥的使用情况，仅统计当前节点
+type Usage struct {
+	Requests int64 `json:"requests"`
+	Tokens   int64 `json:"tokens"`
+	// MinuteRequests / MinuteTokens 当前分钟内的请求数与 token 数，用于按声明的 RPM/TPM 选择密钥
+	MinuteRequests int64 `json:"minute_requests"`
+	MinuteTokens   int64 `json:"minute_tokens"`
+	LastUsedAt     int64 `json:"last_used_at,omitempty"`
+	// RateLimited 上游返回 429 的次数
+	RateLimited int64 `json:"rate_limited"`
+	// RemainingRequests / RemainingTokens 上游最近一次返回的剩余额度，未返回时为 nil
+	RemainingRequests *int64 `json:"remaining_requests,omitempty"`
+	RemainingTokens   *int64 `json:"remaining_tokens,omitempty"`
+	CooldownUntil     int64  `json:"cooldown_until,omitempty"`
+
+	lastUsed      time.Time
+	cooldownUntil time.Time
+}
+
+// LastUsed 最近一次被选中的时间
+func (u Usage) LastUsed() time.Time {
+	return u.lastUsed
+}
+
+// CoolingDown 是否因上游限流处于冷却中
+func (u Usage) CoolingDown(now time.Time) bool {
+	return now.Before(u.cooldownUntil)
+}
+
+// CooldownEnd 冷却结束时间
+func (u Usage) CooldownEnd() time.Time {
+	return u.cooldownUntil
+}
+
+type usageKey struct {
+	channelId int
+	index     int
+}
+
+type entry struct {
+	mu          sync.Mutex
+	usage       Usage
+	minuteStart int64
+}
+
+var entries sync.Map
+
+func getEntry(channelId int, index int) *entry {
+	key := usageKey{channelId: channelId, index: index}
+	if value, ok := entries.Load(key); ok {
+		return value.(*entry)
+	}
+	actual, _ := entries.LoadOrStore(key, &entry{})
+	return actual.(*entry)
+}
+
+// rollMinute 进入新的一分钟时清空分钟计数，调用方需持有锁
+func (e *entry) rollMinute(now time.Time) {
+	minute := now.Unix() / 60
+	if e.minuteStart != minute {
+		e.minuteStart = minute
+		e.usage.MinuteRequests = 0
+		e.usage.MinuteTokens = 0
+	}
+}
+
+// MarkUsed 密钥被选中时调用
+func MarkUsed(channelId int, index int) {
+	now := time.Now()
+	e := getEntry(channelId, index)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rollMinute(now)
+	e.usage.Requests++
+	e.usage.MinuteRequests++
+	e.usage.lastUsed = now
+}
+
+// AddTokens 记录请求消耗的 token 数
+func AddTokens(channelId int, index int, tokens int) {
+	if tokens <= 0 {
+		return
+	}
+	e := getEntry(channelId, index)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rollMinute(time.Now())
+	e.usage.Tokens += int64(tokens)
+	e.usage.MinuteTokens += int64(tokens)
+}
+
+// ObserveResponse 根据上游响应的限流头更新剩余额度，额度耗尽或返回 429 时冷却到重置时间
+func ObserveResponse(channelId int, index int, statusCode int, header http.Header) {
+	now := time.Now()
+	limit := ParseRateLimit(header, now)
+	if statusCode != http.StatusTooManyRequests && limit.Empty() {
+		return
+	}
+
+	e := getEntry(channelId, index)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if limit.RemainingRequests != nil {
+		e.usage.RemainingRequests = limit.RemainingRequests
+	}
+	if limit.RemainingTokens != nil {
+		e.usage.RemainingTokens = limit.RemainingTokens
+	}
+
+	var until time.Time
+	if limit.RemainingRequests != nil && *limit.RemainingRequests <= 0 {
+		until = later(until, limit.ResetRequests)
+	}
+	if limit.RemainingTokens != nil && *limit.RemainingTokens <= 0 {
+		until = later(until, limit.ResetTokens)
+	}
+	if statusCode == http.StatusTooManyRequests {
+		e.usage.RateLimited++
+		until = later(until, limit.RetryAfter)
+		if !until.After(now) {
+			until = now.Add(defaultRateLimitCooldown)
+		}
+	}
+	if until.After(e.usage.cooldownUntil) {
+		e.usage.cooldownUntil = until
+		e.usage.CooldownUntil = until.Unix()
+	}
+}
+
+// Get 获取密钥的使用情况快照
+func Get(channelId int, index int) Usage {
+	value, ok := entries.Load(usageKey{channelId: channelId, index: index})
+	if !ok {
+		return Usage{}
+	}
+	e := value.(*entry)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rollMinute(time.Now())
+	usage := e.usage
+	if !usage.lastUsed.IsZero() {
+		usage.LastUsedAt = usage.lastUsed.Unix()
+	}
+	if !usage.CoolingDown(time.Now()) {
+		usage.CooldownUntil = 0
+	}
+	return usage
+}
+
+// ResetChannel 清空渠道所有密钥的使用情况，密钥被删除导致索引变化时调用
+func ResetChannel(channelId int) {
+	entries.Range(func(key, _ any) bool {
+		if key.(usageKey).channelId == channelId {
+			entries.Delete(key)
+		}
+		return true
+	})
+}
+
+// Reset 清空所有使用情况，主要用于测试
+func Reset() {
+	entries.Range(func(key, _ any) bool {
+		entries.Delete(key)
+		return true
+	})
+}
+
+// RateLimit 上游响应中的限流信息
+type RateLimit struct {
+	RemainingRequests *int64
+	RemainingTokens   *int64
+	ResetRequests     time.Time
+	ResetTokens       time.Time
+	RetryAfter        time.Time
+}
+
+func (l RateLimit) Empty() bool {
+	return l.RemainingRequests == nil && l.RemainingTokens == nil && l.RetryAfter.IsZero()
+}
+
+// ParseRateLimit 解析 x-ratelimit-remaining-* / x-ratelimit-reset-* 与 retry-after 响应头，
+// 同时兼容 anthropic-ratelimit-* 格式
+func ParseRateLimit(header http.Header, now time.Time) RateLimit {
+	var limit RateLimit
+	if header == nil {
+		return limit
+	}
+	limit.RemainingRequests = parseRemaining(header, "x-ratelimit-remaining-requests", "anthropic-ratelimit-requests-remaining")
+	limit.RemainingTokens = parseRemaining(header, "x-ratelimit-remaining-tokens", "anthropic-ratelimit-tokens-remaining")
+	limit.ResetRequests = parseReset(header, now, "x-ratelimit-reset-requests", "anthropic-ratelimit-requests-reset")
+	limit.ResetTokens = parseReset(header, now, "x-ratelimit-reset-tokens", "anthropic-ratelimit-tokens-reset")
+	limit.RetryAfter = parseReset(header, now, "retry-after")
+	return limit
+}
+
+func parseRemaining(header http.Header, names ...string) *int64 {
+	for _, name := range names {
+		value := strings.TrimSpace(header.Get(name))
+		if value == "" {
+			continue
+		}
+		if remaining, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return &remaining
+		}
+	}
+	return nil
+}
+
+// parseReset 支持秒数（retry-after: 20）、Go duration（6m0s、20ms）、RFC3339 与 HTTP 日期
+func parseReset(header http.Header, now time.Time, names ...string) time.Time {
+	for _, name := range names {
+		value := strings.TrimSpace(header.Get(name))
+		if value == "" {
+			continue
+		}
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+			return now.Add(time.Duration(seconds * float64(time.Second)))
+		}
+		if duration, err := time.ParseDuration(value); err == nil {
+			return now.Add(duration)
+		}
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			return t
+		}
+		if t, err := http.ParseTime(value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func later(a time.Time, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
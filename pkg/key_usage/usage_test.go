@@ -0,0 +1,90 @@
+package keyusage
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	header := http.Header{}
+	header.Set("x-ratelimit-remaining-requests", "0")
+	header.Set("x-ratelimit-remaining-tokens", "1500")
+	header.Set("x-ratelimit-reset-requests", "6m0s")
+	header.Set("x-ratelimit-reset-tokens", "20ms")
+
+	limit := ParseRateLimit(header, now)
+	require.Equal(t, int64(0), *limit.RemainingRequests)
+	require.Equal(t, int64(1500), *limit.RemainingTokens)
+	require.Equal(t, now.Add(6*time.Minute), limit.ResetRequests)
+	require.Equal(t, now.Add(20*time.Millisecond), limit.ResetTokens)
+	require.True(t, limit.RetryAfter.IsZero())
+
+	header = http.Header{}
+	header.Set("anthropic-ratelimit-tokens-remaining", "10")
+	header.Set("anthropic-ratelimit-tokens-reset", "2023-11-14T22:14:00Z")
+	header.Set("retry-after", "20")
+	limit = ParseRateLimit(header, now)
+	require.Nil(t, limit.RemainingRequests)
+	require.Equal(t, int64(10), *limit.RemainingTokens)
+	require.Equal(t, time.Date(2023, 11, 14, 22, 14, 0, 0, time.UTC), limit.ResetTokens.UTC())
+	require.Equal(t, now.Add(20*time.Second), limit.RetryAfter)
+
+	require.True(t, ParseRateLimit(http.Header{}, now).Empty())
+}
+
+func TestObserveResponseCooldown(t *testing.T) {
+	Reset()
+	t.Cleanup(Reset)
+
+	header := http.Header{}
+	header.Set("retry-after", "60")
+	ObserveResponse(1, 0, http.StatusTooManyRequests, header)
+	usage := Get(1, 0)
+	require.Equal(t, int64(1), usage.RateLimited)
+	require.True(t, usage.CoolingDown(time.Now()))
+	require.InDelta(t, time.Now().Add(time.Minute).Unix(), usage.CooldownUntil, 1)
+
+	// 429 未给出重置时间时使用默认冷却
+	ObserveResponse(1, 1, http.StatusTooManyRequests, nil)
+	require.True(t, Get(1, 1).CoolingDown(time.Now().Add(defaultRateLimitCooldown-time.Second)))
+
+	// 剩余额度耗尽时冷却到重置时间
+	header = http.Header{}
+	header.Set("x-ratelimit-remaining-requests", "0")
+	header.Set("x-ratelimit-reset-requests", "10s")
+	ObserveResponse(1, 2, http.StatusOK, header)
+	usage = Get(1, 2)
+	require.Equal(t, int64(0), usage.RateLimited)
+	require.Equal(t, int64(0), *usage.RemainingRequests)
+	require.True(t, usage.CoolingDown(time.Now()))
+
+	// 仍有剩余额度时不冷却
+	header.Set("x-ratelimit-remaining-requests", "5")
+	ObserveResponse(1, 3, http.StatusOK, header)
+	require.False(t, Get(1, 3).CoolingDown(time.Now()))
+
+	ResetChannel(1)
+	require.Equal(t, Usage{}, Get(1, 0))
+}
+
+func TestUsageCounters(t *testing.T) {
+	Reset()
+	t.Cleanup(Reset)
+
+	MarkUsed(2, 0)
+	MarkUsed(2, 0)
+	AddTokens(2, 0, 300)
+	AddTokens(2, 0, -1)
+
+	usage := Get(2, 0)
+	require.Equal(t, int64(2), usage.Requests)
+	require.Equal(t, int64(2), usage.MinuteRequests)
+	require.Equal(t, int64(300), usage.Tokens)
+	require.Equal(t, int64(300), usage.MinuteTokens)
+	require.NotZero(t, usage.LastUsedAt)
+	require.Equal(t, Usage{}, Get(2, 1))
+}
@@ -141,8 +141,15 @@ func (a *Adaptor) ConvertEmbeddingRequest(c *gin.Context, info *relaycommon.Rela
 }
 
 func (a *Adaptor) ConvertOpenAIResponsesRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.OpenAIResponsesRequest) (any, error) {
-	// TODO implement me
-	return nil, errors.New("not implemented")
+	if isNovaModel(request.Model) {
+		return nil, errors.New("responses api is not supported for nova models")
+	}
+	claudeReq, err := claude.RequestOpenAIResponses2ClaudeMessage(c, request)
+	if err != nil {
+		return nil, err
+	}
+	info.UpstreamModelName = claudeReq.Model
+	return claudeReq, nil
 }
 
 func (a *Adaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody io.Reader) (any, error) {
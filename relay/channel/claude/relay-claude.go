@@ -588,6 +588,8 @@ type ClaudeResponseInfo struct {
 	ResponseText strings.Builder
 	Usage        *dto.Usage
 	Done         bool
+	// responsesStream 客户端使用 Responses 格式时的流式转换状态
+	responsesStream *responsesStreamState
 }
 
 func cacheCreationTokensForOpenAIUsage(usage *dto.Usage) int {
@@ -826,6 +828,12 @@ func HandleStreamResponseData(c *gin.Context, info *relaycommon.RelayInfo, claud
 		if err != nil {
 			logger.LogError(c, "send_stream_response_failed: "+err.Error())
 		}
+	} else if info.RelayFormat == types.RelayFormatOpenAIResponses {
+		FormatClaudeResponseInfo(&claudeResponse, nil, claudeInfo)
+		if claudeInfo.responsesStream == nil {
+			claudeInfo.responsesStream = &responsesStreamState{}
+		}
+		claudeInfo.responsesStream.handle(c, claudeInfo, &claudeResponse)
 	}
 	return nil
 }
@@ -865,6 +873,11 @@ func HandleStreamFinalResponse(c *gin.Context, info *relaycommon.RelayInfo, clau
 			}
 		}
 		helper.Done(c)
+	} else if info.RelayFormat == types.RelayFormatOpenAIResponses {
+		if claudeInfo.responsesStream == nil {
+			claudeInfo.responsesStream = &responsesStreamState{}
+		}
+		claudeInfo.responsesStream.finish(c, claudeInfo)
 	}
 }
 
@@ -923,6 +936,12 @@ func HandleClaudeResponseData(c *gin.Context, info *relaycommon.RelayInfo, claud
 		if err != nil {
 			return types.NewError(err, types.ErrorCodeBadResponseBody)
 		}
+	case types.RelayFormatOpenAIResponses:
+		responsesResponse := ResponseClaude2OpenAIResponses(&claudeResponse, claudeInfo.Usage, claudeInfo.Created)
+		responseData, err = common.Marshal(responsesResponse)
+		if err != nil {
+			return types.NewError(err, types.ErrorCodeBadResponseBody)
+		}
 	case types.RelayFormatClaude:
 		responseData = data
 	}
@@ -0,0 +1,372 @@
+package claude
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// Claude 返回的 redacted_thinking 以该前缀保存在 reasoning 条目的 encrypted_content 中，回传时还原
+const responsesRedactedThinkingPrefix = "redacted_thinking:"
+
+// Responses reasoning.effort 对应的 thinking budget，与 Chat Completions 的 reasoning_effort 保持一致
+var responsesReasoningBudgets = map[string]int{
+	"low":    1280,
+	"medium": 2048,
+	"high":   4096,
+	"xhigh":  4096,
+}
+
+// responsesInputItem Responses input 数组中的条目
+type responsesInputItem struct {
+	Type             string                              `json:"type"`
+	Role             string                              `json:"role"`
+	Content          json.RawMessage                     `json:"content"`
+	CallId           string                              `json:"call_id"`
+	Name             string                              `json:"name"`
+	Arguments        string                              `json:"arguments"`
+	Output           json.RawMessage                     `json:"output"`
+	Summary          []dto.ResponsesReasoningSummaryPart `json:"summary"`
+	EncryptedContent string                              `json:"encrypted_content"`
+}
+
+// responsesContentPart 消息内容与工具输出中的内容片段
+type responsesContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	ImageUrl string `json:"image_url"`
+	FileData string `json:"file_data"`
+	FileUrl  string `json:"file_url"`
+	FileId   string `json:"file_id"`
+}
+
+func newResponsesConvertError(format string, args ...any) error {
+	return types.NewErrorWithStatusCode(fmt.Errorf(format, args...), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+}
+
+// RequestOpenAIResponses2ClaudeMessage 将 Responses 请求转换为 Claude Messages 请求。
+// Claude 没有服务端会话状态，previous_response_id 与 conversation 需要客户端改为携带完整上下文
+func RequestOpenAIResponses2ClaudeMessage(c *gin.Context, request dto.OpenAIResponsesRequest) (*dto.ClaudeRequest, error) {
+	if request.PreviousResponseID != "" {
+		return nil, newResponsesConvertError("previous_response_id is not supported for Claude models, send the full conversation in input instead")
+	}
+	if len(request.Conversation) > 0 && common.GetJsonType(request.Conversation) != "null" {
+		return nil, newResponsesConvertError("conversation is not supported for Claude models, send the full conversation in input instead")
+	}
+
+	claudeRequest := &dto.ClaudeRequest{
+		Model:       request.Model,
+		Temperature: request.Temperature,
+		TopP:        request.TopP,
+	}
+	if request.MaxOutputTokens != nil && *request.MaxOutputTokens > 0 {
+		claudeRequest.MaxTokens = common.GetPointer(*request.MaxOutputTokens)
+	} else {
+		claudeRequest.MaxTokens = common.GetPointer(uint(model_setting.GetClaudeSettings().GetDefaultMaxTokens(request.Model)))
+	}
+	if request.Stream != nil && *request.Stream {
+		claudeRequest.Stream = common.GetPointer(true)
+	}
+	if user := gjson.GetBytes(request.User, "@this"); user.Type == gjson.String && user.String() != "" {
+		metadata, _ := common.Marshal(dto.ClaudeMetadata{UserId: user.String()})
+		claudeRequest.Metadata = metadata
+	}
+
+	var system []dto.ClaudeMediaMessage
+	if instructions := gjson.ParseBytes(request.Instructions); instructions.Type == gjson.String && instructions.String() != "" {
+		system = append(system, dto.ClaudeMediaMessage{Type: "text", Text: common.GetPointer(instructions.String())})
+	}
+
+	messages, inputSystem, err := convertResponsesInput(c, request.Input)
+	if err != nil {
+		return nil, err
+	}
+	system = append(system, inputSystem...)
+	if len(system) > 0 {
+		claudeRequest.System = system
+	}
+	claudeRequest.Messages = messages
+
+	tools, err := convertResponsesTools(request.GetToolsMap())
+	if err != nil {
+		return nil, err
+	}
+	if len(tools) > 0 {
+		claudeRequest.Tools = tools
+	}
+	if toolChoice := convertResponsesToolChoice(request.ToolChoice, request.ParallelToolCalls); toolChoice != nil {
+		claudeRequest.ToolChoice = toolChoice
+	}
+
+	applyResponsesReasoning(claudeRequest, request.Reasoning)
+
+	if format := gjson.GetBytes(request.Text, "format"); format.Get("type").String() == "json_schema" {
+		outputFormat, _ := common.Marshal(map[string]any{
+			"type":   "json_schema",
+			"schema": json.RawMessage(format.Get("schema").Raw),
+		})
+		claudeRequest.OutputFormat = outputFormat
+	}
+	return claudeRequest, nil
+}
+
+// convertResponsesInput 转换 input，system / developer 消息放入 system，相邻同角色的条目合并为一条消息
+func convertResponsesInput(c *gin.Context, input json.RawMessage) ([]dto.ClaudeMessage, []dto.ClaudeMediaMessage, error) {
+	var messages []dto.ClaudeMessage
+	var system []dto.ClaudeMediaMessage
+	appendBlocks := func(role string, blocks ...dto.ClaudeMediaMessage) {
+		if len(blocks) == 0 {
+			return
+		}
+		if n := len(messages); n > 0 && messages[n-1].Role == role {
+			messages[n-1].Content = append(messages[n-1].Content.([]dto.ClaudeMediaMessage), blocks...)
+			return
+		}
+		messages = append(messages, dto.ClaudeMessage{Role: role, Content: blocks})
+	}
+
+	switch common.GetJsonType(input) {
+	case "string":
+		var text string
+		if err := common.Unmarshal(input, &text); err != nil {
+			return nil, nil, err
+		}
+		appendBlocks("user", dto.ClaudeMediaMessage{Type: "text", Text: common.GetPointer(text)})
+	case "array":
+		var items []responsesInputItem
+		if err := common.Unmarshal(input, &items); err != nil {
+			return nil, nil, newResponsesConvertError("invalid input: %s", err.Error())
+		}
+		for _, item := range items {
+			switch item.Type {
+			case "", "message":
+				blocks, err := convertResponsesContent(c, item.Content)
+				if err != nil {
+					return nil, nil, err
+				}
+				switch item.Role {
+				case "system", "developer":
+					for _, block := range blocks {
+						if block.Type == "text" {
+							system = append(system, block)
+						}
+					}
+				case "assistant":
+					appendBlocks("assistant", blocks...)
+				default:
+					appendBlocks("user", blocks...)
+				}
+			case "function_call":
+				var arguments any = map[string]any{}
+				if strings.TrimSpace(item.Arguments) != "" {
+					if err := common.UnmarshalJsonStr(item.Arguments, &arguments); err != nil {
+						return nil, nil, newResponsesConvertError("invalid arguments of function call %s: %s", item.CallId, err.Error())
+					}
+				}
+				appendBlocks("assistant", dto.ClaudeMediaMessage{Type: "tool_use", Id: item.CallId, Name: item.Name, Input: arguments})
+			case "function_call_output":
+				result, err := convertResponsesToolOutput(c, item.Output)
+				if err != nil {
+					return nil, nil, err
+				}
+				appendBlocks("user", dto.ClaudeMediaMessage{Type: "tool_result", ToolUseId: item.CallId, Content: result})
+			case "reasoning":
+				// 没有签名的 thinking 无法回传给 Claude，直接丢弃
+				if data, ok := strings.CutPrefix(item.EncryptedContent, responsesRedactedThinkingPrefix); ok {
+					appendBlocks("assistant", dto.ClaudeMediaMessage{Type: "redacted_thinking", Data: data})
+				} else if item.EncryptedContent != "" {
+					var thinking []string
+					for _, part := range item.Summary {
+						thinking = append(thinking, part.Text)
+					}
+					appendBlocks("assistant", dto.ClaudeMediaMessage{
+						Type:      "thinking",
+						Thinking:  common.GetPointer(strings.Join(thinking, "\n\n")),
+						Signature: item.EncryptedContent,
+					})
+				}
+			default:
+				return nil, nil, newResponsesConvertError("input item type %q is not supported for Claude models", item.Type)
+			}
+		}
+	}
+
+	if len(messages) > 0 && messages[0].Role != "user" {
+		messages = append([]dto.ClaudeMessage{{Role: "user", Content: []dto.ClaudeMediaMessage{{Type: "text", Text: common.GetPointer("...")}}}}, messages...)
+	}
+	return messages, system, nil
+}
+
+// convertResponsesContent 转换消息内容，空文本会被 Claude 拒绝，因此跳过
+func convertResponsesContent(c *gin.Context, content json.RawMessage) ([]dto.ClaudeMediaMessage, error) {
+	if common.GetJsonType(content) == "string" {
+		var text string
+		if err := common.Unmarshal(content, &text); err != nil {
+			return nil, err
+		}
+		if text == "" {
+			return nil, nil
+		}
+		return []dto.ClaudeMediaMessage{{Type: "text", Text: common.GetPointer(text)}}, nil
+	}
+	var parts []responsesContentPart
+	if err := common.Unmarshal(content, &parts); err != nil {
+		return nil, newResponsesConvertError("invalid message content: %s", err.Error())
+	}
+	blocks := make([]dto.ClaudeMediaMessage, 0, len(parts))
+	for _, part := range parts {
+		switch part.Type {
+		case "input_text", "output_text", "refusal":
+			if part.Text != "" {
+				blocks = append(blocks, dto.ClaudeMediaMessage{Type: "text", Text: common.GetPointer(part.Text)})
+			}
+		case "input_image", "input_file":
+			data := part.ImageUrl
+			if part.Type == "input_file" {
+				data = part.FileData
+				if data == "" {
+					data = part.FileUrl
+				}
+			}
+			if data == "" {
+				return nil, newResponsesConvertError("%s without inline data or url is not supported for Claude models", part.Type)
+			}
+			base64Data, mimeType, err := service.GetBase64Data(c, types.NewFileSourceFromData(data, ""), "formatting file for Claude")
+			if err != nil {
+				return nil, fmt.Errorf("get file data failed: %s", err.Error())
+			}
+			var blockType string
+			switch {
+			case strings.HasPrefix(mimeType, "image/"):
+				blockType = "image"
+			case strings.HasPrefix(mimeType, "application/pdf"):
+				blockType = "document"
+			default:
+				return nil, newResponsesConvertError("file type %q is not supported for Claude models", mimeType)
+			}
+			blocks = append(blocks, dto.ClaudeMediaMessage{
+				Type:   blockType,
+				Source: &dto.ClaudeMessageSource{Type: "base64", MediaType: mimeType, Data: base64Data},
+			})
+		default:
+			return nil, newResponsesConvertError("content type %q is not supported for Claude models", part.Type)
+		}
+	}
+	return blocks, nil
+}
+
+// convertResponsesToolOutput 转换 function_call_output 的 output，字符串原样作为 tool_result 内容
+func convertResponsesToolOutput(c *gin.Context, output json.RawMessage) (any, error) {
+	if common.GetJsonType(output) == "string" {
+		var text string
+		if err := common.Unmarshal(output, &text); err != nil {
+			return nil, err
+		}
+		return text, nil
+	}
+	return convertResponsesContent(c, output)
+}
+
+// convertResponsesTools 转换 function 与 web_search 工具，其他内置工具 Claude 无法执行
+func convertResponsesTools(tools []map[string]any) ([]any, error) {
+	claudeTools := make([]any, 0, len(tools))
+	for _, tool := range tools {
+		toolType, _ := tool["type"].(string)
+		switch toolType {
+		case "function":
+			name, _ := tool["name"].(string)
+			description, _ := tool["description"].(string)
+			schema, _ := tool["parameters"].(map[string]any)
+			if schema == nil {
+				schema = map[string]any{"type": "object", "properties": map[string]any{}}
+			}
+			claudeTools = append(claudeTools, &dto.Tool{Name: name, Description: description, InputSchema: schema})
+		case dto.BuildInToolWebSearchPreview, "web_search":
+			webSearchTool := &dto.ClaudeWebSearchTool{Type: "web_search_20250305", Name: "web_search"}
+			switch tool["search_context_size"] {
+			case "low":
+				webSearchTool.MaxUses = WebSearchMaxUsesLow
+			case "medium":
+				webSearchTool.MaxUses = WebSearchMaxUsesMedium
+			case "high":
+				webSearchTool.MaxUses = WebSearchMaxUsesHigh
+			}
+			if location, ok := tool["user_location"].(map[string]any); ok {
+				userLocation := &dto.ClaudeWebSearchUserLocation{Type: "approximate"}
+				userLocation.Timezone, _ = location["timezone"].(string)
+				userLocation.Country, _ = location["country"].(string)
+				userLocation.Region, _ = location["region"].(string)
+				userLocation.City, _ = location["city"].(string)
+				webSearchTool.UserLocation = userLocation
+			}
+			claudeTools = append(claudeTools, webSearchTool)
+		default:
+			return nil, newResponsesConvertError("tool type %q is not supported for Claude models", toolType)
+		}
+	}
+	return claudeTools, nil
+}
+
+func convertResponsesToolChoice(toolChoice json.RawMessage, parallelToolCalls json.RawMessage) *dto.ClaudeToolChoice {
+	var claudeToolChoice *dto.ClaudeToolChoice
+	choice := gjson.ParseBytes(toolChoice)
+	switch {
+	case choice.Type == gjson.String:
+		switch choice.String() {
+		case "auto":
+			claudeToolChoice = &dto.ClaudeToolChoice{Type: "auto"}
+		case "required":
+			claudeToolChoice = &dto.ClaudeToolChoice{Type: "any"}
+		case "none":
+			claudeToolChoice = &dto.ClaudeToolChoice{Type: "none"}
+		}
+	case choice.Get("type").String() == "function" && choice.Get("name").String() != "":
+		claudeToolChoice = &dto.ClaudeToolChoice{Type: "tool", Name: choice.Get("name").String()}
+	}
+	if gjson.ParseBytes(parallelToolCalls).Type == gjson.False {
+		if claudeToolChoice == nil {
+			claudeToolChoice = &dto.ClaudeToolChoice{Type: "auto"}
+		}
+		if claudeToolChoice.Type != "none" {
+			claudeToolChoice.DisableParallelToolUse = true
+		}
+	}
+	return claudeToolChoice
+}
+
+// applyResponsesReasoning 将 reasoning.effort 转换为 thinking 配置，开启 thinking 时 Claude 不允许修改 temperature / top_p
+func applyResponsesReasoning(claudeRequest *dto.ClaudeRequest, reasoning *dto.Reasoning) {
+	if reasoning == nil {
+		return
+	}
+	budget, ok := responsesReasoningBudgets[reasoning.Effort]
+	if !ok {
+		return
+	}
+	if strings.HasPrefix(claudeRequest.Model, "claude-opus-4-6") || strings.HasPrefix(claudeRequest.Model, "claude-opus-4-7") {
+		effort := reasoning.Effort
+		if effort == "xhigh" {
+			effort = "high"
+		}
+		claudeRequest.Thinking = &dto.Thinking{Type: "adaptive", Display: "summarized"}
+		claudeRequest.OutputConfig = json.RawMessage(fmt.Sprintf(`{"effort":"%s"}`, effort))
+	} else {
+		// budget_tokens 必须小于 max_tokens
+		if *claudeRequest.MaxTokens <= uint(budget) {
+			claudeRequest.MaxTokens = common.GetPointer(uint(budget) + 1024)
+		}
+		claudeRequest.Thinking = &dto.Thinking{Type: "enabled", BudgetTokens: common.GetPointer(budget)}
+	}
+	claudeRequest.Temperature = nil
+	claudeRequest.TopP = nil
+}
@@ -0,0 +1,309 @@
+package claude
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/relay/helper"
+
+	"github.com/gin-gonic/gin"
+)
+
+// responsesIdSuffix 去掉 Claude 消息 ID 的 msg_ 前缀，用于生成 Responses 的 response 与 item ID
+func responsesIdSuffix(messageId string) string {
+	return strings.TrimPrefix(messageId, "msg_")
+}
+
+func responsesStatus(stopReason string) (string, map[string]any) {
+	if stopReason == "max_tokens" {
+		return "incomplete", map[string]any{"reason": "max_output_tokens"}
+	}
+	return "completed", nil
+}
+
+func responsesUsageFromClaude(usage *dto.Usage) map[string]any {
+	openAIUsage := buildOpenAIStyleUsageFromClaudeUsage(usage)
+	return map[string]any{
+		"input_tokens":          openAIUsage.PromptTokens,
+		"input_tokens_details":  map[string]any{"cached_tokens": openAIUsage.PromptTokensDetails.CachedTokens},
+		"output_tokens":         openAIUsage.CompletionTokens,
+		"output_tokens_details": map[string]any{"reasoning_tokens": 0},
+		"total_tokens":          openAIUsage.TotalTokens,
+	}
+}
+
+func responsesMessageItem(id, status, text string) map[string]any {
+	content := []map[string]any{}
+	if status == "completed" {
+		content = append(content, responsesOutputTextPart(text))
+	}
+	return map[string]any{"id": id, "type": "message", "status": status, "role": "assistant", "content": content}
+}
+
+func responsesOutputTextPart(text string) map[string]any {
+	return map[string]any{"type": "output_text", "text": text, "annotations": []any{}}
+}
+
+func responsesReasoningItem(id, thinking, encryptedContent string) map[string]any {
+	summary := []map[string]any{}
+	if thinking != "" {
+		summary = append(summary, map[string]any{"type": "summary_text", "text": thinking})
+	}
+	item := map[string]any{"id": id, "type": "reasoning", "summary": summary}
+	if encryptedContent != "" {
+		item["encrypted_content"] = encryptedContent
+	}
+	return item
+}
+
+func responsesFunctionCallItem(id, status, callId, name, arguments string) map[string]any {
+	return map[string]any{"id": id, "type": "function_call", "status": status, "call_id": callId, "name": name, "arguments": arguments}
+}
+
+// ResponseClaude2OpenAIResponses 将 Claude 非流式响应转换为 Responses 响应，
+// thinking 转换为 reasoning 条目，签名保存在 encrypted_content 中以便下一轮回传
+func ResponseClaude2OpenAIResponses(claudeResponse *dto.ClaudeResponse, usage *dto.Usage, createdAt int64) map[string]any {
+	suffix := responsesIdSuffix(claudeResponse.Id)
+	output := make([]map[string]any, 0, len(claudeResponse.Content))
+	for i, block := range claudeResponse.Content {
+		switch block.Type {
+		case "text":
+			output = append(output, responsesMessageItem(fmt.Sprintf("msg_%s_%d", suffix, i), "completed", block.GetText()))
+		case "thinking":
+			thinking := ""
+			if block.Thinking != nil {
+				thinking = *block.Thinking
+			}
+			output = append(output, responsesReasoningItem(fmt.Sprintf("rs_%s_%d", suffix, i), thinking, block.Signature))
+		case "redacted_thinking":
+			output = append(output, responsesReasoningItem(fmt.Sprintf("rs_%s_%d", suffix, i), "", responsesRedactedThinkingPrefix+block.Data))
+		case "tool_use":
+			arguments, _ := common.Marshal(block.Input)
+			output = append(output, responsesFunctionCallItem(fmt.Sprintf("fc_%s_%d", suffix, i), "completed", block.Id, block.Name, string(arguments)))
+		}
+	}
+	status, incompleteDetails := responsesStatus(claudeResponse.StopReason)
+	return map[string]any{
+		"id":                 "resp_" + suffix,
+		"object":             "response",
+		"created_at":         createdAt,
+		"status":             status,
+		"incomplete_details": incompleteDetails,
+		"model":              claudeResponse.Model,
+		"output":             output,
+		"usage":              responsesUsageFromClaude(usage),
+	}
+}
+
+// responsesStreamBlock Claude 内容块对应的 Responses 输出条目
+type responsesStreamBlock struct {
+	outputIndex int
+	itemId      string
+	blockType   string
+	text        strings.Builder
+	signature   string
+	callId      string
+	name        string
+}
+
+// responsesStreamState 将 Claude 流式事件转换为 Responses 流式事件
+type responsesStreamState struct {
+	sequence   int
+	responseId string
+	suffix     string
+	started    bool
+	stopReason string
+	output     []map[string]any
+	blocks     map[int]*responsesStreamBlock
+}
+
+func (s *responsesStreamState) emit(c *gin.Context, eventType string, payload map[string]any) {
+	payload["type"] = eventType
+	payload["sequence_number"] = s.sequence
+	s.sequence++
+	data, err := common.Marshal(payload)
+	if err != nil {
+		logger.LogError(c, "marshal responses stream event failed: "+err.Error())
+		return
+	}
+	helper.ResponseChunkData(c, dto.ResponsesStreamResponse{Type: eventType}, string(data))
+}
+
+func (s *responsesStreamState) response(claudeInfo *ClaudeResponseInfo, status string) map[string]any {
+	output := s.output
+	if output == nil {
+		output = []map[string]any{}
+	}
+	return map[string]any{
+		"id":         s.responseId,
+		"object":     "response",
+		"created_at": claudeInfo.Created,
+		"status":     status,
+		"model":      claudeInfo.Model,
+		"output":     output,
+	}
+}
+
+func (s *responsesStreamState) start(c *gin.Context, claudeInfo *ClaudeResponseInfo) {
+	if s.started {
+		return
+	}
+	s.started = true
+	s.suffix = responsesIdSuffix(claudeInfo.ResponseId)
+	s.responseId = "resp_" + s.suffix
+	s.emit(c, "response.created", map[string]any{"response": s.response(claudeInfo, "in_progress")})
+	s.emit(c, "response.in_progress", map[string]any{"response": s.response(claudeInfo, "in_progress")})
+}
+
+func (s *responsesStreamState) handle(c *gin.Context, claudeInfo *ClaudeResponseInfo, claudeResponse *dto.ClaudeResponse) {
+	switch claudeResponse.Type {
+	case "message_start":
+		s.start(c, claudeInfo)
+	case "content_block_start":
+		s.start(c, claudeInfo)
+		if claudeResponse.Index != nil && claudeResponse.ContentBlock != nil {
+			s.startBlock(c, *claudeResponse.Index, claudeResponse.ContentBlock)
+		}
+	case "content_block_delta":
+		if claudeResponse.Index != nil && claudeResponse.Delta != nil {
+			s.delta(c, *claudeResponse.Index, claudeResponse.Delta)
+		}
+	case "content_block_stop":
+		if claudeResponse.Index != nil {
+			s.stopBlock(c, *claudeResponse.Index)
+		}
+	case "message_delta":
+		if claudeResponse.Delta != nil && claudeResponse.Delta.StopReason != nil {
+			s.stopReason = *claudeResponse.Delta.StopReason
+		}
+	}
+}
+
+// startBlock 服务端工具（web_search 等）的内容块没有对应的 Responses 条目，直接忽略
+func (s *responsesStreamState) startBlock(c *gin.Context, index int, contentBlock *dto.ClaudeMediaMessage) {
+	block := &responsesStreamBlock{outputIndex: len(s.output), blockType: contentBlock.Type}
+	var item map[string]any
+	switch contentBlock.Type {
+	case "text":
+		block.itemId = fmt.Sprintf("msg_%s_%d", s.suffix, index)
+		item = responsesMessageItem(block.itemId, "in_progress", "")
+	case "thinking", "redacted_thinking":
+		block.itemId = fmt.Sprintf("rs_%s_%d", s.suffix, index)
+		item = responsesReasoningItem(block.itemId, "", "")
+		if contentBlock.Type == "redacted_thinking" {
+			block.signature = responsesRedactedThinkingPrefix + contentBlock.Data
+		}
+	case "tool_use":
+		block.itemId = fmt.Sprintf("fc_%s_%d", s.suffix, index)
+		block.callId = contentBlock.Id
+		block.name = contentBlock.Name
+		item = responsesFunctionCallItem(block.itemId, "in_progress", block.callId, block.name, "")
+	default:
+		return
+	}
+	if s.blocks == nil {
+		s.blocks = make(map[int]*responsesStreamBlock)
+	}
+	s.blocks[index] = block
+	// 先占位，条目完成时替换为最终内容
+	s.output = append(s.output, item)
+	s.emit(c, "response.output_item.added", map[string]any{"output_index": block.outputIndex, "item": item})
+	switch contentBlock.Type {
+	case "text":
+		s.emit(c, "response.content_part.added", map[string]any{
+			"item_id": block.itemId, "output_index": block.outputIndex, "content_index": 0, "part": responsesOutputTextPart(""),
+		})
+	case "thinking":
+		s.emit(c, "response.reasoning_summary_part.added", map[string]any{
+			"item_id": block.itemId, "output_index": block.outputIndex, "summary_index": 0, "part": map[string]any{"type": "summary_text", "text": ""},
+		})
+	}
+}
+
+func (s *responsesStreamState) delta(c *gin.Context, index int, delta *dto.ClaudeMediaMessage) {
+	block, ok := s.blocks[index]
+	if !ok {
+		return
+	}
+	switch delta.Type {
+	case "text_delta":
+		if delta.Text == nil {
+			return
+		}
+		block.text.WriteString(*delta.Text)
+		s.emit(c, "response.output_text.delta", map[string]any{
+			"item_id": block.itemId, "output_index": block.outputIndex, "content_index": 0, "delta": *delta.Text, "logprobs": []any{},
+		})
+	case "thinking_delta":
+		if delta.Thinking == nil {
+			return
+		}
+		block.text.WriteString(*delta.Thinking)
+		s.emit(c, "response.reasoning_summary_text.delta", map[string]any{
+			"item_id": block.itemId, "output_index": block.outputIndex, "summary_index": 0, "delta": *delta.Thinking,
+		})
+	case "signature_delta":
+		block.signature += delta.Signature
+	case "input_json_delta":
+		if delta.PartialJson == nil || *delta.PartialJson == "" {
+			return
+		}
+		block.text.WriteString(*delta.PartialJson)
+		s.emit(c, "response.function_call_arguments.delta", map[string]any{
+			"item_id": block.itemId, "output_index": block.outputIndex, "delta": *delta.PartialJson,
+		})
+	}
+}
+
+func (s *responsesStreamState) stopBlock(c *gin.Context, index int) {
+	block, ok := s.blocks[index]
+	if !ok {
+		return
+	}
+	delete(s.blocks, index)
+	text := block.text.String()
+	var item map[string]any
+	switch block.blockType {
+	case "text":
+		s.emit(c, "response.output_text.done", map[string]any{
+			"item_id": block.itemId, "output_index": block.outputIndex, "content_index": 0, "text": text, "logprobs": []any{},
+		})
+		s.emit(c, "response.content_part.done", map[string]any{
+			"item_id": block.itemId, "output_index": block.outputIndex, "content_index": 0, "part": responsesOutputTextPart(text),
+		})
+		item = responsesMessageItem(block.itemId, "completed", text)
+	case "thinking":
+		s.emit(c, "response.reasoning_summary_text.done", map[string]any{
+			"item_id": block.itemId, "output_index": block.outputIndex, "summary_index": 0, "text": text,
+		})
+		s.emit(c, "response.reasoning_summary_part.done", map[string]any{
+			"item_id": block.itemId, "output_index": block.outputIndex, "summary_index": 0, "part": map[string]any{"type": "summary_text", "text": text},
+		})
+		item = responsesReasoningItem(block.itemId, text, block.signature)
+	case "redacted_thinking":
+		item = responsesReasoningItem(block.itemId, "", block.signature)
+	case "tool_use":
+		if text == "" {
+			text = "{}"
+		}
+		s.emit(c, "response.function_call_arguments.done", map[string]any{
+			"item_id": block.itemId, "output_index": block.outputIndex, "arguments": text,
+		})
+		item = responsesFunctionCallItem(block.itemId, "completed", block.callId, block.name, text)
+	}
+	s.output[block.outputIndex] = item
+	s.emit(c, "response.output_item.done", map[string]any{"output_index": block.outputIndex, "item": item})
+}
+
+// finish 发送 response.completed，max_tokens 截断时发送 response.incomplete
+func (s *responsesStreamState) finish(c *gin.Context, claudeInfo *ClaudeResponseInfo) {
+	s.start(c, claudeInfo)
+	status, incompleteDetails := responsesStatus(s.stopReason)
+	response := s.response(claudeInfo, status)
+	response["incomplete_details"] = incompleteDetails
+	response["usage"] = responsesUsageFromClaude(claudeInfo.Usage)
+	s.emit(c, "response."+status, map[string]any{"response": response})
+}
@@ -0,0 +1,143 @@
+package claude
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestRequestOpenAIResponses2ClaudeMessage(t *testing.T) {
+	var request dto.OpenAIResponsesRequest
+	require.NoError(t, common.UnmarshalJsonStr(`{
+		"model": "claude-sonnet-4-5",
+		"instructions": "be brief",
+		"max_output_tokens": 1000,
+		"temperature": 0.5,
+		"reasoning": {"effort": "medium"},
+		"parallel_tool_calls": false,
+		"tool_choice": {"type": "function", "name": "get_weather"},
+		"tools": [{"type": "function", "name": "get_weather", "parameters": {"type": "object", "properties": {"city": {"type": "string"}}}}],
+		"input": [
+			{"role": "developer", "content": "answer in english"},
+			{"role": "user", "content": [{"type": "input_text", "text": "weather in Paris?"}]},
+			{"type": "reasoning", "summary": [{"type": "summary_text", "text": "need tool"}], "encrypted_content": "sig"},
+			{"type": "reasoning", "summary": [{"type": "summary_text", "text": "unsigned"}]},
+			{"type": "function_call", "call_id": "toolu_1", "name": "get_weather", "arguments": "{\"city\":\"Paris\"}"},
+			{"type": "function_call_output", "call_id": "toolu_1", "output": "sunny"}
+		]
+	}`, &request))
+
+	claudeRequest, err := RequestOpenAIResponses2ClaudeMessage(nil, request)
+	require.NoError(t, err)
+
+	system, ok := claudeRequest.System.([]dto.ClaudeMediaMessage)
+	require.True(t, ok)
+	require.Len(t, system, 2)
+	require.Equal(t, "be brief", *system[0].Text)
+	require.Equal(t, "answer in english", *system[1].Text)
+
+	require.Len(t, claudeRequest.Messages, 3)
+	require.Equal(t, "user", claudeRequest.Messages[0].Role)
+	assistant := claudeRequest.Messages[1].Content.([]dto.ClaudeMediaMessage)
+	require.Len(t, assistant, 2)
+	require.Equal(t, "thinking", assistant[0].Type)
+	require.Equal(t, "need tool", *assistant[0].Thinking)
+	require.Equal(t, "sig", assistant[0].Signature)
+	require.Equal(t, "tool_use", assistant[1].Type)
+	require.Equal(t, map[string]any{"city": "Paris"}, assistant[1].Input)
+	toolResult := claudeRequest.Messages[2].Content.([]dto.ClaudeMediaMessage)
+	require.Equal(t, "tool_result", toolResult[0].Type)
+	require.Equal(t, "toolu_1", toolResult[0].ToolUseId)
+	require.Equal(t, "sunny", toolResult[0].Content)
+
+	require.Len(t, claudeRequest.Tools, 1)
+	toolChoice, ok := claudeRequest.ToolChoice.(*dto.ClaudeToolChoice)
+	require.True(t, ok)
+	require.Equal(t, "tool", toolChoice.Type)
+	require.Equal(t, "get_weather", toolChoice.Name)
+	require.True(t, toolChoice.DisableParallelToolUse)
+	require.Equal(t, "enabled", claudeRequest.Thinking.Type)
+	require.Equal(t, 2048, *claudeRequest.Thinking.BudgetTokens)
+	require.Equal(t, uint(3072), *claudeRequest.MaxTokens)
+	require.Nil(t, claudeRequest.Temperature)
+}
+
+func TestRequestOpenAIResponses2ClaudeMessage_RejectsUnsupported(t *testing.T) {
+	_, err := RequestOpenAIResponses2ClaudeMessage(nil, dto.OpenAIResponsesRequest{Model: "claude-sonnet-4-5", PreviousResponseID: "resp_1"})
+	var apiErr *types.NewAPIError
+	require.True(t, errors.As(err, &apiErr))
+	require.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+
+	_, err = RequestOpenAIResponses2ClaudeMessage(nil, dto.OpenAIResponsesRequest{
+		Model: "claude-sonnet-4-5",
+		Input: []byte(`"hi"`),
+		Tools: []byte(`[{"type":"code_interpreter"}]`),
+	})
+	require.ErrorContains(t, err, "code_interpreter")
+}
+
+func TestHandleStreamResponseData_OpenAIResponses(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	info := &relaycommon.RelayInfo{RelayFormat: types.RelayFormatOpenAIResponses, IsStream: true}
+	claudeInfo := &ClaudeResponseInfo{Created: 1700000000, Usage: &dto.Usage{}}
+
+	events := []string{
+		`{"type":"message_start","message":{"id":"msg_abc","model":"claude-sonnet-4-5","usage":{"input_tokens":10,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"thinking","thinking":""}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"hmm"}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"signature_delta","signature":"sig"}}`,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"content_block_start","index":1,"content_block":{"type":"text","text":""}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"Hello"}}`,
+		`{"type":"content_block_stop","index":1}`,
+		`{"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"toolu_1","name":"get_weather","input":{}}}`,
+		`{"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"{\"city\":"}}`,
+		`{"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"\"Paris\"}"}}`,
+		`{"type":"content_block_stop","index":2}`,
+		`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":20}}`,
+		`{"type":"message_stop"}`,
+	}
+	for _, event := range events {
+		require.Nil(t, HandleStreamResponseData(c, info, claudeInfo, event))
+	}
+	HandleStreamFinalResponse(c, info, claudeInfo)
+
+	var eventTypes []string
+	var last gjson.Result
+	for _, line := range strings.Split(recorder.Body.String(), "\n") {
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			last = gjson.Parse(data)
+			eventTypes = append(eventTypes, last.Get("type").String())
+		}
+	}
+	require.Equal(t, []string{
+		"response.created", "response.in_progress",
+		"response.output_item.added", "response.reasoning_summary_part.added", "response.reasoning_summary_text.delta",
+		"response.reasoning_summary_text.done", "response.reasoning_summary_part.done", "response.output_item.done",
+		"response.output_item.added", "response.content_part.added", "response.output_text.delta",
+		"response.output_text.done", "response.content_part.done", "response.output_item.done",
+		"response.output_item.added", "response.function_call_arguments.delta", "response.function_call_arguments.delta",
+		"response.function_call_arguments.done", "response.output_item.done",
+		"response.completed",
+	}, eventTypes)
+
+	require.Equal(t, int64(19), last.Get("sequence_number").Int())
+	require.Equal(t, "resp_abc", last.Get("response.id").String())
+	require.Equal(t, "sig", last.Get("response.output.0.encrypted_content").String())
+	require.Equal(t, "Hello", last.Get("response.output.1.content.0.text").String())
+	require.Equal(t, `{"city":"Paris"}`, last.Get("response.output.2.arguments").String())
+	require.Equal(t, int64(10), last.Get("response.usage.input_tokens").Int())
+	require.Equal(t, int64(20), last.Get("response.usage.output_tokens").Int())
+}
@@ -0,0 +1,132 @@
+package gemini
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+)
+
+// ConvertImageVariationRequest Gemini 没有原生 variations 接口，
+// 使用原生出图模型（如 gemini-2.5-flash-image）的 generateContent，输入原图与提示词来模拟
+func ConvertImageVariationRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.ImageRequest) (*dto.GeminiChatRequest, error) {
+	if strings.HasPrefix(info.UpstreamModelName, "imagen") {
+		return nil, errors.New("imagen models do not support image variations, please use a gemini image model")
+	}
+	mf := c.Request.MultipartForm
+	if mf == nil {
+		if _, err := c.MultipartForm(); err != nil {
+			return nil, fmt.Errorf("failed to parse image variation form request: %w", err)
+		}
+		mf = c.Request.MultipartForm
+	}
+	imageFiles := mf.File["image"]
+	if len(imageFiles) == 0 {
+		return nil, errors.New("image is required")
+	}
+	file, err := imageFiles[0].Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image file: %w", err)
+	}
+	defer file.Close()
+	imageData, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image file: %w", err)
+	}
+	mimeType := imageFiles[0].Header.Get("Content-Type")
+	if mimeType == "" || mimeType == "application/octet-stream" {
+		mimeType = http.DetectContentType(imageData)
+	}
+
+	prompt := request.Prompt
+	if prompt == "" {
+		prompt = dto.DefaultImageVariationPrompt
+	}
+	geminiRequest := &dto.GeminiChatRequest{
+		Contents: []dto.GeminiChatContent{
+			{
+				Role: "user",
+				Parts: []dto.GeminiPart{
+					{
+						InlineData: &dto.GeminiInlineData{
+							MimeType: mimeType,
+							Data:     base64.StdEncoding.EncodeToString(imageData),
+						},
+					},
+					{
+						Text: prompt,
+					},
+				},
+			},
+		},
+		GenerationConfig: dto.GeminiChatGenerationConfig{
+			ResponseModalities: []string{"TEXT", "IMAGE"},
+		},
+	}
+	if n := int(lo.FromPtrOr(request.N, uint(1))); n > 1 {
+		geminiRequest.GenerationConfig.CandidateCount = &n
+	}
+	return geminiRequest, nil
+}
+
+// GeminiImageVariationHandler 将 generateContent 响应中的图片转换为 OpenAI images 响应
+func GeminiImageVariationHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Response) (*dto.Usage, *types.NewAPIError) {
+	responseBody, readErr := io.ReadAll(resp.Body)
+	if readErr != nil {
+		return nil, types.NewOpenAIError(readErr, types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
+	}
+	_ = resp.Body.Close()
+
+	var geminiResponse dto.GeminiChatResponse
+	if jsonErr := common.Unmarshal(responseBody, &geminiResponse); jsonErr != nil {
+		return nil, types.NewOpenAIError(jsonErr, types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
+	}
+
+	openAIResponse := dto.ImageResponse{
+		Created: common.GetTimestamp(),
+		Data:    make([]dto.ImageData, 0, len(geminiResponse.Candidates)),
+	}
+	for _, candidate := range geminiResponse.Candidates {
+		for _, part := range candidate.Content.Parts {
+			if part.InlineData == nil || !strings.HasPrefix(part.InlineData.MimeType, "image/") {
+				continue
+			}
+			openAIResponse.Data = append(openAIResponse.Data, dto.ImageData{
+				B64Json: part.InlineData.Data,
+			})
+		}
+	}
+	if len(openAIResponse.Data) == 0 {
+		return nil, types.NewOpenAIError(errors.New("no images generated"), types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
+	}
+
+	jsonResponse, jsonErr := common.Marshal(openAIResponse)
+	if jsonErr != nil {
+		return nil, types.NewError(jsonErr, types.ErrorCodeBadResponseBody)
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.WriteHeader(resp.StatusCode)
+	_, _ = c.Writer.Write(jsonResponse)
+
+	// 按次计费时以实际返回的图片数量计费
+	if info.PriceData.UsePrice {
+		info.PriceData.AddOtherRatio("n", float64(len(openAIResponse.Data)))
+	}
+	metadata := geminiResponse.UsageMetadata
+	usage := &dto.Usage{
+		PromptTokens:     metadata.PromptTokenCount,
+		CompletionTokens: metadata.CandidatesTokenCount + metadata.ThoughtsTokenCount,
+		TotalTokens:      metadata.TotalTokenCount,
+	}
+	return usage, nil
+}
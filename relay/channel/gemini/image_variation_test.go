@@ -0,0 +1,78 @@
+package gemini
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func newImageVariationTestContext(t *testing.T) (*gin.Context, *httptest.ResponseRecorder) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("image", "cat.png")
+	require.NoError(t, err)
+	_, err = part.Write([]byte("\x89PNG\r\n\x1a\nfake"))
+	require.NoError(t, err)
+	require.NoError(t, writer.WriteField("n", "2"))
+	require.NoError(t, writer.Close())
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/images/variations", &body)
+	c.Request.Header.Set("Content-Type", writer.FormDataContentType())
+	return c, recorder
+}
+
+func TestConvertImageVariationRequest(t *testing.T) {
+	c, _ := newImageVariationTestContext(t)
+	info := &relaycommon.RelayInfo{ChannelMeta: &relaycommon.ChannelMeta{UpstreamModelName: "gemini-2.5-flash-image"}}
+
+	geminiRequest, err := ConvertImageVariationRequest(c, info, dto.ImageRequest{N: common.GetPointer(uint(2))})
+	require.NoError(t, err)
+	require.Len(t, geminiRequest.Contents, 1)
+	parts := geminiRequest.Contents[0].Parts
+	require.Len(t, parts, 2)
+	require.NotNil(t, parts[0].InlineData)
+	require.Equal(t, "image/png", parts[0].InlineData.MimeType)
+	require.Equal(t, dto.DefaultImageVariationPrompt, parts[1].Text)
+	require.Equal(t, 2, *geminiRequest.GenerationConfig.CandidateCount)
+
+	info.UpstreamModelName = "imagen-4.0-generate-001"
+	_, err = ConvertImageVariationRequest(c, info, dto.ImageRequest{})
+	require.Error(t, err)
+}
+
+func TestGeminiImageVariationHandler(t *testing.T) {
+	c, recorder := newImageVariationTestContext(t)
+	info := &relaycommon.RelayInfo{
+		PriceData:   types.PriceData{UsePrice: true},
+		ChannelMeta: &relaycommon.ChannelMeta{UpstreamModelName: "gemini-2.5-flash-image"},
+	}
+
+	payload := `{"candidates":[{"content":{"role":"model","parts":[{"text":"here"},{"inlineData":{"mimeType":"image/png","data":"aW1n"}}]}}],"usageMetadata":{"promptTokenCount":300,"candidatesTokenCount":1290,"totalTokenCount":1590}}`
+	resp := &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader([]byte(payload)))}
+
+	usage, newAPIError := GeminiImageVariationHandler(c, info, resp)
+	require.Nil(t, newAPIError)
+	require.Equal(t, 300, usage.PromptTokens)
+	require.Equal(t, 1290, usage.CompletionTokens)
+	require.Equal(t, 1.0, info.PriceData.OtherRatios["n"])
+
+	var imageResponse dto.ImageResponse
+	require.NoError(t, common.Unmarshal(recorder.Body.Bytes(), &imageResponse))
+	require.Len(t, imageResponse.Data, 1)
+	require.Equal(t, "aW1n", imageResponse.Data[0].B64Json)
+}
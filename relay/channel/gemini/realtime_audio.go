@@ -0,0 +1,209 @@
+package gemini
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"strconv"
+	"strings"
+)
+
+// Gemini Live 输入音频为 16kHz PCM16，输出音频为 24kHz PCM16
+const (
+	geminiLiveInputSampleRate  = 16000
+	geminiLiveOutputSampleRate = 24000
+)
+
+// realtimeAudioSampleRate OpenAI Realtime 音频格式的采样率，pcm16 为 24kHz，g711 为 8kHz
+func realtimeAudioSampleRate(format string) int {
+	switch format {
+	case "g711_ulaw", "g711_alaw":
+		return 8000
+	default:
+		return 24000
+	}
+}
+
+// parsePCMSampleRate 从 audio/pcm;rate=24000 形式的 mimeType 中解析采样率
+func parsePCMSampleRate(mimeType string, defaultRate int) int {
+	for _, param := range strings.Split(mimeType, ";") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(param), "rate="); ok {
+			if rate, err := strconv.Atoi(value); err == nil && rate > 0 {
+				return rate
+			}
+		}
+	}
+	return defaultRate
+}
+
+// convertRealtimeAudioToGemini 将客户端音频转换为 Gemini Live 需要的 16kHz PCM16
+func convertRealtimeAudioToGemini(data string, format string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", err
+	}
+	samples := decodeRealtimeAudio(raw, format)
+	samples = resamplePCM16(samples, realtimeAudioSampleRate(format), geminiLiveInputSampleRate)
+	return base64.StdEncoding.EncodeToString(encodePCM16(samples)), nil
+}
+
+// convertGeminiAudioToRealtime 将 Gemini Live 输出的 PCM16 转换为客户端 output_audio_format
+func convertGeminiAudioToRealtime(data string, mimeType string, format string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", err
+	}
+	sourceRate := parsePCMSampleRate(mimeType, geminiLiveOutputSampleRate)
+	targetRate := realtimeAudioSampleRate(format)
+	if sourceRate == targetRate && format != "g711_ulaw" && format != "g711_alaw" {
+		return data, nil
+	}
+	samples := resamplePCM16(decodePCM16(raw), sourceRate, targetRate)
+	var out []byte
+	switch format {
+	case "g711_ulaw":
+		out = make([]byte, len(samples))
+		for i, sample := range samples {
+			out[i] = linearToULaw(sample)
+		}
+	case "g711_alaw":
+		out = make([]byte, len(samples))
+		for i, sample := range samples {
+			out[i] = linearToALaw(sample)
+		}
+	default:
+		out = encodePCM16(samples)
+	}
+	return base64.StdEncoding.EncodeToString(out), nil
+}
+
+func decodeRealtimeAudio(raw []byte, format string) []int16 {
+	switch format {
+	case "g711_ulaw":
+		samples := make([]int16, len(raw))
+		for i, b := range raw {
+			samples[i] = uLawToLinear(b)
+		}
+		return samples
+	case "g711_alaw":
+		samples := make([]int16, len(raw))
+		for i, b := range raw {
+			samples[i] = aLawToLinear(b)
+		}
+		return samples
+	default:
+		return decodePCM16(raw)
+	}
+}
+
+// decodePCM16 小端 PCM16，末尾不完整的字节被丢弃
+func decodePCM16(raw []byte) []int16 {
+	samples := make([]int16, len(raw)/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(raw[i*2:]))
+	}
+	return samples
+}
+
+func encodePCM16(samples []int16) []byte {
+	out := make([]byte, len(samples)*2)
+	for i, sample := range samples {
+		binary.LittleEndian.PutUint16(out[i*2:], uint16(sample))
+	}
+	return out
+}
+
+// resamplePCM16 线性插值重采样，实时语音场景下足够
+func resamplePCM16(samples []int16, fromRate, toRate int) []int16 {
+	if fromRate == toRate || len(samples) == 0 {
+		return samples
+	}
+	n := len(samples) * toRate / fromRate
+	out := make([]int16, n)
+	step := float64(fromRate) / float64(toRate)
+	for i := range out {
+		pos := float64(i) * step
+		idx := int(pos)
+		if idx >= len(samples)-1 {
+			out[i] = samples[len(samples)-1]
+			continue
+		}
+		frac := pos - float64(idx)
+		out[i] = int16(float64(samples[idx])*(1-frac) + float64(samples[idx+1])*frac)
+	}
+	return out
+}
+
+// G.711 编解码，参考 ITU-T G.711 的参考实现
+
+func uLawToLinear(u byte) int16 {
+	u = ^u
+	t := (int(u&0x0F) << 3) + 0x84
+	t <<= (u & 0x70) >> 4
+	if u&0x80 != 0 {
+		return int16(0x84 - t)
+	}
+	return int16(t - 0x84)
+}
+
+func linearToULaw(sample int16) byte {
+	const bias, clip = 0x84, 32635
+	s := int(sample)
+	sign := 0
+	if s < 0 {
+		s = -s
+		sign = 0x80
+	}
+	if s > clip {
+		s = clip
+	}
+	s += bias
+	exponent := 7
+	for mask := 0x4000; s&mask == 0 && exponent > 0; mask >>= 1 {
+		exponent--
+	}
+	mantissa := (s >> (exponent + 3)) & 0x0F
+	return ^byte(sign | exponent<<4 | mantissa)
+}
+
+func aLawToLinear(a byte) int16 {
+	a ^= 0x55
+	t := int(a&0x0F) << 4
+	switch segment := int(a&0x70) >> 4; segment {
+	case 0:
+		t += 8
+	case 1:
+		t += 0x108
+	default:
+		t += 0x108
+		t <<= segment - 1
+	}
+	if a&0x80 != 0 {
+		return int16(t)
+	}
+	return int16(-t)
+}
+
+var aLawSegmentEnds = [8]int{0x1F, 0x3F, 0x7F, 0xFF, 0x1FF, 0x3FF, 0x7FF, 0xFFF}
+
+func linearToALaw(sample int16) byte {
+	pcm := int(sample) >> 3
+	mask := 0xD5
+	if pcm < 0 {
+		mask = 0x55
+		pcm = -pcm - 1
+	}
+	segment := 0
+	for segment < len(aLawSegmentEnds) && pcm > aLawSegmentEnds[segment] {
+		segment++
+	}
+	if segment >= len(aLawSegmentEnds) {
+		return byte(0x7F ^ mask)
+	}
+	value := segment << 4
+	if segment < 2 {
+		value |= (pcm >> 1) & 0x0F
+	} else {
+		value |= (pcm >> segment) & 0x0F
+	}
+	return byte(value ^ mask)
+}
@@ -0,0 +1,987 @@
+package gemini
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/tidwall/gjson"
+)
+
+// Gemini Live (BidiGenerateContent) 服务端消息，只解析桥接需要的字段
+// https://ai.google.dev/api/live
+type geminiLiveServerMessage struct {
+	SetupComplete        *struct{}                `json:"setupComplete,omitempty"`
+	ServerContent        *geminiLiveServerContent `json:"serverContent,omitempty"`
+	ToolCall             *geminiLiveToolCall      `json:"toolCall,omitempty"`
+	ToolCallCancellation *struct {
+		Ids []string `json:"ids"`
+	} `json:"toolCallCancellation,omitempty"`
+	GoAway *struct {
+		TimeLeft string `json:"timeLeft"`
+	} `json:"goAway,omitempty"`
+	UsageMetadata *geminiLiveUsageMetadata `json:"usageMetadata,omitempty"`
+}
+
+type geminiLiveServerContent struct {
+	ModelTurn           *dto.GeminiChatContent   `json:"modelTurn,omitempty"`
+	TurnComplete        bool                     `json:"turnComplete,omitempty"`
+	Interrupted         bool                     `json:"interrupted,omitempty"`
+	InputTranscription  *geminiLiveTranscription `json:"inputTranscription,omitempty"`
+	OutputTranscription *geminiLiveTranscription `json:"outputTranscription,omitempty"`
+}
+
+type geminiLiveTranscription struct {
+	Text string `json:"text"`
+}
+
+type geminiLiveToolCall struct {
+	FunctionCalls []geminiLiveFunctionCall `json:"functionCalls"`
+}
+
+type geminiLiveFunctionCall struct {
+	Id   string          `json:"id"`
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+// Live API 的用量字段为 response*，与 generateContent 的 candidates* 不同
+type geminiLiveUsageMetadata struct {
+	PromptTokenCount        int                             `json:"promptTokenCount"`
+	ResponseTokenCount      int                             `json:"responseTokenCount"`
+	CandidatesTokenCount    int                             `json:"candidatesTokenCount"`
+	ThoughtsTokenCount      int                             `json:"thoughtsTokenCount"`
+	TotalTokenCount         int                             `json:"totalTokenCount"`
+	PromptTokensDetails     []dto.GeminiPromptTokensDetails `json:"promptTokensDetails"`
+	ResponseTokensDetails   []dto.GeminiPromptTokensDetails `json:"responseTokensDetails"`
+	CandidatesTokensDetails []dto.GeminiPromptTokensDetails `json:"candidatesTokensDetails"`
+}
+
+// Gemini Live 预置音色，OpenAI 音色（alloy 等）不透传，使用模型默认音色
+var geminiLiveVoices = []string{
+	"Puck", "Charon", "Kore", "Fenrir", "Aoede", "Leda", "Orus", "Zephyr",
+	"Achernar", "Achird", "Algenib", "Algieba", "Alnilam", "Autonoe", "Callirrhoe", "Despina",
+	"Enceladus", "Erinome", "Gacrux", "Iapetus", "Laomedeia", "Pulcherrima", "Rasalgethi", "Sadachbia",
+	"Sadaltager", "Schedar", "Sulafat", "Umbriel", "Vindemiatrix", "Zubenelgenubi",
+}
+
+func geminiLiveVoice(voice string) string {
+	for _, v := range geminiLiveVoices {
+		if strings.EqualFold(v, voice) {
+			return v
+		}
+	}
+	return ""
+}
+
+type geminiLiveResponse struct {
+	id          string
+	outputIndex int
+	output      []map[string]any
+
+	// 当前正在输出的 assistant 消息
+	itemId      string
+	contentType string
+	content     strings.Builder
+}
+
+// geminiLiveBridge 在 OpenAI Realtime 事件与 Gemini Live 消息之间转换。
+// 客户端与上游两个读协程共享状态，所有处理都在 mu 内完成，这也保证了对同一连接的写入是串行的
+type geminiLiveBridge struct {
+	mu         sync.Mutex
+	c          *gin.Context
+	info       *relaycommon.RelayInfo
+	sendClient func(event map[string]any) error
+	sendTarget func(message any) error
+	// consume 按一轮用量预扣费并累加到 sumUsage
+	consume func(usage *dto.RealtimeUsage) error
+
+	session            dto.RealtimeSession
+	setupSent          bool
+	manualActivity     bool
+	activityActive     bool
+	skipResponseCreate bool
+	toolNames          map[string]string
+
+	response        *geminiLiveResponse
+	inputItemId     string
+	inputTranscript strings.Builder
+
+	upstreamUsage *dto.RealtimeUsage
+	localUsage    *dto.RealtimeUsage
+	sumUsage      *dto.RealtimeUsage
+}
+
+func newGeminiLiveBridge(c *gin.Context, info *relaycommon.RelayInfo, sendClient func(event map[string]any) error, sendTarget func(message any) error) *geminiLiveBridge {
+	bridge := &geminiLiveBridge{
+		c:          c,
+		info:       info,
+		sendClient: sendClient,
+		sendTarget: sendTarget,
+		session: dto.RealtimeSession{
+			Modalities:        []string{"text", "audio"},
+			InputAudioFormat:  info.InputAudioFormat,
+			OutputAudioFormat: info.OutputAudioFormat,
+			TurnDetection:     map[string]any{"type": "server_vad"},
+		},
+		toolNames:  make(map[string]string),
+		localUsage: &dto.RealtimeUsage{},
+		sumUsage:   &dto.RealtimeUsage{},
+	}
+	bridge.consume = func(usage *dto.RealtimeUsage) error {
+		return service.PreWssConsumeUsage(c, info, usage, bridge.sumUsage)
+	}
+	return bridge
+}
+
+// GeminiLiveRealtimeHandler 将 OpenAI Realtime 协议桥接到 Gemini Live，按轮次预扣费，返回累计用量
+func GeminiLiveRealtimeHandler(c *gin.Context, info *relaycommon.RelayInfo) (*types.NewAPIError, *dto.RealtimeUsage) {
+	if info == nil || info.ClientWs == nil || info.TargetWs == nil {
+		return types.NewError(fmt.Errorf("invalid websocket connection"), types.ErrorCodeBadResponse), nil
+	}
+
+	info.IsStream = true
+	clientConn := info.ClientWs
+	targetConn := info.TargetWs
+
+	bridge := newGeminiLiveBridge(c, info,
+		func(event map[string]any) error {
+			return helper.WssObject(c, clientConn, event)
+		},
+		func(message any) error {
+			return helper.WssObject(c, targetConn, message)
+		},
+	)
+
+	clientClosed := make(chan struct{})
+	targetClosed := make(chan struct{})
+	errChan := make(chan error, 2)
+
+	// Gemini 需要先收到 setup 才会响应，这里先返回 session.created，setup 延迟到客户端第一条消息
+	if err := bridge.start(); err != nil {
+		return types.NewError(err, types.ErrorCodeBadResponse), nil
+	}
+
+	gopool.Go(func() {
+		defer func() {
+			if r := recover(); r != nil {
+				errChan <- fmt.Errorf("panic in client reader: %v", r)
+			}
+		}()
+		for {
+			select {
+			case <-c.Done():
+				return
+			default:
+				_, message, err := clientConn.ReadMessage()
+				if err != nil {
+					if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+						errChan <- fmt.Errorf("error reading from client: %v", err)
+					}
+					close(clientClosed)
+					return
+				}
+				if err = bridge.handleClientMessage(message); err != nil {
+					errChan <- err
+					return
+				}
+			}
+		}
+	})
+
+	gopool.Go(func() {
+		defer func() {
+			if r := recover(); r != nil {
+				errChan <- fmt.Errorf("panic in target reader: %v", r)
+			}
+		}()
+		for {
+			select {
+			case <-c.Done():
+				return
+			default:
+				_, message, err := targetConn.ReadMessage()
+				if err != nil {
+					var closeErr *websocket.CloseError
+					if errors.As(err, &closeErr) && closeErr.Code != websocket.CloseNormalClosure && closeErr.Text != "" {
+						// Gemini 通过关闭帧返回错误原因，例如模型不支持 Live API
+						bridge.sendError(closeErr.Text)
+					}
+					if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+						errChan <- fmt.Errorf("error reading from target: %v", err)
+					}
+					close(targetClosed)
+					return
+				}
+				info.SetFirstResponseTime()
+				if err = bridge.handleServerMessage(message); err != nil {
+					errChan <- err
+					return
+				}
+			}
+		}
+	})
+
+	select {
+	case <-clientClosed:
+	case <-targetClosed:
+	case err := <-errChan:
+		logger.LogError(c, "realtime error: "+err.Error())
+	case <-c.Done():
+	}
+
+	return nil, bridge.flush()
+}
+
+func (b *geminiLiveBridge) start() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.emit("session.created", map[string]any{"session": b.sessionObject()})
+}
+
+func (b *geminiLiveBridge) emit(eventType string, fields map[string]any) error {
+	event := map[string]any{
+		"event_id": "event_" + common.GetRandomString(20),
+		"type":     eventType,
+	}
+	for k, v := range fields {
+		event[k] = v
+	}
+	return b.sendClient(event)
+}
+
+func (b *geminiLiveBridge) sendError(message string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_ = b.emit(dto.RealtimeEventTypeError, map[string]any{
+		"error": types.OpenAIError{Message: message, Type: "upstream_error"},
+	})
+}
+
+func (b *geminiLiveBridge) sessionObject() map[string]any {
+	tools := b.session.Tools
+	if tools == nil {
+		tools = []dto.RealTimeTool{}
+	}
+	return map[string]any{
+		"id":                  "sess_" + common.GetRandomString(20),
+		"object":              "realtime.session",
+		"model":               b.info.UpstreamModelName,
+		"modalities":          b.session.Modalities,
+		"instructions":        b.session.Instructions,
+		"voice":               b.session.Voice,
+		"input_audio_format":  b.info.InputAudioFormat,
+		"output_audio_format": b.info.OutputAudioFormat,
+		"turn_detection":      b.session.TurnDetection,
+		"tools":               tools,
+		"tool_choice":         common.GetStringIfEmpty(b.session.ToolChoice, "auto"),
+		"temperature":         b.session.Temperature,
+	}
+}
+
+func (b *geminiLiveBridge) mergeSession(session *dto.RealtimeSession, raw []byte) {
+	if session.Modalities != nil {
+		b.session.Modalities = session.Modalities
+	}
+	if session.Instructions != "" {
+		b.session.Instructions = session.Instructions
+	}
+	if session.Voice != "" {
+		b.session.Voice = session.Voice
+	}
+	if session.InputAudioFormat != "" {
+		b.info.InputAudioFormat = session.InputAudioFormat
+	}
+	if session.OutputAudioFormat != "" {
+		b.info.OutputAudioFormat = session.OutputAudioFormat
+	}
+	if turnDetection := gjson.GetBytes(raw, "session.turn_detection"); turnDetection.Exists() {
+		// turn_detection 为 null 时由客户端 commit 决定一轮输入的结束
+		b.session.TurnDetection = session.TurnDetection
+		b.manualActivity = turnDetection.Type == gjson.Null
+	}
+	if session.Tools != nil {
+		b.session.Tools = session.Tools
+		b.info.RealtimeTools = session.Tools
+	}
+	if session.ToolChoice != "" {
+		b.session.ToolChoice = session.ToolChoice
+	}
+	if session.Temperature > 0 {
+		b.session.Temperature = session.Temperature
+	}
+}
+
+// buildSetup 根据会话配置构造 BidiGenerateContentSetup，Live API 不支持在会话中途修改这些配置
+func (b *geminiLiveBridge) buildSetup() map[string]any {
+	modelName := b.info.UpstreamModelName
+	if !strings.HasPrefix(modelName, "models/") {
+		modelName = "models/" + modelName
+	}
+	modality := "TEXT"
+	if len(b.session.Modalities) == 0 || common.StringsContains(b.session.Modalities, "audio") {
+		modality = "AUDIO"
+	}
+	generationConfig := map[string]any{
+		"responseModalities": []string{modality},
+	}
+	if voice := geminiLiveVoice(b.session.Voice); voice != "" && modality == "AUDIO" {
+		generationConfig["speechConfig"] = map[string]any{
+			"voiceConfig": map[string]any{
+				"prebuiltVoiceConfig": map[string]any{"voiceName": voice},
+			},
+		}
+	}
+	if b.session.Temperature > 0 {
+		generationConfig["temperature"] = b.session.Temperature
+	}
+
+	setup := map[string]any{
+		"model":                   modelName,
+		"generationConfig":        generationConfig,
+		"inputAudioTranscription": map[string]any{},
+	}
+	if modality == "AUDIO" {
+		setup["outputAudioTranscription"] = map[string]any{}
+	}
+	if b.session.Instructions != "" {
+		setup["systemInstruction"] = dto.GeminiChatContent{
+			Parts: []dto.GeminiPart{{Text: b.session.Instructions}},
+		}
+	}
+	if b.session.ToolChoice != "none" {
+		declarations := make([]map[string]any, 0, len(b.session.Tools))
+		for _, tool := range b.session.Tools {
+			if tool.Type != "" && tool.Type != "function" {
+				continue
+			}
+			declaration := map[string]any{
+				"name":        tool.Name,
+				"description": tool.Description,
+			}
+			if tool.Parameters != nil {
+				declaration["parameters"] = cleanFunctionParameters(tool.Parameters)
+			}
+			declarations = append(declarations, declaration)
+		}
+		if len(declarations) > 0 {
+			setup["tools"] = []dto.GeminiChatTool{{FunctionDeclarations: declarations}}
+		}
+	}
+	if b.manualActivity {
+		setup["realtimeInputConfig"] = map[string]any{
+			"automaticActivityDetection": map[string]any{"disabled": true},
+		}
+	}
+	return map[string]any{"setup": setup}
+}
+
+func (b *geminiLiveBridge) addLocalUsage(textToken, audioToken int, input bool) {
+	b.localUsage.TotalTokens += textToken + audioToken
+	if input {
+		b.localUsage.InputTokens += textToken + audioToken
+		b.localUsage.InputTokenDetails.TextTokens += textToken
+		b.localUsage.InputTokenDetails.AudioTokens += audioToken
+	} else {
+		b.localUsage.OutputTokens += textToken + audioToken
+		b.localUsage.OutputTokenDetails.TextTokens += textToken
+		b.localUsage.OutputTokenDetails.AudioTokens += audioToken
+	}
+}
+
+// countLocal 上游未返回 usageMetadata 时按 OpenAI Realtime 事件本地估算
+func (b *geminiLiveBridge) countLocal(event dto.RealtimeEvent, input bool) error {
+	textToken, audioToken, err := service.CountTokenRealtime(b.info, event, b.info.UpstreamModelName)
+	if err != nil {
+		return fmt.Errorf("error counting text token: %v", err)
+	}
+	b.addLocalUsage(textToken, audioToken, input)
+	return nil
+}
+
+func (b *geminiLiveBridge) handleClientMessage(message []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	event := dto.RealtimeEvent{}
+	if err := common.Unmarshal(message, &event); err != nil {
+		return fmt.Errorf("error unmarshalling message: %v", err)
+	}
+	if event.Type == dto.RealtimeEventTypeSessionUpdate && event.Session != nil {
+		b.mergeSession(event.Session, message)
+	}
+	if err := b.countLocal(event, true); err != nil {
+		return err
+	}
+
+	if !b.setupSent {
+		if err := b.sendTarget(b.buildSetup()); err != nil {
+			return fmt.Errorf("error writing to target: %v", err)
+		}
+		b.setupSent = true
+		if event.Type == dto.RealtimeEventTypeSessionUpdate {
+			// session.updated 在收到 setupComplete 后返回
+			return nil
+		}
+	}
+
+	switch event.Type {
+	case dto.RealtimeEventTypeSessionUpdate:
+		// 会话已建立，只有音频格式等本地配置会生效
+		return b.emit(dto.RealtimeEventTypeSessionUpdated, map[string]any{"session": b.sessionObject()})
+	case dto.RealtimeEventInputAudioBufferAppend:
+		return b.appendAudio(event.Audio)
+	case "input_audio_buffer.commit":
+		return b.commitAudio()
+	case "input_audio_buffer.clear":
+		return b.emit("input_audio_buffer.cleared", nil)
+	case dto.RealtimeEventTypeConversationCreate:
+		if event.Item == nil {
+			return nil
+		}
+		return b.createItem(event.Item)
+	case dto.RealtimeEventTypeResponseCreate:
+		if b.skipResponseCreate {
+			// Gemini 在收到工具结果或 activityEnd 后会自动继续生成
+			b.skipResponseCreate = false
+			return nil
+		}
+		return b.target(map[string]any{"clientContent": map[string]any{"turnComplete": true}})
+	default:
+		logger.LogDebug(b.c, fmt.Sprintf("gemini live: ignore client event %s", event.Type))
+	}
+	return nil
+}
+
+func (b *geminiLiveBridge) target(message any) error {
+	if err := b.sendTarget(message); err != nil {
+		return fmt.Errorf("error writing to target: %v", err)
+	}
+	return nil
+}
+
+func (b *geminiLiveBridge) appendAudio(audio string) error {
+	data, err := convertRealtimeAudioToGemini(audio, b.info.InputAudioFormat)
+	if err != nil {
+		return fmt.Errorf("error converting input audio: %v", err)
+	}
+	if b.manualActivity && !b.activityActive {
+		if err = b.target(map[string]any{"realtimeInput": map[string]any{"activityStart": map[string]any{}}}); err != nil {
+			return err
+		}
+		b.activityActive = true
+	}
+	return b.target(map[string]any{
+		"realtimeInput": map[string]any{
+			"audio": dto.GeminiInlineData{
+				MimeType: fmt.Sprintf("audio/pcm;rate=%d", geminiLiveInputSampleRate),
+				Data:     data,
+			},
+		},
+	})
+}
+
+func (b *geminiLiveBridge) commitAudio() error {
+	if b.manualActivity && b.activityActive {
+		if err := b.target(map[string]any{"realtimeInput": map[string]any{"activityEnd": map[string]any{}}}); err != nil {
+			return err
+		}
+		b.activityActive = false
+		b.skipResponseCreate = true
+	}
+	if b.inputItemId == "" {
+		b.inputItemId = "item_" + common.GetRandomString(20)
+	}
+	return b.emit("input_audio_buffer.committed", map[string]any{"item_id": b.inputItemId})
+}
+
+func (b *geminiLiveBridge) createItem(item *dto.RealtimeItem) error {
+	if item.Id == "" {
+		item.Id = "item_" + common.GetRandomString(20)
+	}
+	switch item.Type {
+	case "message":
+		role := "user"
+		if item.Role == "assistant" {
+			role = "model"
+		}
+		parts := make([]dto.GeminiPart, 0, len(item.Content))
+		for _, content := range item.Content {
+			switch content.Type {
+			case "input_text", "text":
+				parts = append(parts, dto.GeminiPart{Text: content.Text})
+			case "input_audio":
+				data, err := convertRealtimeAudioToGemini(content.Audio, b.info.InputAudioFormat)
+				if err != nil {
+					return fmt.Errorf("error converting input audio: %v", err)
+				}
+				parts = append(parts, dto.GeminiPart{InlineData: &dto.GeminiInlineData{
+					MimeType: fmt.Sprintf("audio/pcm;rate=%d", geminiLiveInputSampleRate),
+					Data:     data,
+				}})
+			}
+		}
+		if len(parts) == 0 {
+			return nil
+		}
+		err := b.target(map[string]any{
+			"clientContent": map[string]any{
+				"turns":        []dto.GeminiChatContent{{Role: role, Parts: parts}},
+				"turnComplete": false,
+			},
+		})
+		if err != nil {
+			return err
+		}
+	case "function_call_output":
+		var response map[string]any
+		if err := common.UnmarshalJsonStr(item.Output, &response); err != nil || response == nil {
+			response = map[string]any{"output": item.Output}
+		}
+		b.addLocalUsage(service.CountTextToken(item.Output, b.info.UpstreamModelName), 0, true)
+		err := b.target(map[string]any{
+			"toolResponse": map[string]any{
+				"functionResponses": []map[string]any{{
+					"id":       item.CallId,
+					"name":     b.toolNames[item.CallId],
+					"response": response,
+				}},
+			},
+		})
+		if err != nil {
+			return err
+		}
+		b.skipResponseCreate = true
+	default:
+		return nil
+	}
+
+	created := dto.RealtimeEvent{Type: dto.RealtimeEventConversationItemCreated, Item: item}
+	if err := b.countLocal(created, true); err != nil {
+		return err
+	}
+	return b.emit(dto.RealtimeEventConversationItemCreated, map[string]any{"item": item})
+}
+
+func (b *geminiLiveBridge) handleServerMessage(message []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	msg := geminiLiveServerMessage{}
+	if err := common.Unmarshal(message, &msg); err != nil {
+		return fmt.Errorf("error unmarshalling message: %v", err)
+	}
+	if msg.UsageMetadata != nil {
+		// 同一轮内的 usageMetadata 为累计值，取最新一次
+		b.upstreamUsage = realtimeUsageFromGeminiLive(msg.UsageMetadata)
+	}
+
+	switch {
+	case msg.SetupComplete != nil:
+		return b.emit(dto.RealtimeEventTypeSessionUpdated, map[string]any{"session": b.sessionObject()})
+	case msg.ToolCall != nil:
+		return b.handleToolCall(msg.ToolCall)
+	case msg.ToolCallCancellation != nil:
+		logger.LogDebug(b.c, fmt.Sprintf("gemini live: tool calls cancelled %v", msg.ToolCallCancellation.Ids))
+	case msg.GoAway != nil:
+		logger.LogWarn(b.c, fmt.Sprintf("gemini live: upstream will close the session in %s", msg.GoAway.TimeLeft))
+	case msg.ServerContent != nil:
+		return b.handleServerContent(msg.ServerContent)
+	}
+	return nil
+}
+
+func (b *geminiLiveBridge) handleServerContent(content *geminiLiveServerContent) error {
+	if content.InputTranscription != nil && content.InputTranscription.Text != "" {
+		if b.inputItemId == "" {
+			b.inputItemId = "item_" + common.GetRandomString(20)
+		}
+		b.inputTranscript.WriteString(content.InputTranscription.Text)
+		err := b.emit("conversation.item.input_audio_transcription.delta", map[string]any{
+			"item_id":       b.inputItemId,
+			"content_index": 0,
+			"delta":         content.InputTranscription.Text,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if content.ModelTurn != nil {
+		for _, part := range content.ModelTurn.Parts {
+			if part.Thought {
+				continue
+			}
+			if part.InlineData != nil && strings.HasPrefix(part.InlineData.MimeType, "audio/") {
+				if err := b.audioDelta(part.InlineData); err != nil {
+					return err
+				}
+			} else if part.Text != "" {
+				if err := b.textDelta(part.Text); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if content.OutputTranscription != nil && content.OutputTranscription.Text != "" {
+		if err := b.ensureContent("audio"); err != nil {
+			return err
+		}
+		b.response.content.WriteString(content.OutputTranscription.Text)
+		if err := b.emitDelta(dto.RealtimeEventResponseAudioTranscriptionDelta, content.OutputTranscription.Text); err != nil {
+			return err
+		}
+	}
+
+	if content.Interrupted {
+		return b.finishResponse("cancelled", true)
+	}
+	if content.TurnComplete {
+		return b.finishResponse("completed", true)
+	}
+	return nil
+}
+
+func (b *geminiLiveBridge) ensureResponse() error {
+	if b.response != nil {
+		return nil
+	}
+	b.response = &geminiLiveResponse{id: "resp_" + common.GetRandomString(20)}
+	return b.emit("response.created", map[string]any{
+		"response": map[string]any{
+			"id":     b.response.id,
+			"object": "realtime.response",
+			"status": "in_progress",
+			"output": []any{},
+		},
+	})
+}
+
+func (b *geminiLiveBridge) ensureContent(contentType string) error {
+	if err := b.ensureResponse(); err != nil {
+		return err
+	}
+	resp := b.response
+	if resp.itemId != "" {
+		return nil
+	}
+	resp.itemId = "item_" + common.GetRandomString(20)
+	resp.contentType = contentType
+	err := b.emit("response.output_item.added", map[string]any{
+		"response_id":  resp.id,
+		"output_index": resp.outputIndex,
+		"item": map[string]any{
+			"id":      resp.itemId,
+			"object":  "realtime.item",
+			"type":    "message",
+			"status":  "in_progress",
+			"role":    "assistant",
+			"content": []any{},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return b.emit("response.content_part.added", map[string]any{
+		"response_id":   resp.id,
+		"item_id":       resp.itemId,
+		"output_index":  resp.outputIndex,
+		"content_index": 0,
+		"part":          b.contentPart(),
+	})
+}
+
+func (b *geminiLiveBridge) contentPart() map[string]any {
+	resp := b.response
+	if resp.contentType == "audio" {
+		return map[string]any{"type": "audio", "transcript": resp.content.String()}
+	}
+	return map[string]any{"type": "text", "text": resp.content.String()}
+}
+
+func (b *geminiLiveBridge) emitDelta(eventType string, delta string) error {
+	if err := b.countLocal(dto.RealtimeEvent{Type: eventType, Delta: delta}, false); err != nil {
+		return err
+	}
+	return b.emit(eventType, map[string]any{
+		"response_id":   b.response.id,
+		"item_id":       b.response.itemId,
+		"output_index":  b.response.outputIndex,
+		"content_index": 0,
+		"delta":         delta,
+	})
+}
+
+func (b *geminiLiveBridge) audioDelta(data *dto.GeminiInlineData) error {
+	if err := b.ensureContent("audio"); err != nil {
+		return err
+	}
+	audio, err := convertGeminiAudioToRealtime(data.Data, data.MimeType, b.info.OutputAudioFormat)
+	if err != nil {
+		return fmt.Errorf("error converting output audio: %v", err)
+	}
+	return b.emitDelta(dto.RealtimeEventResponseAudioDelta, audio)
+}
+
+func (b *geminiLiveBridge) textDelta(text string) error {
+	if err := b.ensureContent("text"); err != nil {
+		return err
+	}
+	if b.response.contentType == "audio" {
+		// 音频模式下的文本按转写处理
+		b.response.content.WriteString(text)
+		return b.emitDelta(dto.RealtimeEventResponseAudioTranscriptionDelta, text)
+	}
+	b.response.content.WriteString(text)
+	if err := b.emitDelta("response.text.delta", text); err != nil {
+		return err
+	}
+	// CountTokenRealtime 不统计 response.text.delta
+	b.addLocalUsage(service.CountTextToken(text, b.info.UpstreamModelName), 0, false)
+	return nil
+}
+
+// closeMessage 结束当前 assistant 消息并发送对应的 done 事件
+func (b *geminiLiveBridge) closeMessage(status string) error {
+	resp := b.response
+	if resp == nil || resp.itemId == "" {
+		return nil
+	}
+	base := func(extra map[string]any) map[string]any {
+		fields := map[string]any{
+			"response_id":   resp.id,
+			"item_id":       resp.itemId,
+			"output_index":  resp.outputIndex,
+			"content_index": 0,
+		}
+		for k, v := range extra {
+			fields[k] = v
+		}
+		return fields
+	}
+	if resp.contentType == "audio" {
+		if err := b.emit("response.audio.done", base(nil)); err != nil {
+			return err
+		}
+		if err := b.emit("response.audio_transcript.done", base(map[string]any{"transcript": resp.content.String()})); err != nil {
+			return err
+		}
+	} else {
+		if err := b.emit("response.text.done", base(map[string]any{"text": resp.content.String()})); err != nil {
+			return err
+		}
+	}
+	part := b.contentPart()
+	if err := b.emit("response.content_part.done", base(map[string]any{"part": part})); err != nil {
+		return err
+	}
+	item := map[string]any{
+		"id":      resp.itemId,
+		"object":  "realtime.item",
+		"type":    "message",
+		"status":  status,
+		"role":    "assistant",
+		"content": []any{part},
+	}
+	if err := b.emit("response.output_item.done", map[string]any{
+		"response_id":  resp.id,
+		"output_index": resp.outputIndex,
+		"item":         item,
+	}); err != nil {
+		return err
+	}
+	resp.output = append(resp.output, item)
+	resp.outputIndex++
+	resp.itemId = ""
+	resp.contentType = ""
+	resp.content.Reset()
+	return nil
+}
+
+// handleToolCall Gemini 在等待工具结果时不会结束本轮，这里按 OpenAI 语义先结束响应，计费留到 turnComplete
+func (b *geminiLiveBridge) handleToolCall(toolCall *geminiLiveToolCall) error {
+	if err := b.ensureResponse(); err != nil {
+		return err
+	}
+	if err := b.closeMessage("completed"); err != nil {
+		return err
+	}
+	resp := b.response
+	for _, call := range toolCall.FunctionCalls {
+		b.toolNames[call.Id] = call.Name
+		arguments := "{}"
+		if len(call.Args) > 0 {
+			arguments = string(call.Args)
+		}
+		item := map[string]any{
+			"id":        "item_" + common.GetRandomString(20),
+			"object":    "realtime.item",
+			"type":      "function_call",
+			"status":    "in_progress",
+			"name":      call.Name,
+			"call_id":   call.Id,
+			"arguments": "",
+		}
+		if err := b.emit("response.output_item.added", map[string]any{
+			"response_id":  resp.id,
+			"output_index": resp.outputIndex,
+			"item":         item,
+		}); err != nil {
+			return err
+		}
+		argumentsEvent := dto.RealtimeEvent{Type: dto.RealtimeEventResponseFunctionCallArgumentsDelta, Delta: arguments}
+		if err := b.countLocal(argumentsEvent, false); err != nil {
+			return err
+		}
+		if err := b.emit(dto.RealtimeEventResponseFunctionCallArgumentsDone, map[string]any{
+			"response_id":  resp.id,
+			"item_id":      item["id"],
+			"output_index": resp.outputIndex,
+			"call_id":      call.Id,
+			"name":         call.Name,
+			"arguments":    arguments,
+		}); err != nil {
+			return err
+		}
+		item["status"] = "completed"
+		item["arguments"] = arguments
+		if err := b.emit("response.output_item.done", map[string]any{
+			"response_id":  resp.id,
+			"output_index": resp.outputIndex,
+			"item":         item,
+		}); err != nil {
+			return err
+		}
+		resp.output = append(resp.output, item)
+		resp.outputIndex++
+	}
+	return b.finishResponse("completed", false)
+}
+
+// finishResponse 发送 response.done，bill 为 true 时按本轮用量预扣费
+func (b *geminiLiveBridge) finishResponse(status string, bill bool) error {
+	if b.inputItemId != "" && b.inputTranscript.Len() > 0 {
+		err := b.emit("conversation.item.input_audio_transcription.completed", map[string]any{
+			"item_id":       b.inputItemId,
+			"content_index": 0,
+			"transcript":    b.inputTranscript.String(),
+		})
+		if err != nil {
+			return err
+		}
+		b.inputItemId = ""
+		b.inputTranscript.Reset()
+	}
+
+	var usage *dto.RealtimeUsage
+	if bill {
+		usage = b.turnUsage()
+	}
+	if b.response != nil {
+		itemStatus := "completed"
+		if status == "cancelled" {
+			itemStatus = "incomplete"
+		}
+		if err := b.closeMessage(itemStatus); err != nil {
+			return err
+		}
+		output := b.response.output
+		if output == nil {
+			output = []map[string]any{}
+		}
+		err := b.emit(dto.RealtimeEventTypeResponseDone, map[string]any{
+			"response": map[string]any{
+				"id":     b.response.id,
+				"object": "realtime.response",
+				"status": status,
+				"output": output,
+				"usage":  usage,
+			},
+		})
+		if err != nil {
+			return err
+		}
+		b.response = nil
+	}
+	if usage != nil && usage.TotalTokens != 0 {
+		if err := b.consume(usage); err != nil {
+			return fmt.Errorf("error consume usage: %v", err)
+		}
+		logger.LogInfo(b.c, fmt.Sprintf("realtime streaming sumUsage: %v", b.sumUsage))
+	}
+	return nil
+}
+
+// turnUsage 优先使用上游 usageMetadata，否则使用本地估算，取出后清零
+func (b *geminiLiveBridge) turnUsage() *dto.RealtimeUsage {
+	usage := b.upstreamUsage
+	if usage == nil {
+		// 与 OpenAI Realtime 一致，从第二轮开始计入工具定义的 token
+		_ = b.countLocal(dto.RealtimeEvent{Type: dto.RealtimeEventTypeResponseDone}, true)
+		b.info.IsFirstRequest = false
+		usage = b.localUsage
+	}
+	b.upstreamUsage = nil
+	b.localUsage = &dto.RealtimeUsage{}
+	return usage
+}
+
+// flush 连接关闭时结算未完成轮次的用量
+func (b *geminiLiveBridge) flush() *dto.RealtimeUsage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	usage := b.upstreamUsage
+	if usage == nil {
+		usage = b.localUsage
+	}
+	if usage.TotalTokens != 0 {
+		_ = b.consume(usage)
+	}
+	b.upstreamUsage = nil
+	b.localUsage = &dto.RealtimeUsage{}
+	return b.sumUsage
+}
+
+func realtimeUsageFromGeminiLive(metadata *geminiLiveUsageMetadata) *dto.RealtimeUsage {
+	usage := &dto.RealtimeUsage{InputTokens: metadata.PromptTokenCount}
+	responseDetails := metadata.ResponseTokensDetails
+	usage.OutputTokens = metadata.ResponseTokenCount
+	if usage.OutputTokens == 0 {
+		usage.OutputTokens = metadata.CandidatesTokenCount
+		responseDetails = metadata.CandidatesTokensDetails
+	}
+	usage.OutputTokens += metadata.ThoughtsTokenCount
+
+	for _, detail := range metadata.PromptTokensDetails {
+		if detail.Modality == "AUDIO" {
+			usage.InputTokenDetails.AudioTokens += detail.TokenCount
+		}
+	}
+	usage.InputTokenDetails.TextTokens = usage.InputTokens - usage.InputTokenDetails.AudioTokens
+	for _, detail := range responseDetails {
+		if detail.Modality == "AUDIO" {
+			usage.OutputTokenDetails.AudioTokens += detail.TokenCount
+		}
+	}
+	usage.OutputTokenDetails.TextTokens = usage.OutputTokens - usage.OutputTokenDetails.AudioTokens
+
+	usage.TotalTokens = metadata.TotalTokenCount
+	if usage.TotalTokens == 0 {
+		usage.TotalTokens = usage.InputTokens + usage.OutputTokens
+	}
+	return usage
+}
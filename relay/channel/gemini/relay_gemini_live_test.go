@@ -0,0 +1,164 @@
+package gemini
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+type liveBridgeRecorder struct {
+	bridge   *geminiLiveBridge
+	client   []map[string]any
+	target   []string
+	consumed []*dto.RealtimeUsage
+}
+
+func newLiveBridgeRecorder(t *testing.T) *liveBridgeRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/v1/realtime?model=gemini-live-2.5-flash-preview", nil)
+	info := &relaycommon.RelayInfo{
+		InputAudioFormat:  "pcm16",
+		OutputAudioFormat: "pcm16",
+		IsFirstRequest:    true,
+		ChannelMeta:       &relaycommon.ChannelMeta{UpstreamModelName: "gemini-live-2.5-flash-preview"},
+	}
+	r := &liveBridgeRecorder{}
+	r.bridge = newGeminiLiveBridge(c, info,
+		func(event map[string]any) error {
+			r.client = append(r.client, event)
+			return nil
+		},
+		func(message any) error {
+			data, err := common.Marshal(message)
+			require.NoError(t, err)
+			r.target = append(r.target, string(data))
+			return nil
+		},
+	)
+	r.bridge.consume = func(usage *dto.RealtimeUsage) error {
+		r.consumed = append(r.consumed, usage)
+		return nil
+	}
+	return r
+}
+
+func (r *liveBridgeRecorder) clientTypes() []string {
+	eventTypes := make([]string, 0, len(r.client))
+	for _, event := range r.client {
+		eventTypes = append(eventTypes, event["type"].(string))
+	}
+	return eventTypes
+}
+
+func TestGeminiLiveBridgeSetupAndAudio(t *testing.T) {
+	r := newLiveBridgeRecorder(t)
+	session := `{"type":"session.update","session":{"modalities":["text","audio"],"instructions":"be brief","voice":"kore",` +
+		`"input_audio_format":"g711_ulaw","turn_detection":null,` +
+		`"tools":[{"type":"function","name":"get_weather","description":"weather","parameters":{"type":"object","properties":{"city":{"type":"string"}},"additionalProperties":false}}]}}`
+	require.NoError(t, r.bridge.handleClientMessage([]byte(session)))
+	require.Len(t, r.target, 1)
+
+	setup := gjson.Parse(r.target[0]).Get("setup")
+	assert.Equal(t, "models/gemini-live-2.5-flash-preview", setup.Get("model").String())
+	assert.Equal(t, "AUDIO", setup.Get("generationConfig.responseModalities.0").String())
+	assert.Equal(t, "Kore", setup.Get("generationConfig.speechConfig.voiceConfig.prebuiltVoiceConfig.voiceName").String())
+	assert.Equal(t, "be brief", setup.Get("systemInstruction.parts.0.text").String())
+	assert.Equal(t, "get_weather", setup.Get("tools.0.functionDeclarations.0.name").String())
+	assert.False(t, setup.Get("tools.0.functionDeclarations.0.parameters.additionalProperties").Exists())
+	assert.True(t, setup.Get("realtimeInputConfig.automaticActivityDetection.disabled").Bool())
+
+	// 8kHz μ-law 转为 16kHz PCM16，手动模式下先发送 activityStart
+	ulaw := base64.StdEncoding.EncodeToString([]byte{0xFF, 0x7F, 0x00, 0x80})
+	require.NoError(t, r.bridge.handleClientMessage([]byte(`{"type":"input_audio_buffer.append","audio":"`+ulaw+`"}`)))
+	require.NoError(t, r.bridge.handleClientMessage([]byte(`{"type":"input_audio_buffer.commit"}`)))
+	require.NoError(t, r.bridge.handleClientMessage([]byte(`{"type":"response.create"}`)))
+	require.Len(t, r.target, 4)
+	assert.True(t, gjson.Get(r.target[1], "realtimeInput.activityStart").Exists())
+	audio := gjson.Get(r.target[2], "realtimeInput.audio")
+	assert.Equal(t, "audio/pcm;rate=16000", audio.Get("mimeType").String())
+	pcm, err := base64.StdEncoding.DecodeString(audio.Get("data").String())
+	require.NoError(t, err)
+	assert.Len(t, pcm, 16)
+	assert.True(t, gjson.Get(r.target[3], "realtimeInput.activityEnd").Exists())
+
+	require.NoError(t, r.bridge.handleServerMessage([]byte(`{"setupComplete":{}}`)))
+	assert.Equal(t, []string{"input_audio_buffer.committed", "session.updated"}, r.clientTypes())
+	assert.Equal(t, "g711_ulaw", r.client[1]["session"].(map[string]any)["input_audio_format"])
+}
+
+func TestGeminiLiveBridgeResponseAndToolCall(t *testing.T) {
+	r := newLiveBridgeRecorder(t)
+	require.NoError(t, r.bridge.handleClientMessage([]byte(`{"type":"conversation.item.create","item":{"type":"message","role":"user","content":[{"type":"input_text","text":"weather in Paris?"}]}}`)))
+	require.NoError(t, r.bridge.handleClientMessage([]byte(`{"type":"response.create"}`)))
+	require.Len(t, r.target, 3)
+	assert.Equal(t, "weather in Paris?", gjson.Get(r.target[1], "clientContent.turns.0.parts.0.text").String())
+	assert.True(t, gjson.Get(r.target[2], "clientContent.turnComplete").Bool())
+
+	require.NoError(t, r.bridge.handleServerMessage([]byte(`{"toolCall":{"functionCalls":[{"id":"call_1","name":"get_weather","args":{"city":"Paris"}}]}}`)))
+	done := r.client[len(r.client)-1]
+	assert.Equal(t, "response.done", done["type"])
+	assert.Empty(t, r.consumed)
+	args := r.client[len(r.client)-3]
+	assert.Equal(t, "response.function_call_arguments.done", args["type"])
+	assert.JSONEq(t, `{"city":"Paris"}`, args["arguments"].(string))
+
+	require.NoError(t, r.bridge.handleClientMessage([]byte(`{"type":"conversation.item.create","item":{"type":"function_call_output","call_id":"call_1","output":"{\"temp\":20}"}}`)))
+	require.NoError(t, r.bridge.handleClientMessage([]byte(`{"type":"response.create"}`)))
+	require.Len(t, r.target, 4)
+	response := gjson.Get(r.target[3], "toolResponse.functionResponses.0")
+	assert.Equal(t, "get_weather", response.Get("name").String())
+	assert.Equal(t, int64(20), response.Get("response.temp").Int())
+
+	r.client = nil
+	pcm := base64.StdEncoding.EncodeToString(make([]byte, 480))
+	require.NoError(t, r.bridge.handleServerMessage([]byte(`{"serverContent":{"modelTurn":{"parts":[{"inlineData":{"mimeType":"audio/pcm;rate=24000","data":"`+pcm+`"}}]}}}`)))
+	require.NoError(t, r.bridge.handleServerMessage([]byte(`{"serverContent":{"outputTranscription":{"text":"It is 20 degrees."}}}`)))
+	require.NoError(t, r.bridge.handleServerMessage([]byte(`{"serverContent":{"turnComplete":true},"usageMetadata":{"promptTokenCount":30,"responseTokenCount":50,"totalTokenCount":80,`+
+		`"promptTokensDetails":[{"modality":"TEXT","tokenCount":30}],"responseTokensDetails":[{"modality":"AUDIO","tokenCount":45},{"modality":"TEXT","tokenCount":5}]}}`)))
+	assert.Equal(t, []string{
+		"response.created", "response.output_item.added", "response.content_part.added", "response.audio.delta",
+		"response.audio_transcript.delta", "response.audio.done", "response.audio_transcript.done",
+		"response.content_part.done", "response.output_item.done", "response.done",
+	}, r.clientTypes())
+
+	require.Len(t, r.consumed, 1)
+	assert.Equal(t, 80, r.consumed[0].TotalTokens)
+	assert.Equal(t, 30, r.consumed[0].InputTokenDetails.TextTokens)
+	assert.Equal(t, 45, r.consumed[0].OutputTokenDetails.AudioTokens)
+	assert.Equal(t, 5, r.consumed[0].OutputTokenDetails.TextTokens)
+}
+
+func TestRealtimeAudioConversion(t *testing.T) {
+	for _, sample := range []int16{0, 1000, -1000, 12345, -32768, 32767} {
+		assert.InDelta(t, float64(sample), float64(uLawToLinear(linearToULaw(sample))), float64(abs16(sample))/16+8)
+		assert.InDelta(t, float64(sample), float64(aLawToLinear(linearToALaw(sample))), float64(abs16(sample))/16+16)
+	}
+
+	pcm24k := encodePCM16([]int16{0, 300, 600, 900, 1200, 1500})
+	out, err := convertGeminiAudioToRealtime(base64.StdEncoding.EncodeToString(pcm24k), "audio/pcm;rate=24000", "pcm16")
+	require.NoError(t, err)
+	assert.Equal(t, base64.StdEncoding.EncodeToString(pcm24k), out)
+
+	out, err = convertGeminiAudioToRealtime(base64.StdEncoding.EncodeToString(pcm24k), "audio/pcm;rate=24000", "g711_alaw")
+	require.NoError(t, err)
+	alaw, _ := base64.StdEncoding.DecodeString(out)
+	assert.Len(t, alaw, 2)
+}
+
+func abs16(v int16) int {
+	if v < 0 {
+		return -int(v)
+	}
+	return int(v)
+}
@@ -0,0 +1,86 @@
+package openai
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestDoRequestReusesResponsesWebSocket(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var dials int
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/responses", r.URL.Path)
+		assert.Equal(t, "Bearer sk-test", r.Header.Get("Authorization"))
+		conn, err := upgrader.Upgrade(w, r, nil)
+		require.NoError(t, err)
+		defer conn.Close()
+		dials++
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			assert.Equal(t, "response.create", gjson.GetBytes(message, "type").String())
+			assert.False(t, gjson.GetBytes(message, "stream").Exists())
+			if gjson.GetBytes(message, "input").String() == "bad" {
+				_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"error","status":400,"error":{"type":"invalid_request_error","message":"bad input"}}`))
+				continue
+			}
+			_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"response.created"}`))
+			_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"response.completed","response":{"usage":{"input_tokens":3,"output_tokens":5}}}`))
+		}
+	}))
+	defer server.Close()
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/responses", nil)
+	session := channel.NewResponsesWssSession()
+	defer session.Close()
+	common.SetContextKey(c, constant.ContextKeyResponsesWssSession, session)
+
+	info := &relaycommon.RelayInfo{
+		RelayMode:      relayconstant.RelayModeResponses,
+		IsStream:       true,
+		RequestURLPath: "/v1/responses",
+		ChannelMeta: &relaycommon.ChannelMeta{
+			ChannelType:    constant.ChannelTypeOpenAI,
+			ChannelBaseUrl: server.URL,
+			ApiKey:         "sk-test",
+		},
+	}
+	adaptor := &Adaptor{}
+	for i := 0; i < 2; i++ {
+		resp, err := adaptor.DoRequest(c, info, bytes.NewBufferString(`{"model":"gpt-5","input":"hi","stream":true}`))
+		require.NoError(t, err)
+		httpResp := resp.(*http.Response)
+		require.Equal(t, http.StatusOK, httpResp.StatusCode)
+		body, err := io.ReadAll(httpResp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, 2, strings.Count(string(body), "data: "))
+		assert.Contains(t, string(body), `"type":"response.completed"`)
+	}
+
+	resp, err := adaptor.DoRequest(c, info, bytes.NewBufferString(`{"model":"gpt-5","input":"bad","stream":true}`))
+	require.NoError(t, err)
+	httpResp := resp.(*http.Response)
+	assert.Equal(t, http.StatusBadRequest, httpResp.StatusCode)
+	body, _ := io.ReadAll(httpResp.Body)
+	assert.Equal(t, "bad input", gjson.GetBytes(body, "error.message").String())
+	assert.Equal(t, 1, dials)
+}
@@ -0,0 +1,164 @@
+package channel
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/QuantumNous/new-api/relay/common"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// ResponsesWssSession 客户端 Responses WebSocket 连接内复用的上游 WebSocket 连接，按上游地址与密钥区分。
+// 同一客户端连接上的请求是串行的，每个上游连接同一时间只承载一轮响应
+type ResponsesWssSession struct {
+	mu    sync.Mutex
+	conns map[string]*websocket.Conn
+}
+
+func NewResponsesWssSession() *ResponsesWssSession {
+	return &ResponsesWssSession{conns: make(map[string]*websocket.Conn)}
+}
+
+func (s *ResponsesWssSession) get(key string) *websocket.Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns[key]
+}
+
+func (s *ResponsesWssSession) put(key string, conn *websocket.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conns[key] = conn
+}
+
+// drop 上游连接出错后丢弃，下一轮重新建立
+func (s *ResponsesWssSession) drop(key string, conn *websocket.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns[key] == conn {
+		delete(s.conns, key)
+	}
+	_ = conn.Close()
+}
+
+func (s *ResponsesWssSession) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, conn := range s.conns {
+		_ = conn.Close()
+		delete(s.conns, key)
+	}
+}
+
+// isResponsesTerminalEvent 一轮响应结束的事件
+func isResponsesTerminalEvent(eventType string) bool {
+	switch eventType {
+	case "response.completed", "response.failed", "response.incomplete", "error":
+		return true
+	}
+	return false
+}
+
+// DoResponsesWssRequest 通过上游 Responses WebSocket 发送一轮 response.create，
+// 并将返回的事件转换为 SSE 流式响应，以便复用现有的 Responses 流式处理与计费逻辑
+func DoResponsesWssRequest(a Adaptor, c *gin.Context, info *common.RelayInfo, requestBody io.Reader, session *ResponsesWssSession) (*http.Response, error) {
+	fullRequestURL, err := a.GetRequestURL(info)
+	if err != nil {
+		return nil, fmt.Errorf("get request url failed: %w", err)
+	}
+	fullRequestURL = strings.Replace(fullRequestURL, "https://", "wss://", 1)
+	fullRequestURL = strings.Replace(fullRequestURL, "http://", "ws://", 1)
+
+	body, err := io.ReadAll(requestBody)
+	if err != nil {
+		return nil, err
+	}
+	// WebSocket 模式下请求参数与 HTTP 相同，但不需要 stream 与 background
+	if body, err = sjson.SetBytes(body, "type", "response.create"); err != nil {
+		return nil, err
+	}
+	body, _ = sjson.DeleteBytes(body, "stream")
+	body, _ = sjson.DeleteBytes(body, "background")
+
+	sessionKey := fullRequestURL + "|" + info.ApiKey
+	conn := session.get(sessionKey)
+	if conn == nil {
+		targetHeader := http.Header{}
+		if err = a.SetupRequestHeader(c, &targetHeader, info); err != nil {
+			return nil, fmt.Errorf("setup request header failed: %w", err)
+		}
+		headerOverride, err := processHeaderOverride(info, c)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range headerOverride {
+			targetHeader.Set(key, value)
+		}
+		targetHeader.Del("Content-Type")
+		targetHeader.Del("Accept")
+		conn, _, err = websocket.DefaultDialer.Dial(fullRequestURL, targetHeader)
+		if err != nil {
+			return nil, fmt.Errorf("dial failed to %s: %w", fullRequestURL, err)
+		}
+		session.put(sessionKey, conn)
+	}
+
+	if err = conn.WriteMessage(websocket.TextMessage, body); err != nil {
+		session.drop(sessionKey, conn)
+		return nil, fmt.Errorf("write to upstream failed: %w", err)
+	}
+
+	// 首个事件为 error 时按 HTTP 错误返回，便于走统一的错误处理与重试
+	_, first, err := conn.ReadMessage()
+	if err != nil {
+		session.drop(sessionKey, conn)
+		return nil, fmt.Errorf("read from upstream failed: %w", err)
+	}
+	if gjson.GetBytes(first, "type").String() == "error" {
+		statusCode := int(gjson.GetBytes(first, "status").Int())
+		if statusCode < http.StatusBadRequest {
+			statusCode = http.StatusBadRequest
+		}
+		errorBody := fmt.Sprintf(`{"error":%s}`, gjson.GetBytes(first, "error").Raw)
+		return &http.Response{
+			StatusCode: statusCode,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(errorBody)),
+		}, nil
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		message := first
+		for {
+			if _, err := fmt.Fprintf(writer, "data: %s\n\n", message); err != nil {
+				// 下游已停止读取，上游连接上可能仍有本轮剩余事件，直接丢弃连接
+				session.drop(sessionKey, conn)
+				return
+			}
+			if isResponsesTerminalEvent(gjson.GetBytes(message, "type").String()) {
+				_ = writer.Close()
+				return
+			}
+			var readErr error
+			if _, message, readErr = conn.ReadMessage(); readErr != nil {
+				session.drop(sessionKey, conn)
+				_ = writer.CloseWithError(readErr)
+				return
+			}
+		}
+	}()
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       reader,
+	}, nil
+}
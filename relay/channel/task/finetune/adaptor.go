@@ -0,0 +1,294 @@
+package finetune
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/relay/channel"
+	"github.com/QuantumNous/new-api/relay/channel/task/taskcommon"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/tidwall/sjson"
+)
+
+// ============================
+// Request / Response structures
+// ============================
+
+// fineTuningRequest 仅声明网关需要处理的字段，其余字段原样透传给上游
+type fineTuningRequest struct {
+	Model          string `json:"model"`
+	TrainingFile   string `json:"training_file"`
+	ValidationFile string `json:"validation_file,omitempty"`
+}
+
+type responseJob struct {
+	ID             string `json:"id"`
+	Object         string `json:"object"`
+	Model          string `json:"model"`
+	Status         string `json:"status"`
+	FineTunedModel string `json:"fine_tuned_model,omitempty"`
+	TrainedTokens  int    `json:"trained_tokens,omitempty"`
+	CreatedAt      int64  `json:"created_at"`
+	FinishedAt     int64  `json:"finished_at,omitempty"`
+	Error          *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+		Param   string `json:"param"`
+	} `json:"error,omitempty"`
+}
+
+// ============================
+// Adaptor implementation
+// ============================
+
+// TaskAdaptor 将 OpenAI 微调任务作为异步任务处理：创建时预扣费，
+// 轮询到终态后按 trained_tokens 结算。
+type TaskAdaptor struct {
+	taskcommon.BaseBilling
+	ChannelType  int
+	apiKey       string
+	baseURL      string
+	apiVersion   string
+	organization string
+}
+
+func (a *TaskAdaptor) Init(info *relaycommon.RelayInfo) {
+	a.ChannelType = info.ChannelType
+	a.baseURL = info.ChannelBaseUrl
+	a.apiKey = info.ApiKey
+	a.apiVersion = info.ApiVersion
+	a.organization = info.Organization
+}
+
+// JobsURL 构造上游微调任务接口地址，path 为任务ID及其后的子路径（可为空）。
+// Azure 渠道使用 /openai/fine_tuning/jobs 并携带 api-version
+func JobsURL(channelType int, baseURL string, apiVersion string, path string) string {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	if channelType == constant.ChannelTypeAzure {
+		if apiVersion == "" {
+			apiVersion = constant.AzureDefaultAPIVersion
+		}
+		return fmt.Sprintf("%s/openai/fine_tuning/jobs%s?api-version=%s", baseURL, path, url.QueryEscape(apiVersion))
+	}
+	return fmt.Sprintf("%s/v1/fine_tuning/jobs%s", baseURL, path)
+}
+
+// SetupHeader 设置上游鉴权头，与 OpenAI 渠道适配器保持一致
+func SetupHeader(header http.Header, channelType int, key string, organization string) {
+	if channelType == constant.ChannelTypeAzure {
+		header.Set("api-key", key)
+		return
+	}
+	header.Set("Authorization", "Bearer "+key)
+	if channelType == constant.ChannelTypeOpenAI && organization != "" {
+		header.Set("OpenAI-Organization", organization)
+	}
+}
+
+// ChannelJobsURL 按渠道配置构造上游微调任务接口地址，Azure 渠道的 api-version 取自渠道的 other 字段
+func ChannelJobsURL(ch *model.Channel, path string) string {
+	baseURL := ch.GetBaseURL()
+	if baseURL == "" && ch.Type >= 0 && ch.Type < len(constant.ChannelBaseURLs) {
+		baseURL = constant.ChannelBaseURLs[ch.Type]
+	}
+	return JobsURL(ch.Type, baseURL, ch.Other, path)
+}
+
+// SetupChannelHeader 按渠道配置设置上游鉴权头
+func SetupChannelHeader(header http.Header, ch *model.Channel, key string) {
+	organization := ""
+	if ch.OpenAIOrganization != nil {
+		organization = *ch.OpenAIOrganization
+	}
+	SetupHeader(header, ch.Type, key, organization)
+}
+
+func (a *TaskAdaptor) ValidateRequestAndSetAction(c *gin.Context, info *relaycommon.RelayInfo) *dto.TaskError {
+	var req fineTuningRequest
+	if err := common.UnmarshalBodyReusable(c, &req); err != nil {
+		return service.TaskErrorWrapperLocal(err, "invalid_request", http.StatusBadRequest)
+	}
+	if strings.TrimSpace(req.Model) == "" {
+		return service.TaskErrorWrapperLocal(fmt.Errorf("field model is required"), "invalid_request", http.StatusBadRequest)
+	}
+	if strings.TrimSpace(req.TrainingFile) == "" {
+		return service.TaskErrorWrapperLocal(fmt.Errorf("field training_file is required"), "invalid_request", http.StatusBadRequest)
+	}
+	info.Action = constant.TaskActionFineTuning
+	return nil
+}
+
+// AdjustBillingOnComplete 按上游返回的 trained_tokens 计算最终额度：
+// trained_tokens × 模型倍率 × 分组倍率（均取自提交时的计费快照）。
+func (a *TaskAdaptor) AdjustBillingOnComplete(task *model.Task, taskResult *relaycommon.TaskInfo) int {
+	bc := task.PrivateData.BillingContext
+	if bc == nil || bc.ModelRatio <= 0 || taskResult.TotalTokens <= 0 {
+		return 0
+	}
+	quota := float64(taskResult.TotalTokens) * bc.ModelRatio * bc.GroupRatio
+	for _, ratio := range bc.OtherRatios {
+		if ratio != 1.0 && ratio > 0 {
+			quota *= ratio
+		}
+	}
+	return int(quota)
+}
+
+func (a *TaskAdaptor) BuildRequestURL(info *relaycommon.RelayInfo) (string, error) {
+	return JobsURL(a.ChannelType, a.baseURL, a.apiVersion, ""), nil
+}
+
+func (a *TaskAdaptor) BuildRequestHeader(c *gin.Context, req *http.Request, info *relaycommon.RelayInfo) error {
+	SetupHeader(req.Header, a.ChannelType, a.apiKey, a.organization)
+	req.Header.Set("Content-Type", "application/json")
+	return nil
+}
+
+// BuildRequestBody 替换为上游模型名，并将网关文件ID转换为当前渠道的上游文件ID
+func (a *TaskAdaptor) BuildRequestBody(c *gin.Context, info *relaycommon.RelayInfo) (io.Reader, error) {
+	storage, err := common.GetBodyStorage(c)
+	if err != nil {
+		return nil, errors.Wrap(err, "get_request_body_failed")
+	}
+	body, err := storage.Bytes()
+	if err != nil {
+		return nil, errors.Wrap(err, "read_body_bytes_failed")
+	}
+	var req fineTuningRequest
+	if err := common.Unmarshal(body, &req); err != nil {
+		return nil, errors.Wrap(err, "unmarshal_request_failed")
+	}
+	if body, err = sjson.SetBytes(body, "model", info.UpstreamModelName); err != nil {
+		return nil, err
+	}
+	trainingFile, err := service.ResolveUpstreamFileId(c, info, req.TrainingFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolve_training_file_failed")
+	}
+	if body, err = sjson.SetBytes(body, "training_file", trainingFile); err != nil {
+		return nil, err
+	}
+	if req.ValidationFile != "" {
+		validationFile, err := service.ResolveUpstreamFileId(c, info, req.ValidationFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "resolve_validation_file_failed")
+		}
+		if body, err = sjson.SetBytes(body, "validation_file", validationFile); err != nil {
+			return nil, err
+		}
+	}
+	return bytes.NewReader(body), nil
+}
+
+func (a *TaskAdaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody io.Reader) (*http.Response, error) {
+	return channel.DoTaskApiRequest(a, c, info, requestBody)
+}
+
+// DoResponse 返回上游任务对象，但将任务ID替换为公开 task ID，后续查询通过它锁定到创建时的渠道
+func (a *TaskAdaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (taskID string, taskData []byte, taskErr *dto.TaskError) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		taskErr = service.TaskErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError)
+		return
+	}
+	_ = resp.Body.Close()
+
+	var job responseJob
+	if err := common.Unmarshal(responseBody, &job); err != nil {
+		taskErr = service.TaskErrorWrapper(errors.Wrapf(err, "body: %s", responseBody), "unmarshal_response_body_failed", http.StatusInternalServerError)
+		return
+	}
+	if job.ID == "" {
+		taskErr = service.TaskErrorWrapper(fmt.Errorf("job id is empty"), "invalid_response", http.StatusInternalServerError)
+		return
+	}
+
+	publicBody, err := sjson.SetBytes(responseBody, "id", info.PublicTaskID)
+	if err != nil {
+		taskErr = service.TaskErrorWrapper(err, "set_response_id_failed", http.StatusInternalServerError)
+		return
+	}
+	c.Data(http.StatusOK, "application/json", publicBody)
+	return job.ID, responseBody, nil
+}
+
+// FetchTask 查询上游微调任务状态，body 中的 channel_id 用于确定渠道类型、api-version 与组织
+func (a *TaskAdaptor) FetchTask(baseUrl, key string, body map[string]any, proxy string) (*http.Response, error) {
+	taskID, ok := body["task_id"].(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid task_id")
+	}
+
+	channelType, apiVersion, organization := constant.ChannelTypeOpenAI, "", ""
+	if channelId, ok := body["channel_id"].(int); ok {
+		if ch, err := model.CacheGetChannel(channelId); err == nil {
+			channelType, apiVersion = ch.Type, ch.Other
+			if ch.OpenAIOrganization != nil {
+				organization = *ch.OpenAIOrganization
+			}
+		}
+	}
+	req, err := http.NewRequest(http.MethodGet, JobsURL(channelType, baseUrl, apiVersion, "/"+taskID), nil)
+	if err != nil {
+		return nil, err
+	}
+	SetupHeader(req.Header, channelType, key, organization)
+
+	client, err := service.GetHttpClientWithProxy(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("new proxy http client failed: %w", err)
+	}
+	return client.Do(req)
+}
+
+func (a *TaskAdaptor) GetModelList() []string {
+	return ModelList
+}
+
+func (a *TaskAdaptor) GetChannelName() string {
+	return ChannelName
+}
+
+func (a *TaskAdaptor) ParseTaskResult(respBody []byte) (*relaycommon.TaskInfo, error) {
+	var job responseJob
+	if err := common.Unmarshal(respBody, &job); err != nil {
+		return nil, errors.Wrap(err, "unmarshal task result failed")
+	}
+
+	taskResult := relaycommon.TaskInfo{
+		Code:        0,
+		TaskID:      job.ID,
+		TotalTokens: job.TrainedTokens,
+	}
+	switch job.Status {
+	case "validating_files", "queued":
+		taskResult.Status = model.TaskStatusQueued
+	case "running":
+		taskResult.Status = model.TaskStatusInProgress
+	case "succeeded":
+		taskResult.Status = model.TaskStatusSuccess
+		// 微调结果是模型名而不是文件，作为任务结果记录
+		taskResult.Url = job.FineTunedModel
+	case "failed", "cancelled":
+		taskResult.Status = model.TaskStatusFailure
+		if job.Error != nil && job.Error.Message != "" {
+			taskResult.Reason = job.Error.Message
+		} else {
+			taskResult.Reason = "fine-tuning job " + job.Status
+		}
+	default:
+	}
+	return &taskResult, nil
+}
@@ -0,0 +1,55 @@
+package finetune
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTaskResultAndBilling(t *testing.T) {
+	adaptor := &TaskAdaptor{}
+
+	result, err := adaptor.ParseTaskResult([]byte(`{"id":"ftjob-1","status":"running"}`))
+	require.NoError(t, err)
+	assert.Equal(t, model.TaskStatusInProgress, result.Status)
+
+	result, err = adaptor.ParseTaskResult([]byte(`{"id":"ftjob-1","status":"failed","error":{"code":"invalid_training_file","message":"bad file"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, model.TaskStatusFailure, result.Status)
+	assert.Equal(t, "bad file", result.Reason)
+
+	result, err = adaptor.ParseTaskResult([]byte(`{"id":"ftjob-1","status":"succeeded","fine_tuned_model":"ft:gpt-4o-mini:org::abc","trained_tokens":1000}`))
+	require.NoError(t, err)
+	assert.Equal(t, model.TaskStatusSuccess, result.Status)
+	assert.Equal(t, "ft:gpt-4o-mini:org::abc", result.Url)
+	assert.Equal(t, 1000, result.TotalTokens)
+
+	task := &model.Task{PrivateData: model.TaskPrivateData{BillingContext: &model.TaskBillingContext{
+		ModelRatio: 1.5,
+		GroupRatio: 2,
+	}}}
+	assert.Equal(t, 3000, adaptor.AdjustBillingOnComplete(task, result))
+
+	// 缺少计费快照时保持预扣额度
+	assert.Equal(t, 0, adaptor.AdjustBillingOnComplete(&model.Task{}, result))
+}
+
+func TestJobsURLAndHeader(t *testing.T) {
+	assert.Equal(t, "https://api.openai.com/v1/fine_tuning/jobs/ftjob-1/events", JobsURL(constant.ChannelTypeOpenAI, "https://api.openai.com/", "", "/ftjob-1/events"))
+	assert.Equal(t, "https://res.openai.azure.com/openai/fine_tuning/jobs?api-version=2024-10-21", JobsURL(constant.ChannelTypeAzure, "https://res.openai.azure.com", "2024-10-21", ""))
+
+	header := http.Header{}
+	SetupHeader(header, constant.ChannelTypeAzure, "sk-azure", "org-1")
+	assert.Equal(t, "sk-azure", header.Get("api-key"))
+	assert.Empty(t, header.Get("Authorization"))
+	assert.Empty(t, header.Get("OpenAI-Organization"))
+
+	header = http.Header{}
+	SetupHeader(header, constant.ChannelTypeOpenAI, "sk-openai", "org-1")
+	assert.Equal(t, "Bearer sk-openai", header.Get("Authorization"))
+	assert.Equal(t, "org-1", header.Get("OpenAI-Organization"))
+}
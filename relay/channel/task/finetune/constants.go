@@ -0,0 +1,12 @@
+package finetune
+
+var ModelList = []string{
+	"gpt-4.1-2025-04-14",
+	"gpt-4.1-mini-2025-04-14",
+	"gpt-4.1-nano-2025-04-14",
+	"gpt-4o-2024-08-06",
+	"gpt-4o-mini-2024-07-18",
+	"gpt-3.5-turbo-0125",
+}
+
+var ChannelName = "fine-tuning"
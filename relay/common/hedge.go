@@ -0,0 +1,331 @@
+package common
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrHedgeLost 对冲请求中落败的尝试写出数据时返回
+var ErrHedgeLost = errors.New("hedged request lost the race")
+
+// HedgeRace 对冲请求的竞速状态，最先向客户端写出数据的尝试胜出，其余尝试被取消
+type HedgeRace struct {
+	ctx      context.Context
+	mu       sync.Mutex
+	winner   *HedgeAttempt
+	attempts []*HedgeAttempt
+	decided  chan struct{}
+}
+
+// HedgeAttempt 对冲请求中的单次尝试，存储在 RelayInfo.Hedge 上
+type HedgeAttempt struct {
+	race      *HedgeRace
+	ChannelId int
+	// estimatedPromptTokens 请求的预估输入 token 数，落败时上游已接收请求，用于估算其消耗
+	estimatedPromptTokens int
+	ctx                   context.Context
+	cancel                context.CancelFunc
+	done                  chan struct{}
+	doneOnce              sync.Once
+	usage                 *HedgeUsage
+}
+
+// HedgeUsage 落败尝试的用量，仅用于日志展示，不参与结算。
+// Reported 为 true 时是上游返回的实际用量，Estimated 为 true 时是被取消时按预估输入 token 数记录的用量
+type HedgeUsage struct {
+	ChannelId        int
+	Reported         bool
+	Estimated        bool
+	Quota            int
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// NewHedgeRace 创建竞速状态，ctx 通常为客户端请求的上下文，客户端断开时取消所有尝试
+func NewHedgeRace(ctx context.Context) *HedgeRace {
+	return &HedgeRace{ctx: ctx, decided: make(chan struct{})}
+}
+
+// NewAttempt 创建一次尝试，上游请求使用其独立的可取消上下文
+func (r *HedgeRace) NewAttempt(channelId int, estimatedPromptTokens int) *HedgeAttempt {
+	ctx, cancel := context.WithCancel(r.ctx)
+	attempt := &HedgeAttempt{
+		race:                  r,
+		ChannelId:             channelId,
+		estimatedPromptTokens: estimatedPromptTokens,
+		ctx:                   ctx,
+		cancel:                cancel,
+		done:                  make(chan struct{}),
+	}
+	r.mu.Lock()
+	r.attempts = append(r.attempts, attempt)
+	r.mu.Unlock()
+	return attempt
+}
+
+// Decided 胜出方确定后关闭
+func (r *HedgeRace) Decided() <-chan struct{} {
+	return r.decided
+}
+
+func (r *HedgeRace) Winner() *HedgeAttempt {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.winner
+}
+
+// Hedged 是否实际发出了对冲请求
+func (r *HedgeRace) Hedged() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.attempts) > 1
+}
+
+// Close 取消所有尝试的上游请求，释放上下文
+func (r *HedgeRace) Close() {
+	r.mu.Lock()
+	attempts := slices.Clone(r.attempts)
+	r.mu.Unlock()
+	for _, attempt := range attempts {
+		attempt.cancel()
+	}
+}
+
+func (a *HedgeAttempt) Context() context.Context {
+	return a.ctx
+}
+
+// Claim 尝试成为胜出方并取消其他尝试，返回本次尝试是否为胜出方
+func (a *HedgeAttempt) Claim() bool {
+	r := a.race
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.winner != nil {
+		return r.winner == a
+	}
+	r.winner = a
+	close(r.decided)
+	for _, attempt := range r.attempts {
+		if attempt == a {
+			continue
+		}
+		attempt.cancel()
+		// 落败请求被取消后通常无法上报实际用量，先记录预估的输入消耗
+		if attempt.usage == nil {
+			attempt.usage = &HedgeUsage{
+				ChannelId:    attempt.ChannelId,
+				Estimated:    true,
+				PromptTokens: attempt.estimatedPromptTokens,
+			}
+		}
+	}
+	return true
+}
+
+// IsLoser 其他尝试已胜出
+func (a *HedgeAttempt) IsLoser() bool {
+	winner := a.race.Winner()
+	return winner != nil && winner != a
+}
+
+// IsHedged 是否实际发出了对冲请求
+func (a *HedgeAttempt) IsHedged() bool {
+	return a.race.Hedged()
+}
+
+// Finish 尝试处理结束时调用
+func (a *HedgeAttempt) Finish() {
+	a.doneOnce.Do(func() {
+		close(a.done)
+	})
+}
+
+// ReportUsage 落败尝试上报上游返回的用量
+func (a *HedgeAttempt) ReportUsage(quota int, promptTokens int, completionTokens int) {
+	a.race.mu.Lock()
+	defer a.race.mu.Unlock()
+	a.usage = &HedgeUsage{
+		ChannelId:        a.ChannelId,
+		Reported:         true,
+		Quota:            quota,
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+	}
+}
+
+// LoserUsages 返回其他尝试当前的用量，不等待它们结束，未上报实际用量的尝试返回被取消时记录的预估用量
+func (a *HedgeAttempt) LoserUsages() []HedgeUsage {
+	a.race.mu.Lock()
+	defer a.race.mu.Unlock()
+	usages := make([]HedgeUsage, 0, len(a.race.attempts)-1)
+	for _, attempt := range a.race.attempts {
+		if attempt == a {
+			continue
+		}
+		usage := HedgeUsage{ChannelId: attempt.ChannelId}
+		if attempt.usage != nil {
+			usage = *attempt.usage
+		}
+		usages = append(usages, usage)
+	}
+	return usages
+}
+
+// WrapWriter 包装响应写入，首次写出数据时参与竞速，落败后的写入全部丢弃。
+// 胜出前响应头写入独立的 header（以 header 为初始值），胜出时再复制到真实的响应中
+func (a *HedgeAttempt) WrapWriter(w gin.ResponseWriter, header http.Header) gin.ResponseWriter {
+	return &hedgeWriter{
+		ResponseWriter: w,
+		attempt:        a,
+		header:         header.Clone(),
+	}
+}
+
+type hedgeWriter struct {
+	gin.ResponseWriter
+	attempt   *HedgeAttempt
+	header    http.Header
+	status    int
+	committed bool
+	lost      bool
+}
+
+func (w *hedgeWriter) commit() bool {
+	if w.committed {
+		return true
+	}
+	if w.lost {
+		return false
+	}
+	if !w.attempt.Claim() {
+		w.lost = true
+		return false
+	}
+	w.committed = true
+	header := w.ResponseWriter.Header()
+	for key, values := range w.header {
+		header[key] = values
+	}
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	return true
+}
+
+func (w *hedgeWriter) Header() http.Header {
+	if w.committed {
+		return w.ResponseWriter.Header()
+	}
+	return w.header
+}
+
+func (w *hedgeWriter) WriteHeader(code int) {
+	if w.committed {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.status = code
+}
+
+func (w *hedgeWriter) WriteHeaderNow() {
+	if w.committed {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *hedgeWriter) Write(data []byte) (int, error) {
+	if !w.commit() {
+		return 0, ErrHedgeLost
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *hedgeWriter) WriteString(s string) (int, error) {
+	if !w.commit() {
+		return 0, ErrHedgeLost
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *hedgeWriter) Flush() {
+	if w.committed {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *hedgeWriter) Status() int {
+	if w.committed {
+		return w.ResponseWriter.Status()
+	}
+	if w.status != 0 {
+		return w.status
+	}
+	return http.StatusOK
+}
+
+func (w *hedgeWriter) Size() int {
+	if w.committed {
+		return w.ResponseWriter.Size()
+	}
+	return -1
+}
+
+func (w *hedgeWriter) Written() bool {
+	return w.committed && w.ResponseWriter.Written()
+}
+
+func (w *hedgeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, errors.New("hijack is not supported for hedged requests")
+}
+
+// CloneForHedge 复制请求信息供对冲请求并发使用，处理过程中会被修改的字段各自独立
+func (info *RelayInfo) CloneForHedge() *RelayInfo {
+	clone := *info
+	clone.RequestConversionChain = slices.Clone(info.RequestConversionChain)
+	clone.ParamOverrideAudit = slices.Clone(info.ParamOverrideAudit)
+	clone.RuntimeHeadersOverride = maps.Clone(info.RuntimeHeadersOverride)
+	clone.PriceData.OtherRatios = maps.Clone(info.PriceData.OtherRatios)
+	clone.StreamStatus = nil
+	clone.LastError = nil
+	if info.ClaudeConvertInfo != nil {
+		claudeConvertInfo := *info.ClaudeConvertInfo
+		if claudeConvertInfo.Usage != nil {
+			usage := *claudeConvertInfo.Usage
+			claudeConvertInfo.Usage = &usage
+		}
+		clone.ClaudeConvertInfo = &claudeConvertInfo
+	}
+	if info.RerankerInfo != nil {
+		rerankerInfo := *info.RerankerInfo
+		clone.RerankerInfo = &rerankerInfo
+	}
+	if info.ResponsesUsageInfo != nil {
+		builtInTools := make(map[string]*BuildInToolInfo, len(info.ResponsesUsageInfo.BuiltInTools))
+		for name, tool := range info.ResponsesUsageInfo.BuiltInTools {
+			if tool == nil {
+				builtInTools[name] = nil
+				continue
+			}
+			toolInfo := *tool
+			builtInTools[name] = &toolInfo
+		}
+		clone.ResponsesUsageInfo = &ResponsesUsageInfo{BuiltInTools: builtInTools}
+	}
+	if info.ChannelMeta != nil {
+		channelMeta := *info.ChannelMeta
+		clone.ChannelMeta = &channelMeta
+	}
+	if info.TaskRelayInfo != nil {
+		taskRelayInfo := *info.TaskRelayInfo
+		clone.TaskRelayInfo = &taskRelayInfo
+	}
+	return &clone
+}
@@ -0,0 +1,90 @@
+package common
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHedgeWriterFirstWriteWins(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Header("X-Request-Id", "req")
+
+	race := NewHedgeRace(context.Background())
+	primary := race.NewAttempt(1, 0)
+	hedge := race.NewAttempt(2, 0)
+	header := c.Writer.Header().Clone()
+	primaryWriter := primary.WrapWriter(c.Writer, header)
+	hedgeWriter := hedge.WrapWriter(c.Writer, header)
+
+	primaryWriter.Header().Set("Content-Type", "text/plain")
+	hedgeWriter.Header().Set("Content-Type", "text/event-stream")
+	hedgeWriter.WriteHeader(http.StatusCreated)
+	require.True(t, race.Hedged())
+	require.Nil(t, race.Winner())
+
+	_, err := hedgeWriter.Write([]byte("data: hedge\n\n"))
+	require.NoError(t, err)
+	_, err = primaryWriter.Write([]byte("primary"))
+	require.ErrorIs(t, err, ErrHedgeLost)
+
+	require.Same(t, hedge, race.Winner())
+	require.True(t, primary.IsLoser())
+	require.ErrorIs(t, primary.Context().Err(), context.Canceled)
+	require.NoError(t, hedge.Context().Err())
+	require.Equal(t, http.StatusCreated, recorder.Code)
+	require.Equal(t, "data: hedge\n\n", recorder.Body.String())
+	require.Equal(t, "text/event-stream", recorder.Header().Get("Content-Type"))
+	require.Equal(t, "req", recorder.Header().Get("X-Request-Id"))
+}
+
+func TestHedgeLoserUsages(t *testing.T) {
+	race := NewHedgeRace(context.Background())
+	primary := race.NewAttempt(1, 20)
+	hedge := race.NewAttempt(2, 20)
+	require.True(t, primary.Claim())
+	require.False(t, hedge.Claim())
+
+	// 不等待落败请求结束，尚未上报用量时返回被取消时记录的预估输入用量
+	require.Equal(t, []HedgeUsage{{ChannelId: 2, Estimated: true, PromptTokens: 20}}, primary.LoserUsages())
+
+	hedge.ReportUsage(100, 20, 5)
+	hedge.Finish()
+	require.Equal(t, []HedgeUsage{{ChannelId: 2, Reported: true, Quota: 100, PromptTokens: 20, CompletionTokens: 5}}, primary.LoserUsages())
+}
+
+func TestHedgeRaceFollowsClientContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	race := NewHedgeRace(ctx)
+	primary := race.NewAttempt(1, 0)
+	hedge := race.NewAttempt(2, 0)
+	require.True(t, primary.Claim())
+
+	// 客户端断开时胜出的请求同样被取消
+	cancel()
+	require.ErrorIs(t, primary.Context().Err(), context.Canceled)
+	require.ErrorIs(t, hedge.Context().Err(), context.Canceled)
+}
+
+func TestRelayInfoCloneForHedge(t *testing.T) {
+	info := &RelayInfo{
+		ClaudeConvertInfo:  &ClaudeConvertInfo{Index: 1},
+		ResponsesUsageInfo: &ResponsesUsageInfo{BuiltInTools: map[string]*BuildInToolInfo{"web_search": {CallCount: 1}}},
+	}
+	info.PriceData.AddOtherRatio("n", 2)
+
+	clone := info.CloneForHedge()
+	clone.ClaudeConvertInfo.Index = 5
+	clone.ResponsesUsageInfo.BuiltInTools["web_search"].CallCount = 3
+	clone.PriceData.AddOtherRatio("n", 4)
+
+	require.Equal(t, 1, info.ClaudeConvertInfo.Index)
+	require.Equal(t, 1, info.ResponsesUsageInfo.BuiltInTools["web_search"].CallCount)
+	require.Equal(t, 2.0, info.PriceData.OtherRatios["n"])
+}
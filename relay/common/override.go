@@ -41,7 +41,7 @@ type paramOverrideAuditRecorder struct {
 
 type ConditionOperation struct {
 	Path           string      `json:"path"`             // JSON路径
-	Mode           string      `json:"mode"`             // full, prefix, suffix, contains, gt, gte, lt, lte
+	Mode           string      `json:"mode"`             // full, prefix, suffix, contains, regex, gt, gte, lt, lte
 	Value          interface{} `json:"value"`            // 匹配的值
 	Invert         bool        `json:"invert"`           // 反选功能，true表示取反结果
 	PassMissingKey bool        `json:"pass_missing_key"` // 未获取到json key时的行为
@@ -517,6 +517,11 @@ func checkConditions(jsonStr, contextJSON string, conditions []ConditionOperatio
 	return lo.SomeBy(results, func(item bool) bool { return item }), nil
 }
 
+// CheckConditions 判断 jsonStr 是否满足条件，jsonStr 中不存在的路径从 contextJSON 中取值
+func CheckConditions(jsonStr, contextJSON string, conditions []ConditionOperation, logic string) (bool, error) {
+	return checkConditions(jsonStr, contextJSON, conditions, logic)
+}
+
 func checkSingleCondition(jsonStr, contextJSON string, condition ConditionOperation) (bool, error) {
 	// 处理负数索引
 	path := processNegativeIndex(jsonStr, condition.Path)
@@ -590,6 +595,12 @@ func compareGjsonValues(jsonValue, targetValue gjson.Result, mode string) (bool,
 		return strings.HasSuffix(jsonValue.String(), targetValue.String()), nil
 	case "contains":
 		return strings.Contains(jsonValue.String(), targetValue.String()), nil
+	case "regex":
+		re, err := regexp.Compile(targetValue.String())
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(jsonValue.String()), nil
 	case "gt":
 		return compareNumeric(jsonValue, targetValue, "gt")
 	case "gte":
@@ -921,6 +932,11 @@ func applyOperations(jsonStr string, operations []ParamOperation, conditionConte
 	return result, nil
 }
 
+// ParseReturnError 解析 return_error 配置，字符串为错误信息，对象可指定 message、status_code、code、type、skip_retry
+func ParseReturnError(value interface{}) (*ParamOverrideReturnError, error) {
+	return parseParamOverrideReturnError(value)
+}
+
 func parseParamOverrideReturnError(value interface{}) (*ParamOverrideReturnError, error) {
 	result := &ParamOverrideReturnError{
 		StatusCode: http.StatusBadRequest,
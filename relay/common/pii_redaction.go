@@ -0,0 +1,9 @@
+package common
+
+// PIIRedactionState 记录请求中被脱敏的 PII
+type PIIRedactionState struct {
+	// Counts 各实体类型被替换的次数
+	Counts map[string]int
+	// Originals 可逆脱敏时占位符对应的原文
+	Originals map[string]string
+}
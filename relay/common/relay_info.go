@@ -86,12 +86,19 @@ type TokenCountMeta struct {
 
 type RelayInfo struct {
 	TokenId           int
+	TokenParentId     int // 子令牌的父令牌，限流与周期预算计入父令牌
 	TokenKey          string
 	TokenGroup        string
 	UserId            int
 	UsingGroup        string // 使用的分组，当auto跨分组重试时，会变动
 	UserGroup         string // 用户所在分组
 	TokenUnlimited    bool
+	TokenTPMReserved  int // 按令牌 TPM 限制预留的 token 数，结算时按实际用量调整
+	TokenBudgetQuota  int // 令牌周期预算，0 表示未设置
+	TokenBudgetSoft   int // 令牌周期预算的通知阈值
+	TokenJWTId        string
+	TokenJWTQuota     int
+	TokenJWTExpiresAt int64
 	StartTime         time.Time
 	FirstResponseTime time.Time
 	isFirstResponse   bool
@@ -102,6 +109,7 @@ type RelayInfo struct {
 	UsePrice               bool
 	RelayMode              int
 	OriginModelName        string
+	RequestedModelName     string // 发生模型降级时客户端请求的模型，OriginModelName 为实际使用的模型
 	RequestURLPath         string
 	RequestHeaders         map[string]string
 	ShouldIncludeUsage     bool
@@ -128,6 +136,9 @@ type RelayInfo struct {
 	// Billing 是计费会话，封装了预扣费/结算/退款的统一生命周期。
 	// 免费模型时为 nil。
 	Billing BillingSettler
+	// Hedge 启用对冲请求时的单次尝试，未启用时为 nil。
+	// 落败的尝试不结算，上游请求使用其上下文以便被取消。
+	Hedge *HedgeAttempt
 	// BillingSource indicates whether this request is billed from wallet quota or subscription.
 	// "" or "wallet" => wallet; "subscription" => subscription
 	BillingSource string
@@ -145,8 +156,10 @@ type RelayInfo struct {
 	// SubscriptionAmountTotal / SubscriptionAmountUsedAfterPreConsume are used to compute remaining in logs.
 	SubscriptionAmountTotal               int64
 	SubscriptionAmountUsedAfterPreConsume int64
-	IsClaudeBetaQuery                     bool // /v1/messages?beta=true
-	IsChannelTest                         bool // channel test request
+	IsClaudeBetaQuery                     bool    // /v1/messages?beta=true
+	IsChannelTest                         bool    // channel test request
+	BatchId                               string  // 由 Batch 任务重放的请求所属批处理ID
+	BatchDiscountRatio                    float64 // Batch 请求的折扣倍率，已计入分组倍率
 	RetryIndex                            int
 	LastError                             *types.NewAPIError
 	RuntimeHeadersOverride                map[string]interface{}
@@ -171,6 +184,15 @@ type RelayInfo struct {
 
 	StreamStatus *StreamStatus
 
+	// OutputScanner 流式输出扫描器，开启输出审核时设置
+	OutputScanner StreamOutputScanner
+	// ModerationAudit 内容审核的命中记录，写入日志
+	ModerationAudit []string
+	// PIIRedaction 请求的 PII 脱敏结果，已脱敏的请求在重试时不再处理
+	PIIRedaction *PIIRedactionState
+	// InjectionGuardAudit 提示词注入检测的命中记录，写入日志
+	InjectionGuardAudit []string
+
 	ThinkingContentInfo
 	TokenCountMeta
 	*ClaudeConvertInfo
@@ -462,13 +484,24 @@ func genBaseRelayInfo(c *gin.Context, request dto.Request) *RelayInfo {
 		UserQuota:  common.GetContextKeyInt(c, constant.ContextKeyUserQuota),
 		UserEmail:  common.GetContextKeyString(c, constant.ContextKeyUserEmail),
 
-		OriginModelName: common.GetContextKeyString(c, constant.ContextKeyOriginalModel),
+		OriginModelName:    common.GetContextKeyString(c, constant.ContextKeyOriginalModel),
+		RequestedModelName: common.GetContextKeyString(c, constant.ContextKeyRequestedModel),
 
 		TokenId:        common.GetContextKeyInt(c, constant.ContextKeyTokenId),
+		TokenParentId:  common.GetContextKeyInt(c, constant.ContextKeyTokenParentId),
 		TokenKey:       common.GetContextKeyString(c, constant.ContextKeyTokenKey),
 		TokenUnlimited: common.GetContextKeyBool(c, constant.ContextKeyTokenUnlimited),
 		TokenGroup:     tokenGroup,
 
+		TokenBudgetQuota: common.GetContextKeyInt(c, constant.ContextKeyTokenBudgetQuota),
+		TokenBudgetSoft:  common.GetContextKeyInt(c, constant.ContextKeyTokenBudgetSoftLimit),
+
+		TokenJWTId:        common.GetContextKeyString(c, constant.ContextKeyTokenJWTId),
+		TokenJWTQuota:     common.GetContextKeyInt(c, constant.ContextKeyTokenJWTQuota),
+		TokenJWTExpiresAt: common.GetContextKeyInt64(c, constant.ContextKeyTokenJWTExpiresAt),
+
+		BatchId: common.GetContextKeyString(c, constant.ContextKeyBatchId),
+
 		isFirstResponse: true,
 		RelayMode:       relayconstant.Path2RelayMode(c.Request.URL.Path),
 		RequestURLPath:  c.Request.URL.String(),
@@ -582,6 +615,11 @@ func GenRelayInfo(c *gin.Context, relayFormat types.RelayFormat, request dto.Req
 	return info, nil
 }
 
+// SkipTokenQuota 是否跳过令牌额度的扣除与退还：操练场没有令牌，JWT 令牌不落库，额度上限按 jti 单独统计
+func (info *RelayInfo) SkipTokenQuota() bool {
+	return info.IsPlayground || info.TokenJWTId != ""
+}
+
 func (info *RelayInfo) InitRequestConversionChain() {
 	if info == nil {
 		return
@@ -647,6 +685,14 @@ func (info *RelayInfo) GetEstimatePromptTokens() int {
 	return info.estimatePromptTokens
 }
 
+// LimitTokenId 令牌限流与周期预算计量的令牌，子令牌计入父令牌
+func (info *RelayInfo) LimitTokenId() int {
+	if info.TokenParentId != 0 {
+		return info.TokenParentId
+	}
+	return info.TokenId
+}
+
 func (info *RelayInfo) SetFirstResponseTime() {
 	if info.isFirstResponse {
 		info.FirstResponseTime = time.Now()
@@ -19,10 +19,67 @@ const (
 	StreamEndReasonEOF         StreamEndReason = "eof"
 	StreamEndReasonPanic       StreamEndReason = "panic"
 	StreamEndReasonPingFail    StreamEndReason = "ping_fail"
+	StreamEndReasonModeration  StreamEndReason = "moderation"
 )
 
 const maxStreamErrorEntries = 20
 
+// StreamOutputScanner 扫描上游流式数据，返回转发给下游的数据，stop 为 true 时丢弃该数据并终止输出
+type StreamOutputScanner interface {
+	ScanStreamData(data string) (result string, stop bool)
+}
+
+// StreamOutputFlusher 可选接口，流正常结束时返回扫描器暂存的、仍需转发给下游的数据
+type StreamOutputFlusher interface {
+	FlushStreamData() []string
+}
+
+// streamOutputScannerChain 按追加顺序依次执行多个扫描器
+type streamOutputScannerChain []StreamOutputScanner
+
+func (chain streamOutputScannerChain) ScanStreamData(data string) (string, bool) {
+	for _, scanner := range chain {
+		var stop bool
+		if data, stop = scanner.ScanStreamData(data); stop {
+			return "", true
+		}
+	}
+	return data, false
+}
+
+// FlushStreamData 前面扫描器输出的暂存数据继续交给后面的扫描器处理
+func (chain streamOutputScannerChain) FlushStreamData() []string {
+	var flushed []string
+	for _, scanner := range chain {
+		next := make([]string, 0, len(flushed))
+		for _, data := range flushed {
+			if data, stop := scanner.ScanStreamData(data); !stop {
+				next = append(next, data)
+			}
+		}
+		if flusher, ok := scanner.(StreamOutputFlusher); ok {
+			next = append(next, flusher.FlushStreamData()...)
+		}
+		flushed = next
+	}
+	return flushed
+}
+
+// AddOutputScanner 追加流式输出扫描器
+func (info *RelayInfo) AddOutputScanner(scanner StreamOutputScanner) {
+	if scanner == nil {
+		return
+	}
+	switch existing := info.OutputScanner.(type) {
+	case nil:
+		info.OutputScanner = scanner
+	case streamOutputScannerChain:
+		info.OutputScanner = append(existing, scanner)
+	default:
+		info.OutputScanner = streamOutputScannerChain{existing, scanner}
+	}
+}
+
 type StreamErrorEntry struct {
 	Message   string
 	Timestamp time.Time
@@ -45,6 +45,11 @@ func TextHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *types
 		return types.NewError(err, types.ErrorCodeChannelModelMappedError, types.ErrOptionWithSkipRetry())
 	}
 
+	// 将引用的网关文件替换为当前渠道可用的上游文件ID或内联内容
+	if err = service.ResolveRequestFiles(c, info, request); err != nil {
+		return types.NewError(err, types.ErrorCodeConvertRequestFailed)
+	}
+
 	includeUsage := true
 	// 判断用户是否需要返回使用情况
 	if request.StreamOptions != nil {
@@ -0,0 +1,96 @@
+package relay
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// CountTokensHelper 计算 Claude count_tokens 与 Gemini countTokens 请求的输入 token 数，不计费。
+// 分发到原生 Claude / Gemini 渠道时转发到上游，其他渠道或上游失败时在本地估算
+func CountTokensHelper(c *gin.Context, info *relaycommon.RelayInfo) (int, *types.NewAPIError) {
+	info.InitChannelMeta(c)
+
+	if err := helper.ModelMappedHelper(c, info, info.Request); err != nil {
+		return 0, types.NewError(err, types.ErrorCodeChannelModelMappedError, types.ErrOptionWithSkipRetry())
+	}
+
+	if operation_setting.GetCountTokensSetting().UpstreamEnabled && supportsUpstreamCountTokens(info) {
+		tokens, err := countTokensUpstream(c, info)
+		if err == nil {
+			return tokens, nil
+		}
+		logger.LogWarn(c, fmt.Sprintf("upstream count tokens failed, fallback to local estimate: %s", err.Error()))
+	}
+	return service.CountInputTokens(c, info.Request.GetTokenCountMeta(), info.UpstreamModelName), nil
+}
+
+func supportsUpstreamCountTokens(info *relaycommon.RelayInfo) bool {
+	switch info.RelayFormat {
+	case types.RelayFormatClaude:
+		return info.ChannelType == constant.ChannelTypeAnthropic
+	case types.RelayFormatGemini:
+		return info.ChannelType == constant.ChannelTypeGemini
+	}
+	return false
+}
+
+// countTokensUpstream 原样转发请求体，仅将 Claude 请求中的模型替换为映射后的模型，Gemini 的模型在 URL 中
+func countTokensUpstream(c *gin.Context, info *relaycommon.RelayInfo) (int, error) {
+	storage, err := common.GetBodyStorage(c)
+	if err != nil {
+		return 0, err
+	}
+	body, err := storage.Bytes()
+	if err != nil {
+		return 0, err
+	}
+	tokensPath := "totalTokens"
+	if info.RelayFormat == types.RelayFormatClaude {
+		tokensPath = "input_tokens"
+		if body, err = sjson.SetBytes(body, "model", info.UpstreamModelName); err != nil {
+			return 0, err
+		}
+	}
+
+	adaptor := GetAdaptor(info.ApiType)
+	if adaptor == nil {
+		return 0, fmt.Errorf("invalid api type: %d", info.ApiType)
+	}
+	adaptor.Init(info)
+	resp, err := adaptor.DoRequest(c, info, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	httpResp, ok := resp.(*http.Response)
+	if !ok || httpResp == nil {
+		return 0, fmt.Errorf("unexpected response type %T", resp)
+	}
+	defer service.CloseResponseBodyGracefully(httpResp)
+	responseBody, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return 0, err
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("status code %d: %s", httpResp.StatusCode, string(responseBody))
+	}
+	tokens := gjson.GetBytes(responseBody, tokensPath)
+	if !tokens.Exists() {
+		return 0, fmt.Errorf("%s not found in response: %s", tokensPath, string(responseBody))
+	}
+	return int(tokens.Int()), nil
+}
@@ -0,0 +1,86 @@
+package relay
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func newCountTokensContext(t *testing.T, path, body string, channelType int, baseURL string) *gin.Context {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	common.SetContextKey(c, constant.ContextKeyChannelType, channelType)
+	common.SetContextKey(c, constant.ContextKeyChannelBaseUrl, baseURL)
+	common.SetContextKey(c, constant.ContextKeyChannelKey, "sk-test")
+	common.SetContextKey(c, constant.ContextKeyOriginalModel, "claude-sonnet-4-5")
+	c.Set("model_mapping", `{"claude-sonnet-4-5":"claude-sonnet-4-5-20250929"}`)
+	return c
+}
+
+func countTokens(t *testing.T, c *gin.Context, format types.RelayFormat) int {
+	t.Helper()
+	request, err := helper.GetAndValidateCountTokensRequest(c, format)
+	require.NoError(t, err)
+	info, err := relaycommon.GenRelayInfo(c, format, request, nil)
+	require.NoError(t, err)
+	tokens, apiErr := CountTokensHelper(c, info)
+	require.Nil(t, apiErr)
+	return tokens
+}
+
+func TestCountTokensHelper_ClaudeUpstream(t *testing.T) {
+	service.InitHttpClient()
+	var upstreamPath, upstreamModel string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		upstreamPath = r.URL.Path
+		upstreamModel = gjson.GetBytes(body, "model").String()
+		assert.Equal(t, "sk-test", r.Header.Get("x-api-key"))
+		_, _ = w.Write([]byte(`{"input_tokens":42}`))
+	}))
+	defer server.Close()
+
+	body := `{"model":"claude-sonnet-4-5","messages":[{"role":"user","content":"hello"}]}`
+	c := newCountTokensContext(t, "/v1/messages/count_tokens", body, constant.ChannelTypeAnthropic, server.URL)
+	assert.Equal(t, 42, countTokens(t, c, types.RelayFormatClaude))
+	assert.Equal(t, "/v1/messages/count_tokens", upstreamPath)
+	assert.Equal(t, "claude-sonnet-4-5-20250929", upstreamModel)
+}
+
+func TestCountTokensHelper_LocalFallback(t *testing.T) {
+	service.InitHttpClient()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	body := `{"model":"claude-sonnet-4-5","system":"you are a helpful assistant",` +
+		`"tools":[{"name":"get_weather","description":"get weather","input_schema":{"type":"object"}}],` +
+		`"messages":[{"role":"user","content":"hello there, how is the weather today?"}]}`
+	// 上游失败时回退到本地估算
+	fallback := countTokens(t, newCountTokensContext(t, "/v1/messages/count_tokens", body, constant.ChannelTypeAnthropic, server.URL), types.RelayFormatClaude)
+	assert.Greater(t, fallback, 0)
+	// 非原生 Claude 渠道直接在本地估算
+	local := countTokens(t, newCountTokensContext(t, "/v1/messages/count_tokens", body, constant.ChannelTypeOpenAI, server.URL), types.RelayFormatClaude)
+	assert.Equal(t, fallback, local)
+
+	gemini := `{"generateContentRequest":{"contents":[{"role":"user","parts":[{"text":"hello there, how is the weather today?"}]}]}}`
+	c := newCountTokensContext(t, "/v1beta/models/gemini-2.5-flash:countTokens", gemini, constant.ChannelTypeOpenAI, server.URL)
+	assert.Greater(t, countTokens(t, c, types.RelayFormatGemini), 0)
+}
@@ -61,6 +61,12 @@ func HandleGroupRatio(ctx *gin.Context, relayInfo *relaycommon.RelayInfo) types.
 		groupRatioInfo.GroupRatio = ratio_setting.GetGroupRatio(relayInfo.UsingGroup)
 	}
 
+	// batch requests are billed at a discount
+	if relayInfo.BatchId != "" {
+		relayInfo.BatchDiscountRatio = operation_setting.GetBatchDiscountRatio(relayInfo.OriginModelName)
+		groupRatioInfo.GroupRatio *= relayInfo.BatchDiscountRatio
+	}
+
 	return groupRatioInfo
 }
 
@@ -196,6 +196,13 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 		}()
 		sr := newStreamResult(info.StreamStatus)
 		for data := range dataChan {
+			if info.OutputScanner != nil {
+				var stop bool
+				if data, stop = info.OutputScanner.ScanStreamData(data); stop {
+					info.StreamStatus.SetEndReason(relaycommon.StreamEndReasonModeration, nil)
+					return
+				}
+			}
 			sr.reset()
 			writeMutex.Lock()
 			dataHandler(data, sr)
@@ -204,6 +211,17 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 				return
 			}
 		}
+		if flusher, ok := info.OutputScanner.(relaycommon.StreamOutputFlusher); ok {
+			for _, data := range flusher.FlushStreamData() {
+				sr.reset()
+				writeMutex.Lock()
+				dataHandler(data, sr)
+				writeMutex.Unlock()
+				if sr.IsStopped() {
+					return
+				}
+			}
+		}
 	})
 
 	// Scanner goroutine with improved error handling
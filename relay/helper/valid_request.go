@@ -142,6 +142,8 @@ func GetAndValidOpenAIImageRequest(c *gin.Context, relayMode int) (*dto.ImageReq
 	imageRequest := &dto.ImageRequest{}
 
 	switch relayMode {
+	case relayconstant.RelayModeImagesVariations:
+		return getAndValidOpenAIImageVariationRequest(c)
 	case relayconstant.RelayModeImagesEdits:
 		if strings.Contains(c.Request.Header.Get("Content-Type"), "multipart/form-data") {
 			_, err := c.MultipartForm()
@@ -226,6 +228,46 @@ func GetAndValidOpenAIImageRequest(c *gin.Context, relayMode int) (*dto.ImageReq
 	return imageRequest, nil
 }
 
+// getAndValidOpenAIImageVariationRequest 解析 /v1/images/variations 的 multipart 请求，
+// variations 没有 prompt，Prompt 仅在客户端额外传入时保留，供通过编辑接口模拟的渠道使用
+func getAndValidOpenAIImageVariationRequest(c *gin.Context) (*dto.ImageRequest, error) {
+	if !strings.Contains(c.Request.Header.Get("Content-Type"), "multipart/form-data") {
+		return nil, errors.New("image variations request must be multipart/form-data")
+	}
+	if _, err := c.MultipartForm(); err != nil {
+		return nil, fmt.Errorf("failed to parse image variation form request: %w", err)
+	}
+	if files := c.Request.MultipartForm.File["image"]; len(files) == 0 {
+		return nil, errors.New("image is required")
+	}
+	formData := c.Request.PostForm
+	imageRequest := &dto.ImageRequest{
+		Model:          formData.Get("model"),
+		Prompt:         formData.Get("prompt"),
+		Size:           formData.Get("size"),
+		ResponseFormat: formData.Get("response_format"),
+		N:              common.GetPointer(uint(common.String2Int(formData.Get("n")))),
+	}
+	if imageRequest.Model == "" {
+		imageRequest.Model = "dall-e-2"
+	}
+	if imageRequest.Model == "dall-e-2" || imageRequest.Model == "dall-e" {
+		if imageRequest.Size != "" && imageRequest.Size != "256x256" && imageRequest.Size != "512x512" && imageRequest.Size != "1024x1024" {
+			return nil, errors.New("size must be one of 256x256, 512x512, or 1024x1024 for dall-e-2 or dall-e")
+		}
+		if imageRequest.Size == "" {
+			imageRequest.Size = "1024x1024"
+		}
+	}
+	if *imageRequest.N == 0 {
+		imageRequest.N = common.GetPointer(uint(1))
+	}
+	if *imageRequest.N > 10 {
+		return nil, errors.New("n must be between 1 and 10")
+	}
+	return imageRequest, nil
+}
+
 func GetAndValidateClaudeRequest(c *gin.Context) (textRequest *dto.ClaudeRequest, err error) {
 	textRequest = &dto.ClaudeRequest{}
 	err = common.UnmarshalBodyReusable(c, textRequest)
@@ -246,6 +288,39 @@ func GetAndValidateClaudeRequest(c *gin.Context) (textRequest *dto.ClaudeRequest
 	return textRequest, nil
 }
 
+// GetAndValidateCountTokensRequest 解析 count_tokens 请求，
+// Gemini countTokens 的内容既可以直接放在 contents 中，也可以包装在 generateContentRequest 中
+func GetAndValidateCountTokensRequest(c *gin.Context, format types.RelayFormat) (dto.Request, error) {
+	switch format {
+	case types.RelayFormatClaude:
+		request, err := GetAndValidateClaudeRequest(c)
+		if err != nil {
+			return nil, err
+		}
+		return request, nil
+	case types.RelayFormatGemini:
+		var wrapper struct {
+			GenerateContentRequest *dto.GeminiChatRequest `json:"generateContentRequest"`
+		}
+		if err := common.UnmarshalBodyReusable(c, &wrapper); err != nil {
+			return nil, err
+		}
+		if wrapper.GenerateContentRequest != nil {
+			if len(wrapper.GenerateContentRequest.Contents) == 0 {
+				return nil, errors.New("contents is required")
+			}
+			return wrapper.GenerateContentRequest, nil
+		}
+		request, err := GetAndValidateGeminiRequest(c)
+		if err != nil {
+			return nil, err
+		}
+		return request, nil
+	default:
+		return nil, fmt.Errorf("unsupported relay format for count tokens: %s", format)
+	}
+}
+
 func GetAndValidateTextRequest(c *gin.Context, relayMode int) (*dto.GeneralOpenAIRequest, error) {
 	textRequest := &dto.GeneralOpenAIRequest{}
 	err := common.UnmarshalBodyReusable(c, textRequest)
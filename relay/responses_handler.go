@@ -65,13 +65,31 @@ func ResponsesHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *
 		return types.NewError(err, types.ErrorCodeChannelModelMappedError, types.ErrOptionWithSkipRetry())
 	}
 
+	// 将引用的网关文件替换为当前渠道可用的上游文件ID或内联内容
+	if err = service.ResolveRequestFiles(c, info, request); err != nil {
+		return types.NewError(err, types.ErrorCodeConvertRequestFailed)
+	}
+
 	adaptor := GetAdaptor(info.ApiType)
 	if adaptor == nil {
 		return types.NewError(fmt.Errorf("invalid api type: %d", info.ApiType), types.ErrorCodeInvalidApiType, types.ErrOptionWithSkipRetry())
 	}
 	adaptor.Init(info)
+	passThrough := model_setting.GetGlobalSettings().PassThroughRequestEnabled || info.ChannelSetting.PassThroughBodyEnabled
+	if !passThrough {
+		// 上游不保存会话状态时，由网关展开 previous_response_id 并保存本轮对话
+		stateRecorder, apiErr := service.PrepareResponsesState(c, info, request)
+		if apiErr != nil {
+			return apiErr
+		}
+		if stateRecorder != nil {
+			defer func() {
+				stateRecorder.Finish(c, info, newAPIError == nil)
+			}()
+		}
+	}
 	var requestBody io.Reader
-	if model_setting.GetGlobalSettings().PassThroughRequestEnabled || info.ChannelSetting.PassThroughBodyEnabled {
+	if passThrough {
 		storage, err := common.GetBodyStorage(c)
 		if err != nil {
 			return types.NewError(err, types.ErrorCodeReadRequestBodyFailed, types.ErrOptionWithSkipRetry())
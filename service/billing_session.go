@@ -58,7 +58,7 @@ func (s *BillingSession) Settle(actualQuota int) error {
 	}
 	// 2) 调整令牌额度
 	var tokenErr error
-	if !s.relayInfo.IsPlayground {
+	if !s.relayInfo.SkipTokenQuota() {
 		if delta > 0 {
 			tokenErr = model.DecreaseTokenQuota(s.relayInfo.TokenId, s.relayInfo.TokenKey, delta)
 		} else {
@@ -70,7 +70,9 @@ func (s *BillingSession) Settle(actualQuota int) error {
 				s.relayInfo.UserId, s.relayInfo.TokenId, delta, tokenErr.Error()))
 		}
 	}
-	// 3) 更新 relayInfo 上的订阅 PostDelta（用于日志）
+	// 3) 调整令牌周期预算与 JWT 花费
+	recordTokenSpend(s.relayInfo, delta)
+	// 4) 更新 relayInfo 上的订阅 PostDelta（用于日志）
 	if s.funding.Source() == BillingSourceSubscription {
 		s.relayInfo.SubscriptionPostDelta += int64(delta)
 	}
@@ -97,11 +99,13 @@ func (s *BillingSession) Refund(c *gin.Context) {
 	// 复制需要的值到闭包中
 	tokenId := s.relayInfo.TokenId
 	tokenKey := s.relayInfo.TokenKey
-	isPlayground := s.relayInfo.IsPlayground
+	skipTokenQuota := s.relayInfo.SkipTokenQuota()
 	tokenConsumed := s.tokenConsumed
 	extraReserved := s.extraReserved
 	subscriptionId := s.relayInfo.SubscriptionId
 	funding := s.funding
+	relayInfo := s.relayInfo
+	preConsumedQuota := s.preConsumedQuota
 
 	gopool.Go(func() {
 		// 1) 退还资金来源
@@ -114,11 +118,13 @@ func (s *BillingSession) Refund(c *gin.Context) {
 			}
 		}
 		// 2) 退还令牌额度
-		if tokenConsumed > 0 && !isPlayground {
+		if tokenConsumed > 0 && !skipTokenQuota {
 			if err := model.IncreaseTokenQuota(tokenId, tokenKey, tokenConsumed); err != nil {
 				common.SysLog("error refunding token quota: " + err.Error())
 			}
 		}
+		// 3) 退还令牌周期预算与 JWT 花费
+		recordTokenSpend(relayInfo, -preConsumedQuota)
 	})
 }
 
@@ -162,11 +168,20 @@ func (s *BillingSession) Reserve(targetQuota int) error {
 		return nil
 	}
 
+	if apiErr := reserveTokenBudget(s.relayInfo, delta, delta); apiErr != nil {
+		return apiErr
+	}
+	if apiErr := reserveJWTQuota(s.relayInfo, delta, delta); apiErr != nil {
+		adjustTokenBudget(s.relayInfo, -delta)
+		return apiErr
+	}
 	if err := s.reserveFunding(delta); err != nil {
+		recordTokenSpend(s.relayInfo, -delta)
 		return err
 	}
 	if err := s.reserveToken(delta); err != nil {
 		s.rollbackFundingReserve(delta)
+		recordTokenSpend(s.relayInfo, -delta)
 		return err
 	}
 
@@ -195,9 +210,19 @@ func (s *BillingSession) preConsume(c *gin.Context, quota int) *types.NewAPIErro
 		logger.LogInfo(c, fmt.Sprintf("用户 %d 需要预扣费 %s (funding=%s)", s.relayInfo.UserId, logger.FormatQuota(effectiveQuota), s.funding.Source()))
 	}
 
+	// ---- 令牌周期预算与 JWT 额度上限（硬限制，按预扣额度原子预留，信任额度旁路同样受限） ----
+	if apiErr := reserveTokenBudget(s.relayInfo, quota, effectiveQuota); apiErr != nil {
+		return apiErr
+	}
+	if apiErr := reserveJWTQuota(s.relayInfo, quota, effectiveQuota); apiErr != nil {
+		adjustTokenBudget(s.relayInfo, -effectiveQuota)
+		return apiErr
+	}
+
 	// ---- 1) 预扣令牌额度 ----
 	if effectiveQuota > 0 {
 		if err := PreConsumeTokenQuota(s.relayInfo, effectiveQuota); err != nil {
+			recordTokenSpend(s.relayInfo, -effectiveQuota)
 			return types.NewErrorWithStatusCode(err, types.ErrorCodePreConsumeTokenQuotaFailed, http.StatusForbidden, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
 		}
 		s.tokenConsumed = effectiveQuota
@@ -205,8 +230,9 @@ func (s *BillingSession) preConsume(c *gin.Context, quota int) *types.NewAPIErro
 
 	// ---- 2) 预扣资金来源 ----
 	if err := s.funding.PreConsume(effectiveQuota); err != nil {
-		// 预扣费失败，回滚令牌额度
-		if s.tokenConsumed > 0 && !s.relayInfo.IsPlayground {
+		// 预扣费失败，回滚令牌额度、周期预算与 JWT 花费
+		recordTokenSpend(s.relayInfo, -effectiveQuota)
+		if s.tokenConsumed > 0 && !s.relayInfo.SkipTokenQuota() {
 			if rollbackErr := model.IncreaseTokenQuota(s.relayInfo.TokenId, s.relayInfo.TokenKey, s.tokenConsumed); rollbackErr != nil {
 				common.SysLog(fmt.Sprintf("error rolling back token quota (userId=%d, tokenId=%d, amount=%d, fundingErr=%s): %s",
 					s.relayInfo.UserId, s.relayInfo.TokenId, s.tokenConsumed, err.Error(), rollbackErr.Error()))
@@ -269,7 +295,7 @@ func (s *BillingSession) rollbackFundingReserve(delta int) {
 }
 
 func (s *BillingSession) reserveToken(delta int) error {
-	if delta <= 0 || s.relayInfo.IsPlayground {
+	if delta <= 0 || s.relayInfo.SkipTokenQuota() {
 		return nil
 	}
 	if err := PreConsumeTokenQuota(s.relayInfo, delta); err != nil {
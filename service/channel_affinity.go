@@ -25,6 +25,10 @@ const (
 	ginKeyChannelAffinityMeta       = "channel_affinity_meta"
 	ginKeyChannelAffinityLogInfo    = "channel_affinity_log_info"
 	ginKeyChannelAffinitySkipRetry  = "channel_affinity_skip_retry_on_failure"
+	ginKeyChannelAffinityConnection = "channel_affinity_connection_id"
+
+	// 连接级亲和不依赖规则配置，使用固定的规则名便于统计与日志展示
+	channelAffinityConnectionRuleName = "websocket_connection"
 
 	channelAffinityCacheNamespace           = "new-api:channel_affinity:v1"
 	channelAffinityUsageCacheStatsNamespace = "new-api:channel_affinity_usage_cache_stats:v1"
@@ -548,6 +552,11 @@ func ApplyChannelAffinityOverrideTemplate(c *gin.Context, paramOverride map[stri
 }
 
 func GetPreferredChannelByAffinity(c *gin.Context, modelName string, usingGroup string) (int, bool) {
+	if c != nil {
+		if connectionId := c.GetString(ginKeyChannelAffinityConnection); connectionId != "" {
+			return getPreferredChannelByConnection(c, connectionId, modelName, usingGroup)
+		}
+	}
 	setting := operation_setting.GetChannelAffinitySetting()
 	if setting == nil || !setting.Enabled {
 		return 0, false
@@ -683,10 +692,11 @@ func RecordChannelAffinity(c *gin.Context, channelID int) {
 		return
 	}
 	setting := operation_setting.GetChannelAffinitySetting()
-	if setting == nil || !setting.Enabled {
+	connectionAffinity := c != nil && c.GetString(ginKeyChannelAffinityConnection) != ""
+	if (setting == nil || !setting.Enabled) && !connectionAffinity {
 		return
 	}
-	if setting.SwitchOnSuccess && c != nil {
+	if setting != nil && setting.SwitchOnSuccess && c != nil {
 		if successChannelID := c.GetInt("channel_id"); successChannelID > 0 {
 			channelID = successChannelID
 		}
@@ -695,7 +705,7 @@ func RecordChannelAffinity(c *gin.Context, channelID int) {
 	if !ok {
 		return
 	}
-	if ttlSeconds <= 0 {
+	if ttlSeconds <= 0 && setting != nil {
 		ttlSeconds = setting.DefaultTTLSeconds
 	}
 	if ttlSeconds <= 0 {
@@ -707,6 +717,63 @@ func RecordChannelAffinity(c *gin.Context, channelID int) {
 	}
 }
 
+// SetChannelAffinityConnection 为 WebSocket 等长连接开启连接级亲和：
+// 连接内的每轮请求优先复用该连接上同一分组、同一模型首次成功使用的渠道，不受亲和规则开关影响
+func SetChannelAffinityConnection(c *gin.Context, connectionId string) {
+	if c == nil || connectionId == "" {
+		return
+	}
+	c.Set(ginKeyChannelAffinityConnection, connectionId)
+}
+
+// ReleaseChannelAffinityConnection 连接关闭时清理连接级亲和记录
+func ReleaseChannelAffinityConnection(connectionId string) {
+	if connectionId == "" {
+		return
+	}
+	prefix := channelAffinityConnectionKeyPrefix(connectionId)
+	if _, err := getChannelAffinityCache().DeleteByPrefix(prefix); err != nil {
+		common.SysError(fmt.Sprintf("channel affinity cache delete failed: prefix=%s, err=%v", prefix, err))
+	}
+}
+
+func channelAffinityConnectionKeyPrefix(connectionId string) string {
+	return channelAffinityConnectionRuleName + ":" + connectionId
+}
+
+func getPreferredChannelByConnection(c *gin.Context, connectionId string, modelName string, usingGroup string) (int, bool) {
+	ttlSeconds := 0
+	if setting := operation_setting.GetChannelAffinitySetting(); setting != nil {
+		ttlSeconds = setting.DefaultTTLSeconds
+	}
+	if ttlSeconds <= 0 {
+		ttlSeconds = 3600
+	}
+	path := ""
+	if c.Request != nil && c.Request.URL != nil {
+		path = c.Request.URL.Path
+	}
+	cacheKeySuffix := strings.Join([]string{channelAffinityConnectionKeyPrefix(connectionId), usingGroup, modelName}, ":")
+	setChannelAffinityContext(c, channelAffinityMeta{
+		CacheKey:       channelAffinityCacheNamespace + ":" + cacheKeySuffix,
+		TTLSeconds:     ttlSeconds,
+		RuleName:       channelAffinityConnectionRuleName,
+		KeySourceType:  "connection",
+		KeyHint:        buildChannelAffinityKeyHint(connectionId),
+		KeyFingerprint: affinityFingerprint(connectionId),
+		UsingGroup:     usingGroup,
+		ModelName:      modelName,
+		RequestPath:    path,
+	})
+
+	channelID, found, err := getChannelAffinityCache().Get(cacheKeySuffix)
+	if err != nil {
+		common.SysError(fmt.Sprintf("channel affinity cache get failed: key=%s, err=%v", cacheKeySuffix, err))
+		return 0, false
+	}
+	return channelID, found
+}
+
 type ChannelAffinityUsageCacheStats struct {
 	RuleName            string `json:"rule_name"`
 	UsingGroup          string `json:"using_group"`
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChannelAffinityConnection(t *testing.T) {
+	setting := operation_setting.GetChannelAffinitySetting()
+	enabled := setting.Enabled
+	setting.Enabled = false
+	t.Cleanup(func() { setting.Enabled = enabled })
+
+	newTurn := func() *gin.Context {
+		ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		ctx.Request = httptest.NewRequest(http.MethodPost, "/v1/responses", nil)
+		SetChannelAffinityConnection(ctx, "conn-test")
+		return ctx
+	}
+
+	// 连接级亲和不受规则开关影响
+	first := newTurn()
+	_, found := GetPreferredChannelByAffinity(first, "gpt-5", "default")
+	require.False(t, found)
+	RecordChannelAffinity(first, 7)
+
+	second := newTurn()
+	channelID, found := GetPreferredChannelByAffinity(second, "gpt-5", "default")
+	require.True(t, found)
+	require.Equal(t, 7, channelID)
+	stats, ok := GetChannelAffinityStatsContext(second)
+	require.True(t, ok)
+	require.Equal(t, channelAffinityConnectionRuleName, stats.RuleName)
+
+	_, found = GetPreferredChannelByAffinity(newTurn(), "gpt-5-mini", "default")
+	require.False(t, found)
+
+	ReleaseChannelAffinityConnection("conn-test")
+	_, found = GetPreferredChannelByAffinity(newTurn(), "gpt-5", "default")
+	require.False(t, found)
+
+	// 未开启连接级亲和的请求不受影响
+	plain, _ := gin.CreateTestContext(httptest.NewRecorder())
+	plain.Request = httptest.NewRequest(http.MethodPost, "/v1/responses", nil)
+	_, found = GetPreferredChannelByAffinity(plain, "gpt-5", "default")
+	require.False(t, found)
+}
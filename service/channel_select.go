@@ -2,12 +2,22 @@ package service
 
 import (
 	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/model"
+	channelstats "github.com/QuantumNous/new-api/pkg/channel_stats"
+	circuitbreaker "github.com/QuantumNous/new-api/pkg/circuit_breaker"
+	keyusage "github.com/QuantumNous/new-api/pkg/key_usage"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
 	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
 	"github.com/gin-gonic/gin"
 )
 
@@ -17,6 +27,19 @@ type RetryParam struct {
 	ModelName    string
 	Retry        *int
 	resetNextTry bool
+	// reselect 下一次忽略分发时选定的渠道重新选择，breakerReselects 为因此重新选择的次数
+	reselect         bool
+	breakerReselects int
+	// PromptTokens / CompletionTokens 请求的预估 token 数，用于按成本选择渠道
+	PromptTokens     int
+	CompletionTokens int
+}
+
+func (p *RetryParam) selectHint() model.ChannelSelectHint {
+	return model.ChannelSelectHint{
+		PromptTokens:     p.PromptTokens,
+		CompletionTokens: p.CompletionTokens,
+	}
 }
 
 func (p *RetryParam) GetRetry() int {
@@ -45,6 +68,28 @@ func (p *RetryParam) ResetRetryNextTry() {
 	p.resetNextTry = true
 }
 
+// maxBreakerReselects 渠道因半开探测名额已满被拒绝时，不消耗重试次数重新选择渠道的次数上限
+const maxBreakerReselects = 3
+
+// ReselectAfterBreakerReject 渠道因半开探测名额已满被拒绝时，在不消耗重试次数的前提下重新选择渠道，
+// 超过次数上限时返回 false
+func (p *RetryParam) ReselectAfterBreakerReject() bool {
+	if p.breakerReselects >= maxBreakerReselects {
+		return false
+	}
+	p.breakerReselects++
+	p.reselect = true
+	p.ResetRetryNextTry()
+	return true
+}
+
+// TakeReselect 返回本次是否需要忽略分发时选定的渠道重新选择
+func (p *RetryParam) TakeReselect() bool {
+	reselect := p.reselect
+	p.reselect = false
+	return reselect
+}
+
 // CacheGetRandomSatisfiedChannel tries to get a random channel that satisfies the requirements.
 // 尝试获取一个满足要求的随机渠道。
 //
@@ -115,7 +160,7 @@ func CacheGetRandomSatisfiedChannel(param *RetryParam) (*model.Channel, string,
 			}
 			logger.LogDebug(param.Ctx, "Auto selecting group: %s, priorityRetry: %d", autoGroup, priorityRetry)
 
-			channel, _ = model.GetRandomSatisfiedChannel(autoGroup, param.ModelName, priorityRetry)
+			channel, _ = model.GetRandomSatisfiedChannelWithHint(autoGroup, param.ModelName, priorityRetry, param.selectHint())
 			if channel == nil {
 				// Current group has no available channel for this model, try next group
 				// 当前分组没有该模型的可用渠道，尝试下一个分组
@@ -153,10 +198,99 @@ func CacheGetRandomSatisfiedChannel(param *RetryParam) (*model.Channel, string,
 			break
 		}
 	} else {
-		channel, err = model.GetRandomSatisfiedChannel(param.TokenGroup, param.ModelName, param.GetRetry())
+		channel, err = model.GetRandomSatisfiedChannelWithHint(param.TokenGroup, param.ModelName, param.GetRetry(), param.selectHint())
 		if err != nil {
 			return nil, param.TokenGroup, err
 		}
 	}
 	return channel, selectGroup, nil
 }
+
+// ChannelAttempt 单次渠道请求，用于更新渠道选择统计与熔断器
+type ChannelAttempt struct {
+	ChannelId  int
+	Start      time.Time
+	breakerKey string
+	probe      bool
+}
+
+// BeginChannelAttempt 标记渠道开始处理请求，成功时需与 FinishChannelAttempt 成对调用。
+// 需在选中渠道并写入上下文后调用，多密钥渠道按所选密钥熔断。
+// 半开状态的探测名额已满时返回可重试的错误，调用方应重新选择渠道
+func BeginChannelAttempt(c *gin.Context, channelId int, info *relaycommon.RelayInfo) (*ChannelAttempt, *types.NewAPIError) {
+	attempt := &ChannelAttempt{
+		ChannelId:  channelId,
+		breakerKey: circuitbreaker.ChannelKey(channelId),
+	}
+	if common.GetContextKeyBool(c, constant.ContextKeyChannelIsMultiKey) {
+		attempt.breakerKey = circuitbreaker.ChannelKeyIndexKey(channelId, common.GetContextKeyInt(c, constant.ContextKeyChannelMultiKeyIndex))
+	}
+	probe, allowed := circuitbreaker.Acquire(attempt.breakerKey)
+	if !allowed {
+		return nil, types.NewErrorWithStatusCode(fmt.Errorf("channel #%d is half-open and its probe requests are in flight", channelId), types.ErrorCodeCircuitBreakerOpen, http.StatusServiceUnavailable)
+	}
+	attempt.probe = probe
+	channelstats.Begin(channelId, info.OriginModelName)
+	attempt.Start = time.Now()
+	return attempt, nil
+}
+
+// FinishChannelAttempt 将单次渠道请求结果计入渠道选择统计与熔断器，
+// 与渠道质量无关的错误（如请求参数错误）不计入
+func FinishChannelAttempt(attempt *ChannelAttempt, info *relaycommon.RelayInfo, err *types.NewAPIError) {
+	if err != nil && !isChannelQualityError(err) {
+		CancelChannelAttempt(attempt, info)
+		return
+	}
+	circuitbreaker.Record(attempt.breakerKey, attempt.probe, err == nil)
+
+	now := time.Now()
+	sample := channelstats.Sample{
+		Success:   err == nil,
+		LatencyMs: now.Sub(attempt.Start).Milliseconds(),
+	}
+	if info.IsStream && info.HasSendResponse() && info.FirstResponseTime.After(attempt.Start) {
+		sample.HasTtft = true
+		sample.TtftMs = info.FirstResponseTime.Sub(attempt.Start).Milliseconds()
+	}
+	channelstats.Finish(attempt.ChannelId, info.OriginModelName, sample)
+}
+
+// CancelChannelAttempt 请求被主动取消（如对冲请求落败）时调用，不计入统计与熔断
+func CancelChannelAttempt(attempt *ChannelAttempt, info *relaycommon.RelayInfo) {
+	channelstats.Cancel(attempt.ChannelId, info.OriginModelName)
+	circuitbreaker.Release(attempt.breakerKey, attempt.probe)
+}
+
+// recordMultiKeyTokens 记录多密钥渠道中所用密钥消耗的 token 数，用于按限额选择密钥
+func recordMultiKeyTokens(info *relaycommon.RelayInfo, tokens int) {
+	if info.ChannelMeta == nil || !info.ChannelIsMultiKey {
+		return
+	}
+	keyusage.AddTokens(info.ChannelId, info.ChannelMultiKeyIndex, tokens)
+}
+
+// ShouldDeferDisableToCircuitBreaker 启用熔断后，限流、超时与上游 5xx 等暂时性错误交给熔断器处理，
+// 不再直接自动禁用渠道；命中自动禁用关键词的错误仍按原逻辑禁用
+func ShouldDeferDisableToCircuitBreaker(err *types.NewAPIError) bool {
+	if !circuitbreaker.Enabled() || err == nil || types.IsChannelError(err) {
+		return false
+	}
+	if !isChannelQualityError(err) {
+		return false
+	}
+	search, _ := AcSearch(strings.ToLower(err.Error()), operation_setting.AutomaticDisableKeywords, true)
+	return !search
+}
+
+func isChannelQualityError(err *types.NewAPIError) bool {
+	if types.IsChannelError(err) {
+		return true
+	}
+	if types.IsSkipRetryError(err) {
+		return false
+	}
+	return err.StatusCode >= http.StatusInternalServerError ||
+		err.StatusCode == http.StatusTooManyRequests ||
+		err.StatusCode == http.StatusRequestTimeout
+}
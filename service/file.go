@@ -0,0 +1,522 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+var (
+	ErrFileTooLarge             = errors.New("file exceeds the maximum allowed size")
+	ErrFileStorageQuotaExceeded = errors.New("file storage quota exceeded")
+)
+
+const (
+	fileCleanupTickInterval = 10 * time.Minute
+	fileCleanupBatchSize    = 200
+)
+
+var (
+	fileCleanupOnce    sync.Once
+	fileCleanupRunning atomic.Bool
+)
+
+// GetFileStorage 获取文件内容存储
+func GetFileStorage() common.FileStorage {
+	return common.GetFileStorage(operation_setting.GetFileSetting().StoragePath)
+}
+
+func ToOpenAIFile(file *model.File) dto.OpenAIFile {
+	openAIFile := dto.OpenAIFile{
+		Id:        file.FileId,
+		Object:    "file",
+		Bytes:     file.Bytes,
+		CreatedAt: file.CreatedAt,
+		Filename:  file.Filename,
+		Purpose:   file.Purpose,
+		Status:    file.Status,
+	}
+	if file.ExpiresAt > 0 {
+		expiresAt := file.ExpiresAt
+		openAIFile.ExpiresAt = &expiresAt
+	}
+	return openAIFile
+}
+
+type CreateFileParams struct {
+	UserId       int
+	TokenId      int
+	Group        string
+	Filename     string
+	ContentType  string
+	Purpose      string
+	Size         int64
+	ExpiresAfter int64 // 秒，0 表示使用默认保留时长
+	SkipQuota    bool  // 网关生成的文件（如批处理输出）不受用户存储配额限制
+}
+
+// CreateFile 校验大小与用户存储配额后保存文件内容并写入记录，配额在写入记录时原子校验
+func CreateFile(params CreateFileParams, content io.Reader) (*model.File, error) {
+	if maxSize := operation_setting.GetFileMaxSizeBytes(); maxSize > 0 && params.Size > maxSize && !params.SkipQuota {
+		return nil, ErrFileTooLarge
+	}
+	var limit int64
+	if !params.SkipQuota {
+		limit = operation_setting.GetUserFileStorageLimitBytes(params.Group)
+	}
+	// 先按声明的大小快速拒绝，写入记录时再按实际大小原子地校验
+	if limit > 0 {
+		used, err := model.SumUserFileBytes(params.UserId)
+		if err != nil {
+			return nil, err
+		}
+		if used+params.Size > limit {
+			return nil, ErrFileStorageQuotaExceeded
+		}
+	}
+
+	fileId := model.GenerateFileId()
+	storage := GetFileStorage()
+	written, err := storage.Save(fileId, content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save file: %w", err)
+	}
+
+	now := common.GetTimestamp()
+	file := &model.File{
+		FileId:      fileId,
+		UserId:      params.UserId,
+		TokenId:     params.TokenId,
+		Filename:    params.Filename,
+		Purpose:     params.Purpose,
+		Bytes:       written,
+		ContentType: params.ContentType,
+		StorageKey:  fileId,
+		Status:      model.FileStatusProcessed,
+		CreatedAt:   now,
+	}
+	if params.ExpiresAfter > 0 {
+		file.ExpiresAt = now + params.ExpiresAfter
+	} else if hours := operation_setting.GetFileSetting().DefaultExpireHours; hours > 0 {
+		file.ExpiresAt = now + int64(hours)*3600
+	}
+	inserted, err := file.InsertWithinQuota(limit)
+	if err != nil || !inserted {
+		_ = storage.Delete(fileId)
+		if err == nil {
+			err = ErrFileStorageQuotaExceeded
+		}
+		return nil, err
+	}
+	return file, nil
+}
+
+// DeleteFile 删除文件记录与内容，并尽力删除上游副本
+func DeleteFile(file *model.File) error {
+	if err := model.DeleteFile(file.Id); err != nil {
+		return err
+	}
+	if err := GetFileStorage().Delete(file.StorageKey); err != nil {
+		common.SysError(fmt.Sprintf("failed to delete file content %s: %s", file.FileId, err.Error()))
+	}
+	if len(file.UpstreamFiles) > 0 {
+		upstreamFiles := file.UpstreamFiles
+		gopool.Go(func() {
+			for key, ref := range upstreamFiles {
+				if err := deleteUpstreamFile(key, ref.FileId); err != nil {
+					common.SysLog(fmt.Sprintf("failed to delete upstream file %s (%s): %s", ref.FileId, key, err.Error()))
+				}
+			}
+		})
+	}
+	return nil
+}
+
+// supportsUpstreamFiles 渠道是否支持 OpenAI 文件接口，不支持时以 base64 内联文件内容
+func supportsUpstreamFiles(channelType int) bool {
+	switch channelType {
+	case constant.ChannelTypeOpenAI, constant.ChannelTypeAzure:
+		return true
+	}
+	return false
+}
+
+func upstreamFilesURL(channelType int, baseURL string, apiVersion string, fileId string) string {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	if channelType == constant.ChannelTypeAzure {
+		if apiVersion == "" {
+			apiVersion = constant.AzureDefaultAPIVersion
+		}
+		path := "/openai/files"
+		if fileId != "" {
+			path += "/" + fileId
+		}
+		return fmt.Sprintf("%s%s?api-version=%s", baseURL, path, apiVersion)
+	}
+	if fileId != "" {
+		return fmt.Sprintf("%s/v1/files/%s", baseURL, fileId)
+	}
+	return baseURL + "/v1/files"
+}
+
+func setUpstreamFileAuth(req *http.Request, channelType int, key string, organization string) {
+	if channelType == constant.ChannelTypeAzure {
+		req.Header.Set("api-key", key)
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+key)
+	if organization != "" {
+		req.Header.Set("OpenAI-Organization", organization)
+	}
+}
+
+// uploadFileToChannel 将文件重新上传到当前请求选中的渠道
+func uploadFileToChannel(info *relaycommon.RelayInfo, file *model.File) (string, error) {
+	content, err := GetFileStorage().Open(file.StorageKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file content: %w", err)
+	}
+	defer content.Close()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	if err := writer.WriteField("purpose", file.Purpose); err != nil {
+		return "", err
+	}
+	part, err := writer.CreateFormFile("file", file.Filename)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(part, content); err != nil {
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, upstreamFilesURL(info.ChannelType, info.ChannelBaseUrl, info.ApiVersion, ""), body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	setUpstreamFileAuth(req, info.ChannelType, info.ApiKey, info.Organization)
+
+	client, err := GetHttpClientWithProxy(info.ChannelSetting.Proxy)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("upstream file upload failed: status %d, body: %s", resp.StatusCode, string(respBody))
+	}
+	upstreamFileId := gjson.GetBytes(respBody, "id").String()
+	if upstreamFileId == "" {
+		return "", fmt.Errorf("upstream file upload returned no id: %s", string(respBody))
+	}
+	return upstreamFileId, nil
+}
+
+func deleteUpstreamFile(upstreamKey string, upstreamFileId string) error {
+	channelId, keyIndex, isMultiKey := model.ParseUpstreamFileKey(upstreamKey)
+	channel, err := model.CacheGetChannel(channelId)
+	if err != nil {
+		return err
+	}
+	keys := channel.GetKeys()
+	key := channel.Key
+	if isMultiKey {
+		if keyIndex < 0 || keyIndex >= len(keys) {
+			return fmt.Errorf("channel key index %d out of range", keyIndex)
+		}
+		key = keys[keyIndex]
+	}
+	apiVersion := ""
+	if channel.Type == constant.ChannelTypeAzure {
+		apiVersion = channel.Other
+	}
+	req, err := http.NewRequest(http.MethodDelete, upstreamFilesURL(channel.Type, channel.GetBaseURL(), apiVersion, upstreamFileId), nil)
+	if err != nil {
+		return err
+	}
+	organization := ""
+	if channel.OpenAIOrganization != nil {
+		organization = *channel.OpenAIOrganization
+	}
+	setUpstreamFileAuth(req, channel.Type, key, organization)
+	client, err := GetHttpClientWithProxy(channel.GetSetting().Proxy)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// ensureUpstreamFile 返回文件在当前渠道中的上游ID，不存在时上传并记录
+func ensureUpstreamFile(c *gin.Context, info *relaycommon.RelayInfo, file *model.File) (string, error) {
+	upstreamKey := model.UpstreamFileKey(info.ChannelId, info.ChannelIsMultiKey, info.ChannelMultiKeyIndex)
+	if ref, ok := file.UpstreamFiles[upstreamKey]; ok && ref.FileId != "" {
+		return ref.FileId, nil
+	}
+	upstreamFileId, err := uploadFileToChannel(info, file)
+	if err != nil {
+		return "", err
+	}
+	if err := model.SetFileUpstream(file.Id, upstreamKey, upstreamFileId); err != nil {
+		logger.LogWarn(c, fmt.Sprintf("failed to record upstream file %s for %s: %s", upstreamFileId, file.FileId, err.Error()))
+	}
+	if file.UpstreamFiles == nil {
+		file.UpstreamFiles = model.UpstreamFiles{}
+	}
+	file.UpstreamFiles[upstreamKey] = model.UpstreamFileRef{FileId: upstreamFileId, UploadedAt: common.GetTimestamp()}
+	logger.LogInfo(c, fmt.Sprintf("file %s uploaded to channel #%d as %s", file.FileId, info.ChannelId, upstreamFileId))
+	return upstreamFileId, nil
+}
+
+func inlineFileData(file *model.File) (string, error) {
+	if limit := operation_setting.GetFileInlineMaxSizeBytes(); limit > 0 && file.Bytes > limit {
+		return "", fmt.Errorf("file %s is too large to be inlined for this channel", file.FileId)
+	}
+	content, err := GetFileStorage().Open(file.StorageKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file content: %w", err)
+	}
+	defer content.Close()
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return "", err
+	}
+	contentType := file.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return fmt.Sprintf("data:%s;base64,%s", contentType, base64.StdEncoding.EncodeToString(data)), nil
+}
+
+// collectFileIdPaths 递归查找请求中引用网关文件的 file_id 字段路径
+func collectFileIdPaths(result gjson.Result, path string, paths *[]string) {
+	switch {
+	case result.IsArray():
+		for i, item := range result.Array() {
+			collectFileIdPaths(item, joinJSONPath(path, fmt.Sprintf("%d", i)), paths)
+		}
+	case result.IsObject():
+		result.ForEach(func(key, value gjson.Result) bool {
+			if key.String() == "file_id" && value.Type == gjson.String && model.IsGatewayFileId(value.String()) {
+				*paths = append(*paths, path)
+				return true
+			}
+			collectFileIdPaths(value, joinJSONPath(path, escapeJSONPathKey(key.String())), paths)
+			return true
+		})
+	}
+}
+
+func joinJSONPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func escapeJSONPathKey(key string) string {
+	replacer := strings.NewReplacer(".", `\.`, "*", `\*`, "?", `\?`, "|", `\|`, "#", `\#`, "@", `\@`)
+	return replacer.Replace(key)
+}
+
+// requestBodyMayReferenceFiles 原始请求体中不包含文件ID时无需序列化遍历请求，
+// 未缓存请求体时无法判断，按可能引用处理
+func requestBodyMayReferenceFiles(c *gin.Context) bool {
+	storage, ok := c.Get(common.KeyBodyStorage)
+	if !ok || storage == nil {
+		return true
+	}
+	bs, ok := storage.(common.BodyStorage)
+	if !ok {
+		return true
+	}
+	body, err := bs.Bytes()
+	if err != nil {
+		return true
+	}
+	return bytes.Contains(body, []byte("file-"))
+}
+
+// ResolveRequestFiles 将请求中引用的网关文件ID替换为当前渠道可用的形式：
+// 支持文件接口的渠道替换为上游文件ID（必要时重新上传），其他渠道内联为 base64 file_data。
+// 非当前用户拥有的文件ID保持不变，交由上游处理。
+func ResolveRequestFiles[T any](c *gin.Context, info *relaycommon.RelayInfo, request *T) error {
+	if request == nil || !requestBodyMayReferenceFiles(c) {
+		return nil
+	}
+	data, err := common.Marshal(request)
+	if err != nil {
+		return err
+	}
+	if !bytes.Contains(data, []byte(`"file-`)) {
+		return nil
+	}
+	var paths []string
+	collectFileIdPaths(gjson.ParseBytes(data), "", &paths)
+	if len(paths) == 0 {
+		return nil
+	}
+
+	files := make(map[string]*model.File)
+	changed := false
+	for _, path := range paths {
+		idPath := joinJSONPath(path, "file_id")
+		fileId := gjson.GetBytes(data, idPath).String()
+		file, ok := files[fileId]
+		if !ok {
+			file, err = model.GetUserFileById(info.UserId, fileId)
+			if err != nil && !errors.Is(err, model.ErrFileNotFound) {
+				return err
+			}
+			files[fileId] = file
+		}
+		if file == nil {
+			continue
+		}
+		if supportsUpstreamFiles(info.ChannelType) {
+			upstreamFileId, err := ensureUpstreamFile(c, info, file)
+			if err != nil {
+				return err
+			}
+			data, err = sjson.SetBytes(data, idPath, upstreamFileId)
+			if err != nil {
+				return err
+			}
+		} else {
+			fileData, err := inlineFileData(file)
+			if err != nil {
+				return err
+			}
+			data, err = sjson.DeleteBytes(data, idPath)
+			if err != nil {
+				return err
+			}
+			data, err = sjson.SetBytes(data, joinJSONPath(path, "file_data"), fileData)
+			if err != nil {
+				return err
+			}
+			if !gjson.GetBytes(data, joinJSONPath(path, "filename")).Exists() {
+				data, err = sjson.SetBytes(data, joinJSONPath(path, "filename"), file.Filename)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	var resolved T
+	if err := common.Unmarshal(data, &resolved); err != nil {
+		return err
+	}
+	*request = resolved
+	return nil
+}
+
+// ResolveUpstreamFileId 将单个网关文件ID转换为当前渠道的上游文件ID（必要时重新上传）。
+// 非网关文件、非当前用户拥有的文件或渠道不支持文件接口时原样返回。
+func ResolveUpstreamFileId(c *gin.Context, info *relaycommon.RelayInfo, fileId string) (string, error) {
+	if !model.IsGatewayFileId(fileId) || !supportsUpstreamFiles(info.ChannelType) {
+		return fileId, nil
+	}
+	file, err := model.GetUserFileById(info.UserId, fileId)
+	if err != nil {
+		if errors.Is(err, model.ErrFileNotFound) {
+			return fileId, nil
+		}
+		return "", err
+	}
+	return ensureUpstreamFile(c, info, file)
+}
+
+// StartFileCleanupTask 定期清理已过期的文件
+func StartFileCleanupTask() {
+	fileCleanupOnce.Do(func() {
+		if !common.IsMasterNode {
+			return
+		}
+		gopool.Go(func() {
+			logger.LogInfo(context.Background(), fmt.Sprintf("file cleanup task started: tick=%s", fileCleanupTickInterval))
+			ticker := time.NewTicker(fileCleanupTickInterval)
+			defer ticker.Stop()
+
+			runFileCleanupOnce()
+			for range ticker.C {
+				runFileCleanupOnce()
+			}
+		})
+	})
+}
+
+func runFileCleanupOnce() {
+	if !fileCleanupRunning.CompareAndSwap(false, true) {
+		return
+	}
+	defer fileCleanupRunning.Store(false)
+
+	ctx := context.Background()
+	total := 0
+	for {
+		files, err := model.GetExpiredFiles(fileCleanupBatchSize)
+		if err != nil {
+			logger.LogWarn(ctx, fmt.Sprintf("file cleanup task failed: %v", err))
+			return
+		}
+		for _, file := range files {
+			if err := DeleteFile(file); err != nil {
+				logger.LogWarn(ctx, fmt.Sprintf("failed to delete expired file %s: %v", file.FileId, err))
+				return
+			}
+		}
+		total += len(files)
+		if len(files) < fileCleanupBatchSize {
+			break
+		}
+	}
+	if common.DebugEnabled && total > 0 {
+		logger.LogDebug(ctx, "file cleanup: deleted_count=%d", total)
+	}
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupFileTest(t *testing.T) {
+	t.Helper()
+	common.SetFileStorage(&common.LocalFileStorage{Dir: t.TempDir()})
+	t.Cleanup(func() {
+		common.SetFileStorage(nil)
+		model.DB.Exec("DELETE FROM files")
+	})
+}
+
+func newFileTestContext() *gin.Context {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	return c
+}
+
+func TestCreateFile_StorageQuota(t *testing.T) {
+	setupFileTest(t)
+	setting := operation_setting.GetFileSetting()
+	origLimit := setting.UserStorageLimitMB
+	setting.UserStorageLimitMB = 1
+	t.Cleanup(func() { setting.UserStorageLimitMB = origLimit })
+
+	content := strings.Repeat("a", 600<<10)
+	file, err := CreateFile(CreateFileParams{UserId: 1, Filename: "a.txt", Purpose: "user_data", Size: int64(len(content))}, strings.NewReader(content))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), file.Bytes)
+	assert.True(t, model.IsGatewayFileId(file.FileId))
+
+	_, err = CreateFile(CreateFileParams{UserId: 1, Filename: "b.txt", Purpose: "user_data", Size: int64(len(content))}, strings.NewReader(content))
+	assert.ErrorIs(t, err, ErrFileStorageQuotaExceeded)
+	// 写入记录时按实际大小再次校验，声明的大小偏小时同样拒绝
+	_, err = CreateFile(CreateFileParams{UserId: 1, Filename: "b.txt", Purpose: "user_data", Size: 1}, strings.NewReader(content))
+	assert.ErrorIs(t, err, ErrFileStorageQuotaExceeded)
+	used, err := model.SumUserFileBytes(1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), used)
+
+	// 其他用户不受影响
+	_, err = CreateFile(CreateFileParams{UserId: 2, Filename: "b.txt", Purpose: "user_data", Size: int64(len(content))}, strings.NewReader(content))
+	assert.NoError(t, err)
+
+	// 已过期但尚未清理的文件不占用配额
+	require.NoError(t, model.DB.Model(&model.File{}).Where("id = ?", file.Id).Update("expires_at", common.GetTimestamp()-1).Error)
+	_, err = CreateFile(CreateFileParams{UserId: 1, Filename: "c.txt", Purpose: "user_data", Size: int64(len(content))}, strings.NewReader(content))
+	assert.NoError(t, err)
+}
+
+func TestResolveRequestFiles_InlineForNonFileChannel(t *testing.T) {
+	setupFileTest(t)
+	file, err := CreateFile(CreateFileParams{UserId: 1, Filename: "doc.pdf", ContentType: "application/pdf", Purpose: "user_data", Size: 5}, strings.NewReader("hello"))
+	require.NoError(t, err)
+
+	request := &dto.GeneralOpenAIRequest{
+		Model: "claude-test",
+		Messages: []dto.Message{{
+			Role:    "user",
+			Content: []any{map[string]any{"type": "file", "file": map[string]any{"file_id": file.FileId}}},
+		}},
+	}
+	info := &relaycommon.RelayInfo{UserId: 1, ChannelMeta: &relaycommon.ChannelMeta{ChannelType: constant.ChannelTypeAnthropic, ChannelId: 3}}
+	require.NoError(t, ResolveRequestFiles(newFileTestContext(), info, request))
+
+	contents := request.Messages[0].ParseContent()
+	require.Len(t, contents, 1)
+	fileContent := contents[0].GetFile()
+	require.NotNil(t, fileContent)
+	assert.Empty(t, fileContent.FileId)
+	assert.Equal(t, "doc.pdf", fileContent.FileName)
+	assert.Equal(t, "data:application/pdf;base64,aGVsbG8=", fileContent.FileData)
+}
+
+func TestResolveRequestFiles_SkipsBodyWithoutFileId(t *testing.T) {
+	setupFileTest(t)
+	c := newFileTestContext()
+	storage, err := common.CreateBodyStorage([]byte(`{"model":"claude-test","messages":[{"role":"user","content":"hi"}]}`))
+	require.NoError(t, err)
+	c.Set(common.KeyBodyStorage, storage)
+	defer common.CleanupBodyStorage(c)
+
+	// 请求体中没有文件ID时不查询文件，未知的文件ID也原样保留
+	request := &dto.GeneralOpenAIRequest{
+		Model:    "claude-test",
+		Messages: []dto.Message{{Role: "user", Content: []any{map[string]any{"type": "file", "file": map[string]any{"file_id": "file-not-in-body"}}}}},
+	}
+	info := &relaycommon.RelayInfo{UserId: 1, ChannelMeta: &relaycommon.ChannelMeta{ChannelType: constant.ChannelTypeAnthropic, ChannelId: 3}}
+	require.NoError(t, ResolveRequestFiles(c, info, request))
+	assert.Equal(t, "file-not-in-body", request.Messages[0].ParseContent()[0].GetFile().FileId)
+}
+
+func TestResolveRequestFiles_PinsUpstreamFile(t *testing.T) {
+	setupFileTest(t)
+	var uploads atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/files", r.URL.Path)
+		assert.Equal(t, "Bearer sk-upstream", r.Header.Get("Authorization"))
+		require.NoError(t, r.ParseMultipartForm(1<<20))
+		assert.Equal(t, "user_data", r.FormValue("purpose"))
+		uploads.Add(1)
+		_, _ = w.Write([]byte(`{"id":"file-upstream-1","object":"file"}`))
+	}))
+	defer server.Close()
+	InitHttpClient()
+
+	file, err := CreateFile(CreateFileParams{UserId: 1, Filename: "doc.txt", Purpose: "user_data", Size: 5}, strings.NewReader("hello"))
+	require.NoError(t, err)
+
+	info := &relaycommon.RelayInfo{UserId: 1, ChannelMeta: &relaycommon.ChannelMeta{
+		ChannelType:    constant.ChannelTypeOpenAI,
+		ChannelId:      7,
+		ChannelBaseUrl: server.URL,
+		ApiKey:         "sk-upstream",
+	}}
+	for i := 0; i < 2; i++ {
+		request := &dto.OpenAIResponsesRequest{
+			Model: "gpt-test",
+			Input: []byte(`[{"role":"user","content":[{"type":"input_file","file_id":"` + file.FileId + `"},{"type":"input_file","file_id":"file-someone-else"}]}]`),
+		}
+		require.NoError(t, ResolveRequestFiles(newFileTestContext(), info, request))
+		assert.JSONEq(t, `[{"role":"user","content":[{"type":"input_file","file_id":"file-upstream-1"},{"type":"input_file","file_id":"file-someone-else"}]}]`, string(request.Input))
+	}
+	assert.Equal(t, int32(1), uploads.Load())
+
+	stored, err := model.GetUserFileById(1, file.FileId)
+	require.NoError(t, err)
+	assert.Equal(t, "file-upstream-1", stored.UpstreamFiles["7"].FileId)
+}
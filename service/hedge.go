@@ -0,0 +1,70 @@
+package service
+
+import (
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+	"github.com/gin-gonic/gin"
+)
+
+// GetHedgeDelay 返回本次请求的对冲延迟，令牌开启对冲或所在分组默认启用对冲时生效。
+// 实时语音与指定渠道的请求不做对冲
+func GetHedgeDelay(c *gin.Context, info *relaycommon.RelayInfo) (time.Duration, bool) {
+	setting := operation_setting.GetHedgeSetting()
+	if !setting.Enabled || info.RelayFormat == types.RelayFormatOpenAIRealtime {
+		return 0, false
+	}
+	if _, ok := c.Get("specific_channel_id"); ok {
+		return 0, false
+	}
+	if !common.GetContextKeyBool(c, constant.ContextKeyTokenHedging) && !operation_setting.IsHedgeGroup(info.UsingGroup) {
+		return 0, false
+	}
+	delay := time.Duration(setting.DelayMs) * time.Millisecond
+	if delay <= 0 {
+		delay = 800 * time.Millisecond
+	}
+	return delay, true
+}
+
+// hedgeLoserConsumed 对冲请求中落败的尝试不结算也不记录消费日志，仅上报用量供胜出请求的日志展示。
+// 返回 true 表示调用方应直接返回。SettleBilling 中同样会检查，未单独处理的结算路径也不会为落败请求扣费
+func hedgeLoserConsumed(relayInfo *relaycommon.RelayInfo, quota int, promptTokens int, completionTokens int) bool {
+	if relayInfo.Hedge == nil || relayInfo.Hedge.Claim() {
+		return false
+	}
+	relayInfo.Hedge.ReportUsage(quota, promptTokens, completionTokens)
+	return true
+}
+
+// appendHedgeOtherInfo 在胜出请求的日志中记录对冲信息。
+// 不等待落败请求结束，结算时尚未上报实际用量的落败请求记录预估的输入 token 数
+func appendHedgeOtherInfo(other map[string]interface{}, relayInfo *relaycommon.RelayInfo) {
+	if other == nil || relayInfo.Hedge == nil || !relayInfo.Hedge.IsHedged() {
+		return
+	}
+	other["hedge"] = true
+	if !operation_setting.GetHedgeSetting().RecordLoserUsage {
+		return
+	}
+	losers := make([]map[string]interface{}, 0)
+	for _, usage := range relayInfo.Hedge.LoserUsages() {
+		loser := map[string]interface{}{
+			"channel_id": usage.ChannelId,
+		}
+		if usage.Reported {
+			loser["quota"] = usage.Quota
+			loser["prompt_tokens"] = usage.PromptTokens
+			loser["completion_tokens"] = usage.CompletionTokens
+		} else if usage.Estimated {
+			loser["prompt_tokens"] = usage.PromptTokens
+			loser["estimated"] = true
+		}
+		losers = append(losers, loser)
+	}
+	other["hedge_losers"] = losers
+}
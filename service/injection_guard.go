@@ -0,0 +1,276 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// 被移除的片段替换为该提示，保留消息结构以免破坏工具调用的对应关系
+const injectionGuardStripNotice = "[content removed by prompt injection guard]"
+
+// 内置的启发式规则，匹配工具结果与检索内容中常见的注入话术
+var injectionHeuristics = []struct {
+	name    string
+	pattern *regexp.Regexp
+}{
+	{"ignore_instructions", regexp.MustCompile(`(?i)\b(ignore|disregard|forget|override)\b.{0,30}\b(previous|prior|above|earlier|all|system|original)\b.{0,20}\b(instructions?|prompts?|rules|directions)\b`)},
+	{"ignore_instructions_zh", regexp.MustCompile(`(忽略|无视|忘记|忽视).{0,10}(之前|以上|前面|先前|所有|系统).{0,10}(指令|指示|提示|规则|要求)`)},
+	{"role_override", regexp.MustCompile(`(?i)\byou are now\b|\bact as an? (unrestricted|jailbroken)\b|\b(developer|dan|god) mode\b`)},
+	{"fake_system_message", regexp.MustCompile(`(?im)<\|im_start\|>|<\|system\|>|</?system>|\[/?(system|inst)\]|^\s*(system|assistant)\s*:`)},
+	{"prompt_exfiltration", regexp.MustCompile(`(?i)\b(reveal|print|show|repeat|output)\b.{0,30}\b(system prompt|hidden instructions|initial instructions)\b`)},
+	{"secret_exfiltration", regexp.MustCompile(`(?i)\b(send|post|upload|exfiltrate|forward)\b.{0,40}\b(api[_ -]?keys?|credentials|secrets?|passwords?|\.env|ssh keys?)\b`)},
+	{"concealment", regexp.MustCompile(`(?i)\bdo not (tell|inform|mention|reveal)\b.{0,20}\buser\b`)},
+	{"remote_exec", regexp.MustCompile(`(?i)\b(curl|wget)\b[^\n|]{0,200}\|\s*(ba|z)?sh\b`)},
+}
+
+// injectionGuardPart 待检查的工具结果或检索内容，Path 为移除时替换的位置
+type injectionGuardPart struct {
+	Kind        string
+	Role        string
+	Path        string
+	Text        string
+	Raw         string
+	Replacement any
+}
+
+// extractInjectionGuardParts 提取请求体中的工具结果与检索内容
+func extractInjectionGuardParts(body []byte, format types.RelayFormat) []injectionGuardPart {
+	var parts []injectionGuardPart
+	root := gjson.ParseBytes(body)
+	switch format {
+	case types.RelayFormatOpenAI:
+		root.Get("messages").ForEach(func(i, message gjson.Result) bool {
+			role := message.Get("role").String()
+			if role == "tool" || role == "function" {
+				parts = append(parts, injectionGuardPart{
+					Kind: "tool_result", Role: role, Path: fmt.Sprintf("messages.%d.content", i.Int()),
+					Text: injectionGuardText(message.Get("content")), Raw: message.Raw, Replacement: injectionGuardStripNotice,
+				})
+			}
+			return true
+		})
+	case types.RelayFormatClaude:
+		root.Get("messages").ForEach(func(i, message gjson.Result) bool {
+			role := message.Get("role").String()
+			message.Get("content").ForEach(func(j, block gjson.Result) bool {
+				prefix := fmt.Sprintf("messages.%d.content.%d", i.Int(), j.Int())
+				switch block.Get("type").String() {
+				case "tool_result":
+					parts = append(parts, injectionGuardPart{
+						Kind: "tool_result", Role: role, Path: prefix + ".content",
+						Text: injectionGuardText(block.Get("content")), Raw: block.Raw, Replacement: injectionGuardStripNotice,
+					})
+				case "document":
+					if block.Get("source.type").String() == "text" {
+						parts = append(parts, injectionGuardPart{
+							Kind: "document", Role: role, Path: prefix + ".source.data",
+							Text: block.Get("source.data").String(), Raw: block.Raw, Replacement: injectionGuardStripNotice,
+						})
+					}
+				case "search_result":
+					parts = append(parts, injectionGuardPart{
+						Kind: "search_result", Role: role, Path: prefix + ".content",
+						Text: injectionGuardText(block.Get("content")), Raw: block.Raw,
+						Replacement: []map[string]string{{"type": "text", "text": injectionGuardStripNotice}},
+					})
+				}
+				return true
+			})
+			return true
+		})
+	case types.RelayFormatOpenAIResponses:
+		root.Get("input").ForEach(func(i, item gjson.Result) bool {
+			switch item.Get("type").String() {
+			case "function_call_output", "custom_tool_call_output":
+				parts = append(parts, injectionGuardPart{
+					Kind: "tool_result", Path: fmt.Sprintf("input.%d.output", i.Int()),
+					Text: injectionGuardText(item.Get("output")), Raw: item.Raw, Replacement: injectionGuardStripNotice,
+				})
+			}
+			return true
+		})
+	case types.RelayFormatGemini:
+		root.Get("contents").ForEach(func(i, content gjson.Result) bool {
+			role := content.Get("role").String()
+			content.Get("parts").ForEach(func(j, part gjson.Result) bool {
+				if response := part.Get("functionResponse.response"); response.Exists() {
+					parts = append(parts, injectionGuardPart{
+						Kind: "tool_result", Role: role, Path: fmt.Sprintf("contents.%d.parts.%d.functionResponse.response", i.Int(), j.Int()),
+						Text: response.Raw, Raw: part.Raw, Replacement: map[string]string{"content": injectionGuardStripNotice},
+					})
+				}
+				return true
+			})
+			return true
+		})
+	}
+	return parts
+}
+
+// injectionGuardText 提取字符串或内容块数组中的文本，其他结构使用原始 JSON
+func injectionGuardText(value gjson.Result) string {
+	if value.Type == gjson.String {
+		return value.String()
+	}
+	if !value.IsArray() {
+		return value.Raw
+	}
+	var texts []string
+	value.ForEach(func(_, item gjson.Result) bool {
+		if item.Type == gjson.String {
+			texts = append(texts, item.String())
+		} else if text := item.Get("text"); text.Exists() {
+			texts = append(texts, text.String())
+		}
+		return true
+	})
+	return strings.Join(texts, "\n")
+}
+
+// isInjectionGuardTarget 判断请求是否来自配置的 agent 客户端
+func isInjectionGuardTarget(c *gin.Context, setting *operation_setting.InjectionGuardSetting) bool {
+	if len(setting.AffinityRules) == 0 {
+		return true
+	}
+	userAgent := c.Request.UserAgent()
+	for _, rule := range operation_setting.GetChannelAffinitySetting().Rules {
+		if slices.Contains(setting.AffinityRules, rule.Name) && matchAnyIncludeFold(rule.UserAgentInclude, userAgent) {
+			return true
+		}
+	}
+	return false
+}
+
+type injectionGuardHit struct {
+	name        string
+	action      string
+	returnError any
+}
+
+// matchInjectionGuard 使用配置规则与启发式规则检查片段
+func matchInjectionGuard(setting *operation_setting.InjectionGuardSetting, part injectionGuardPart, body string) ([]injectionGuardHit, error) {
+	var hits []injectionGuardHit
+	doc, err := common.Marshal(map[string]any{
+		"text": part.Text,
+		"kind": part.Kind,
+		"role": part.Role,
+		"part": json.RawMessage(part.Raw),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range setting.Rules {
+		if !rule.Enabled || len(rule.Conditions) == 0 {
+			continue
+		}
+		conditions := make([]relaycommon.ConditionOperation, 0, len(rule.Conditions))
+		for _, condition := range rule.Conditions {
+			conditions = append(conditions, relaycommon.ConditionOperation{
+				Path:           condition.Path,
+				Mode:           condition.Mode,
+				Value:          condition.Value,
+				Invert:         condition.Invert,
+				PassMissingKey: condition.PassMissingKey,
+			})
+		}
+		matched, err := relaycommon.CheckConditions(string(doc), body, conditions, rule.Logic)
+		if err != nil {
+			return nil, fmt.Errorf("injection guard rule %s: %w", rule.Name, err)
+		}
+		if matched {
+			hits = append(hits, injectionGuardHit{name: rule.Name, action: rule.Action, returnError: rule.ReturnError})
+		}
+	}
+	if setting.Heuristics {
+		for _, heuristic := range injectionHeuristics {
+			if heuristic.pattern.MatchString(part.Text) {
+				hits = append(hits, injectionGuardHit{name: "heuristic:" + heuristic.name, action: setting.HeuristicAction})
+			}
+		}
+	}
+	return hits, nil
+}
+
+// GuardPromptInjection 检查请求中的工具结果与检索内容，命中时按规则拒绝请求、在日志中标记或移除片段。
+// 返回请求体是否被改写，改写后需要重新解析请求
+func GuardPromptInjection(c *gin.Context, info *relaycommon.RelayInfo) (bool, *types.NewAPIError) {
+	setting := operation_setting.GetInjectionGuardSetting()
+	if !setting.Enabled || info.IsChannelTest || !isInjectionGuardTarget(c, setting) {
+		return false, nil
+	}
+	if !strings.HasPrefix(c.Request.Header.Get("Content-Type"), "application/json") {
+		return false, nil
+	}
+	storage, err := common.GetBodyStorage(c)
+	if err != nil {
+		return false, types.NewError(err, types.ErrorCodeReadRequestBodyFailed, types.ErrOptionWithSkipRetry())
+	}
+	body, err := storage.Bytes()
+	if err != nil {
+		return false, types.NewError(err, types.ErrorCodeReadRequestBodyFailed, types.ErrOptionWithSkipRetry())
+	}
+
+	newBody, stripped := body, false
+	for _, part := range extractInjectionGuardParts(body, info.RelayFormat) {
+		hits, err := matchInjectionGuard(setting, part, string(body))
+		if err != nil {
+			return false, types.NewError(err, types.ErrorCodeInvalidRequest, types.ErrOptionWithSkipRetry())
+		}
+		strip := false
+		for _, hit := range hits {
+			info.InjectionGuardAudit = append(info.InjectionGuardAudit, fmt.Sprintf("%s:%s:%s", part.Kind, hit.name, hit.action))
+			switch hit.action {
+			case operation_setting.InjectionGuardActionBlock:
+				logger.LogWarn(c, fmt.Sprintf("prompt injection guard blocked request: rule=%s, part=%s", hit.name, part.Path))
+				return false, relaycommon.NewAPIErrorFromParamOverride(injectionGuardReturnError(c, hit))
+			case operation_setting.InjectionGuardActionStrip:
+				strip = true
+			}
+		}
+		if strip {
+			if newBody, err = sjson.SetBytes(newBody, part.Path, part.Replacement); err != nil {
+				return false, types.NewError(err, types.ErrorCodeInvalidRequest, types.ErrOptionWithSkipRetry())
+			}
+			stripped = true
+		}
+	}
+	if !stripped {
+		return false, nil
+	}
+	if err = replaceRequestBody(c, storage, newBody); err != nil {
+		return false, types.NewError(err, types.ErrorCodeReadRequestBodyFailed, types.ErrOptionWithSkipRetry())
+	}
+	return true, nil
+}
+
+// injectionGuardReturnError 解析规则配置的 return_error，未配置或配置无效时使用默认错误
+func injectionGuardReturnError(c *gin.Context, hit injectionGuardHit) *relaycommon.ParamOverrideReturnError {
+	if hit.returnError != nil {
+		returnErr, err := relaycommon.ParseReturnError(hit.returnError)
+		if err == nil {
+			return returnErr
+		}
+		logger.LogError(c, fmt.Sprintf("invalid return_error of injection guard rule %s: %s", hit.name, err.Error()))
+	}
+	return &relaycommon.ParamOverrideReturnError{
+		Message:    "request blocked by prompt injection guard: " + hit.name,
+		StatusCode: http.StatusBadRequest,
+		Code:       string(types.ErrorCodePromptBlocked),
+		Type:       "invalid_request_error",
+		SkipRetry:  true,
+	}
+}
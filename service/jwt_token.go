@@ -0,0 +1,288 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/pkg/cachex"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/samber/hot"
+)
+
+const (
+	jwtDenyListNamespace = "new-api:jwt_deny:v1"
+
+	// 吊销时未提供过期时间则按最长有效期保留，未限制最长有效期时保留 30 天
+	jwtDefaultDenyTTL = 30 * 24 * time.Hour
+	jwksRefreshPeriod = 10 * time.Minute
+)
+
+var (
+	jwtDenyListOnce sync.Once
+	jwtDenyList     *cachex.HybridCache[int]
+)
+
+// JWTTokenClaims JWT 令牌的声明，sub 为用户 ID
+type JWTTokenClaims struct {
+	Name   string   `json:"name,omitempty"`
+	Group  string   `json:"group,omitempty"`
+	Models []string `json:"models,omitempty"`
+	Quota  int      `json:"quota,omitempty"` // 额度上限，0 表示不限制（仍受用户余额限制）
+	jwt.RegisteredClaims
+}
+
+func newIntHybridCache(namespace string, capacity int) *cachex.HybridCache[int] {
+	return cachex.NewHybridCache[int](cachex.HybridCacheConfig[int]{
+		Namespace: cachex.Namespace(namespace),
+		Redis:     common.RDB,
+		RedisEnabled: func() bool {
+			return common.RedisEnabled && common.RDB != nil
+		},
+		RedisCodec: cachex.IntCodec{},
+		Memory: func() *hot.HotCache[string, int] {
+			return hot.NewHotCache[string, int](hot.LRU, capacity).
+				WithTTL(jwtDefaultDenyTTL).
+				WithJanitor().
+				Build()
+		},
+	})
+}
+
+func getJWTDenyList() *cachex.HybridCache[int] {
+	jwtDenyListOnce.Do(func() {
+		jwtDenyList = newIntHybridCache(jwtDenyListNamespace, 100_000)
+	})
+	return jwtDenyList
+}
+
+// IsJWTBearer 判断 Authorization 中的凭证是否为 JWT，sk- 令牌不包含点号
+func IsJWTBearer(key string) bool {
+	return operation_setting.GetJWTTokenSetting().Enabled &&
+		strings.HasPrefix(key, "eyJ") && strings.Count(key, ".") == 2
+}
+
+// ValidateJWTToken 校验 JWT 签名、有效期与吊销状态，并转换为不落库的令牌。
+// 转换后的令牌 Id 为 0，额度上限按 jti 在数据库中统计
+func ValidateJWTToken(raw string) (*model.Token, *JWTTokenClaims, error) {
+	setting := operation_setting.GetJWTTokenSetting()
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{setting.Algorithm}),
+		jwt.WithExpirationRequired(),
+	}
+	if setting.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(setting.Issuer))
+	}
+	if setting.Audience != "" {
+		opts = append(opts, jwt.WithAudience(setting.Audience))
+	}
+	claims := &JWTTokenClaims{}
+	if _, err := jwt.ParseWithClaims(raw, claims, jwtKeyFunc(setting), opts...); err != nil {
+		return nil, nil, err
+	}
+	if claims.ID == "" {
+		return nil, nil, errors.New("jwt: missing jti")
+	}
+	if setting.MaxTTLSeconds > 0 {
+		if claims.IssuedAt == nil {
+			return nil, nil, errors.New("jwt: missing iat")
+		}
+		if claims.ExpiresAt.Sub(claims.IssuedAt.Time) > time.Duration(setting.MaxTTLSeconds)*time.Second {
+			return nil, nil, errors.New("jwt: ttl exceeds the allowed maximum")
+		}
+	}
+	userId, err := strconv.Atoi(claims.Subject)
+	if err != nil || userId <= 0 {
+		return nil, nil, errors.New("jwt: invalid sub")
+	}
+	if _, revoked, _ := getJWTDenyList().Get(claims.ID); revoked {
+		return nil, nil, errors.New("jwt: token revoked")
+	}
+
+	name := claims.Name
+	if name == "" {
+		name = claims.ID
+	}
+	token := &model.Token{
+		UserId:             userId,
+		Key:                "jwt-" + claims.ID,
+		Name:               "jwt:" + name,
+		Status:             common.TokenStatusEnabled,
+		ExpiredTime:        claims.ExpiresAt.Unix(),
+		UnlimitedQuota:     claims.Quota <= 0,
+		ModelLimitsEnabled: len(claims.Models) > 0,
+		ModelLimits:        strings.Join(claims.Models, ","),
+		Group:              claims.Group,
+	}
+	if claims.Quota > 0 {
+		spend, err := model.GetJWTSpend(claims.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		token.RemainQuota = claims.Quota - spend
+		if token.RemainQuota <= 0 {
+			return nil, nil, errors.New("jwt: quota exhausted")
+		}
+	}
+	return token, claims, nil
+}
+
+func jwtKeyFunc(setting *operation_setting.JWTTokenSetting) jwt.Keyfunc {
+	return func(t *jwt.Token) (interface{}, error) {
+		switch setting.Algorithm {
+		case "HS256":
+			if setting.Secret == "" {
+				return nil, errors.New("jwt: secret not configured")
+			}
+			return []byte(setting.Secret), nil
+		case "RS256", "EdDSA":
+			if setting.JWKSURL != "" {
+				kid, _ := t.Header["kid"].(string)
+				if key, err := getJWKSKey(setting.JWKSURL, kid); err == nil || setting.PublicKey == "" {
+					return key, err
+				}
+			}
+			if setting.Algorithm == "RS256" {
+				return jwt.ParseRSAPublicKeyFromPEM([]byte(setting.PublicKey))
+			}
+			return jwt.ParseEdPublicKeyFromPEM([]byte(setting.PublicKey))
+		default:
+			return nil, fmt.Errorf("jwt: unsupported algorithm %s", setting.Algorithm)
+		}
+	}
+}
+
+type jwksKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+}
+
+var jwks = struct {
+	sync.Mutex
+	url       string
+	keys      map[string]interface{}
+	fetchedAt time.Time
+}{}
+
+// getJWKSKey 按 kid 获取 JWKS 中的公钥，定期刷新，找不到 kid 时立即刷新一次以支持密钥轮换
+func getJWKSKey(url string, kid string) (interface{}, error) {
+	jwks.Lock()
+	defer jwks.Unlock()
+	stale := jwks.url != url || time.Since(jwks.fetchedAt) > jwksRefreshPeriod
+	if !stale {
+		if key, ok := jwks.keys[kid]; ok {
+			return key, nil
+		}
+	}
+	// 避免未知 kid 频繁触发拉取
+	if stale || time.Since(jwks.fetchedAt) > 10*time.Second {
+		keys, err := fetchJWKS(url)
+		if err != nil {
+			return nil, err
+		}
+		jwks.url, jwks.keys, jwks.fetchedAt = url, keys, time.Now()
+	}
+	if key, ok := jwks.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("jwt: key %q not found in jwks", kid)
+}
+
+func fetchJWKS(url string) (map[string]interface{}, error) {
+	resp, err := GetHttpClient().Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwt: fetch jwks failed, status %d", resp.StatusCode)
+	}
+	var body struct {
+		Keys []jwksKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]interface{}, len(body.Keys))
+	for _, k := range body.Keys {
+		switch {
+		case k.Kty == "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case k.Kty == "OKP" && k.Crv == "Ed25519":
+			x, err := base64.RawURLEncoding.DecodeString(k.X)
+			if err != nil || len(x) != ed25519.PublicKeySize {
+				continue
+			}
+			keys[k.Kid] = ed25519.PublicKey(x)
+		}
+	}
+	return keys, nil
+}
+
+// RevokeJWT 将 jti 加入吊销列表，expiresAt 为令牌的过期时间（秒），未知时传 0
+func RevokeJWT(jti string, expiresAt int64) error {
+	if jti == "" {
+		return errors.New("jti 不能为空")
+	}
+	ttl := jwtDefaultDenyTTL
+	if maxTTL := operation_setting.GetJWTTokenSetting().MaxTTLSeconds; maxTTL > 0 {
+		ttl = time.Duration(maxTTL) * time.Second
+	}
+	if expiresAt > 0 {
+		ttl = time.Until(time.Unix(expiresAt, 0))
+		if ttl <= 0 {
+			return nil
+		}
+	}
+	return getJWTDenyList().SetWithTTL(jti, 1, ttl)
+}
+
+// reserveJWTQuota JWT 额度上限的硬限制：按本次预扣额度 quota 原子地检查并预留花费，超出上限时拒绝请求。
+// reserved 为实际计入花费的额度，信任额度旁路时小于 quota，检查通过后按 reserved 保留
+func reserveJWTQuota(relayInfo *relaycommon.RelayInfo, quota int, reserved int) *types.NewAPIError {
+	if relayInfo.TokenJWTId == "" || relayInfo.TokenJWTQuota <= 0 {
+		return nil
+	}
+	ok, err := model.ReserveJWTSpend(relayInfo.TokenJWTId, quota, relayInfo.TokenJWTQuota, relayInfo.TokenJWTExpiresAt)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeUpdateDataError, types.ErrOptionWithSkipRetry())
+	}
+	if !ok {
+		return types.NewErrorWithStatusCode(errors.New("JWT 令牌额度不足"), types.ErrorCodePreConsumeTokenQuotaFailed, http.StatusForbidden, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+	}
+	adjustJWTSpend(relayInfo, reserved-quota)
+	return nil
+}
+
+// adjustJWTSpend 调整 JWT 令牌的花费，记录保留到令牌过期
+func adjustJWTSpend(relayInfo *relaycommon.RelayInfo, delta int) {
+	if relayInfo.TokenJWTId == "" || relayInfo.TokenJWTQuota <= 0 || delta == 0 {
+		return
+	}
+	if err := model.AddJWTSpend(relayInfo.TokenJWTId, delta, relayInfo.TokenJWTExpiresAt); err != nil {
+		common.SysLog(fmt.Sprintf("error updating jwt spend (jti=%s, delta=%d): %s", relayInfo.TokenJWTId, delta, err.Error()))
+	}
+}
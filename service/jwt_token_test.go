@@ -0,0 +1,114 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateJWTToken(t *testing.T) {
+	setting := operation_setting.GetJWTTokenSetting()
+	original := *setting
+	t.Cleanup(func() { *setting = original })
+	setting.Enabled = true
+	setting.Algorithm = "HS256"
+	setting.Secret = "test-secret"
+	setting.Issuer = "partner"
+	setting.MaxTTLSeconds = 3600
+
+	now := time.Now()
+	sign := func(claims JWTTokenClaims, method jwt.SigningMethod, key interface{}) string {
+		raw, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		require.NoError(t, err)
+		return raw
+	}
+	claims := func(jti string, ttl time.Duration) JWTTokenClaims {
+		return JWTTokenClaims{
+			Group:  "default",
+			Models: []string{"gpt-4.1", "claude-sonnet-4"},
+			Quota:  1000,
+			RegisteredClaims: jwt.RegisteredClaims{
+				ID:        jti,
+				Subject:   "7",
+				Issuer:    "partner",
+				IssuedAt:  jwt.NewNumericDate(now),
+				ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			},
+		}
+	}
+
+	raw := sign(claims("jti-ok", time.Minute), jwt.SigningMethodHS256, []byte("test-secret"))
+	require.True(t, IsJWTBearer(raw))
+	token, parsed, err := ValidateJWTToken(raw)
+	require.NoError(t, err)
+	require.Equal(t, "jti-ok", parsed.ID)
+	require.Equal(t, 7, token.UserId)
+	require.Equal(t, 0, token.Id)
+	require.Equal(t, "default", token.Group)
+	require.True(t, token.ModelLimitsEnabled)
+	require.Equal(t, map[string]bool{"gpt-4.1": true, "claude-sonnet-4": true}, token.GetModelLimitsMap())
+	require.False(t, token.UnlimitedQuota)
+	require.Equal(t, 1000, token.RemainQuota)
+
+	// 错误的密钥、过长的有效期、错误的签发者
+	_, _, err = ValidateJWTToken(sign(claims("jti-bad-key", time.Minute), jwt.SigningMethodHS256, []byte("other")))
+	require.Error(t, err)
+	_, _, err = ValidateJWTToken(sign(claims("jti-long", 2*time.Hour), jwt.SigningMethodHS256, []byte("test-secret")))
+	require.Error(t, err)
+	wrongIssuer := claims("jti-issuer", time.Minute)
+	wrongIssuer.Issuer = "someone-else"
+	_, _, err = ValidateJWTToken(sign(wrongIssuer, jwt.SigningMethodHS256, []byte("test-secret")))
+	require.Error(t, err)
+
+	// 算法与配置不一致时拒绝
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	_, _, err = ValidateJWTToken(sign(claims("jti-eddsa", time.Minute), jwt.SigningMethodEdDSA, priv))
+	require.Error(t, err)
+
+	// 吊销后拒绝
+	require.NoError(t, RevokeJWT("jti-ok", now.Add(time.Minute).Unix()))
+	_, _, err = ValidateJWTToken(raw)
+	require.Error(t, err)
+
+	setting.Enabled = false
+	require.False(t, IsJWTBearer(raw))
+}
+
+func TestJWTQuotaReservation(t *testing.T) {
+	truncate(t)
+	t.Cleanup(func() { model.DB.Exec("DELETE FROM jwt_spends") })
+	info := &relaycommon.RelayInfo{TokenJWTId: "jti-quota", TokenJWTQuota: 1000, TokenJWTExpiresAt: time.Now().Add(time.Hour).Unix()}
+
+	require.Nil(t, reserveJWTQuota(info, 600, 600))
+	// 预留后剩余额度不足，并发请求无法超出上限
+	apiErr := reserveJWTQuota(info, 500, 500)
+	require.NotNil(t, apiErr)
+	require.Equal(t, types.ErrorCodePreConsumeTokenQuotaFailed, apiErr.GetErrorCode())
+
+	// 信任旁路只检查上限，按实际计入的额度保留
+	require.Nil(t, reserveJWTQuota(info, 400, 0))
+	spend, err := model.GetJWTSpend("jti-quota")
+	require.NoError(t, err)
+	require.Equal(t, 600, spend)
+
+	adjustJWTSpend(info, -200)
+	require.Nil(t, reserveJWTQuota(info, 500, 500))
+	spend, err = model.GetJWTSpend("jti-quota")
+	require.NoError(t, err)
+	require.Equal(t, 900, spend)
+
+	// 过期的花费记录被清理
+	require.NoError(t, model.DB.Model(&model.JWTSpend{}).Where("jti = ?", "jti-quota").Update("expires_at", time.Now().Unix()-1).Error)
+	n, err := model.DeleteExpiredJWTSpends(10)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+}
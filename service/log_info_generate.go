@@ -51,6 +51,26 @@ func GenerateTextOtherInfo(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, m
 		other["is_model_mapped"] = true
 		other["upstream_model_name"] = relayInfo.UpstreamModelName
 	}
+	if relayInfo.RequestedModelName != "" && relayInfo.RequestedModelName != relayInfo.OriginModelName {
+		other["requested_model"] = relayInfo.RequestedModelName
+		other["served_model"] = relayInfo.OriginModelName
+	}
+	if relayInfo.TokenJWTId != "" {
+		other["jwt_id"] = relayInfo.TokenJWTId
+	}
+	if len(relayInfo.ModerationAudit) > 0 {
+		other["moderation"] = moderationAuditSummary(relayInfo.ModerationAudit)
+	}
+	if relayInfo.PIIRedaction != nil && len(relayInfo.PIIRedaction.Counts) > 0 {
+		other["pii_redaction"] = relayInfo.PIIRedaction.Counts
+	}
+	if len(relayInfo.InjectionGuardAudit) > 0 {
+		other["injection_guard"] = moderationAuditSummary(relayInfo.InjectionGuardAudit)
+	}
+	if relayInfo.BatchId != "" {
+		other["batch_id"] = relayInfo.BatchId
+		other["batch_discount_ratio"] = relayInfo.BatchDiscountRatio
+	}
 
 	isSystemPromptOverwritten := common.GetContextKeyBool(ctx, constant.ContextKeySystemPromptOverride)
 	if isSystemPromptOverwritten {
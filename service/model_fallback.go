@@ -0,0 +1,89 @@
+package service
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+	"github.com/gin-gonic/gin"
+)
+
+// modelFallbackPaths 支持模型降级的接口，实时语音、任务等接口的模型与请求强绑定，不做降级
+var modelFallbackPaths = []string{
+	"/v1/chat/completions",
+	"/pg/chat/completions",
+	"/v1/completions",
+	"/v1/messages",
+	"/v1/responses",
+	"/v1beta/models",
+}
+
+// IsModelFallbackEnabled 令牌单独设置优先，未设置时使用默认设置。指定渠道的请求不做降级
+func IsModelFallbackEnabled(c *gin.Context) bool {
+	setting := operation_setting.GetModelFallbackSetting()
+	if !setting.Enabled {
+		return false
+	}
+	if _, ok := c.Get("specific_channel_id"); ok {
+		return false
+	}
+	if c.Request == nil || c.Request.URL == nil || strings.HasPrefix(c.Request.URL.Path, "/v1/responses/compact") {
+		return false
+	}
+	if !slices.ContainsFunc(modelFallbackPaths, func(prefix string) bool {
+		return strings.HasPrefix(c.Request.URL.Path, prefix)
+	}) {
+		return false
+	}
+	switch common.GetContextKeyString(c, constant.ContextKeyTokenModelFallback) {
+	case model.TokenModelFallbackEnabled:
+		return true
+	case model.TokenModelFallbackDisabled:
+		return false
+	}
+	return setting.DefaultEnabled
+}
+
+// GetModelFallbacks 获取请求模型的降级模型，跳过令牌无权使用的模型
+func GetModelFallbacks(c *gin.Context, requestedModel string) []string {
+	if !IsModelFallbackEnabled(c) {
+		return nil
+	}
+	chain := operation_setting.GetModelFallbackChain(requestedModel)
+	fallbacks := make([]string, 0, len(chain))
+	for _, fallbackModel := range chain {
+		if fallbackModel == "" || fallbackModel == requestedModel || slices.Contains(fallbacks, fallbackModel) {
+			continue
+		}
+		if !isTokenModelAllowed(c, fallbackModel) {
+			continue
+		}
+		fallbacks = append(fallbacks, fallbackModel)
+	}
+	return fallbacks
+}
+
+// NextModelFallback 获取降级链中当前模型之后的下一个模型
+func NextModelFallback(c *gin.Context, requestedModel string, currentModel string) (string, bool) {
+	fallbacks := GetModelFallbacks(c, requestedModel)
+	next := slices.Index(fallbacks, currentModel) + 1
+	if next >= len(fallbacks) {
+		return "", false
+	}
+	return fallbacks[next], true
+}
+
+func isTokenModelAllowed(c *gin.Context, modelName string) bool {
+	if !common.GetContextKeyBool(c, constant.ContextKeyTokenModelLimitEnabled) {
+		return true
+	}
+	modelLimit, ok := common.GetContextKeyType[map[string]bool](c, constant.ContextKeyTokenModelLimit)
+	if !ok {
+		return false
+	}
+	return modelLimit[ratio_setting.FormatMatchingModelName(modelName)]
+}
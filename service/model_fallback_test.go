@@ -0,0 +1,64 @@
+package service
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModelFallbackChain(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setting := operation_setting.GetModelFallbackSetting()
+	original := *setting
+	t.Cleanup(func() { *setting = original })
+	setting.Enabled = true
+	setting.DefaultEnabled = true
+	setting.Chains = map[string][]string{
+		"claude-sonnet-4": {"gpt-4.1", "claude-sonnet-4", "gpt-4.1", "gemini-2.5-pro"},
+	}
+
+	newContext := func(path string) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("POST", path, nil)
+		return c
+	}
+
+	c := newContext("/v1/messages")
+	require.Equal(t, []string{"gpt-4.1", "gemini-2.5-pro"}, GetModelFallbacks(c, "claude-sonnet-4"))
+	next, ok := NextModelFallback(c, "claude-sonnet-4", "claude-sonnet-4")
+	require.True(t, ok)
+	require.Equal(t, "gpt-4.1", next)
+	next, ok = NextModelFallback(c, "claude-sonnet-4", "gpt-4.1")
+	require.True(t, ok)
+	require.Equal(t, "gemini-2.5-pro", next)
+	_, ok = NextModelFallback(c, "claude-sonnet-4", "gemini-2.5-pro")
+	require.False(t, ok)
+
+	// 令牌限制模型时跳过无权使用的降级模型
+	common.SetContextKey(c, constant.ContextKeyTokenModelLimitEnabled, true)
+	common.SetContextKey(c, constant.ContextKeyTokenModelLimit, map[string]bool{"gemini-2.5-pro": true})
+	require.Equal(t, []string{"gemini-2.5-pro"}, GetModelFallbacks(c, "claude-sonnet-4"))
+
+	// 令牌单独关闭
+	c = newContext("/v1/chat/completions")
+	common.SetContextKey(c, constant.ContextKeyTokenModelFallback, model.TokenModelFallbackDisabled)
+	require.Empty(t, GetModelFallbacks(c, "claude-sonnet-4"))
+
+	// 默认关闭时令牌可单独开启
+	setting.DefaultEnabled = false
+	c = newContext("/v1/chat/completions")
+	require.Empty(t, GetModelFallbacks(c, "claude-sonnet-4"))
+	common.SetContextKey(c, constant.ContextKeyTokenModelFallback, model.TokenModelFallbackEnabled)
+	require.Len(t, GetModelFallbacks(c, "claude-sonnet-4"), 2)
+
+	// 不支持降级的接口
+	c = newContext("/v1/embeddings")
+	common.SetContextKey(c, constant.ContextKeyTokenModelFallback, model.TokenModelFallbackEnabled)
+	require.Empty(t, GetModelFallbacks(c, "claude-sonnet-4"))
+}
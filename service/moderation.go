@@ -0,0 +1,481 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	moderationRedactMask     = "**###**"
+	moderationDefaultTimeout = 10 * time.Second
+	moderationDefaultModel   = "omni-moderation-latest"
+)
+
+// ModerationInput 待审核的内容
+type ModerationInput struct {
+	Text      string
+	ImageURLs []string
+}
+
+// ModerationHit 检查器命中的一个类别
+type ModerationHit struct {
+	Checker  string
+	Category string
+	Score    float64
+	// Matches 命中的原文片段，仅 keyword / regex 检查器提供
+	Matches []string
+}
+
+type moderationChecker interface {
+	Check(ctx context.Context, input *ModerationInput) ([]ModerationHit, error)
+}
+
+// moderationRedactor 能定位命中内容的检查器，可用于脱敏与流式输出扫描
+type moderationRedactor interface {
+	moderationChecker
+	// spans 返回命中内容在 text 中的 rune 区间
+	spans(text []rune) [][2]int
+}
+
+var moderationCheckerCache sync.Map // config json -> moderationChecker
+
+func buildModerationChecker(cfg operation_setting.ModerationChecker) (moderationChecker, error) {
+	cacheKey, err := common.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cached, ok := moderationCheckerCache.Load(string(cacheKey)); ok {
+		return cached.(moderationChecker), nil
+	}
+	category := cfg.Category
+	if category == "" {
+		category = cfg.Name
+	}
+	var checker moderationChecker
+	switch cfg.Type {
+	case operation_setting.ModerationCheckerKeyword:
+		checker = &keywordModerationChecker{name: cfg.Name, category: category, words: cfg.Keywords}
+	case operation_setting.ModerationCheckerRegex:
+		patterns := make([]*regexp.Regexp, 0, len(cfg.Patterns))
+		for _, p := range cfg.Patterns {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return nil, fmt.Errorf("moderation checker %s: invalid pattern %q: %w", cfg.Name, p, err)
+			}
+			patterns = append(patterns, re)
+		}
+		checker = &regexModerationChecker{name: cfg.Name, category: category, patterns: patterns}
+	case operation_setting.ModerationCheckerOpenAI:
+		checker = &openAIModerationChecker{cfg: cfg}
+	case operation_setting.ModerationCheckerHTTP:
+		checker = &httpModerationChecker{cfg: cfg}
+	default:
+		return nil, fmt.Errorf("moderation checker %s: unsupported type %q", cfg.Name, cfg.Type)
+	}
+	moderationCheckerCache.Store(string(cacheKey), checker)
+	return checker, nil
+}
+
+// policyModerationCheckers 获取策略使用的检查器，名称为空表示全部已启用的检查器
+func policyModerationCheckers(setting *operation_setting.ModerationSetting, policy *operation_setting.ModerationPolicy) ([]moderationChecker, []string) {
+	checkers := make([]moderationChecker, 0, len(setting.Checkers))
+	names := make([]string, 0, len(setting.Checkers))
+	for _, cfg := range setting.Checkers {
+		if !cfg.Enabled {
+			continue
+		}
+		if len(policy.Checkers) > 0 && !common.StringsContains(policy.Checkers, cfg.Name) {
+			continue
+		}
+		checker, err := buildModerationChecker(cfg)
+		if err != nil {
+			common.SysError(err.Error())
+			continue
+		}
+		checkers = append(checkers, checker)
+		names = append(names, cfg.Name)
+	}
+	return checkers, names
+}
+
+// ModerationDecision 审核结果
+type ModerationDecision struct {
+	Hits []ModerationHit
+	// Blocked 命中了处理方式为拒绝的类别
+	Blocked  bool
+	Category string
+	// Redactors 需要脱敏的检查器
+	Redactors []moderationRedactor
+	Audit     []string
+}
+
+// Redact 使用命中的检查器替换文本中的命中内容
+func (d *ModerationDecision) Redact(text string) string {
+	for _, redactor := range d.Redactors {
+		text = redactText(text, redactor.spans([]rune(text)))
+	}
+	return text
+}
+
+// RunModeration 按策略执行审核
+func RunModeration(ctx context.Context, policy *operation_setting.ModerationPolicy, input *ModerationInput) (*ModerationDecision, error) {
+	setting := operation_setting.GetModerationSetting()
+	checkers, names := policyModerationCheckers(setting, policy)
+	if !policy.CheckImages {
+		input = &ModerationInput{Text: input.Text}
+	}
+	decision := &ModerationDecision{}
+	for i, checker := range checkers {
+		hits, err := checker.Check(ctx, input)
+		if err != nil {
+			if setting.FailOpen {
+				common.SysError(fmt.Sprintf("moderation checker %s failed: %s", names[i], err.Error()))
+				continue
+			}
+			return nil, fmt.Errorf("moderation checker %s failed: %w", names[i], err)
+		}
+		redactor, canRedact := checker.(moderationRedactor)
+		redact := false
+		for _, hit := range hits {
+			action := policy.ActionFor(hit.Category)
+			if action == operation_setting.ModerationActionRedact && !canRedact {
+				action = operation_setting.ModerationActionBlock
+			}
+			switch action {
+			case operation_setting.ModerationActionBlock:
+				if !decision.Blocked {
+					decision.Blocked = true
+					decision.Category = hit.Category
+				}
+			case operation_setting.ModerationActionRedact:
+				redact = true
+			}
+			decision.Hits = append(decision.Hits, hit)
+			decision.Audit = append(decision.Audit, fmt.Sprintf("input:%s:%s:%s", hit.Checker, hit.Category, action))
+		}
+		if redact {
+			decision.Redactors = append(decision.Redactors, redactor)
+		}
+	}
+	return decision, nil
+}
+
+// ModerateRequest 按用户所用分组的策略审核请求内容，并在策略开启时设置流式输出扫描器。
+// 返回的结果包含需要脱敏的检查器时，由调用方改写请求体后重新解析请求
+func ModerateRequest(c *gin.Context, info *relaycommon.RelayInfo, meta *types.TokenCountMeta) (*ModerationDecision, *types.NewAPIError) {
+	setting := operation_setting.GetModerationSetting()
+	if !setting.Enabled || info.IsChannelTest {
+		return nil, nil
+	}
+	policy := setting.PolicyForGroup(info.UsingGroup)
+	decision, err := RunModeration(c.Request.Context(), policy, ModerationInputFromMeta(meta))
+	if err != nil {
+		return nil, types.NewErrorWithStatusCode(err, types.ErrorCodeSensitiveWordsDetected, http.StatusServiceUnavailable, types.ErrOptionWithSkipRetry())
+	}
+	info.ModerationAudit = append(info.ModerationAudit, decision.Audit...)
+	if decision.Blocked {
+		logger.LogWarn(c, fmt.Sprintf("content moderation blocked request: category=%s", decision.Category))
+		return nil, types.NewErrorWithStatusCode(fmt.Errorf("content blocked by moderation policy: %s", decision.Category), types.ErrorCodeSensitiveWordsDetected, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	if info.IsStream {
+		info.AddOutputScanner(NewModerationOutputScanner(info, policy))
+	}
+	return decision, nil
+}
+
+// ModerationInputFromMeta 从请求的 token 统计信息中提取待审核的文本与图片
+func ModerationInputFromMeta(meta *types.TokenCountMeta) *ModerationInput {
+	input := &ModerationInput{}
+	if meta == nil {
+		return input
+	}
+	input.Text = meta.CombineText
+	for _, file := range meta.Files {
+		if file == nil || file.FileType != types.FileTypeImage || file.Source == nil {
+			continue
+		}
+		switch source := file.Source.(type) {
+		case *types.URLSource:
+			input.ImageURLs = append(input.ImageURLs, source.URL)
+		case *types.Base64Source:
+			if source.Base64Data == "" {
+				continue
+			}
+			if strings.HasPrefix(source.Base64Data, "data:") {
+				input.ImageURLs = append(input.ImageURLs, source.Base64Data)
+			} else {
+				mimeType := source.MimeType
+				if mimeType == "" {
+					mimeType = "image/png"
+				}
+				input.ImageURLs = append(input.ImageURLs, "data:"+mimeType+";base64,"+source.Base64Data)
+			}
+		}
+	}
+	return input
+}
+
+func redactText(text string, spans [][2]int) string {
+	if len(spans) == 0 {
+		return text
+	}
+	runes := []rune(text)
+	var builder strings.Builder
+	builder.Grow(len(text))
+	last := 0
+	for _, span := range mergeSpans(spans) {
+		builder.WriteString(string(runes[last:span[0]]))
+		builder.WriteString(moderationRedactMask)
+		last = span[1]
+	}
+	builder.WriteString(string(runes[last:]))
+	return builder.String()
+}
+
+// mergeSpans 排序并合并重叠的区间
+func mergeSpans(spans [][2]int) [][2]int {
+	sorted := make([][2]int, len(spans))
+	copy(sorted, spans)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i][0] < sorted[j][0] })
+	merged := sorted[:0]
+	for _, span := range sorted {
+		if n := len(merged); n > 0 && span[0] <= merged[n-1][1] {
+			merged[n-1][1] = max(merged[n-1][1], span[1])
+			continue
+		}
+		merged = append(merged, span)
+	}
+	return merged
+}
+
+func spansToHit(name, category string, text []rune, spans [][2]int) []ModerationHit {
+	if len(spans) == 0 {
+		return nil
+	}
+	matches := make([]string, 0, len(spans))
+	for _, span := range spans {
+		matches = append(matches, string(text[span[0]:span[1]]))
+	}
+	return []ModerationHit{{Checker: name, Category: category, Score: 1, Matches: matches}}
+}
+
+// keywordModerationChecker 关键词检查，大小写不敏感
+type keywordModerationChecker struct {
+	name     string
+	category string
+	words    []string
+}
+
+func (k *keywordModerationChecker) spans(text []rune) [][2]int {
+	m := getOrBuildAC(k.words)
+	if m == nil || len(text) == 0 {
+		return nil
+	}
+	lower := make([]rune, len(text))
+	for i, r := range text {
+		lower[i] = unicode.ToLower(r)
+	}
+	hits := m.MultiPatternSearch(lower, false)
+	spans := make([][2]int, 0, len(hits))
+	for _, hit := range hits {
+		spans = append(spans, [2]int{hit.Pos, hit.Pos + len(hit.Word)})
+	}
+	return spans
+}
+
+func (k *keywordModerationChecker) Check(ctx context.Context, input *ModerationInput) ([]ModerationHit, error) {
+	text := []rune(input.Text)
+	return spansToHit(k.name, k.category, text, k.spans(text)), nil
+}
+
+// regexModerationChecker 正则检查
+type regexModerationChecker struct {
+	name     string
+	category string
+	patterns []*regexp.Regexp
+}
+
+func (r *regexModerationChecker) spans(text []rune) [][2]int {
+	if len(text) == 0 {
+		return nil
+	}
+	s := string(text)
+	var spans [][2]int
+	for _, re := range r.patterns {
+		for _, loc := range re.FindAllStringIndex(s, -1) {
+			if loc[0] == loc[1] {
+				continue
+			}
+			// 字节偏移转换为 rune 偏移
+			start := len([]rune(s[:loc[0]]))
+			spans = append(spans, [2]int{start, start + len([]rune(s[loc[0]:loc[1]]))})
+		}
+	}
+	return spans
+}
+
+func (r *regexModerationChecker) Check(ctx context.Context, input *ModerationInput) ([]ModerationHit, error) {
+	text := []rune(input.Text)
+	return spansToHit(r.name, r.category, text, r.spans(text)), nil
+}
+
+func moderationTimeout(cfg operation_setting.ModerationChecker) time.Duration {
+	if cfg.TimeoutSeconds > 0 {
+		return time.Duration(cfg.TimeoutSeconds) * time.Second
+	}
+	return moderationDefaultTimeout
+}
+
+func doModerationRequest(ctx context.Context, client *http.Client, url string, headers map[string]string, body any, out any) error {
+	payload, err := common.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status code %d: %s", resp.StatusCode, string(respBody))
+	}
+	return common.Unmarshal(respBody, out)
+}
+
+// openAIModerationChecker 通过内部渠道调用 OpenAI 兼容的 /v1/moderations
+type openAIModerationChecker struct {
+	cfg operation_setting.ModerationChecker
+}
+
+type openAIModerationResponse struct {
+	Results []struct {
+		Flagged        bool               `json:"flagged"`
+		Categories     map[string]bool    `json:"categories"`
+		CategoryScores map[string]float64 `json:"category_scores"`
+	} `json:"results"`
+}
+
+func (o *openAIModerationChecker) Check(ctx context.Context, input *ModerationInput) ([]ModerationHit, error) {
+	if input.Text == "" && len(input.ImageURLs) == 0 {
+		return nil, nil
+	}
+	channel, err := model.CacheGetChannel(o.cfg.ChannelId)
+	if err != nil {
+		return nil, err
+	}
+	if channel.Status != common.ChannelStatusEnabled {
+		return nil, fmt.Errorf("channel #%d is not enabled", channel.Id)
+	}
+	key, _, apiErr := channel.GetNextEnabledKey()
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	client, err := GetHttpClientWithProxy(channel.GetSetting().Proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	modelName := o.cfg.Model
+	if modelName == "" {
+		modelName = moderationDefaultModel
+	}
+	var payloadInput any = input.Text
+	if len(input.ImageURLs) > 0 {
+		parts := make([]map[string]any, 0, len(input.ImageURLs)+1)
+		if input.Text != "" {
+			parts = append(parts, map[string]any{"type": "text", "text": input.Text})
+		}
+		for _, url := range input.ImageURLs {
+			parts = append(parts, map[string]any{"type": "image_url", "image_url": map[string]string{"url": url}})
+		}
+		payloadInput = parts
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, moderationTimeout(o.cfg))
+	defer cancel()
+	var resp openAIModerationResponse
+	url := strings.TrimSuffix(channel.GetBaseURL(), "/") + "/v1/moderations"
+	headers := map[string]string{"Authorization": "Bearer " + key}
+	if err = doModerationRequest(ctx, client, url, headers, map[string]any{"model": modelName, "input": payloadInput}, &resp); err != nil {
+		return nil, err
+	}
+
+	var hits []ModerationHit
+	for _, result := range resp.Results {
+		for category, score := range result.CategoryScores {
+			flagged := result.Categories[category]
+			if o.cfg.Threshold > 0 {
+				flagged = score >= o.cfg.Threshold
+			}
+			if flagged {
+				hits = append(hits, ModerationHit{Checker: o.cfg.Name, Category: category, Score: score})
+			}
+		}
+	}
+	return hits, nil
+}
+
+// httpModerationChecker 本地 HTTP 分类服务
+type httpModerationChecker struct {
+	cfg operation_setting.ModerationChecker
+}
+
+func (h *httpModerationChecker) Check(ctx context.Context, input *ModerationInput) ([]ModerationHit, error) {
+	if input.Text == "" && len(input.ImageURLs) == 0 {
+		return nil, nil
+	}
+	if h.cfg.URL == "" {
+		return nil, errors.New("classifier url is empty")
+	}
+	ctx, cancel := context.WithTimeout(ctx, moderationTimeout(h.cfg))
+	defer cancel()
+	var resp struct {
+		Categories map[string]float64 `json:"categories"`
+	}
+	body := map[string]any{"text": input.Text, "images": input.ImageURLs}
+	if err := doModerationRequest(ctx, GetHttpClient(), h.cfg.URL, nil, body, &resp); err != nil {
+		return nil, err
+	}
+	threshold := h.cfg.Threshold
+	if threshold <= 0 {
+		threshold = 0.5
+	}
+	var hits []ModerationHit
+	for category, score := range resp.Categories {
+		if score >= threshold {
+			hits = append(hits, ModerationHit{Checker: h.cfg.Name, Category: category, Score: score})
+		}
+	}
+	return hits, nil
+}
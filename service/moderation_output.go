@@ -0,0 +1,150 @@
+package service
+
+import (
+	"fmt"
+
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// moderationOutputScanner 扫描流式输出中的文本增量。
+// 保留最近的前文用于识别跨分片的命中，已发送的部分无法撤回，脱敏只作用于当前分片中的命中内容
+type moderationOutputScanner struct {
+	info        *relaycommon.RelayInfo
+	policy      *operation_setting.ModerationPolicy
+	redactors   []moderationRedactor
+	categories  []string
+	checkers    []string
+	lookback    []rune
+	maxLookback int
+}
+
+// NewModerationOutputScanner 创建流式输出扫描器，策略未开启输出扫描或没有可用的 keyword / regex 检查器时返回 nil
+func NewModerationOutputScanner(info *relaycommon.RelayInfo, policy *operation_setting.ModerationPolicy) relaycommon.StreamOutputScanner {
+	if !policy.ScanOutput {
+		return nil
+	}
+	setting := operation_setting.GetModerationSetting()
+	checkers, names := policyModerationCheckers(setting, policy)
+	scanner := &moderationOutputScanner{
+		info:        info,
+		policy:      policy,
+		maxLookback: max(setting.OutputLookbackChars, 0),
+	}
+	for i, checker := range checkers {
+		redactor, ok := checker.(moderationRedactor)
+		if !ok {
+			continue
+		}
+		scanner.redactors = append(scanner.redactors, redactor)
+		scanner.checkers = append(scanner.checkers, names[i])
+		switch c := checker.(type) {
+		case *keywordModerationChecker:
+			scanner.categories = append(scanner.categories, c.category)
+		case *regexModerationChecker:
+			scanner.categories = append(scanner.categories, c.category)
+		}
+	}
+	if len(scanner.redactors) == 0 {
+		return nil
+	}
+	return scanner
+}
+
+// streamTextPaths 返回流式分片中文本增量所在的路径，兼容 OpenAI、Claude、Gemini 与 Responses 格式
+func streamTextPaths(data string) []string {
+	var paths []string
+	root := gjson.Parse(data)
+	root.Get("choices").ForEach(func(key, choice gjson.Result) bool {
+		prefix := "choices." + key.String()
+		if choice.Get("delta.content").Type == gjson.String {
+			paths = append(paths, prefix+".delta.content")
+		} else if choice.Get("text").Type == gjson.String {
+			paths = append(paths, prefix+".text")
+		}
+		return true
+	})
+	root.Get("candidates").ForEach(func(key, candidate gjson.Result) bool {
+		candidate.Get("content.parts").ForEach(func(partKey, part gjson.Result) bool {
+			if part.Get("text").Type == gjson.String && !part.Get("thought").Bool() {
+				paths = append(paths, "candidates."+key.String()+".content.parts."+partKey.String()+".text")
+			}
+			return true
+		})
+		return true
+	})
+	if root.Get("delta.text").Type == gjson.String {
+		paths = append(paths, "delta.text")
+	}
+	if root.Get("type").String() == "response.output_text.delta" && root.Get("delta").Type == gjson.String {
+		paths = append(paths, "delta")
+	}
+	return paths
+}
+
+func (s *moderationOutputScanner) ScanStreamData(data string) (string, bool) {
+	for _, path := range streamTextPaths(data) {
+		text := gjson.Get(data, path).String()
+		if text == "" {
+			continue
+		}
+		redacted, stop := s.scanText(text)
+		if stop {
+			return "", true
+		}
+		if redacted != text {
+			if updated, err := sjson.Set(data, path, redacted); err == nil {
+				data = updated
+			}
+		}
+	}
+	return data, false
+}
+
+// scanText 扫描前文与新增文本，只处理结束位置落在新增文本中的命中
+func (s *moderationOutputScanner) scanText(text string) (string, bool) {
+	newRunes := []rune(text)
+	offset := len(s.lookback)
+	window := append(append(make([]rune, 0, offset+len(newRunes)), s.lookback...), newRunes...)
+
+	var redactSpans [][2]int
+	for i, redactor := range s.redactors {
+		var fresh [][2]int
+		for _, span := range redactor.spans(window) {
+			if span[1] > offset {
+				fresh = append(fresh, span)
+			}
+		}
+		if len(fresh) == 0 {
+			continue
+		}
+		action := s.policy.ActionFor(s.categories[i])
+		s.info.ModerationAudit = append(s.info.ModerationAudit,
+			fmt.Sprintf("output:%s:%s:%s", s.checkers[i], s.categories[i], action))
+		switch action {
+		case operation_setting.ModerationActionBlock:
+			return "", true
+		case operation_setting.ModerationActionRedact:
+			for _, span := range fresh {
+				redactSpans = append(redactSpans, [2]int{max(span[0], offset) - offset, span[1] - offset})
+			}
+		}
+	}
+
+	s.lookback = window
+	if len(s.lookback) > s.maxLookback {
+		s.lookback = s.lookback[len(s.lookback)-s.maxLookback:]
+	}
+	return redactText(text, redactSpans), false
+}
+
+// moderationAuditSummary 统计审核命中记录，便于在日志中展示
+func moderationAuditSummary(audit []string) map[string]int {
+	summary := make(map[string]int, len(audit))
+	for _, entry := range audit {
+		summary[entry]++
+	}
+	return summary
+}
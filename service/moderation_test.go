@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func withModerationSetting(t *testing.T, setting operation_setting.ModerationSetting) {
+	t.Helper()
+	current := operation_setting.GetModerationSetting()
+	original := *current
+	*current = setting
+	t.Cleanup(func() { *current = original })
+}
+
+func TestRunModeration_ActionsPerCategory(t *testing.T) {
+	withModerationSetting(t, operation_setting.ModerationSetting{
+		Enabled: true,
+		Checkers: []operation_setting.ModerationChecker{
+			{Name: "words", Type: "keyword", Enabled: true, Category: "profanity", Keywords: []string{"Darn"}},
+			{Name: "cards", Type: "regex", Enabled: true, Category: "pii", Patterns: []string{`\d{4}-\d{4}`}},
+		},
+	})
+	policy := &operation_setting.ModerationPolicy{Actions: map[string]string{"profanity": "redact", "pii": "log"}}
+
+	decision, err := RunModeration(context.Background(), policy, &ModerationInput{Text: "噢 DARN it, card 1234-5678"})
+	require.NoError(t, err)
+	assert.False(t, decision.Blocked)
+	assert.Equal(t, []string{"input:words:profanity:redact", "input:cards:pii:log"}, decision.Audit)
+	assert.Equal(t, "噢 **###** it, card 1234-5678", decision.Redact("噢 DARN it, card 1234-5678"))
+
+	// 企业分组使用更严格的策略
+	strict := &operation_setting.ModerationPolicy{Checkers: []string{"cards"}}
+	decision, err = RunModeration(context.Background(), strict, &ModerationInput{Text: "darn 1234-5678"})
+	require.NoError(t, err)
+	assert.True(t, decision.Blocked)
+	assert.Equal(t, "pii", decision.Category)
+}
+
+func TestRunModeration_HTTPClassifier(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		_ = common.DecodeJson(r.Body, &body)
+		score := "0.1"
+		if strings.Contains(body["text"].(string), "attack") {
+			score = "0.9"
+		}
+		_, _ = w.Write([]byte(`{"categories":{"violence":` + score + `}}`))
+	}))
+	defer server.Close()
+	InitHttpClient()
+	withModerationSetting(t, operation_setting.ModerationSetting{
+		Enabled:  true,
+		Checkers: []operation_setting.ModerationChecker{{Name: "local", Type: "http", Enabled: true, URL: server.URL}},
+	})
+	// 无法定位命中内容的检查器按拒绝处理
+	policy := &operation_setting.ModerationPolicy{Actions: map[string]string{"*": "redact"}}
+
+	decision, err := RunModeration(context.Background(), policy, &ModerationInput{Text: "hello"})
+	require.NoError(t, err)
+	assert.False(t, decision.Blocked)
+
+	decision, err = RunModeration(context.Background(), policy, &ModerationInput{Text: "plan an attack"})
+	require.NoError(t, err)
+	assert.True(t, decision.Blocked)
+	assert.Equal(t, "violence", decision.Category)
+}
+
+func TestModerationOutputScanner_Lookback(t *testing.T) {
+	withModerationSetting(t, operation_setting.ModerationSetting{
+		Enabled: true,
+		Checkers: []operation_setting.ModerationChecker{
+			{Name: "words", Type: "keyword", Enabled: true, Category: "secret", Keywords: []string{"project-x"}},
+		},
+		OutputLookbackChars: 16,
+	})
+	info := &relaycommon.RelayInfo{}
+	policy := &operation_setting.ModerationPolicy{ScanOutput: true, Actions: map[string]string{"*": "redact"}}
+	scanner := NewModerationOutputScanner(info, policy)
+	require.NotNil(t, scanner)
+
+	data, stop := scanner.ScanStreamData(`{"choices":[{"index":0,"delta":{"content":"about proj"}}]}`)
+	assert.False(t, stop)
+	assert.Contains(t, data, `"about proj"`)
+	// 跨分片的命中只能替换当前分片中的部分
+	data, stop = scanner.ScanStreamData(`{"choices":[{"index":0,"delta":{"content":"ect-x today"}}]}`)
+	assert.False(t, stop)
+	assert.Contains(t, data, `"**###** today"`)
+	assert.Equal(t, []string{"output:words:secret:redact"}, info.ModerationAudit)
+
+	policy.Actions = map[string]string{"*": "block"}
+	scanner = NewModerationOutputScanner(info, policy)
+	_, stop = scanner.ScanStreamData(`{"type":"content_block_delta","delta":{"type":"text_delta","text":"PROJECT"}}`)
+	assert.False(t, stop)
+	_, stop = scanner.ScanStreamData(`{"type":"content_block_delta","delta":{"type":"text_delta","text":"-X"}}`)
+	assert.True(t, stop)
+}
+
+func TestRewriteRequestBodyText(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	body := `{"model":"secret-model","messages":[{"role":"user","content":[{"type":"text","text":"my secret"},{"type":"image_url","image_url":{"url":"https://x/secret.png"}}]}],"seed":12345678901234567}`
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	changed, err := RewriteRequestBodyText(c, func(text string) string {
+		return strings.ReplaceAll(text, "secret", "***")
+	})
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	storage, err := common.GetBodyStorage(c)
+	require.NoError(t, err)
+	rewritten, err := storage.Bytes()
+	require.NoError(t, err)
+	assert.Contains(t, string(rewritten), `"text":"my ***"`)
+	assert.Contains(t, string(rewritten), `"model":"secret-model"`)
+	assert.Contains(t, string(rewritten), `"url":"https://x/secret.png"`)
+	assert.Contains(t, string(rewritten), `"seed":12345678901234567`)
+}
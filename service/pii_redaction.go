@@ -0,0 +1,249 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// piiDetector 识别一种实体类型，validate 为空表示正则命中即可
+type piiDetector struct {
+	entity   string
+	pattern  *regexp.Regexp
+	validate func(value string) bool
+}
+
+// 按优先级排列，重叠的命中保留先识别的实体
+var piiDetectors = []piiDetector{
+	{
+		entity:  operation_setting.PIIEntityAPIKey,
+		pattern: regexp.MustCompile(`(?:sk-[A-Za-z0-9_-]{20,}|AKIA[0-9A-Z]{16}|AIza[0-9A-Za-z_-]{35}|gh[pousr]_[A-Za-z0-9]{36,}|xox[abprs]-[A-Za-z0-9-]{10,})`),
+	},
+	{
+		entity:  operation_setting.PIIEntityEmail,
+		pattern: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`),
+	},
+	{
+		entity:   operation_setting.PIIEntityNationalId,
+		pattern:  regexp.MustCompile(`\d{17}[\dXx]|\d{3}-\d{2}-\d{4}`),
+		validate: validNationalId,
+	},
+	{
+		entity:   operation_setting.PIIEntityCreditCard,
+		pattern:  regexp.MustCompile(`\d{4}(?: \d{4}){3}|\d{4}(?:-\d{4}){3}|\d{4}[ -]\d{6}[ -]\d{5}|\d{13,19}`),
+		validate: validCreditCard,
+	},
+	{
+		entity:   operation_setting.PIIEntityPhone,
+		pattern:  regexp.MustCompile(`(?:\+\d{1,3}[ -]?)?(?:\(\d{1,4}\)[ -]?)?\d{2,4}(?:[ -]?\d{2,4}){1,4}`),
+		validate: validPhone,
+	},
+}
+
+type piiMatch struct {
+	start  int
+	end    int
+	entity string
+}
+
+// detectPII 识别文本中的 PII，返回按位置排序且互不重叠的命中
+func detectPII(text string, enabled func(entity string) bool) []piiMatch {
+	var matches []piiMatch
+	for _, detector := range piiDetectors {
+		if !enabled(detector.entity) {
+			continue
+		}
+		for _, loc := range detector.pattern.FindAllStringIndex(text, -1) {
+			if !piiBoundary(text, loc[0], loc[1]) {
+				continue
+			}
+			if detector.validate != nil && !detector.validate(text[loc[0]:loc[1]]) {
+				continue
+			}
+			overlapped := slices.ContainsFunc(matches, func(m piiMatch) bool {
+				return loc[0] < m.end && m.start < loc[1]
+			})
+			if !overlapped {
+				matches = append(matches, piiMatch{start: loc[0], end: loc[1], entity: detector.entity})
+			}
+		}
+	}
+	slices.SortFunc(matches, func(a, b piiMatch) int { return a.start - b.start })
+	return matches
+}
+
+// piiBoundary 命中内容前后不能紧邻字母或数字，避免截取更长标识符中的一段
+func piiBoundary(text string, start, end int) bool {
+	isWord := func(r rune) bool { return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) }
+	if start > 0 {
+		if r, _ := utf8.DecodeLastRuneInString(text[:start]); isWord(r) {
+			return false
+		}
+	}
+	if end < len(text) {
+		if r, _ := utf8.DecodeRuneInString(text[end:]); isWord(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func piiDigits(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, value)
+}
+
+// validCreditCard 13-19 位数字并通过 Luhn 校验
+func validCreditCard(value string) bool {
+	digits := piiDigits(value)
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+	sum := 0
+	for i := 0; i < len(digits); i++ {
+		d := int(digits[len(digits)-1-i] - '0')
+		if i%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// validNationalId 校验中国居民身份证（ISO 7064 MOD 11-2 校验码）与美国 SSN
+func validNationalId(value string) bool {
+	if len(value) == 11 {
+		area, group, serial := value[0:3], value[4:6], value[7:11]
+		return area != "000" && area != "666" && area[0] != '9' && group != "00" && serial != "0000"
+	}
+	weights := []int{7, 9, 10, 5, 8, 4, 2, 1, 6, 3, 7, 9, 10, 5, 8, 4, 2}
+	sum := 0
+	for i, w := range weights {
+		sum += int(value[i]-'0') * w
+	}
+	return strings.ToUpper(value[17:]) == string("10X98765432"[sum%11])
+}
+
+// validPhone 10-15 位数字，避免把日期、金额等普通数字当作电话号码
+func validPhone(value string) bool {
+	digits := piiDigits(value)
+	return len(digits) >= 10 && len(digits) <= 15
+}
+
+// PIIRedactor 对请求文本脱敏，可逆脱敏时同一原文使用同一占位符
+type PIIRedactor struct {
+	setting      *operation_setting.PIIRedactionSetting
+	state        *relaycommon.PIIRedactionState
+	placeholders map[string]string
+	sequence     map[string]int
+}
+
+func newPIIRedactor(setting *operation_setting.PIIRedactionSetting) *PIIRedactor {
+	return &PIIRedactor{
+		setting:      setting,
+		state:        &relaycommon.PIIRedactionState{Counts: map[string]int{}, Originals: map[string]string{}},
+		placeholders: map[string]string{},
+		sequence:     map[string]int{},
+	}
+}
+
+// NewPIIRedactor 根据分组与当前渠道设置创建脱敏器，返回 nil 表示无需脱敏。
+// 请求脱敏后在重试时不再处理，因此切换到未开启脱敏的渠道时仍发送脱敏后的内容
+func NewPIIRedactor(c *gin.Context, info *relaycommon.RelayInfo) *PIIRedactor {
+	if info.PIIRedaction != nil || info.IsChannelTest {
+		return nil
+	}
+	switch info.RelayFormat {
+	case types.RelayFormatOpenAI, types.RelayFormatClaude, types.RelayFormatGemini, types.RelayFormatOpenAIResponses:
+	default:
+		return nil
+	}
+	setting := operation_setting.GetPIIRedactionSetting()
+	channelSetting, _ := common.GetContextKeyType[dto.ChannelSettings](c, constant.ContextKeyChannelSetting)
+	if !setting.ShouldRedact(info.UsingGroup, channelSetting.PIIRedactionEnabled) {
+		return nil
+	}
+	redactor := newPIIRedactor(setting)
+	info.PIIRedaction = redactor.state
+	return redactor
+}
+
+// Redact 替换文本中的 PII
+func (r *PIIRedactor) Redact(text string) string {
+	matches := detectPII(text, r.setting.EntityEnabled)
+	if len(matches) == 0 {
+		return text
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		b.WriteString(text[last:m.start])
+		b.WriteString(r.replacement(m.entity, text[m.start:m.end]))
+		r.state.Counts[m.entity]++
+		last = m.end
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+func (r *PIIRedactor) replacement(entity, value string) string {
+	label := strings.ToUpper(entity)
+	if r.setting.Reversible {
+		if placeholder, ok := r.placeholders[value]; ok {
+			return placeholder
+		}
+		r.sequence[entity]++
+		placeholder := fmt.Sprintf("[%s_%d]", label, r.sequence[entity])
+		r.placeholders[value] = placeholder
+		r.state.Originals[placeholder] = value
+		return placeholder
+	}
+	switch r.setting.ReplacementStyle {
+	case operation_setting.PIIReplacementMask:
+		return maskPII(entity, value)
+	case operation_setting.PIIReplacementHash:
+		return fmt.Sprintf("[%s:%s]", label, common.GenerateHMAC(value)[:8])
+	default:
+		return "[" + label + "]"
+	}
+}
+
+// maskPII 邮箱保留首字符与域名，其余类型保留末尾 4 位字母或数字
+func maskPII(entity, value string) string {
+	if entity == operation_setting.PIIEntityEmail {
+		at := strings.LastIndex(value, "@")
+		return value[:1] + "***" + value[at:]
+	}
+	runes := []rune(value)
+	kept := 0
+	for i := len(runes) - 1; i >= 0; i-- {
+		if !unicode.IsLetter(runes[i]) && !unicode.IsDigit(runes[i]) {
+			continue
+		}
+		if kept < 4 {
+			kept++
+			continue
+		}
+		runes[i] = '*'
+	}
+	return string(runes)
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPIIRedactor_Label(t *testing.T) {
+	redactor := newPIIRedactor(&operation_setting.PIIRedactionSetting{Enabled: true})
+	text := "联系 alice@example.com 或 +86 138-0013-8000，卡号 4111 1111 1111 1111，" +
+		"身份证 11010519491231002X，SSN 123-45-6789，key sk-abcdefghijklmnopqrstuvwxyz，" +
+		"订单 2024-01-15 金额 1234567，无效卡号 4111 1111 1111 1112"
+
+	redacted := redactor.Redact(text)
+	assert.Equal(t, "联系 [EMAIL] 或 [PHONE]，卡号 [CREDIT_CARD]，身份证 [NATIONAL_ID]，SSN [NATIONAL_ID]，key [API_KEY]，"+
+		"订单 2024-01-15 金额 1234567，无效卡号 4111 1111 1111 1112", redacted)
+	assert.Equal(t, map[string]int{"email": 1, "phone": 1, "credit_card": 1, "national_id": 2, "api_key": 1}, redactor.state.Counts)
+}
+
+func TestPIIRedactor_StylesAndEntityTypes(t *testing.T) {
+	redactor := newPIIRedactor(&operation_setting.PIIRedactionSetting{
+		Enabled:          true,
+		EntityTypes:      []string{"email", "credit_card"},
+		ReplacementStyle: "mask",
+	})
+	assert.Equal(t, "a***@example.com ****-****-****-1111 13800138000",
+		redactor.Redact("alice@example.com 4111-1111-1111-1111 13800138000"))
+
+	redactor = newPIIRedactor(&operation_setting.PIIRedactionSetting{Enabled: true, ReplacementStyle: "hash"})
+	first := redactor.Redact("alice@example.com")
+	assert.Regexp(t, `^\[EMAIL:[0-9a-f]{8}\]$`, first)
+	assert.Equal(t, first, redactor.Redact("alice@example.com"))
+}
+
+func TestPIIRedactor_ReversibleStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	withPIISetting(t, operation_setting.PIIRedactionSetting{Enabled: true, Reversible: true})
+	info := &relaycommon.RelayInfo{RelayFormat: types.RelayFormatOpenAI, IsStream: true}
+
+	// 分组与渠道都未开启时不脱敏
+	assert.Nil(t, NewPIIRedactor(c, info))
+	c.Set(string(constant.ContextKeyChannelSetting), dto.ChannelSettings{PIIRedactionEnabled: true})
+	redactor := NewPIIRedactor(c, info)
+	require.NotNil(t, redactor)
+	assert.Equal(t, "mail [EMAIL_1], again [EMAIL_1], other [EMAIL_2]",
+		redactor.Redact("mail a@x.com, again a@x.com, other b@y.org"))
+	assert.Nil(t, NewPIIRedactor(c, info))
+
+	SetupPIIRestore(c, info)
+	require.NotNil(t, info.OutputScanner)
+	data, _ := info.OutputScanner.ScanStreamData(`{"choices":[{"index":0,"delta":{"content":"Sent to [EMA"}}]}`)
+	assert.Contains(t, data, `"content":"Sent to "`)
+	data, _ = info.OutputScanner.ScanStreamData(`{"choices":[{"index":0,"delta":{"content":"IL_2] and [x]"}}]}`)
+	assert.Contains(t, data, `"content":"b@y.org and [x]"`)
+
+	// 流结束时暂存的文本原样转发，不会丢失
+	data, _ = info.OutputScanner.ScanStreamData(`{"choices":[{"index":0,"delta":{"content":"list: ["}}]}`)
+	assert.Contains(t, data, `"content":"list: "`)
+	flusher, ok := info.OutputScanner.(relaycommon.StreamOutputFlusher)
+	require.True(t, ok)
+	flushed := flusher.FlushStreamData()
+	require.Len(t, flushed, 1)
+	assert.Contains(t, flushed[0], `"content":"["`)
+	assert.Empty(t, flusher.FlushStreamData())
+}
+
+func TestPIIRedactor_ReversibleNonStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	info := &relaycommon.RelayInfo{PIIRedaction: &relaycommon.PIIRedactionState{
+		Originals: map[string]string{"[EMAIL_1]": `a"b@x.com`},
+	}}
+	SetupPIIRestore(c, info)
+
+	IOCopyBytesGracefully(c, nil, []byte(`{"content":"hi [EMAIL_1]"}`))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, `{"content":"hi a\"b@x.com"}`, recorder.Body.String())
+	assert.Empty(t, recorder.Header().Get("Content-Length"))
+}
+
+func withPIISetting(t *testing.T, setting operation_setting.PIIRedactionSetting) {
+	t.Helper()
+	current := operation_setting.GetPIIRedactionSetting()
+	original := *current
+	*current = setting
+	t.Cleanup(func() { *current = original })
+}
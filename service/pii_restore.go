@@ -0,0 +1,138 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// SetupPIIRestore 可逆脱敏时在响应中还原占位符，流式响应通过输出扫描器处理，非流式响应包装 ResponseWriter
+func SetupPIIRestore(c *gin.Context, info *relaycommon.RelayInfo) {
+	if info.PIIRedaction == nil || len(info.PIIRedaction.Originals) == 0 {
+		return
+	}
+	if info.IsStream {
+		info.AddOutputScanner(newPIIRestoreScanner(info.PIIRedaction.Originals))
+		return
+	}
+	pairs := make([]string, 0, len(info.PIIRedaction.Originals)*2)
+	for placeholder, original := range info.PIIRedaction.Originals {
+		// 非流式响应为 JSON，原文需要按 JSON 字符串转义
+		escaped, err := common.Marshal(original)
+		if err != nil {
+			continue
+		}
+		pairs = append(pairs, placeholder, string(escaped[1:len(escaped)-1]))
+	}
+	c.Writer = &piiRestoreWriter{ResponseWriter: c.Writer, replacer: strings.NewReplacer(pairs...)}
+}
+
+// piiRestoreWriter 还原非流式响应体中的占位符，响应长度会变化，因此去掉 Content-Length
+type piiRestoreWriter struct {
+	gin.ResponseWriter
+	replacer *strings.Replacer
+}
+
+func (w *piiRestoreWriter) WriteHeader(code int) {
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *piiRestoreWriter) WriteHeaderNow() {
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *piiRestoreWriter) Write(data []byte) (int, error) {
+	if _, err := w.WriteString(string(data)); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (w *piiRestoreWriter) WriteString(s string) (int, error) {
+	w.Header().Del("Content-Length")
+	return w.ResponseWriter.WriteString(w.replacer.Replace(s))
+}
+
+// piiRestoreScanner 还原流式输出中的占位符。
+// 占位符可能被拆分到多个分片中，分片末尾疑似不完整的占位符会暂存并拼接到下一个分片，
+// 流结束时仍暂存的文本按原样转发
+type piiRestoreScanner struct {
+	originals map[string]string
+	replacer  *strings.Replacer
+	pending   map[string]string
+	// template 最近一次暂存文本的分片，流结束时以其为模板转发暂存的文本
+	template string
+}
+
+func newPIIRestoreScanner(originals map[string]string) *piiRestoreScanner {
+	pairs := make([]string, 0, len(originals)*2)
+	for placeholder, original := range originals {
+		pairs = append(pairs, placeholder, original)
+	}
+	return &piiRestoreScanner{
+		originals: originals,
+		replacer:  strings.NewReplacer(pairs...),
+		pending:   map[string]string{},
+	}
+}
+
+func (s *piiRestoreScanner) ScanStreamData(data string) (string, bool) {
+	for _, path := range streamTextPaths(data) {
+		original := gjson.Get(data, path).String()
+		text := s.replacer.Replace(s.pending[path] + original)
+		delete(s.pending, path)
+		if cut := s.partialPlaceholder(text); cut >= 0 {
+			s.pending[path] = text[cut:]
+			s.template = data
+			text = text[:cut]
+		}
+		if text != original {
+			if updated, err := sjson.Set(data, path, text); err == nil {
+				data = updated
+			}
+		}
+	}
+	return data, false
+}
+
+// FlushStreamData 流结束时转发暂存的文本，分片中的其他文本置空避免重复输出
+func (s *piiRestoreScanner) FlushStreamData() []string {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	data := s.template
+	for _, path := range streamTextPaths(data) {
+		if _, ok := s.pending[path]; !ok {
+			data, _ = sjson.Set(data, path, "")
+		}
+	}
+	for path, text := range s.pending {
+		if updated, err := sjson.Set(data, path, text); err == nil {
+			data = updated
+		}
+	}
+	s.pending = map[string]string{}
+	return []string{data}
+}
+
+// partialPlaceholder 返回文本末尾不完整占位符的起始位置，不存在时返回 -1
+func (s *piiRestoreScanner) partialPlaceholder(text string) int {
+	i := strings.LastIndexByte(text, '[')
+	if i < 0 {
+		return -1
+	}
+	suffix := text[i:]
+	for placeholder := range s.originals {
+		if len(suffix) < len(placeholder) && strings.HasPrefix(placeholder, suffix) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 请求体中承载用户输入文本的字段，覆盖 OpenAI Chat/Completions/Responses、Claude Messages 与 Gemini 格式
+var requestTextKeys = map[string]bool{
+	"content":      true,
+	"text":         true,
+	"input":        true,
+	"prompt":       true,
+	"instructions": true,
+	"system":       true,
+	"output":       true,
+}
+
+// RewriteRequestBodyText 改写请求体中的文本内容并替换请求体缓存，返回是否发生改写。
+// 改写后需要重新解析请求，仅支持 JSON 请求体
+func RewriteRequestBodyText(c *gin.Context, rewrite func(text string) string) (bool, error) {
+	if !strings.HasPrefix(c.Request.Header.Get("Content-Type"), "application/json") {
+		return false, nil
+	}
+	storage, err := common.GetBodyStorage(c)
+	if err != nil {
+		return false, err
+	}
+	body, err := storage.Bytes()
+	if err != nil {
+		return false, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var root any
+	if err = decoder.Decode(&root); err != nil {
+		return false, err
+	}
+	root, changed := rewriteRequestText(root, false, rewrite)
+	if !changed {
+		return false, nil
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err = encoder.Encode(root); err != nil {
+		return false, err
+	}
+	if err = replaceRequestBody(c, storage, bytes.TrimRight(buf.Bytes(), "\n")); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// replaceRequestBody 使用改写后的请求体替换请求体缓存
+func replaceRequestBody(c *gin.Context, storage common.BodyStorage, newBody []byte) error {
+	newStorage, err := common.CreateBodyStorage(newBody)
+	if err != nil {
+		return err
+	}
+	_ = storage.Close()
+	c.Set(common.KeyBodyStorage, newStorage)
+	c.Request.Body = io.NopCloser(newStorage)
+	c.Request.ContentLength = int64(len(newBody))
+	return nil
+}
+
+// rewriteRequestText 递归改写文本字段中的字符串，inText 表示当前值位于文本字段内
+func rewriteRequestText(value any, inText bool, rewrite func(text string) string) (any, bool) {
+	changed := false
+	switch v := value.(type) {
+	case string:
+		if !inText {
+			return v, false
+		}
+		rewritten := rewrite(v)
+		return rewritten, rewritten != v
+	case []any:
+		for i, item := range v {
+			var itemChanged bool
+			v[i], itemChanged = rewriteRequestText(item, inText, rewrite)
+			changed = changed || itemChanged
+		}
+	case map[string]any:
+		for key, item := range v {
+			var itemChanged bool
+			v[key], itemChanged = rewriteRequestText(item, requestTextKeys[key], rewrite)
+			changed = changed || itemChanged
+		}
+	}
+	return value, changed
+}
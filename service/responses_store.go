@@ -0,0 +1,346 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	responsesStoreCleanupTickInterval = 10 * time.Minute
+	responsesStoreCleanupBatchSize    = 200
+)
+
+var (
+	responsesStoreCleanupOnce    sync.Once
+	responsesStoreCleanupRunning atomic.Bool
+)
+
+// storedResponseData 网关保存的一轮对话：本轮输入条目（不含 previous_response_id 展开的部分）与响应对象
+type storedResponseData struct {
+	Input    []json.RawMessage `json:"input"`
+	Response json.RawMessage   `json:"response"`
+}
+
+// responsesChannelKeepsState 上游自身保存 Responses 会话状态的渠道
+func responsesChannelKeepsState(info *relaycommon.RelayInfo) bool {
+	switch info.ChannelType {
+	case constant.ChannelTypeOpenAI, constant.ChannelTypeAzure:
+		return true
+	}
+	return false
+}
+
+// responsesInputItems 将 Responses input 统一为条目数组，字符串输入视为一条用户消息
+func responsesInputItems(input json.RawMessage) ([]json.RawMessage, error) {
+	switch common.GetJsonType(input) {
+	case "string":
+		var text string
+		if err := common.Unmarshal(input, &text); err != nil {
+			return nil, err
+		}
+		item, err := common.Marshal(map[string]any{"type": "message", "role": "user", "content": text})
+		if err != nil {
+			return nil, err
+		}
+		return []json.RawMessage{item}, nil
+	case "array":
+		var items []json.RawMessage
+		if err := common.Unmarshal(input, &items); err != nil {
+			return nil, err
+		}
+		return items, nil
+	}
+	return nil, nil
+}
+
+// loadStoredResponseData 读取并解析用户的一轮对话记录
+func loadStoredResponseData(userId int, responseId string) (*model.StoredResponse, *storedResponseData, error) {
+	record, err := model.GetUserStoredResponse(userId, responseId)
+	if err != nil {
+		return nil, nil, err
+	}
+	var data storedResponseData
+	if err = common.UnmarshalJsonStr(record.Data, &data); err != nil {
+		return nil, nil, fmt.Errorf("invalid stored response %s: %w", responseId, err)
+	}
+	return record, &data, nil
+}
+
+// expandStoredResponseChain 沿 previous_response_id 回溯，按时间顺序返回历史输入条目与输出条目。
+// 更早的记录已过期时从该处截断
+func expandStoredResponseChain(userId int, responseId string) ([]json.RawMessage, error) {
+	maxDepth := operation_setting.GetResponsesStoreSetting().MaxChainDepth
+	var chain []*storedResponseData
+	for id := responseId; id != ""; {
+		if maxDepth > 0 && len(chain) >= maxDepth {
+			return nil, fmt.Errorf("conversation exceeds the maximum of %d stored responses, send the full conversation in input instead", maxDepth)
+		}
+		record, data, err := loadStoredResponseData(userId, id)
+		if err != nil {
+			if len(chain) > 0 && errors.Is(err, model.ErrStoredResponseNotFound) {
+				break
+			}
+			return nil, err
+		}
+		chain = append(chain, data)
+		id = record.PreviousResponseId
+	}
+
+	var items []json.RawMessage
+	for i := len(chain) - 1; i >= 0; i-- {
+		items = append(items, chain[i].Input...)
+		for _, output := range gjson.GetBytes(chain[i].Response, "output").Array() {
+			items = append(items, json.RawMessage(output.Raw))
+		}
+	}
+	return items, nil
+}
+
+// ResponsesStateRecorder 记录一轮 Responses 请求的最终响应对象，请求成功后写入网关存储。
+// 流式响应取 response.completed 等终止事件中的 response，非流式响应取整个响应体
+type ResponsesStateRecorder struct {
+	gin.ResponseWriter
+	input              []json.RawMessage
+	previousResponseId string
+	buf                bytes.Buffer
+	response           json.RawMessage
+}
+
+func (r *ResponsesStateRecorder) Write(data []byte) (int, error) {
+	r.capture(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *ResponsesStateRecorder) WriteString(s string) (int, error) {
+	r.capture([]byte(s))
+	return r.ResponseWriter.WriteString(s)
+}
+
+func (r *ResponsesStateRecorder) capture(data []byte) {
+	r.buf.Write(data)
+	if !strings.HasPrefix(r.Header().Get("Content-Type"), "text/event-stream") {
+		return
+	}
+	for {
+		raw := r.buf.Bytes()
+		idx := bytes.Index(raw, []byte("\n\n"))
+		if idx < 0 {
+			return
+		}
+		block := string(raw[:idx])
+		r.buf.Next(idx + 2)
+		for _, line := range strings.Split(block, "\n") {
+			payload, ok := strings.CutPrefix(line, "data:")
+			if !ok {
+				continue
+			}
+			event := gjson.Parse(strings.TrimSpace(payload))
+			switch event.Get("type").String() {
+			case "response.completed", "response.incomplete", "response.failed":
+				if response := event.Get("response"); response.IsObject() {
+					r.response = json.RawMessage(response.Raw)
+				}
+			}
+		}
+	}
+}
+
+// responseObject 本轮的最终响应对象，未取得时返回 nil
+func (r *ResponsesStateRecorder) responseObject() json.RawMessage {
+	if r.response != nil {
+		return r.response
+	}
+	if body := bytes.TrimSpace(r.buf.Bytes()); gjson.GetBytes(body, "object").String() == "response" {
+		return body
+	}
+	return nil
+}
+
+// Finish 还原 ResponseWriter，请求成功时保存本轮对话
+func (r *ResponsesStateRecorder) Finish(c *gin.Context, info *relaycommon.RelayInfo, succeeded bool) {
+	c.Writer = r.ResponseWriter
+	if !succeeded {
+		return
+	}
+	response := r.responseObject()
+	responseId := gjson.GetBytes(response, "id").String()
+	if responseId == "" {
+		return
+	}
+	if r.previousResponseId != "" {
+		response, _ = sjson.SetBytes(response, "previous_response_id", r.previousResponseId)
+	}
+	data, err := common.Marshal(storedResponseData{Input: r.input, Response: response})
+	if err != nil {
+		logger.LogError(c, fmt.Sprintf("failed to marshal stored response %s: %s", responseId, err.Error()))
+		return
+	}
+	record := &model.StoredResponse{
+		ResponseId:         responseId,
+		UserId:             info.UserId,
+		TokenId:            info.TokenId,
+		Model:              info.OriginModelName,
+		PreviousResponseId: r.previousResponseId,
+		Data:               string(data),
+	}
+	if ttl := operation_setting.GetResponsesStoreSetting().TTLHours; ttl > 0 {
+		record.ExpiresAt = common.GetTimestamp() + int64(ttl)*3600
+	}
+	if err = record.Insert(); err != nil {
+		logger.LogError(c, fmt.Sprintf("failed to save stored response %s: %s", responseId, err.Error()))
+	}
+}
+
+// PrepareResponsesState 在转换请求前处理网关侧的 Responses 状态：
+// previous_response_id 命中网关存储时展开为完整输入；分发到不保存状态的渠道且 store 未关闭时，
+// 返回的 recorder 已接管 c.Writer，调用方需在请求结束后调用 Finish
+func PrepareResponsesState(c *gin.Context, info *relaycommon.RelayInfo, request *dto.OpenAIResponsesRequest) (*ResponsesStateRecorder, *types.NewAPIError) {
+	if !operation_setting.GetResponsesStoreSetting().Enabled || info.RelayMode != relayconstant.RelayModeResponses {
+		return nil, nil
+	}
+	keepsState := responsesChannelKeepsState(info)
+	input, err := responsesInputItems(request.Input)
+	if err != nil {
+		return nil, types.NewErrorWithStatusCode(fmt.Errorf("invalid input: %w", err), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+
+	previousResponseId := request.PreviousResponseID
+	if previousResponseId != "" {
+		history, err := expandStoredResponseChain(info.UserId, previousResponseId)
+		switch {
+		case errors.Is(err, model.ErrStoredResponseNotFound):
+			// 未命中时交给保存状态的上游处理
+			if !keepsState {
+				return nil, types.NewErrorWithStatusCode(fmt.Errorf("previous response with id '%s' not found", previousResponseId), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+			}
+			previousResponseId = ""
+		case err != nil:
+			return nil, types.NewErrorWithStatusCode(err, types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+		default:
+			merged, err := common.Marshal(append(history, input...))
+			if err != nil {
+				return nil, types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
+			}
+			request.Input = merged
+			request.PreviousResponseID = ""
+		}
+	}
+
+	if keepsState || gjson.GetBytes(request.Store, "@this").Type == gjson.False {
+		return nil, nil
+	}
+	recorder := &ResponsesStateRecorder{
+		ResponseWriter:     c.Writer,
+		input:              input,
+		previousResponseId: previousResponseId,
+	}
+	c.Writer = recorder
+	return recorder, nil
+}
+
+// GetStoredResponse 获取网关保存的响应对象
+func GetStoredResponse(userId int, responseId string) (json.RawMessage, error) {
+	_, data, err := loadStoredResponseData(userId, responseId)
+	if err != nil {
+		return nil, err
+	}
+	return data.Response, nil
+}
+
+// ListStoredResponseInputItems 获取生成该响应时使用的全部输入条目（含 previous_response_id 展开的历史），
+// 缺少 id 的条目按位置生成稳定的 id 以便分页
+func ListStoredResponseInputItems(userId int, responseId string) ([]json.RawMessage, error) {
+	record, data, err := loadStoredResponseData(userId, responseId)
+	if err != nil {
+		return nil, err
+	}
+	var items []json.RawMessage
+	if record.PreviousResponseId != "" {
+		if items, err = expandStoredResponseChain(userId, record.PreviousResponseId); err != nil && !errors.Is(err, model.ErrStoredResponseNotFound) {
+			return nil, err
+		}
+	}
+	items = append(items, data.Input...)
+	suffix := strings.TrimPrefix(responseId, "resp_")
+	for i, item := range items {
+		if gjson.GetBytes(item, "id").String() == "" {
+			items[i], _ = sjson.SetBytes(item, "id", fmt.Sprintf("item_%s_%d", suffix, i))
+		}
+	}
+	return items, nil
+}
+
+// DeleteStoredResponse 删除网关保存的一轮对话，不影响链上的其他记录
+func DeleteStoredResponse(userId int, responseId string) error {
+	record, err := model.GetUserStoredResponse(userId, responseId)
+	if err != nil {
+		return err
+	}
+	return model.DeleteStoredResponse(record.Id)
+}
+
+// StartResponsesStoreCleanupTask 定期清理已过期的对话记录
+func StartResponsesStoreCleanupTask() {
+	responsesStoreCleanupOnce.Do(func() {
+		if !common.IsMasterNode {
+			return
+		}
+		gopool.Go(func() {
+			logger.LogInfo(context.Background(), fmt.Sprintf("responses store cleanup task started: tick=%s", responsesStoreCleanupTickInterval))
+			ticker := time.NewTicker(responsesStoreCleanupTickInterval)
+			defer ticker.Stop()
+
+			runResponsesStoreCleanupOnce()
+			for range ticker.C {
+				runResponsesStoreCleanupOnce()
+			}
+		})
+	})
+}
+
+func runResponsesStoreCleanupOnce() {
+	if !responsesStoreCleanupRunning.CompareAndSwap(false, true) {
+		return
+	}
+	defer responsesStoreCleanupRunning.Store(false)
+
+	ctx := context.Background()
+	total := 0
+	for {
+		n, err := model.DeleteExpiredStoredResponses(responsesStoreCleanupBatchSize)
+		if err != nil {
+			logger.LogWarn(ctx, fmt.Sprintf("responses store cleanup task failed: %v", err))
+			return
+		}
+		total += n
+		if n < responsesStoreCleanupBatchSize {
+			break
+		}
+	}
+	if common.DebugEnabled && total > 0 {
+		logger.LogDebug(ctx, "responses store cleanup: deleted_count=%d", total)
+	}
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func enableResponsesStore(t *testing.T) {
+	t.Helper()
+	setting := operation_setting.GetResponsesStoreSetting()
+	original := *setting
+	setting.Enabled = true
+	t.Cleanup(func() {
+		*setting = original
+		model.DB.Exec("DELETE FROM stored_responses")
+	})
+}
+
+func newResponsesStateContext(channelType int) (*gin.Context, *httptest.ResponseRecorder, *relaycommon.RelayInfo) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/responses", nil)
+	info := &relaycommon.RelayInfo{
+		UserId:          1,
+		TokenId:         2,
+		OriginModelName: "claude-sonnet-4-5",
+		RelayMode:       relayconstant.RelayModeResponses,
+		ChannelMeta:     &relaycommon.ChannelMeta{ChannelType: channelType},
+	}
+	return c, recorder, info
+}
+
+// runResponsesTurn 模拟一轮流式请求，上游返回给定 ID 与输出文本的 response.completed
+func runResponsesTurn(t *testing.T, request *dto.OpenAIResponsesRequest, responseId string, text string) {
+	t.Helper()
+	c, recorder, info := newResponsesStateContext(constant.ChannelTypeAnthropic)
+	stateRecorder, apiErr := PrepareResponsesState(c, info, request)
+	require.Nil(t, apiErr)
+	require.NotNil(t, stateRecorder)
+
+	c.Header("Content-Type", "text/event-stream")
+	completed := `{"type":"response.completed","response":{"id":"` + responseId + `","object":"response","status":"completed",` +
+		`"output":[{"id":"msg_1","type":"message","role":"assistant","content":[{"type":"output_text","text":"` + text + `"}]}]}}`
+	_, err := c.Writer.WriteString("event: response.created\ndata: {\"type\":\"response.created\"}\n\nevent: response.completed\n")
+	require.NoError(t, err)
+	_, err = c.Writer.WriteString("data: " + completed + "\n\n")
+	require.NoError(t, err)
+	stateRecorder.Finish(c, info, true)
+
+	assert.NotSame(t, stateRecorder, c.Writer)
+	assert.Contains(t, recorder.Body.String(), responseId)
+}
+
+func TestResponsesStateStoreAndExpand(t *testing.T) {
+	enableResponsesStore(t)
+	require.NoError(t, common.SetSecretEncryptionKeys("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="))
+	t.Cleanup(func() { _ = common.SetSecretEncryptionKeys("") })
+
+	runResponsesTurn(t, &dto.OpenAIResponsesRequest{Input: []byte(`"hi"`)}, "resp_a", "hello")
+
+	// 对话记录加密保存
+	var raw model.StoredResponse
+	require.NoError(t, model.DB.Where("response_id = ?", "resp_a").First(&raw).Error)
+	assert.True(t, common.IsEncryptedSecret(raw.Data))
+	assert.Greater(t, raw.ExpiresAt, int64(0))
+
+	second := &dto.OpenAIResponsesRequest{
+		Input:              []byte(`[{"type":"message","role":"user","content":"and you?"}]`),
+		PreviousResponseID: "resp_a",
+	}
+	runResponsesTurn(t, second, "resp_b", "fine")
+	assert.Empty(t, second.PreviousResponseID)
+	input := gjson.ParseBytes(second.Input).Array()
+	require.Len(t, input, 3)
+	assert.Equal(t, "hi", input[0].Get("content").String())
+	assert.Equal(t, "hello", input[1].Get("content.0.text").String())
+	assert.Equal(t, "and you?", input[2].Get("content").String())
+
+	response, err := GetStoredResponse(1, "resp_b")
+	require.NoError(t, err)
+	assert.Equal(t, "resp_a", gjson.GetBytes(response, "previous_response_id").String())
+
+	items, err := ListStoredResponseInputItems(1, "resp_b")
+	require.NoError(t, err)
+	require.Len(t, items, 3)
+	assert.Equal(t, "item_b_0", gjson.GetBytes(items[0], "id").String())
+	assert.Equal(t, "msg_1", gjson.GetBytes(items[1], "id").String())
+
+	// 其他用户不可见
+	_, err = GetStoredResponse(3, "resp_b")
+	assert.ErrorIs(t, err, model.ErrStoredResponseNotFound)
+
+	require.NoError(t, DeleteStoredResponse(1, "resp_a"))
+	_, err = GetStoredResponse(1, "resp_a")
+	assert.ErrorIs(t, err, model.ErrStoredResponseNotFound)
+}
+
+func TestResponsesStatePreviousResponseNotFound(t *testing.T) {
+	enableResponsesStore(t)
+
+	// 不保存状态的渠道直接返回错误
+	c, _, info := newResponsesStateContext(constant.ChannelTypeAnthropic)
+	_, apiErr := PrepareResponsesState(c, info, &dto.OpenAIResponsesRequest{PreviousResponseID: "resp_missing"})
+	require.NotNil(t, apiErr)
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+
+	// OpenAI 渠道交给上游处理，也不在网关保存
+	c, _, info = newResponsesStateContext(constant.ChannelTypeOpenAI)
+	request := &dto.OpenAIResponsesRequest{PreviousResponseID: "resp_missing"}
+	stateRecorder, apiErr := PrepareResponsesState(c, info, request)
+	assert.Nil(t, apiErr)
+	assert.Nil(t, stateRecorder)
+	assert.Equal(t, "resp_missing", request.PreviousResponseID)
+}
+
+func TestResponsesStateStoreDisabledByRequest(t *testing.T) {
+	enableResponsesStore(t)
+	c, _, info := newResponsesStateContext(constant.ChannelTypeAnthropic)
+	stateRecorder, apiErr := PrepareResponsesState(c, info, &dto.OpenAIResponsesRequest{Input: []byte(`"hi"`), Store: []byte(`false`)})
+	assert.Nil(t, apiErr)
+	assert.Nil(t, stateRecorder)
+}
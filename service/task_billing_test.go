@@ -44,6 +44,9 @@ func TestMain(m *testing.M) {
 		&model.Channel{},
 		&model.TopUp{},
 		&model.UserSubscription{},
+		&model.File{},
+		&model.StoredResponse{},
+		&model.JWTSpend{},
 	); err != nil {
 		panic("failed to migrate: " + err.Error())
 	}
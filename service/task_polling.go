@@ -353,15 +353,16 @@ func updateVideoSingleTask(ctx context.Context, adaptor TaskPollingAdaptor, ch *
 		logger.LogError(ctx, fmt.Sprintf("Task %s not found in taskM", taskId))
 		return fmt.Errorf("task %s not found", taskId)
 	}
-	key := ch.Key
-
 	privateData := task.PrivateData
-	if privateData.Key != "" {
-		key = privateData.Key
+	key := privateData.Key
+	if key == "" {
+		// 多 key 渠道使用创建任务时的 key
+		key = ch.GetKeyByIndex(privateData.KeyIndex)
 	}
 	resp, err := adaptor.FetchTask(baseURL, key, map[string]any{
-		"task_id": task.GetUpstreamTaskID(),
-		"action":  task.Action,
+		"task_id":    task.GetUpstreamTaskID(),
+		"action":     task.Action,
+		"channel_id": ch.Id,
 	}, proxy)
 	if err != nil {
 		return fmt.Errorf("fetchTask failed for task %s: %w", taskId, err)
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+// reserveTokenBudget 令牌周期预算的硬限制：按本次预扣额度 quota 原子地检查并预留本周期花费，超出预算时拒绝请求。
+// reserved 为实际计入花费的额度，信任额度旁路时小于 quota，检查通过后按 reserved 保留；子令牌计入父令牌的预算
+func reserveTokenBudget(relayInfo *relaycommon.RelayInfo, quota int, reserved int) *types.NewAPIError {
+	if relayInfo.TokenBudgetQuota <= 0 || relayInfo.IsPlayground {
+		return nil
+	}
+	ok, err := model.ReserveTokenBudget(relayInfo.LimitTokenId(), quota, relayInfo.TokenBudgetQuota)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeUpdateDataError, types.ErrOptionWithSkipRetry())
+	}
+	if !ok {
+		used, _ := model.GetTokenBudgetUsed(relayInfo.LimitTokenId())
+		return types.NewErrorWithStatusCode(
+			fmt.Errorf("令牌本周期预算不足，预算：%s，已花费：%s，需要：%s", logger.FormatQuota(relayInfo.TokenBudgetQuota), logger.FormatQuota(used), logger.FormatQuota(quota)),
+			types.ErrorCodeTokenBudgetExceeded,
+			http.StatusForbidden,
+			types.ErrOptionWithSkipRetry(),
+			types.ErrOptionWithNoRecordErrorLog(),
+		)
+	}
+	if reserved != quota {
+		adjustTokenBudget(relayInfo, reserved-quota)
+	} else if reserved > 0 && relayInfo.TokenBudgetSoft > 0 {
+		used, err := model.GetTokenBudgetUsed(relayInfo.LimitTokenId())
+		if err == nil && used-reserved < relayInfo.TokenBudgetSoft && used >= relayInfo.TokenBudgetSoft {
+			checkAndSendTokenBudgetNotify(relayInfo, used)
+		}
+	}
+	return nil
+}
+
+// adjustTokenBudget 随令牌额度的补扣与退还同步调整本周期花费
+func adjustTokenBudget(relayInfo *relaycommon.RelayInfo, delta int) {
+	if relayInfo.TokenBudgetQuota <= 0 || relayInfo.IsPlayground || delta == 0 {
+		return
+	}
+	used, err := model.AddTokenBudgetUsed(relayInfo.LimitTokenId(), delta)
+	if err != nil {
+		common.SysLog(fmt.Sprintf("error updating token budget (tokenId=%d, delta=%d): %s", relayInfo.LimitTokenId(), delta, err.Error()))
+		return
+	}
+	if delta > 0 && used-delta < relayInfo.TokenBudgetSoft && used >= relayInfo.TokenBudgetSoft {
+		checkAndSendTokenBudgetNotify(relayInfo, used)
+	}
+}
+
+// recordTokenSpend 记录令牌维度的花费：周期预算与 JWT 额度上限
+func recordTokenSpend(relayInfo *relaycommon.RelayInfo, delta int) {
+	adjustTokenBudget(relayInfo, delta)
+	adjustJWTSpend(relayInfo, delta)
+}
+
+func checkAndSendTokenBudgetNotify(relayInfo *relaycommon.RelayInfo, used int) {
+	gopool.Go(func() {
+		prompt := "您的令牌本周期花费即将达到预算"
+		tokenName := fmt.Sprintf("#%d", relayInfo.LimitTokenId())
+
+		var content string
+		var values []interface{}
+		notifyType := relayInfo.UserSetting.NotifyType
+		if notifyType == "" {
+			notifyType = dto.NotifyTypeEmail
+		}
+		if notifyType == dto.NotifyTypeBark || notifyType == dto.NotifyTypeGotify {
+			content = "{{value}}，令牌 {{value}} 已花费 {{value}}，预算 {{value}}"
+		} else {
+			content = "{{value}}，令牌 {{value}} 本周期已花费 {{value}}，预算为 {{value}}，达到预算后该令牌的请求将被拒绝直至下个周期。"
+		}
+		values = []interface{}{prompt, tokenName, logger.FormatQuota(used), logger.FormatQuota(relayInfo.TokenBudgetQuota)}
+
+		if err := NotifyUser(relayInfo.UserId, relayInfo.UserEmail, relayInfo.UserSetting, dto.NewNotify(dto.NotifyTypeQuotaExceed, prompt, content, values)); err != nil {
+			common.SysError(fmt.Sprintf("failed to send token budget notify to user %d: %s", relayInfo.UserId, err.Error()))
+		}
+	})
+}
@@ -297,6 +297,35 @@ func EstimateRequestToken(c *gin.Context, meta *types.TokenCountMeta, info *rela
 	return tkm, nil
 }
 
+// CountInputTokens 本地估算请求的输入 token 数，用于 count_tokens 接口。
+// 与 EstimateRequestToken 不同，不受 CountToken 开关影响，图片统一按 getImageToken 计算
+func CountInputTokens(c *gin.Context, meta *types.TokenCountMeta, model string) int {
+	if meta == nil {
+		return 0
+	}
+	tokens := CountTextToken(meta.CombineText, model)
+	tokens += meta.ToolsCount * 8
+	tokens += meta.MessagesCount * 3
+	for _, file := range meta.Files {
+		switch file.FileType {
+		case types.FileTypeImage:
+			imageTokens, err := getImageToken(c, file, model, true)
+			if err != nil {
+				// 图片无法读取时使用与 EstimateRequestToken 相同的默认值
+				imageTokens = 520
+			}
+			tokens += imageTokens
+		case types.FileTypeAudio:
+			tokens += 256
+		case types.FileTypeVideo:
+			tokens += 4096 * 2
+		default:
+			tokens += 4096
+		}
+	}
+	return tokens
+}
+
 func CountTokenRealtime(info *relaycommon.RelayInfo, request dto.RealtimeEvent, model string) (int, int, error) {
 	audioToken := 0
 	textToken := 0
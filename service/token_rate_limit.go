@@ -0,0 +1,178 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/limiter"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+	"github.com/gin-gonic/gin"
+)
+
+// 令牌桶按秒补充，每分钟限额 N 折算为容量 N*60、速率 N/秒，每次请求扣除 60 倍用量
+const tokenRateLimitWindow = 60
+
+// 并发计数的兜底过期时间，避免进程异常退出后计数无法归还
+const tokenConcurrencyTTL = time.Hour
+
+type tokenBucket interface {
+	Take(ctx context.Context, key string, force bool, opts ...limiter.Option) (bool, int64, error)
+}
+
+func getTokenBucket() tokenBucket {
+	if common.RedisEnabled {
+		return limiter.New(context.Background(), common.RDB)
+	}
+	return limiter.NewMemory()
+}
+
+func takeTokenBucket(key string, limit int, amount int, force bool) (bool, int64, error) {
+	return getTokenBucket().Take(context.Background(), key, force,
+		limiter.WithCapacity(int64(limit)*tokenRateLimitWindow),
+		limiter.WithRate(int64(limit)),
+		limiter.WithRequested(int64(amount)*tokenRateLimitWindow),
+	)
+}
+
+// setRateLimitHeaders 写入 OpenAI 兼容的 x-ratelimit-* 响应头，kind 为 requests 或 tokens
+func setRateLimitHeaders(c *gin.Context, kind string, limit int, remaining int64) {
+	capacity := int64(limit) * tokenRateLimitWindow
+	reset := time.Duration((capacity-remaining+int64(limit)-1)/int64(limit)) * time.Second
+	c.Header("x-ratelimit-limit-"+kind, strconv.Itoa(limit))
+	c.Header("x-ratelimit-remaining-"+kind, strconv.FormatInt(max(remaining, 0)/tokenRateLimitWindow, 10))
+	c.Header("x-ratelimit-reset-"+kind, reset.String())
+}
+
+// limitTokenId 令牌限流计量的令牌，子令牌计入父令牌
+func limitTokenId(c *gin.Context) int {
+	if parentId := common.GetContextKeyInt(c, constant.ContextKeyTokenParentId); parentId != 0 {
+		return parentId
+	}
+	return common.GetContextKeyInt(c, constant.ContextKeyTokenId)
+}
+
+// TakeTokenRequestRateLimit 按令牌的 RPM 限制扣除一次请求，未设置限制时直接放行
+func TakeTokenRequestRateLimit(c *gin.Context) (bool, error) {
+	rpm := common.GetContextKeyInt(c, constant.ContextKeyTokenRateLimitRPM)
+	if rpm <= 0 {
+		return true, nil
+	}
+	tokenId := limitTokenId(c)
+	allowed, remaining, err := takeTokenBucket(fmt.Sprintf("tokenRateLimit:rpm:%d", tokenId), rpm, 1, false)
+	if err != nil {
+		return false, err
+	}
+	setRateLimitHeaders(c, "requests", rpm, remaining)
+	return allowed, nil
+}
+
+// ReserveTokenTPM 按预估 token 数预留令牌的 TPM 额度，请求结束后由 SettleTokenTPM 按实际用量结算。
+// 单次预估超过限额时按限额预留，避免大请求永远无法通过
+func ReserveTokenTPM(c *gin.Context, info *relaycommon.RelayInfo, estimatedTokens int) *types.NewAPIError {
+	tpm := common.GetContextKeyInt(c, constant.ContextKeyTokenRateLimitTPM)
+	if tpm <= 0 {
+		return nil
+	}
+	reserved := min(max(estimatedTokens, 0), tpm)
+	allowed, remaining, err := takeTokenBucket(fmt.Sprintf("tokenRateLimit:tpm:%d", info.LimitTokenId()), tpm, reserved, false)
+	if err != nil {
+		// 限流存储异常时不影响请求
+		logger.LogError(c, "token tpm rate limit failed: "+err.Error())
+		return nil
+	}
+	setRateLimitHeaders(c, "tokens", tpm, remaining)
+	if !allowed {
+		return types.NewErrorWithStatusCode(fmt.Errorf("令牌已达到每分钟 %d tokens 的限制", tpm), types.ErrorCodeTokenRateLimitExceeded, http.StatusTooManyRequests, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+	}
+	info.TokenTPMReserved = reserved
+	return nil
+}
+
+// SettleTokenTPM 按实际用量结算预留的 TPM 额度，多退少补；请求失败时以 0 结算即全部退还
+func SettleTokenTPM(ctx *gin.Context, info *relaycommon.RelayInfo, actualTokens int) {
+	tpm := common.GetContextKeyInt(ctx, constant.ContextKeyTokenRateLimitTPM)
+	if tpm <= 0 {
+		return
+	}
+	delta := actualTokens - info.TokenTPMReserved
+	info.TokenTPMReserved = 0
+	if delta == 0 {
+		return
+	}
+	if _, _, err := takeTokenBucket(fmt.Sprintf("tokenRateLimit:tpm:%d", info.LimitTokenId()), tpm, delta, true); err != nil {
+		logger.LogError(ctx, "token tpm settle failed: "+err.Error())
+	}
+}
+
+var (
+	tokenConcurrencyMutex sync.Mutex
+	tokenConcurrency      = make(map[int]int)
+)
+
+// AcquireTokenConcurrency 占用令牌的一个并发流式请求名额，返回的 release 用于归还名额
+func AcquireTokenConcurrency(c *gin.Context, info *relaycommon.RelayInfo) (func(), *types.NewAPIError) {
+	maxConcurrency := common.GetContextKeyInt(c, constant.ContextKeyTokenMaxConcurrency)
+	if maxConcurrency <= 0 || !info.IsStream {
+		return func() {}, nil
+	}
+	acquired, release, err := acquireTokenConcurrency(info.LimitTokenId(), maxConcurrency)
+	if err != nil {
+		logger.LogError(c, "token concurrency limit failed: "+err.Error())
+		return func() {}, nil
+	}
+	if !acquired {
+		return nil, types.NewErrorWithStatusCode(fmt.Errorf("令牌已达到最大并发流式请求数 %d", maxConcurrency), types.ErrorCodeTokenRateLimitExceeded, http.StatusTooManyRequests, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+	}
+	return release, nil
+}
+
+func acquireTokenConcurrency(tokenId int, maxConcurrency int) (bool, func(), error) {
+	if common.RedisEnabled {
+		ctx := context.Background()
+		key := fmt.Sprintf("tokenConcurrency:%d", tokenId)
+		count, err := common.RDB.Incr(ctx, key).Result()
+		if err != nil {
+			return false, nil, err
+		}
+		common.RDB.Expire(ctx, key, tokenConcurrencyTTL)
+		var once sync.Once
+		release := func() {
+			once.Do(func() {
+				common.RDB.Decr(context.Background(), key)
+			})
+		}
+		if count > int64(maxConcurrency) {
+			release()
+			return false, nil, nil
+		}
+		return true, release, nil
+	}
+
+	tokenConcurrencyMutex.Lock()
+	defer tokenConcurrencyMutex.Unlock()
+	if tokenConcurrency[tokenId] >= maxConcurrency {
+		return false, nil, nil
+	}
+	tokenConcurrency[tokenId]++
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			tokenConcurrencyMutex.Lock()
+			defer tokenConcurrencyMutex.Unlock()
+			if tokenConcurrency[tokenId] <= 1 {
+				delete(tokenConcurrency, tokenId)
+				return
+			}
+			tokenConcurrency[tokenId]--
+		})
+	}
+	return true, release, nil
+}
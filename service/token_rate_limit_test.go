@@ -0,0 +1,102 @@
+package service
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func newTokenRateLimitContext(tokenId int) (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	common.SetContextKey(c, constant.ContextKeyTokenId, tokenId)
+	return c, recorder
+}
+
+func TestTokenRequestRateLimit(t *testing.T) {
+	c, recorder := newTokenRateLimitContext(910001)
+	common.SetContextKey(c, constant.ContextKeyTokenRateLimitRPM, 2)
+
+	for i := 0; i < 2; i++ {
+		allowed, err := TakeTokenRequestRateLimit(c)
+		require.NoError(t, err)
+		require.True(t, allowed)
+	}
+	require.Equal(t, "2", recorder.Header().Get("x-ratelimit-limit-requests"))
+	require.Equal(t, "0", recorder.Header().Get("x-ratelimit-remaining-requests"))
+	require.Equal(t, "1m0s", recorder.Header().Get("x-ratelimit-reset-requests"))
+
+	allowed, err := TakeTokenRequestRateLimit(c)
+	require.NoError(t, err)
+	require.False(t, allowed)
+}
+
+func TestTokenTPMReserveAndSettle(t *testing.T) {
+	c, recorder := newTokenRateLimitContext(910002)
+	common.SetContextKey(c, constant.ContextKeyTokenRateLimitTPM, 1000)
+	info := &relaycommon.RelayInfo{TokenId: 910002}
+
+	require.Nil(t, ReserveTokenTPM(c, info, 600))
+	require.Equal(t, 600, info.TokenTPMReserved)
+	require.Equal(t, "400", recorder.Header().Get("x-ratelimit-remaining-tokens"))
+
+	// 实际用量少于预留时退还差额
+	SettleTokenTPM(c, info, 100)
+	require.Zero(t, info.TokenTPMReserved)
+	require.Nil(t, ReserveTokenTPM(c, info, 900))
+
+	apiErr := ReserveTokenTPM(c, info, 100)
+	require.NotNil(t, apiErr)
+	require.Equal(t, types.ErrorCodeTokenRateLimitExceeded, apiErr.GetErrorCode())
+}
+
+func TestTokenConcurrencyLimit(t *testing.T) {
+	c, _ := newTokenRateLimitContext(910003)
+	common.SetContextKey(c, constant.ContextKeyTokenMaxConcurrency, 1)
+	info := &relaycommon.RelayInfo{TokenId: 910003, IsStream: true}
+
+	release, apiErr := AcquireTokenConcurrency(c, info)
+	require.Nil(t, apiErr)
+	_, apiErr = AcquireTokenConcurrency(c, info)
+	require.NotNil(t, apiErr)
+
+	// 非流式请求不受并发限制
+	info.IsStream = false
+	_, apiErr = AcquireTokenConcurrency(c, info)
+	require.Nil(t, apiErr)
+
+	info.IsStream = true
+	release()
+	release()
+	release, apiErr = AcquireTokenConcurrency(c, info)
+	require.Nil(t, apiErr)
+	release()
+}
+
+func TestChildTokenRateLimitCountsAgainstParent(t *testing.T) {
+	parent, _ := newTokenRateLimitContext(910004)
+	common.SetContextKey(parent, constant.ContextKeyTokenRateLimitRPM, 2)
+	child, _ := newTokenRateLimitContext(910005)
+	common.SetContextKey(child, constant.ContextKeyTokenParentId, 910004)
+	common.SetContextKey(child, constant.ContextKeyTokenRateLimitRPM, 2)
+
+	allowed, err := TakeTokenRequestRateLimit(parent)
+	require.NoError(t, err)
+	require.True(t, allowed)
+	allowed, err = TakeTokenRequestRateLimit(child)
+	require.NoError(t, err)
+	require.True(t, allowed)
+
+	// 子令牌与父令牌共用限额
+	allowed, err = TakeTokenRequestRateLimit(child)
+	require.NoError(t, err)
+	require.False(t, allowed)
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+const (
+	tokenRotationTickInterval = 1 * time.Minute
+	tokenRotationBatchSize    = 100
+)
+
+var (
+	tokenRotationOnce    sync.Once
+	tokenRotationRunning atomic.Bool
+)
+
+// GetTokenRotationGraceSeconds 返回轮换时旧密钥的宽限期，graceSeconds 小于 0 时使用默认宽限期
+func GetTokenRotationGraceSeconds(graceSeconds int) (int64, error) {
+	setting := operation_setting.GetTokenRotationSetting()
+	if graceSeconds < 0 {
+		graceSeconds = setting.GraceSeconds
+	}
+	if setting.MaxGraceSeconds > 0 && graceSeconds > setting.MaxGraceSeconds {
+		return 0, fmt.Errorf("宽限期不能超过 %d 秒", setting.MaxGraceSeconds)
+	}
+	return int64(max(graceSeconds, 0)), nil
+}
+
+// StartTokenRotationTask 清理宽限期已结束的旧密钥、已过期的子令牌与 JWT 花费记录，并执行令牌的定期自动轮换
+func StartTokenRotationTask() {
+	tokenRotationOnce.Do(func() {
+		if !common.IsMasterNode {
+			return
+		}
+		gopool.Go(func() {
+			logger.LogInfo(context.Background(), fmt.Sprintf("token rotation task started: tick=%s", tokenRotationTickInterval))
+			ticker := time.NewTicker(tokenRotationTickInterval)
+			defer ticker.Stop()
+
+			runTokenRotationOnce()
+			for range ticker.C {
+				runTokenRotationOnce()
+			}
+		})
+	})
+}
+
+func runTokenRotationOnce() {
+	if !tokenRotationRunning.CompareAndSwap(false, true) {
+		return
+	}
+	defer tokenRotationRunning.Store(false)
+
+	ctx := context.Background()
+	for {
+		n, err := model.ExpireTokenPreviousKeys(tokenRotationBatchSize)
+		if err != nil {
+			logger.LogWarn(ctx, fmt.Sprintf("token previous key expire task failed: %v", err))
+			break
+		}
+		if n < tokenRotationBatchSize {
+			break
+		}
+	}
+
+	for {
+		n, err := model.ExpireChildTokens(tokenRotationBatchSize)
+		if err != nil {
+			logger.LogWarn(ctx, fmt.Sprintf("child token expire task failed: %v", err))
+			break
+		}
+		if n < tokenRotationBatchSize {
+			break
+		}
+	}
+	for {
+		n, err := model.DeleteExpiredJWTSpends(tokenRotationBatchSize)
+		if err != nil {
+			logger.LogWarn(ctx, fmt.Sprintf("jwt spend cleanup task failed: %v", err))
+			break
+		}
+		if n < tokenRotationBatchSize {
+			break
+		}
+	}
+
+	setting := operation_setting.GetTokenRotationSetting()
+	if !setting.AutoRotateEnabled {
+		return
+	}
+	tokens, err := model.GetTokensDueForAutoRotation(tokenRotationBatchSize)
+	if err != nil {
+		logger.LogWarn(ctx, fmt.Sprintf("token auto rotation task failed: %v", err))
+		return
+	}
+	for _, token := range tokens {
+		if err := model.RotateTokenKey(token, int64(max(setting.GraceSeconds, 0))); err != nil {
+			logger.LogWarn(ctx, fmt.Sprintf("token auto rotation failed, token_id=%d: %v", token.Id, err))
+			continue
+		}
+		notifyTokenRotated(token)
+	}
+}
+
+// notifyTokenRotated 通知用户令牌已自动轮换：配置了 Webhook 时推送新密钥，否则只发送轮换提醒
+func notifyTokenRotated(token *model.Token) {
+	user, err := model.GetUserById(token.UserId, false)
+	if err != nil {
+		common.SysLog(fmt.Sprintf("failed to get user %d for token rotation notify: %s", token.UserId, err.Error()))
+		return
+	}
+	userSetting := user.GetSetting()
+	title := "令牌密钥已自动轮换"
+	expiry := "旧密钥已失效"
+	if token.PreviousKeyExpiry > 0 {
+		expiry = fmt.Sprintf("旧密钥将于 %s 失效", time.Unix(token.PreviousKeyExpiry, 0).Format(time.DateTime))
+	}
+	if userSetting.WebhookUrl != "" {
+		// Webhook 内容使用 fmt 格式化填充新密钥
+		content := fmt.Sprintf("令牌 %s 的密钥已自动轮换，%s，新密钥：%%s", strings.ReplaceAll(token.Name, "%", "%%"), expiry)
+		data := dto.NewNotify(dto.NotifyTypeTokenRotated, title, content, []interface{}{token.GetFullKey()})
+		if err := SendWebhookNotify(userSetting.WebhookUrl, userSetting.WebhookSecret, data); err != nil {
+			common.SysLog(fmt.Sprintf("failed to send token rotation webhook to user %d: %s", token.UserId, err.Error()))
+		}
+		return
+	}
+	content := fmt.Sprintf("令牌 %s 的密钥已自动轮换，请在控制台查看新密钥，%s", token.Name, expiry)
+	if err := NotifyUser(user.Id, user.Email, userSetting, dto.NewNotify(dto.NotifyTypeTokenRotated, title, content, nil)); err != nil {
+		common.SysLog(fmt.Sprintf("failed to notify user %d for token rotation: %s", token.UserId, err.Error()))
+	}
+}
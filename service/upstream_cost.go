@@ -0,0 +1,52 @@
+package service
+
+import (
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/pkg/billingexpr"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/gin-gonic/gin"
+)
+
+// ShouldRerouteByCost 分发时尚未估算 token，按成本选择渠道的分组需要在估算后重新选择首个渠道。
+// 指定渠道与命中渠道亲和的请求保持原渠道
+func ShouldRerouteByCost(c *gin.Context, info *relaycommon.RelayInfo) bool {
+	if _, ok := c.Get("specific_channel_id"); ok {
+		return false
+	}
+	if _, ok := c.Get(ginKeyChannelAffinityLogInfo); ok {
+		return false
+	}
+	group := info.UsingGroup
+	if group == "auto" {
+		group = common.GetContextKeyString(c, constant.ContextKeyAutoGroup)
+	}
+	return operation_setting.GetChannelSelectionStrategy(group) == operation_setting.ChannelSelectionLowestCost
+}
+
+// calculateUpstreamCost 按渠道配置的上游成本计算本次请求实际支付给上游的额度，未配置时返回 false
+func calculateUpstreamCost(relayInfo *relaycommon.RelayInfo, usage *dto.Usage, isClaudeUsageSemantic bool) (int, bool) {
+	if usage == nil || relayInfo.ChannelMeta == nil {
+		return 0, false
+	}
+	upstreamCost, ok := relayInfo.ChannelOtherSettings.GetUpstreamCost(relayInfo.OriginModelName)
+	if !ok {
+		return 0, false
+	}
+	cost, err := upstreamCost.Cost(BuildTieredTokenParams(usage, isClaudeUsageSemantic, upstreamCost.UsedVars()))
+	if err != nil {
+		return 0, false
+	}
+	return billingexpr.QuotaRound(cost * common.QuotaPerUnit), true
+}
+
+// appendUpstreamCostInfo 在日志中记录上游成本与毛利，单位均为额度
+func appendUpstreamCostInfo(other map[string]interface{}, quota int, upstreamCost int) {
+	if other == nil {
+		return
+	}
+	other["upstream_cost"] = upstreamCost
+	other["margin"] = quota - upstreamCost
+}
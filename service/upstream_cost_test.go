@@ -0,0 +1,45 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalculateUpstreamCost(t *testing.T) {
+	usage := &dto.Usage{PromptTokens: 1000, CompletionTokens: 500}
+	usage.PromptTokensDetails.CachedTokens = 400
+	relayInfo := &relaycommon.RelayInfo{
+		OriginModelName: "gpt-4o",
+		ChannelMeta: &relaycommon.ChannelMeta{
+			ChannelOtherSettings: dto.ChannelOtherSettings{
+				UpstreamCost: map[string]dto.UpstreamCost{
+					"gpt-4o": {Expr: "p * 2 + cr * 0.5 + c * 8"},
+					"*":      {InputPrice: 1, OutputPrice: 2},
+				},
+			},
+		},
+	}
+
+	// 表达式单独计价缓存时，缓存 token 从 p 中扣除
+	cost, ok := calculateUpstreamCost(relayInfo, usage, false)
+	require.True(t, ok)
+	require.Equal(t, int((600*2+400*0.5+500*8)/1_000_000*common.QuotaPerUnit), cost)
+
+	relayInfo.OriginModelName = "gpt-4o-mini"
+	cost, ok = calculateUpstreamCost(relayInfo, usage, false)
+	require.True(t, ok)
+	require.Equal(t, int((1000.0*1+500*2)/1_000_000*common.QuotaPerUnit), cost)
+
+	relayInfo.ChannelOtherSettings = dto.ChannelOtherSettings{}
+	_, ok = calculateUpstreamCost(relayInfo, usage, false)
+	require.False(t, ok)
+
+	other := map[string]interface{}{}
+	appendUpstreamCostInfo(other, 1000, 400)
+	require.Equal(t, 400, other["upstream_cost"])
+	require.Equal(t, 600, other["margin"])
+}
@@ -0,0 +1,49 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// BatchSetting /v1/batches 批处理配置
+type BatchSetting struct {
+	Enabled bool `json:"enabled"` // 是否启用批处理接口
+	// DefaultDiscountRatio 批处理请求默认计费倍率（在分组倍率基础上相乘）
+	DefaultDiscountRatio float64 `json:"default_discount_ratio"`
+	// ModelDiscountRatio 按模型覆盖批处理计费倍率
+	ModelDiscountRatio map[string]float64 `json:"model_discount_ratio"`
+	// MaxRequestsPerBatch 单个批处理最大请求行数
+	MaxRequestsPerBatch int `json:"max_requests_per_batch"`
+	// Concurrency 单个批处理的并发请求数
+	Concurrency int `json:"concurrency"`
+	// MaxRunningBatches 同时执行的批处理数量
+	MaxRunningBatches int `json:"max_running_batches"`
+}
+
+// 默认配置
+var batchSetting = BatchSetting{
+	Enabled:              false,
+	DefaultDiscountRatio: 0.5,
+	ModelDiscountRatio:   map[string]float64{},
+	MaxRequestsPerBatch:  50000,
+	Concurrency:          4,
+	MaxRunningBatches:    2,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("batch_setting", &batchSetting)
+}
+
+// GetBatchSetting 获取批处理配置
+func GetBatchSetting() *BatchSetting {
+	return &batchSetting
+}
+
+// GetBatchDiscountRatio 获取模型的批处理计费倍率
+func GetBatchDiscountRatio(model string) float64 {
+	if ratio, ok := batchSetting.ModelDiscountRatio[model]; ok && ratio >= 0 {
+		return ratio
+	}
+	if batchSetting.DefaultDiscountRatio < 0 {
+		return 1
+	}
+	return batchSetting.DefaultDiscountRatio
+}
@@ -0,0 +1,67 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+const (
+	ChannelSelectionWeightedRandom = "weighted_random" // 按权重随机（默认，与原有行为一致）
+	ChannelSelectionLeastLatency   = "least_latency"   // 选择滚动平均延迟最低的渠道
+	ChannelSelectionErrorRate      = "error_rate"      // 按错误率 EWMA 衰减权重后随机
+	ChannelSelectionPowerOfTwo     = "power_of_two"    // 按权重随机抽取两个渠道，选择综合得分更优的一个
+	ChannelSelectionLowestCost     = "lowest_cost"     // 按上游成本选择本次请求预估成本最低的渠道
+)
+
+// ChannelSelectionSetting 同优先级渠道的选择策略配置
+type ChannelSelectionSetting struct {
+	// DefaultStrategy 未单独配置的分组使用的策略
+	DefaultStrategy string `json:"default_strategy"`
+	// GroupStrategies 按分组覆盖选择策略
+	GroupStrategies map[string]string `json:"group_strategies"`
+	// EWMAAlpha 滚动统计的平滑系数，越大越偏向最近的请求
+	EWMAAlpha float64 `json:"ewma_alpha"`
+	// MinSamples 样本数不足的渠道视为冷启动，按平均水平参与选择
+	MinSamples int `json:"min_samples"`
+	// ColdProbePercent 最低延迟策略下分给冷启动渠道的探测流量百分比，用于积累样本
+	ColdProbePercent int `json:"cold_probe_percent"`
+	// FailureLatencyMs 失败请求计入延迟统计的惩罚值（毫秒），实际耗时更长时取实际耗时
+	FailureLatencyMs int `json:"failure_latency_ms"`
+	// StatsTTLSeconds 超过该时间未更新的统计视为过期，渠道恢复后可重新获得流量
+	StatsTTLSeconds int `json:"stats_ttl_seconds"`
+	// MinWeightPercent 错误率策略下渠道保留的最低权重百分比，用于持续探测
+	MinWeightPercent int `json:"min_weight_percent"`
+	// CostCompletionTokens 按成本选择时，请求未指定 max_tokens 时预估的输出 token 数
+	CostCompletionTokens int `json:"cost_completion_tokens"`
+}
+
+// 默认配置
+var channelSelectionSetting = ChannelSelectionSetting{
+	DefaultStrategy:      ChannelSelectionWeightedRandom,
+	GroupStrategies:      map[string]string{},
+	EWMAAlpha:            0.2,
+	MinSamples:           10,
+	ColdProbePercent:     10,
+	FailureLatencyMs:     30000,
+	StatsTTLSeconds:      600,
+	MinWeightPercent:     5,
+	CostCompletionTokens: 500,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("channel_selection_setting", &channelSelectionSetting)
+}
+
+// GetChannelSelectionSetting 获取渠道选择策略配置
+func GetChannelSelectionSetting() *ChannelSelectionSetting {
+	return &channelSelectionSetting
+}
+
+// GetChannelSelectionStrategy 获取分组使用的渠道选择策略
+func GetChannelSelectionStrategy(group string) string {
+	if strategy, ok := channelSelectionSetting.GroupStrategies[group]; ok && strategy != "" {
+		return strategy
+	}
+	if channelSelectionSetting.DefaultStrategy == "" {
+		return ChannelSelectionWeightedRandom
+	}
+	return channelSelectionSetting.DefaultStrategy
+}
@@ -0,0 +1,42 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// CircuitBreakerSetting 渠道与多密钥的熔断配置
+type CircuitBreakerSetting struct {
+	// Enabled 启用后限流、超时与上游 5xx 错误由熔断器处理，不再直接自动禁用渠道
+	Enabled bool `json:"enabled"`
+	// FailureThreshold 统计窗口内失败次数达到该值时熔断
+	FailureThreshold int `json:"failure_threshold"`
+	// FailureWindowSeconds 失败次数统计窗口
+	FailureWindowSeconds int `json:"failure_window_seconds"`
+	// OpenSeconds 熔断后的冷却时间，之后进入半开状态
+	OpenSeconds int `json:"open_seconds"`
+	// MaxOpenSeconds 半开探测失败后冷却时间翻倍，最长不超过该值
+	MaxOpenSeconds int `json:"max_open_seconds"`
+	// HalfOpenMaxRequests 半开状态下同时放行的探测请求数
+	HalfOpenMaxRequests int `json:"half_open_max_requests"`
+	// HalfOpenSuccessThreshold 半开状态下连续成功该次数后恢复
+	HalfOpenSuccessThreshold int `json:"half_open_success_threshold"`
+}
+
+// 默认配置
+var circuitBreakerSetting = CircuitBreakerSetting{
+	Enabled:                  false,
+	FailureThreshold:         5,
+	FailureWindowSeconds:     60,
+	OpenSeconds:              30,
+	MaxOpenSeconds:           600,
+	HalfOpenMaxRequests:      1,
+	HalfOpenSuccessThreshold: 2,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("circuit_breaker_setting", &circuitBreakerSetting)
+}
+
+// GetCircuitBreakerSetting 获取熔断配置
+func GetCircuitBreakerSetting() *CircuitBreakerSetting {
+	return &circuitBreakerSetting
+}
@@ -0,0 +1,24 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// CountTokensSetting Claude /v1/messages/count_tokens 与 Gemini countTokens 接口配置，这两个接口均不计费
+type CountTokensSetting struct {
+	// UpstreamEnabled 分发到原生 Claude / Gemini 渠道时转发到上游计算，关闭或上游失败时在本地估算
+	UpstreamEnabled bool `json:"upstream_enabled"`
+}
+
+// 默认配置
+var countTokensSetting = CountTokensSetting{
+	UpstreamEnabled: true,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("count_tokens_setting", &countTokensSetting)
+}
+
+// GetCountTokensSetting 获取 count_tokens 接口配置
+func GetCountTokensSetting() *CountTokensSetting {
+	return &countTokensSetting
+}
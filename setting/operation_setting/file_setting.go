@@ -0,0 +1,59 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// FileSetting /v1/files 文件接口配置
+type FileSetting struct {
+	Enabled bool `json:"enabled"` // 是否启用文件接口
+	// MaxFileSizeMB 单个文件大小上限（MB）
+	MaxFileSizeMB int `json:"max_file_size_mb"`
+	// UserStorageLimitMB 每个用户可占用的存储总量（MB），0 表示不限制
+	UserStorageLimitMB int `json:"user_storage_limit_mb"`
+	// GroupStorageLimitMB 按用户分组覆盖存储总量（MB）
+	GroupStorageLimitMB map[string]int `json:"group_storage_limit_mb"`
+	// DefaultExpireHours 未指定 expires_after 时的默认保留时长（小时），0 表示不过期
+	DefaultExpireHours int `json:"default_expire_hours"`
+	// InlineMaxSizeMB 目标渠道不支持文件接口时，以 base64 内联文件的大小上限（MB）
+	InlineMaxSizeMB int `json:"inline_max_size_mb"`
+	// StoragePath 文件存储目录，为空时使用数据目录（工作目录）下的 new-api-files，需位于持久化存储中
+	StoragePath string `json:"storage_path"`
+}
+
+// 默认配置
+var fileSetting = FileSetting{
+	Enabled:             false,
+	MaxFileSizeMB:       512,
+	UserStorageLimitMB:  1024,
+	GroupStorageLimitMB: map[string]int{},
+	DefaultExpireHours:  0,
+	InlineMaxSizeMB:     20,
+	StoragePath:         "",
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("file_setting", &fileSetting)
+}
+
+// GetFileSetting 获取文件接口配置
+func GetFileSetting() *FileSetting {
+	return &fileSetting
+}
+
+// GetFileMaxSizeBytes 单个文件大小上限（字节）
+func GetFileMaxSizeBytes() int64 {
+	return int64(fileSetting.MaxFileSizeMB) << 20
+}
+
+// GetFileInlineMaxSizeBytes 内联文件大小上限（字节）
+func GetFileInlineMaxSizeBytes() int64 {
+	return int64(fileSetting.InlineMaxSizeMB) << 20
+}
+
+// GetUserFileStorageLimitBytes 获取分组对应的存储总量上限（字节），0 表示不限制
+func GetUserFileStorageLimitBytes(group string) int64 {
+	if limit, ok := fileSetting.GroupStorageLimitMB[group]; ok {
+		return int64(limit) << 20
+	}
+	return int64(fileSetting.UserStorageLimitMB) << 20
+}
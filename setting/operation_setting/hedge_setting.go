@@ -0,0 +1,43 @@
+package operation_setting
+
+import (
+	"slices"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// HedgeSetting 对冲请求配置：首个渠道在延迟内未返回数据时向第二个渠道发出相同请求，
+// 采用先返回的结果并取消另一个请求，仅对胜出的请求计费
+type HedgeSetting struct {
+	// Enabled 总开关，关闭时分组与令牌的对冲设置均不生效
+	Enabled bool `json:"enabled"`
+	// DelayMs 首个渠道超过该时间仍未返回首字时发出对冲请求
+	DelayMs int `json:"delay_ms"`
+	// Groups 默认启用对冲的分组，令牌也可以单独开启
+	Groups []string `json:"groups"`
+	// RecordLoserUsage 在胜出请求的日志中记录落败请求的用量
+	RecordLoserUsage bool `json:"record_loser_usage"`
+}
+
+// 默认配置
+var hedgeSetting = HedgeSetting{
+	Enabled:          false,
+	DelayMs:          800,
+	Groups:           []string{},
+	RecordLoserUsage: true,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("hedge_setting", &hedgeSetting)
+}
+
+// GetHedgeSetting 获取对冲请求配置
+func GetHedgeSetting() *HedgeSetting {
+	return &hedgeSetting
+}
+
+// IsHedgeGroup 分组是否默认启用对冲
+func IsHedgeGroup(group string) bool {
+	return slices.Contains(hedgeSetting.Groups, group)
+}
@@ -0,0 +1,62 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// 注入检测命中后的处理方式
+const (
+	InjectionGuardActionBlock = "block" // 按参数覆盖 return_error 的语义拒绝请求
+	InjectionGuardActionTag   = "tag"   // 仅在日志中标记
+	InjectionGuardActionStrip = "strip" // 将命中的消息片段替换为移除提示
+)
+
+// InjectionGuardCondition 条件语法与参数覆盖的 conditions 相同，额外支持 regex 模式。
+// 路径相对于待检查的片段：text 为片段文本，kind 为片段类型（tool_result、document 等），role 为所在消息的角色，
+// part 为片段原始 JSON；片段中不存在的路径从请求体中取值
+type InjectionGuardCondition struct {
+	Path           string `json:"path"`
+	Mode           string `json:"mode"`
+	Value          any    `json:"value"`
+	Invert         bool   `json:"invert"`
+	PassMissingKey bool   `json:"pass_missing_key"`
+}
+
+// InjectionGuardRule 注入检测规则
+type InjectionGuardRule struct {
+	Name       string                    `json:"name"`
+	Enabled    bool                      `json:"enabled"`
+	Conditions []InjectionGuardCondition `json:"conditions"`
+	Logic      string                    `json:"logic"` // AND, OR (默认OR)
+	Action     string                    `json:"action"`
+	// ReturnError block 时返回的错误，格式同参数覆盖的 return_error，为空时使用默认提示
+	ReturnError any `json:"return_error,omitempty"`
+}
+
+// InjectionGuardSetting 检查工具结果与检索内容中的提示词注入
+type InjectionGuardSetting struct {
+	Enabled bool `json:"enabled"`
+	// AffinityRules 使用这些渠道亲和规则的 user_agent_include 识别 agent 客户端，为空时检查全部请求
+	AffinityRules []string `json:"affinity_rules"`
+	// Heuristics 是否启用内置的启发式规则，命中时使用 HeuristicAction 处理
+	Heuristics      bool                 `json:"heuristics"`
+	HeuristicAction string               `json:"heuristic_action"`
+	Rules           []InjectionGuardRule `json:"rules"`
+}
+
+// 默认配置
+var injectionGuardSetting = InjectionGuardSetting{
+	Enabled:         false,
+	AffinityRules:   []string{},
+	Heuristics:      true,
+	HeuristicAction: InjectionGuardActionTag,
+	Rules:           []InjectionGuardRule{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("injection_guard_setting", &injectionGuardSetting)
+}
+
+// GetInjectionGuardSetting 获取提示词注入检测配置
+func GetInjectionGuardSetting() *InjectionGuardSetting {
+	return &injectionGuardSetting
+}
@@ -0,0 +1,38 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// JWTTokenSetting JWT 令牌配置：开启后请求可直接携带管理员配置的密钥签发的 JWT，无需在数据库中创建令牌。
+// sub 为用户 ID，可选的 group、models、quota 分别对应令牌分组、可用模型与额度上限，jti 必填，用于吊销与额度统计
+type JWTTokenSetting struct {
+	Enabled bool `json:"enabled"`
+	// Algorithm 签名算法：HS256、RS256、EdDSA
+	Algorithm string `json:"algorithm"`
+	// Secret HS256 的共享密钥
+	Secret string `json:"secret"`
+	// PublicKey RS256/EdDSA 的 PEM 公钥，配置了 JWKSURL 时优先使用 JWKS 中与 kid 匹配的公钥
+	PublicKey string `json:"public_key"`
+	JWKSURL   string `json:"jwks_url"`
+	// Issuer / Audience 非空时校验 iss / aud
+	Issuer   string `json:"issuer"`
+	Audience string `json:"audience"`
+	// MaxTTLSeconds 令牌最长有效期（exp - iat），0 表示不限制
+	MaxTTLSeconds int `json:"max_ttl_seconds"`
+}
+
+// 默认配置
+var jwtTokenSetting = JWTTokenSetting{
+	Enabled:       false,
+	Algorithm:     "HS256",
+	MaxTTLSeconds: 86400,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("jwt_token_setting", &jwtTokenSetting)
+}
+
+// GetJWTTokenSetting 获取 JWT 令牌配置
+func GetJWTTokenSetting() *JWTTokenSetting {
+	return &jwtTokenSetting
+}
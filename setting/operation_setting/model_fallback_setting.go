@@ -0,0 +1,36 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// ModelFallbackSetting 模型降级配置：请求模型的所有渠道都不可用或返回可重试的错误时，
+// 按降级链依次改用其他模型，并按实际使用的模型计费
+type ModelFallbackSetting struct {
+	// Enabled 总开关，关闭时令牌的降级设置也不生效
+	Enabled bool `json:"enabled"`
+	// DefaultEnabled 令牌未单独设置时是否启用降级
+	DefaultEnabled bool `json:"default_enabled"`
+	// Chains 按请求模型配置的降级模型，按顺序尝试，例如 {"claude-sonnet-4": ["gpt-4.1", "gemini-2.5-pro"]}
+	Chains map[string][]string `json:"chains"`
+}
+
+// 默认配置
+var modelFallbackSetting = ModelFallbackSetting{
+	Enabled:        false,
+	DefaultEnabled: true,
+	Chains:         map[string][]string{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("model_fallback_setting", &modelFallbackSetting)
+}
+
+// GetModelFallbackSetting 获取模型降级配置
+func GetModelFallbackSetting() *ModelFallbackSetting {
+	return &modelFallbackSetting
+}
+
+// GetModelFallbackChain 获取模型的降级模型列表
+func GetModelFallbackChain(model string) []string {
+	return modelFallbackSetting.Chains[model]
+}
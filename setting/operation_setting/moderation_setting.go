@@ -0,0 +1,102 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// 审核命中后的处理方式
+const (
+	ModerationActionBlock  = "block"  // 拒绝请求，流式输出时终止输出
+	ModerationActionRedact = "redact" // 替换命中内容，无法定位命中内容的检查器按拒绝处理
+	ModerationActionLog    = "log"    // 仅记录到日志
+)
+
+// 审核检查器类型
+const (
+	ModerationCheckerKeyword = "keyword"
+	ModerationCheckerRegex   = "regex"
+	ModerationCheckerOpenAI  = "openai"
+	ModerationCheckerHTTP    = "http"
+)
+
+// ModerationChecker 审核检查器配置
+type ModerationChecker struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Enabled bool   `json:"enabled"`
+	// Category keyword / regex 命中时归入的类别
+	Category string   `json:"category"`
+	Keywords []string `json:"keywords"`
+	Patterns []string `json:"patterns"`
+	// ChannelId openai 类型使用的渠道，请求该渠道的 /v1/moderations，Model 为空时使用 omni-moderation-latest
+	ChannelId int    `json:"channel_id"`
+	Model     string `json:"model"`
+	// URL http 类型的本地分类服务地址，请求体为 {"text": "...", "images": [...]}，
+	// 响应体为 {"categories": {"类别": 分数}}
+	URL string `json:"url"`
+	// Threshold openai / http 类型的类别分数阈值，openai 类型为 0 时使用上游返回的判定结果
+	Threshold      float64 `json:"threshold"`
+	TimeoutSeconds int     `json:"timeout_seconds"`
+}
+
+// ModerationPolicy 审核策略
+type ModerationPolicy struct {
+	// Checkers 使用的检查器名称，为空表示全部已启用的检查器
+	Checkers []string `json:"checkers"`
+	// Actions 类别对应的处理方式，"*" 为未配置类别的处理方式，默认拒绝
+	Actions map[string]string `json:"actions"`
+	// CheckImages 是否将图片链接交给 openai / http 检查器
+	CheckImages bool `json:"check_images"`
+	// ScanOutput 是否使用 keyword / regex 检查器扫描流式输出
+	ScanOutput bool `json:"scan_output"`
+}
+
+// ModerationSetting 内容审核配置
+type ModerationSetting struct {
+	Enabled       bool                        `json:"enabled"`
+	Checkers      []ModerationChecker         `json:"checkers"`
+	DefaultPolicy ModerationPolicy            `json:"default_policy"`
+	GroupPolicies map[string]ModerationPolicy `json:"group_policies"`
+	// OutputLookbackChars 扫描流式输出时保留的前文字符数，用于识别跨分片的命中
+	OutputLookbackChars int `json:"output_lookback_chars"`
+	// FailOpen 远程检查器请求失败时是否放行，默认拒绝
+	FailOpen bool `json:"fail_open"`
+}
+
+// 默认配置
+var moderationSetting = ModerationSetting{
+	Enabled:       false,
+	Checkers:      []ModerationChecker{},
+	GroupPolicies: map[string]ModerationPolicy{},
+	DefaultPolicy: ModerationPolicy{
+		Actions: map[string]string{"*": ModerationActionBlock},
+	},
+	OutputLookbackChars: 256,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("moderation_setting", &moderationSetting)
+}
+
+// GetModerationSetting 获取内容审核配置
+func GetModerationSetting() *ModerationSetting {
+	return &moderationSetting
+}
+
+// PolicyForGroup 获取分组的审核策略，未单独配置的分组使用默认策略
+func (s *ModerationSetting) PolicyForGroup(group string) *ModerationPolicy {
+	if policy, ok := s.GroupPolicies[group]; ok {
+		return &policy
+	}
+	return &s.DefaultPolicy
+}
+
+// ActionFor 获取类别对应的处理方式
+func (p *ModerationPolicy) ActionFor(category string) string {
+	if action, ok := p.Actions[category]; ok {
+		return action
+	}
+	if action, ok := p.Actions["*"]; ok {
+		return action
+	}
+	return ModerationActionBlock
+}
@@ -0,0 +1,66 @@
+package operation_setting
+
+import (
+	"slices"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// PII 实体类型
+const (
+	PIIEntityEmail      = "email"
+	PIIEntityPhone      = "phone"
+	PIIEntityCreditCard = "credit_card"
+	PIIEntityNationalId = "national_id"
+	PIIEntityAPIKey     = "api_key"
+)
+
+// PII 替换方式，开启可逆脱敏时统一替换为带序号的占位符
+const (
+	PIIReplacementLabel = "label" // [EMAIL]
+	PIIReplacementMask  = "mask"  // 保留末尾 4 位，邮箱保留首字符与域名
+	PIIReplacementHash  = "hash"  // [EMAIL:哈希前 8 位]，同一原文的哈希相同
+)
+
+// PIIRedactionSetting 请求发往上游前的 PII 脱敏配置
+type PIIRedactionSetting struct {
+	Enabled bool `json:"enabled"`
+	// EntityTypes 需要脱敏的实体类型，为空表示全部
+	EntityTypes      []string `json:"entity_types"`
+	ReplacementStyle string   `json:"replacement_style"`
+	// Reversible 是否可逆脱敏，替换为 [EMAIL_1] 形式的占位符，并在响应中还原原文
+	Reversible bool `json:"reversible"`
+	// Groups 对这些分组的请求脱敏，渠道也可以在渠道设置中单独开启
+	Groups []string `json:"groups"`
+}
+
+// 默认配置
+var piiRedactionSetting = PIIRedactionSetting{
+	Enabled:          false,
+	EntityTypes:      []string{},
+	ReplacementStyle: PIIReplacementLabel,
+	Groups:           []string{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("pii_redaction_setting", &piiRedactionSetting)
+}
+
+// GetPIIRedactionSetting 获取 PII 脱敏配置
+func GetPIIRedactionSetting() *PIIRedactionSetting {
+	return &piiRedactionSetting
+}
+
+// ShouldRedact 判断分组或渠道是否开启了脱敏
+func (s *PIIRedactionSetting) ShouldRedact(group string, channelEnabled bool) bool {
+	if !s.Enabled {
+		return false
+	}
+	return channelEnabled || slices.Contains(s.Groups, group)
+}
+
+// EntityEnabled 判断实体类型是否需要脱敏
+func (s *PIIRedactionSetting) EntityEnabled(entity string) bool {
+	return len(s.EntityTypes) == 0 || slices.Contains(s.EntityTypes, entity)
+}
@@ -0,0 +1,30 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// ResponsesStoreSetting 网关侧 Responses 状态存储配置。
+// 分发到不保存会话状态的渠道（OpenAI、Azure 以外）时，由网关保存对话记录并展开 previous_response_id
+type ResponsesStoreSetting struct {
+	Enabled bool `json:"enabled"` // 是否启用网关侧存储，需要配置 SECRET_ENCRYPTION_KEY
+	// TTLHours 对话记录保留时长（小时），0 表示不过期
+	TTLHours int `json:"ttl_hours"`
+	// MaxChainDepth 展开 previous_response_id 时最多回溯的轮数
+	MaxChainDepth int `json:"max_chain_depth"`
+}
+
+// 默认配置
+var responsesStoreSetting = ResponsesStoreSetting{
+	Enabled:       false,
+	TTLHours:      720,
+	MaxChainDepth: 200,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("responses_store_setting", &responsesStoreSetting)
+}
+
+// GetResponsesStoreSetting 获取 Responses 状态存储配置
+func GetResponsesStoreSetting() *ResponsesStoreSetting {
+	return &responsesStoreSetting
+}
@@ -0,0 +1,24 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// ResponsesWebSocketSetting /v1/responses WebSocket 传输配置
+type ResponsesWebSocketSetting struct {
+	// UpstreamEnabled 分发到 OpenAI 渠道时通过上游 WebSocket 转发，关闭时每轮转换为一次 HTTP 流式请求
+	UpstreamEnabled bool `json:"upstream_enabled"`
+}
+
+// 默认配置
+var responsesWebSocketSetting = ResponsesWebSocketSetting{
+	UpstreamEnabled: true,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("responses_websocket_setting", &responsesWebSocketSetting)
+}
+
+// GetResponsesWebSocketSetting 获取 Responses WebSocket 传输配置
+func GetResponsesWebSocketSetting() *ResponsesWebSocketSetting {
+	return &responsesWebSocketSetting
+}
@@ -0,0 +1,30 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// TokenRotationSetting 令牌密钥轮换配置：轮换后旧密钥在宽限期内仍然有效，宽限期结束后失效
+type TokenRotationSetting struct {
+	// GraceSeconds 轮换时未指定宽限期时使用的默认宽限期，自动轮换同样使用该值
+	GraceSeconds int `json:"grace_seconds"`
+	// MaxGraceSeconds 手动轮换时允许指定的最长宽限期
+	MaxGraceSeconds int `json:"max_grace_seconds"`
+	// AutoRotateEnabled 是否执行令牌设置的定期自动轮换，新密钥通过用户配置的 Webhook 推送
+	AutoRotateEnabled bool `json:"auto_rotate_enabled"`
+}
+
+// 默认配置
+var tokenRotationSetting = TokenRotationSetting{
+	GraceSeconds:      86400,
+	MaxGraceSeconds:   7 * 86400,
+	AutoRotateEnabled: false,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("token_rotation_setting", &tokenRotationSetting)
+}
+
+// GetTokenRotationSetting 获取令牌密钥轮换配置
+func GetTokenRotationSetting() *TokenRotationSetting {
+	return &tokenRotationSetting
+}
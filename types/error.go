@@ -50,6 +50,7 @@ const (
 	ErrorCodeDoRequestFailed    ErrorCode = "do_request_failed"
 	ErrorCodeGetChannelFailed   ErrorCode = "get_channel_failed"
 	ErrorCodeGenRelayInfoFailed ErrorCode = "gen_relay_info_failed"
+	ErrorCodeCircuitBreakerOpen ErrorCode = "circuit_breaker_open"
 
 	// channel error
 	ErrorCodeChannelNoAvailableKey        ErrorCode = "channel:no_available_key"
@@ -85,6 +86,10 @@ const (
 	// quota error
 	ErrorCodeInsufficientUserQuota      ErrorCode = "insufficient_user_quota"
 	ErrorCodePreConsumeTokenQuotaFailed ErrorCode = "pre_consume_token_quota_failed"
+	ErrorCodeTokenBudgetExceeded        ErrorCode = "token_budget_exceeded"
+
+	// rate limit error
+	ErrorCodeTokenRateLimitExceeded ErrorCode = "token_rate_limit_exceeded"
 )
 
 type NewAPIError struct {